@@ -8,6 +8,7 @@ import (
 
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"github.com/Altinity/clickhouse-backup/pkg/logcli"
+	"github.com/Altinity/clickhouse-backup/pkg/notification"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
 
 	"github.com/Altinity/clickhouse-backup/pkg/backup"
@@ -24,7 +25,7 @@ var (
 )
 
 func main() {
-	log.SetHandler(logcli.New(os.Stdout))
+	log.SetHandler(status.NewLogHandler(logcli.New(os.Stdout)))
 	cliapp := cli.NewApp()
 	cliapp.Name = "clickhouse-backup"
 	cliapp.Usage = "Tool for easy backup of ClickHouse with cloud support"
@@ -49,6 +50,12 @@ func main() {
 			Required: false,
 			Usage:    "internal parameter for API call",
 		},
+		cli.StringFlag{
+			Name:   "progress-format",
+			Value:  status.ProgressFormatBar,
+			Usage:  "'bar' or 'json', json prints progress of create, upload, download and restore to stdout as JSON lines and logs to stderr",
+			EnvVar: "CLICKHOUSE_BACKUP_PROGRESS_FORMAT",
+		},
 	}
 	cliapp.CommandNotFound = func(c *cli.Context, command string) {
 		fmt.Printf("Error. Unknown command: '%s'\n\n", command)
@@ -304,13 +311,59 @@ func main() {
 				},
 			),
 		},
+		{
+			Name:      "verify",
+			Usage:     "Verify remote backup consistency without download",
+			UsageText: "clickhouse-backup verify [--deep] <backup_name>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Verify(c.Args().First(), c.Bool("deep"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "deep",
+					Hidden: false,
+					Usage:  "Stream and decompress each data archive or part and compare files with checksums.txt from each data part, much slower and require network traffic equal to backup size",
+				},
+			),
+		},
+		{
+			Name:      "consolidate",
+			Usage:     "Build new full backup on remote storage from incremental backup and all backups from its required backups chain",
+			UsageText: "clickhouse-backup consolidate <backup_name> [<new_backup_name>]",
+			Description: "Data copied between remote backups, use server-side copy for s3, gcs and azblob, ClickHouse data is not touched.\n" +
+				"When <new_backup_name> is not defined, <backup_name>_full will be used",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				return b.Consolidate(c.Args().First(), c.Args().Get(1), c.Int("command-id"))
+			},
+			Flags: cliapp.Flags,
+		},
 		{
 			Name:      "restore",
 			Usage:     "Create schema and restore data from backup",
-			UsageText: "clickhouse-backup restore  [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--partitions=<partitions_names>] [-s, --schema] [-d, --data] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] <backup_name>",
+			UsageText: "clickhouse-backup restore  [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--restore-table-mapping=<originDB>.<originTable>:<targetDB>.<targetTable>[,<...>]] [--restore-zookeeper-path-mapping=<originPrefix>:<targetPrefix>[,<...>]] [--restore-replicated-conversion=to_merge_tree|to_replicated] [--partitions=<partitions_names>] [-s, --schema] [-d, --data] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] [--atomic] [--where=<expr>] [--dry-run] <backup_name>",
 			Action: func(c *cli.Context) error {
 				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.Restore(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("ignore-dependencies"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Int("command-id"))
+				return b.Restore(c.Args().First(), backup.RestoreOptions{
+					TablePattern:         c.String("t"),
+					ReplicatedConversion: c.String("restore-replicated-conversion"),
+					Where:                c.String("where"),
+					DatabaseMapping:      c.StringSlice("restore-database-mapping"),
+					TableMapping:         c.StringSlice("restore-table-mapping"),
+					ZookeeperPathMapping: c.StringSlice("restore-zookeeper-path-mapping"),
+					Partitions:           c.StringSlice("partitions"),
+					SchemaOnly:           c.Bool("s"),
+					DataOnly:             c.Bool("d"),
+					DropTable:            c.Bool("rm"),
+					IgnoreDependencies:   c.Bool("ignore-dependencies"),
+					RBAC:                 c.Bool("rbac"),
+					RBACOnly:             c.Bool("rbac-only"),
+					Configs:              c.Bool("configs"),
+					ConfigsOnly:          c.Bool("configs-only"),
+					Atomic:               c.Bool("atomic"),
+					DryRun:               c.Bool("dry-run"),
+				}, c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -323,6 +376,21 @@ func main() {
 					Usage:  "Define the rule to restore data. For the database not defined in this struct, the program will not deal with it.",
 					Hidden: false,
 				},
+				cli.StringSliceFlag{
+					Name:   "restore-table-mapping",
+					Usage:  "Define the rule to restore table with other name, applied before --restore-database-mapping. For the table not defined in this struct, the program will not deal with it.",
+					Hidden: false,
+				},
+				cli.StringSliceFlag{
+					Name:   "restore-zookeeper-path-mapping",
+					Usage:  "Replace ZooKeeper path prefix in Replicated*MergeTree engine and Replicated database arguments, format <originPrefix>:<targetPrefix>, the longest matched prefix wins",
+					Hidden: false,
+				},
+				cli.StringFlag{
+					Name:   "restore-replicated-conversion",
+					Usage:  "Convert engines during restore schema, to_merge_tree converts Replicated*MergeTree to *MergeTree and Replicated database to Atomic, to_replicated converts *MergeTree to Replicated*MergeTree with default_replica_path and default_replica_name from server config",
+					Hidden: false,
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -373,15 +441,49 @@ func main() {
 					Hidden: false,
 					Usage:  "Restore 'clickhouse-server' configuration files only, will skip backup data, will backup schema only if --schema added",
 				},
+				cli.BoolFlag{
+					Name:   "atomic",
+					Hidden: false,
+					Usage:  "Restore data of existing tables into staging <table>__restore_<timestamp> tables and replace existing tables via EXCHANGE TABLES, or REPLACE PARTITION for replicated tables and when --partitions is used, only after all parts attached, existing tables are not dropped, other existing objects, like inner tables of materialized views, are kept as is with their data",
+				},
+				cli.StringFlag{
+					Name:   "where",
+					Hidden: false,
+					Usage:  "Restore data into staging <table>__restore_where_<timestamp> tables and insert only rows matched by SQL expression into existing tables, staging tables are dropped after, use with --partitions to download and attach only relevant partitions",
+				},
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Print which databases and tables will be dropped and created, which parts will be attached, and missing or conflicting tables, nothing is changed",
+				},
 			),
 		},
 		{
 			Name:      "restore_remote",
 			Usage:     "Download and restore",
-			UsageText: "clickhouse-backup restore_remote [--schema] [--data] [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--partitions=<partitions_names>] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] [--skip-rbac] [--skip-configs] [--resumable] <backup_name>",
+			UsageText: "clickhouse-backup restore_remote [--schema] [--data] [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--restore-table-mapping=<originDB>.<originTable>:<targetDB>.<targetTable>[,<...>]] [--restore-zookeeper-path-mapping=<originPrefix>:<targetPrefix>[,<...>]] [--restore-replicated-conversion=to_merge_tree|to_replicated] [--partitions=<partitions_names>] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] [--skip-rbac] [--skip-configs] [--resumable] [--atomic] [--where=<expr>] [--dry-run] <backup_name>",
 			Action: func(c *cli.Context) error {
 				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.RestoreFromRemote(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("i"), c.Bool("rbac"), c.Bool("rbac-only"), c.Bool("configs"), c.Bool("configs-only"), c.Bool("resume"), c.Int("command-id"))
+				return b.RestoreFromRemote(c.Args().First(), backup.RestoreOptions{
+					TablePattern:         c.String("t"),
+					ReplicatedConversion: c.String("restore-replicated-conversion"),
+					Where:                c.String("where"),
+					DatabaseMapping:      c.StringSlice("restore-database-mapping"),
+					TableMapping:         c.StringSlice("restore-table-mapping"),
+					ZookeeperPathMapping: c.StringSlice("restore-zookeeper-path-mapping"),
+					Partitions:           c.StringSlice("partitions"),
+					SchemaOnly:           c.Bool("s"),
+					DataOnly:             c.Bool("d"),
+					DropTable:            c.Bool("rm"),
+					IgnoreDependencies:   c.Bool("i"),
+					RBAC:                 c.Bool("rbac"),
+					RBACOnly:             c.Bool("rbac-only"),
+					Configs:              c.Bool("configs"),
+					ConfigsOnly:          c.Bool("configs-only"),
+					Resume:               c.Bool("resume"),
+					Atomic:               c.Bool("atomic"),
+					DryRun:               c.Bool("dry-run"),
+				}, c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -394,6 +496,21 @@ func main() {
 					Usage:  "Define the rule to restore data. For the database not defined in this struct, the program will not deal with it.",
 					Hidden: false,
 				},
+				cli.StringSliceFlag{
+					Name:   "restore-table-mapping",
+					Usage:  "Define the rule to restore table with other name, applied before --restore-database-mapping. For the table not defined in this struct, the program will not deal with it.",
+					Hidden: false,
+				},
+				cli.StringSliceFlag{
+					Name:   "restore-zookeeper-path-mapping",
+					Usage:  "Replace ZooKeeper path prefix in Replicated*MergeTree engine and Replicated database arguments, format <originPrefix>:<targetPrefix>, the longest matched prefix wins",
+					Hidden: false,
+				},
+				cli.StringFlag{
+					Name:   "restore-replicated-conversion",
+					Usage:  "Convert engines during restore schema, to_merge_tree converts Replicated*MergeTree to *MergeTree and Replicated database to Atomic, to_replicated converts *MergeTree to Replicated*MergeTree with default_replica_path and default_replica_name from server config",
+					Hidden: false,
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -449,6 +566,21 @@ func main() {
 					Hidden: false,
 					Usage:  "Save intermediate upload state and resume upload if backup exists on remote storage, ignored with 'remote_storage: custom' or 'use_embedded_backup_restore: true'",
 				},
+				cli.BoolFlag{
+					Name:   "atomic",
+					Hidden: false,
+					Usage:  "Restore data of existing tables into staging <table>__restore_<timestamp> tables and replace existing tables via EXCHANGE TABLES, or REPLACE PARTITION for replicated tables and when --partitions is used, only after all parts attached, existing tables are not dropped, other existing objects, like inner tables of materialized views, are kept as is with their data",
+				},
+				cli.StringFlag{
+					Name:   "where",
+					Hidden: false,
+					Usage:  "Restore data into staging <table>__restore_where_<timestamp> tables and insert only rows matched by SQL expression into existing tables, staging tables are dropped after, use with --partitions to download and attach only relevant partitions",
+				},
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Print restore plan and how many bytes will be downloaded based on remote backup metadata, nothing is downloaded or changed",
+				},
 			),
 		},
 		{
@@ -469,6 +601,87 @@ func main() {
 			},
 			Flags: cliapp.Flags,
 		},
+		{
+			Name:      "retention",
+			Usage:     "Delete old backups according to `retention` policy or `backups_to_keep_local` / `backups_to_keep_remote`",
+			UsageText: "clickhouse-backup retention <local|remote> [--dry-run]",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				if c.Args().Get(0) != "local" && c.Args().Get(0) != "remote" {
+					log.Errorf("Unknown command '%s'\n", c.Args().Get(0))
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				return b.Retention(c.Args().Get(0), c.Bool("dry-run"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Only print list of backups which will be deleted, without deletion",
+				},
+			),
+		},
+		{
+			Name:      "pin",
+			Usage:     "Protect specific backup and backups which it requires from retention and deletion",
+			UsageText: "clickhouse-backup pin <local|remote> <backup_name>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				if c.Args().Get(1) == "" {
+					log.Errorf("Backup name must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				if c.Args().Get(0) != "local" && c.Args().Get(0) != "remote" {
+					log.Errorf("Unknown command '%s'\n", c.Args().Get(0))
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				return b.Pin(c.Args().Get(0), c.Args().Get(1), c.Int("command-id"))
+			},
+			Flags: cliapp.Flags,
+		},
+		{
+			Name:      "unpin",
+			Usage:     "Remove protection added by `pin` command",
+			UsageText: "clickhouse-backup unpin <local|remote> <backup_name>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				if c.Args().Get(1) == "" {
+					log.Errorf("Backup name must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				if c.Args().Get(0) != "local" && c.Args().Get(0) != "remote" {
+					log.Errorf("Unknown command '%s'\n", c.Args().Get(0))
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				return b.Unpin(c.Args().Get(0), c.Args().Get(1), c.Int("command-id"))
+			},
+			Flags: cliapp.Flags,
+		},
+		{
+			Name:      "diff",
+			Usage:     "Compare databases, tables, schema, parts and sizes of two backups",
+			UsageText: "clickhouse-backup diff [--format=text|json] <local|remote> <backup_a> <backup_b>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				if c.Args().Get(1) == "" || c.Args().Get(2) == "" {
+					log.Errorf("Two backup names must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				if c.Args().Get(0) != "local" && c.Args().Get(0) != "remote" {
+					log.Errorf("Unknown command '%s'\n", c.Args().Get(0))
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				return b.Diff(c.Args().Get(0), c.Args().Get(1), c.Args().Get(2), c.String("format"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				cli.StringFlag{
+					Name:   "format",
+					Value:  "text",
+					Usage:  "Output format, text or json",
+					Hidden: false,
+				},
+			),
+		},
 		{
 			Name:  "default-config",
 			Usage: "Print default config",
@@ -596,7 +809,43 @@ func main() {
 			),
 		},
 	}
-	if err := cliapp.Run(os.Args); err != nil {
+	for i := range cliapp.Commands {
+		cliapp.Commands[i].Before = chainBeforeFuncs(setProgressFormat, cliapp.Commands[i].Before)
+	}
+	err := cliapp.Run(os.Args)
+	// webhooks are called in background, process shall not exit before delivery
+	notification.Wait()
+	if err != nil {
 		log.Fatal(err.Error())
 	}
 }
+
+// chainBeforeFuncs - nil funcs are skipped, first error stops the chain
+func chainBeforeFuncs(funcs ...cli.BeforeFunc) cli.BeforeFunc {
+	return func(c *cli.Context) error {
+		for _, before := range funcs {
+			if before == nil {
+				continue
+			}
+			if err := before(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// setProgressFormat - progress-format could be passed before or after command name, json progress owns stdout, so logs are moved to stderr
+func setProgressFormat(c *cli.Context) error {
+	format := c.String("progress-format")
+	if !c.IsSet("progress-format") && c.GlobalIsSet("progress-format") {
+		format = c.GlobalString("progress-format")
+	}
+	if err := status.Current.SetProgressFormat(format); err != nil {
+		return err
+	}
+	if format == status.ProgressFormatJSON {
+		log.SetHandler(status.NewLogHandler(logcli.New(os.Stderr)))
+	}
+	return nil
+}
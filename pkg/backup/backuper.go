@@ -5,10 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"path"
+	"regexp"
 
 	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
 	"github.com/Altinity/clickhouse-backup/pkg/resumable"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
 	"github.com/Altinity/clickhouse-backup/pkg/storage"
 
 	apexLog "github.com/apex/log"
@@ -38,6 +41,16 @@ type Backuper struct {
 	isEmbedded             bool
 	resume                 bool
 	resumableState         *resumable.State
+	// live -> staging tables for restore --atomic, --where and restore_masking
+	stagingTables map[metadata.TableTitle]stagingTable
+	// results of restore_validation, will show in /backup/status
+	restoreValidations []status.RestoreValidation
+	// name from watch_jobs, job config is reloaded by name during watch
+	watchJob string
+	// when not nil, backups_to_keep_* and retention apply only to matched backups
+	retentionBackupNameRE *regexp.Regexp
+	// create_remote and restore_remote send one notification instead of notification for each step
+	isNotifying bool
 }
 
 func NewBackuper(cfg *config.Config, opts ...BackuperOpt) *Backuper {
@@ -58,12 +71,28 @@ func NewBackuper(cfg *config.Config, opts ...BackuperOpt) *Backuper {
 	return b
 }
 
+// setCommandLog - log entries of API command are published to /backup/actions/{command_id}/events subscribers and kept in /backup/actions/{command_id}/log
+func (b *Backuper) setCommandLog(commandId int) {
+	if commandId == status.NotFromAPI {
+		return
+	}
+	b.log = b.log.WithField("command_id", commandId)
+	b.ch.Log = b.ch.Log.WithField("command_id", commandId)
+}
+
 func WithVersioner(v versioner) BackuperOpt {
 	return func(b *Backuper) {
 		b.vers = v
 	}
 }
 
+// WithWatchJob - watch backups only for one job from watch_jobs config section
+func WithWatchJob(name string) BackuperOpt {
+	return func(b *Backuper) {
+		b.watchJob = name
+	}
+}
+
 func WithBackupSharder(s backupSharder) BackuperOpt {
 	return func(b *Backuper) {
 		b.bs = s
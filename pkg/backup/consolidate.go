@@ -0,0 +1,378 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/metrics"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+	"github.com/eapache/go-resiliency/retrier"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// Consolidate - build new full backup on remote storage from incremental backup and all backups from its `required_backup` chain
+// data copied between remote backups only, clickhouse data is not touched
+func (b *Backuper) Consolidate(backupName, newBackupName string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	b.setCommandLog(commandId)
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if newBackupName == "" {
+		newBackupName = backupName + "_full"
+	}
+	newBackupName = utils.CleanBackupNameRE.ReplaceAllString(newBackupName, "")
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":     backupName,
+		"new_backup": newBackupName,
+		"operation":  "consolidate",
+	})
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("general->remote_storage: %s doesn't support consolidate", b.cfg.General.RemoteStorage)
+	}
+	if backupName == "" {
+		_ = b.PrintRemoteBackups(ctx, "all")
+		return fmt.Errorf("select backup for consolidate")
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	b.dst = bd
+
+	start := time.Now()
+	remoteBackups, err := bd.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	remoteBackupsMap := make(map[string]storage.Backup, len(remoteBackups))
+	for _, backup := range remoteBackups {
+		remoteBackupsMap[backup.BackupName] = backup
+	}
+	if _, exists := remoteBackupsMap[newBackupName]; exists {
+		return fmt.Errorf("'%s' already exists on remote storage", newBackupName)
+	}
+	chain, err := getConsolidateChain(remoteBackupsMap, backupName)
+	if err != nil {
+		return err
+	}
+	backupMetadata := chain[0].BackupMetadata
+
+	compressedSize := int64(0)
+	metadataSize := int64(0)
+	consolidateSemaphore := semaphore.NewWeighted(int64(b.cfg.General.UploadConcurrency))
+	consolidateGroup, consolidateCtx := errgroup.WithContext(ctx)
+	for _, t := range backupMetadata.Tables {
+		if err := consolidateSemaphore.Acquire(consolidateCtx, 1); err != nil {
+			log.Errorf("can't acquire semaphore during Consolidate: %v", err)
+			break
+		}
+		tableTitle := t
+		consolidateGroup.Go(func() error {
+			defer consolidateSemaphore.Release(1)
+			tableStart := time.Now()
+			tableDataSize, tableMetadataSize, err := b.consolidateTable(consolidateCtx, chain, newBackupName, tableTitle)
+			if err != nil {
+				return err
+			}
+			atomic.AddInt64(&compressedSize, tableDataSize)
+			atomic.AddInt64(&metadataSize, tableMetadataSize)
+			log.WithFields(apexLog.Fields{
+				"table":    fmt.Sprintf("%s.%s", tableTitle.Database, tableTitle.Table),
+				"duration": utils.HumanizeDuration(time.Since(tableStart)),
+				"size":     utils.FormatBytes(uint64(tableDataSize + tableMetadataSize)),
+			}).Info("done")
+			return nil
+		})
+	}
+	if err := consolidateGroup.Wait(); err != nil {
+		return fmt.Errorf("one of Consolidate go-routine return error: %v", err)
+	}
+
+	// rbac, configs and checksums manifests are the same for whole chain, copy it as is
+	err = bd.Walk(ctx, backupName+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+		name := strings.TrimPrefix(f.Name(), "/")
+		if name == "metadata.json" || name == storage.PinFileName || name == storage.RequiredByPinnedFileName || strings.HasPrefix(name, "shadow/") || (strings.HasPrefix(name, "metadata/") && strings.HasSuffix(name, ".json")) {
+			return nil
+		}
+		size, err := b.copyRemoteFile(ctx, path.Join(backupName, name), path.Join(newBackupName, name))
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(name, "metadata/") {
+			atomic.AddInt64(&metadataSize, size)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't copy %s files: %v", backupName, err)
+	}
+
+	backupMetadata.BackupName = newBackupName
+	backupMetadata.RequiredBackup = ""
+	backupMetadata.CompressedSize = uint64(compressedSize)
+	backupMetadata.MetadataSize = uint64(metadataSize)
+	newBackupMetadataBody, err := json.MarshalIndent(backupMetadata, "", "\t")
+	if err != nil {
+		return err
+	}
+	remoteBackupMetaFile := path.Join(newBackupName, "metadata.json")
+	retry := metrics.NewRetrier("consolidate", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
+	err = retry.RunCtx(ctx, func(ctx context.Context) error {
+		return bd.PutFile(ctx, remoteBackupMetaFile, io.NopCloser(bytes.NewReader(newBackupMetadataBody)))
+	})
+	if err != nil {
+		return fmt.Errorf("can't upload %s: %v", remoteBackupMetaFile, err)
+	}
+	log.WithFields(apexLog.Fields{
+		"chain":    len(chain),
+		"duration": utils.HumanizeDuration(time.Since(start)),
+		"size":     utils.FormatBytes(uint64(compressedSize + metadataSize)),
+	}).Info("done")
+	return nil
+}
+
+// getConsolidateChain return backupName and all backups from its `required_backup` chain, from newest to oldest
+func getConsolidateChain(remoteBackups map[string]storage.Backup, backupName string) ([]storage.Backup, error) {
+	backup, exists := remoteBackups[backupName]
+	if !exists {
+		return nil, fmt.Errorf("'%s' is not found on remote storage", backupName)
+	}
+	if backup.Broken != "" {
+		return nil, fmt.Errorf("'%s' is %s", backupName, backup.Broken)
+	}
+	if backup.Legacy || strings.Contains(backup.Tags, "embedded") {
+		return nil, fmt.Errorf("'%s' is legacy or embedded backup, consolidate is not supported", backupName)
+	}
+	if backup.RequiredBackup == "" {
+		return nil, fmt.Errorf("'%s' is already full backup, nothing to consolidate", backupName)
+	}
+	for disk, diskType := range backup.DiskTypes {
+		if diskType == "s3" || diskType == "azure_blob_storage" {
+			return nil, fmt.Errorf("'%s' contains object disk %s, consolidate is not supported", backupName, disk)
+		}
+	}
+	chain := []storage.Backup{backup}
+	for requiredBackup := backup.RequiredBackup; requiredBackup != ""; {
+		required, exists := remoteBackups[requiredBackup]
+		if !exists {
+			return nil, fmt.Errorf("required backup '%s' not found on remote storage", requiredBackup)
+		}
+		if required.Broken != "" {
+			return nil, fmt.Errorf("required backup '%s' is %s", requiredBackup, required.Broken)
+		}
+		if required.Legacy {
+			return nil, fmt.Errorf("required backup '%s' is legacy backup, consolidate is not supported", requiredBackup)
+		}
+		if (required.DataFormat == DirectoryFormat) != (backup.DataFormat == DirectoryFormat) {
+			return nil, fmt.Errorf("required backup '%s' data_format=%s not compatible with '%s' data_format=%s", requiredBackup, required.DataFormat, backupName, backup.DataFormat)
+		}
+		// data copied as is without decryption, so whole chain shall use the same key
+		if required.EncryptionKeyID != backup.EncryptionKeyID {
+			return nil, fmt.Errorf("required backup '%s' encrypted with key id=%s, but '%s' with key id=%s", requiredBackup, required.EncryptionKeyID, backupName, backup.EncryptionKeyID)
+		}
+		if len(chain) > len(remoteBackups) {
+			return nil, fmt.Errorf("'%s' has cyclic required_backup chain", backupName)
+		}
+		chain = append(chain, required)
+		requiredBackup = required.RequiredBackup
+	}
+	return chain, nil
+}
+
+// consolidateTable copy own data and all required parts from chain into newBackupName, return copied data size and uploaded metadata size
+func (b *Backuper) consolidateTable(ctx context.Context, chain []storage.Backup, newBackupName string, tableTitle metadata.TableTitle) (int64, int64, error) {
+	backup := chain[0]
+	table, err := b.readTableMetadataRemote(ctx, backup.BackupName, tableTitle)
+	if err != nil {
+		return 0, 0, err
+	}
+	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	newTableRemotePath := path.Join(newBackupName, "shadow", dbAndTableDir)
+	newTable := *table
+	newTable.Parts = make(map[string][]metadata.Part, len(table.Parts))
+	newTable.Files = make(map[string][]string, len(table.Files))
+	newTable.FilesSize = make(map[string]int64)
+	dataSize := int64(0)
+	copyArchive := func(srcArchive, disk, dstArchive string) error {
+		if _, exists := newTable.FilesSize[dstArchive]; exists {
+			return nil
+		}
+		size, err := b.copyRemoteFile(ctx, srcArchive, path.Join(newTableRemotePath, dstArchive))
+		if err != nil {
+			return err
+		}
+		newTable.Files[disk] = append(newTable.Files[disk], dstArchive)
+		newTable.FilesSize[dstArchive] = size
+		dataSize += size
+		return nil
+	}
+	copyPart := func(srcPartPath, dstPartPath string) error {
+		copiedFiles := 0
+		err := b.dst.Walk(ctx, srcPartPath+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+			if b.dst.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
+				return nil
+			}
+			size, err := b.copyRemoteFile(ctx, path.Join(srcPartPath, f.Name()), path.Join(dstPartPath, f.Name()))
+			dataSize += size
+			copiedFiles++
+			return err
+		})
+		if err == nil && copiedFiles == 0 {
+			return fmt.Errorf("%s not found on remote storage", srcPartPath)
+		}
+		return err
+	}
+
+	if !table.MetadataOnly && backup.DataFormat != DirectoryFormat {
+		for disk, archives := range table.Files {
+			for _, archive := range archives {
+				if err = copyArchive(path.Join(backup.BackupName, "shadow", dbAndTableDir, archive), disk, archive); err != nil {
+					return 0, 0, err
+				}
+			}
+		}
+	}
+	requiredTables := make(map[string]*metadata.TableMetadata)
+	for disk, parts := range table.Parts {
+		for _, part := range parts {
+			if !table.MetadataOnly {
+				if !part.Required && backup.DataFormat == DirectoryFormat {
+					if err = copyPart(path.Join(backup.BackupName, "shadow", dbAndTableDir, disk, part.Name), path.Join(newTableRemotePath, disk, part.Name)); err != nil {
+						return 0, 0, err
+					}
+				}
+				if part.Required {
+					required, requiredTable, requiredDisk, err := b.findConsolidatePart(ctx, chain[1:], requiredTables, tableTitle, disk, part)
+					if err != nil {
+						return 0, 0, err
+					}
+					requiredTableRemotePath := path.Join(required.BackupName, "shadow", dbAndTableDir)
+					if required.DataFormat == DirectoryFormat {
+						err = copyPart(path.Join(requiredTableRemotePath, requiredDisk, part.Name), path.Join(newTableRemotePath, disk, part.Name))
+					} else {
+						ext := config.ArchiveExtensions[required.DataFormat]
+						partArchive := path.Join(requiredTableRemotePath, fmt.Sprintf("%s_%s.%s", requiredDisk, common.TablePathEncode(part.Name), ext))
+						if _, statErr := b.dst.StatFile(ctx, partArchive); statErr == nil {
+							err = copyArchive(partArchive, disk, fmt.Sprintf("%s_%s.%s", disk, common.TablePathEncode(part.Name), ext))
+						} else {
+							// upload_by_part: false, part is somewhere inside one of disk archives, so copy all of them
+							for _, archive := range requiredTable.Files[requiredDisk] {
+								if err = copyArchive(path.Join(requiredTableRemotePath, archive), disk, fmt.Sprintf("%s_%s_%s", disk, required.BackupName, archive)); err != nil {
+									break
+								}
+							}
+						}
+					}
+					if err != nil {
+						return 0, 0, err
+					}
+				}
+			}
+			part.Required = false
+			newTable.Parts[disk] = append(newTable.Parts[disk], part)
+		}
+	}
+	metadataSize, err := b.uploadTableMetadataRegular(ctx, newBackupName, newTable)
+	if err != nil {
+		return 0, 0, err
+	}
+	return dataSize, metadataSize, nil
+}
+
+// findConsolidatePart return the nearest backup from chain which contains part data, table metadata and disk of this backup, the same disk is preferable
+func (b *Backuper) findConsolidatePart(ctx context.Context, chain []storage.Backup, requiredTables map[string]*metadata.TableMetadata, tableTitle metadata.TableTitle, disk string, part metadata.Part) (storage.Backup, *metadata.TableMetadata, string, error) {
+	for _, required := range chain {
+		requiredTable, isCached := requiredTables[required.BackupName]
+		if !isCached {
+			var err error
+			if requiredTable, err = b.readTableMetadataRemote(ctx, required.BackupName, tableTitle); err != nil {
+				return storage.Backup{}, nil, "", err
+			}
+			requiredTables[required.BackupName] = requiredTable
+		}
+		foundDisk := ""
+		isRequired := false
+		for requiredDisk, requiredParts := range requiredTable.Parts {
+			for _, requiredPart := range requiredParts {
+				if requiredPart.Name == part.Name && (foundDisk == "" || requiredDisk == disk) {
+					foundDisk = requiredDisk
+					isRequired = requiredPart.Required
+				}
+			}
+		}
+		if foundDisk == "" {
+			break
+		}
+		if !isRequired {
+			return required, requiredTable, foundDisk, nil
+		}
+	}
+	return storage.Backup{}, nil, "", fmt.Errorf("%s.%s %s not found in required backups chain", tableTitle.Database, tableTitle.Table, part.Name)
+}
+
+func (b *Backuper) readTableMetadataRemote(ctx context.Context, backupName string, tableTitle metadata.TableTitle) (*metadata.TableMetadata, error) {
+	remoteTableMetadata := path.Join(backupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
+	r, err := b.dst.GetFileReader(ctx, remoteTableMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s: %v", remoteTableMetadata, err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		_ = r.Close()
+		return nil, fmt.Errorf("can't read %s: %v", remoteTableMetadata, err)
+	}
+	if err = r.Close(); err != nil {
+		return nil, err
+	}
+	var table metadata.TableMetadata
+	if err = json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", remoteTableMetadata, err)
+	}
+	return &table, nil
+}
+
+func (b *Backuper) copyRemoteFile(ctx context.Context, srcKey, dstKey string) (int64, error) {
+	var size int64
+	retry := metrics.NewRetrier("consolidate", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		var err error
+		size, err = b.dst.CopyFile(ctx, srcKey, dstKey)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("can't copy %s -> %s: %v", srcKey, dstKey, err)
+	}
+	b.log.WithField("logger", "copyRemoteFile").Debugf("%s -> %s", srcKey, dstKey)
+	return size, nil
+}
@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetConsolidateChain(t *testing.T) {
+	remoteBackups := map[string]storage.Backup{}
+	for _, b := range []metadata.BackupMetadata{
+		{BackupName: "full", DataFormat: "tar"},
+		{BackupName: "increment1", DataFormat: "tar", RequiredBackup: "full"},
+		{BackupName: "increment2", DataFormat: "zstd", RequiredBackup: "increment1"},
+		{BackupName: "directory", DataFormat: DirectoryFormat, RequiredBackup: "increment2"},
+		{BackupName: "lost", DataFormat: "tar", RequiredBackup: "not_exists"},
+		{BackupName: "encrypted", DataFormat: "tar", RequiredBackup: "full", EncryptionKeyID: "key1"},
+	} {
+		remoteBackups[b.BackupName] = storage.Backup{BackupMetadata: b}
+	}
+
+	chain, err := getConsolidateChain(remoteBackups, "increment2")
+	require.NoError(t, err)
+	names := make([]string, len(chain))
+	for i := range chain {
+		names[i] = chain[i].BackupName
+	}
+	assert.Equal(t, []string{"increment2", "increment1", "full"}, names)
+
+	for _, backupName := range []string{"full", "directory", "lost", "encrypted", "not_exists"} {
+		_, err = getConsolidateChain(remoteBackups, backupName)
+		assert.Error(t, err, backupName)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
 	"github.com/Altinity/clickhouse-backup/pkg/keeper"
 	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/metrics"
 	"github.com/Altinity/clickhouse-backup/pkg/partition"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
 	"github.com/Altinity/clickhouse-backup/pkg/storage"
@@ -52,11 +53,16 @@ func NewBackupName() string {
 
 // CreateBackup - create new backup of all tables matched by tablePattern
 // If backupName is empty string will use default backup name
-func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []string, schemaOnly, createRBAC, rbacOnly, createConfigs, configsOnly, skipCheckPartsColumns bool, version string, commandId int) error {
+func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []string, schemaOnly, createRBAC, rbacOnly, createConfigs, configsOnly, skipCheckPartsColumns bool, version string, commandId int) (err error) {
+	notify := b.startNotification("create")
+	defer func() {
+		b.finishNotification(notify, backupName, err)
+	}()
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
 	}
+	b.setCommandLog(commandId)
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 
@@ -66,6 +72,7 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 		backupName = NewBackupName()
 	}
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	status.Current.SetBackupName(commandId, backupName)
 	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "create",
@@ -88,15 +95,20 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 		return fmt.Errorf("can't get tables from clickhouse: %v", err)
 	}
 	i := 0
+	bytesTotal := uint64(0)
 	for _, table := range tables {
 		if table.Skip {
 			continue
 		}
 		i++
+		if doBackupData {
+			bytesTotal += table.TotalBytes
+		}
 	}
 	if i == 0 && !b.cfg.General.AllowEmptyBackups {
 		return fmt.Errorf("no tables for backup")
 	}
+	status.Current.StartProgress(ctx, "create", uint64(i), bytesTotal)
 
 	allFunctions, err := b.ch.GetUserDefinedFunctions(ctx)
 	if err != nil {
@@ -118,6 +130,10 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 	// create
 	if b.cfg.ClickHouse.UseEmbeddedBackupRestore {
 		err = b.createBackupEmbedded(ctx, backupName, tablePattern, partitionsNameList, partitionsIdMap, schemaOnly, createRBAC, createConfigs, tables, allDatabases, allFunctions, disks, diskMap, diskTypes, log, startBackup, version)
+		if err == nil {
+			// BACKUP query doesn't report progress for each table
+			status.Current.AddProgress(ctx, uint64(i), bytesTotal)
+		}
 	} else {
 		err = b.createBackupLocal(ctx, backupName, partitionsIdMap, tables, doBackupData, schemaOnly, createRBAC, rbacOnly, createConfigs, configsOnly, version, disks, diskMap, diskTypes, allDatabases, allFunctions, log, startBackup)
 	}
@@ -165,8 +181,10 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, par
 			if table.Skip {
 				continue
 			}
+			startTable := time.Now()
 			var realSize map[string]int64
 			var disksToPartsMap map[string][]metadata.Part
+			var partitionsStats map[string]metadata.PartitionStats
 			if doBackupData && table.BackupType == clickhouse.ShardBackupFull {
 				log.Debug("create data")
 				shadowBackupUUID := strings.ReplaceAll(uuid.New().String(), "-", "")
@@ -183,8 +201,17 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, par
 					return err
 				}
 				// more precise data size calculation
+				tableDataSize := uint64(0)
 				for _, size := range realSize {
-					backupDataSize += uint64(size)
+					tableDataSize += uint64(size)
+				}
+				backupDataSize += tableDataSize
+				metrics.TableLastBackupSize.WithLabelValues(table.Database, table.Name).Set(float64(tableDataSize))
+				metrics.TableLastBackupDuration.WithLabelValues(table.Database, table.Name).Set(time.Since(startTable).Seconds())
+				if len(disksToPartsMap) > 0 {
+					if partitionsStats, err = b.getTablePartitionsStats(ctx, &table, disksToPartsMap); err != nil {
+						log.Warnf("can't get rows count, restore validation will skip: %v", err)
+					}
 				}
 			}
 			// https://github.com/Altinity/clickhouse-backup/issues/529
@@ -211,6 +238,7 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, par
 					Parts:        disksToPartsMap,
 					Mutations:    inProgressMutations,
 					MetadataOnly: schemaOnly || table.BackupType == clickhouse.ShardBackupSchema,
+					Partitions:   partitionsStats,
 				}, disks)
 				if err != nil {
 					if removeBackupErr := b.RemoveBackupLocal(ctx, backupName, disks); removeBackupErr != nil {
@@ -219,11 +247,26 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, par
 					return err
 				}
 				backupMetadataSize += metadataSize
+				if b.cfg.General.DataChecksums && len(disksToPartsMap) > 0 {
+					checksumsSize, err := b.createTableChecksums(ctx, backupName, path.Join(backupPath, "metadata"), table.Database, table.Name, disksToPartsMap, diskMap, disks)
+					if err != nil {
+						if removeBackupErr := b.RemoveBackupLocal(ctx, backupName, disks); removeBackupErr != nil {
+							log.Error(removeBackupErr.Error())
+						}
+						return err
+					}
+					backupMetadataSize += checksumsSize
+				}
 				tableMetas = append(tableMetas, metadata.TableTitle{
 					Database: table.Database,
 					Table:    table.Name,
 				})
 			}
+			if doBackupData {
+				status.Current.AddProgress(ctx, 1, table.TotalBytes)
+			} else {
+				status.Current.AddProgress(ctx, 1, 0)
+			}
 			log.Infof("done")
 		}
 	}
@@ -613,6 +656,40 @@ func (b *Backuper) AddTableToBackup(ctx context.Context, backupName, shadowBacku
 	return disksToPartsMap, realSize, nil
 }
 
+// getTablePartitionsStats - rows and uncompressed size of frozen parts, merged parts are still present in system.parts as inactive
+func (b *Backuper) getTablePartitionsStats(ctx context.Context, table *clickhouse.Table, disksToPartsMap map[string][]metadata.Part) (map[string]metadata.PartitionStats, error) {
+	partsStats := make([]struct {
+		Name              string `ch:"name"`
+		Rows              uint64 `ch:"rows"`
+		UncompressedBytes uint64 `ch:"data_uncompressed_bytes"`
+	}, 0)
+	if err := b.ch.SelectContext(ctx, &partsStats, "SELECT name, rows, data_uncompressed_bytes FROM system.parts WHERE database=? AND table=?", table.Database, table.Name); err != nil {
+		return nil, err
+	}
+	partsStatsMap := make(map[string]metadata.PartitionStats, len(partsStats))
+	for _, partStats := range partsStats {
+		partsStatsMap[partStats.Name] = metadata.PartitionStats{Rows: partStats.Rows, UncompressedBytes: partStats.UncompressedBytes}
+	}
+	partitionsStats := make(map[string]metadata.PartitionStats)
+	for _, parts := range disksToPartsMap {
+		for _, part := range parts {
+			if strings.HasSuffix(part.Name, ".proj") {
+				continue
+			}
+			partStats, exists := partsStatsMap[part.Name]
+			if !exists {
+				return nil, fmt.Errorf("part %s not found in system.parts", part.Name)
+			}
+			partitionId := strings.Split(part.Name, "_")[0]
+			partitionStats := partitionsStats[partitionId]
+			partitionStats.Rows += partStats.Rows
+			partitionStats.UncompressedBytes += partStats.UncompressedBytes
+			partitionsStats[partitionId] = partitionStats
+		}
+	}
+	return partitionsStats, nil
+}
+
 func (b *Backuper) uploadObjectDiskParts(ctx context.Context, backupName, backupShadowPath string, disk clickhouse.Disk) (int64, error) {
 	var size int64
 	var err error
@@ -711,6 +788,10 @@ func (b *Backuper) createTableMetadata(metadataPath string, table metadata.Table
 	if err := filesystemhelper.Mkdir(metadataDatabasePath, b.ch, disks); err != nil {
 		return 0, err
 	}
+	for _, partitionStats := range table.Partitions {
+		table.TotalRows += partitionStats.Rows
+		table.UncompressedBytes += partitionStats.UncompressedBytes
+	}
 	metadataFile := path.Join(metadataDatabasePath, fmt.Sprintf("%s.json", common.TablePathEncode(table.Table)))
 	metadataBody, err := json.MarshalIndent(&table, "", " ")
 	if err != nil {
@@ -724,3 +805,55 @@ func (b *Backuper) createTableMetadata(metadataPath string, table metadata.Table
 	}
 	return uint64(len(metadataBody)), nil
 }
+
+// createTableChecksums calculate SHA-256 for each file in backup data parts, download check it before data will attach
+func (b *Backuper) createTableChecksums(ctx context.Context, backupName, metadataPath, database, table string, disksToPartsMap map[string][]metadata.Part, diskMap map[string]string, disks []clickhouse.Disk) (uint64, error) {
+	start := time.Now()
+	encodedTablePath := path.Join(common.TablePathEncode(database), common.TablePathEncode(table))
+	tableChecksums := metadata.TableChecksums{}
+	for disk, parts := range disksToPartsMap {
+		backupShadowPath := path.Join(diskMap[disk], "backup", backupName, "shadow", encodedTablePath, disk)
+		for _, part := range parts {
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			default:
+			}
+			err := filepath.Walk(path.Join(backupShadowPath, part.Name), func(filePath string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				relativePath, err := filepath.Rel(backupShadowPath, filePath)
+				if err != nil {
+					return err
+				}
+				checksum, err := metadata.CalculateFileChecksum(filePath)
+				if err != nil {
+					return err
+				}
+				tableChecksums[path.Join(disk, filepath.ToSlash(relativePath))] = checksum
+				return nil
+			})
+			if err != nil {
+				return 0, fmt.Errorf("can't calculate checksums for %s: %v", path.Join(backupShadowPath, part.Name), err)
+			}
+		}
+	}
+	checksumsFile := path.Join(metadataPath, common.TablePathEncode(database), fmt.Sprintf("%s.%s", common.TablePathEncode(table), metadata.ChecksumsFileExtension))
+	size, err := tableChecksums.Save(checksumsFile)
+	if err != nil {
+		return 0, fmt.Errorf("can't create %s: %v", checksumsFile, err)
+	}
+	if err = filesystemhelper.Chown(checksumsFile, b.ch, disks, false); err != nil {
+		return 0, err
+	}
+	b.log.WithFields(apexLog.Fields{
+		"table":    fmt.Sprintf("%s.%s", database, table),
+		"files":    len(tableChecksums),
+		"duration": utils.HumanizeDuration(time.Since(start)),
+	}).Debug("checksums calculated")
+	return size, nil
+}
@@ -6,11 +6,16 @@ import (
 	"github.com/Altinity/clickhouse-backup/pkg/status"
 )
 
-func (b *Backuper) CreateToRemote(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, resume bool, version string, commandId int) error {
+func (b *Backuper) CreateToRemote(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, backupRBAC, rbacOnly, backupConfigs, configsOnly, skipCheckPartsColumns, resume bool, version string, commandId int) (err error) {
+	notify := b.startNotification("create_remote")
+	defer func() {
+		b.finishNotification(notify, backupName, err)
+	}()
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
 	}
+	b.setCommandLog(commandId)
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 	if backupName == "" {
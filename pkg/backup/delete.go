@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
@@ -69,6 +70,7 @@ func (b *Backuper) Delete(backupType, backupName string, commandId int) error {
 	if err != nil {
 		return err
 	}
+	b.setCommandLog(commandId)
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 
@@ -82,10 +84,93 @@ func (b *Backuper) Delete(backupType, backupName string, commandId int) error {
 	}
 }
 
-func (b *Backuper) RemoveOldBackupsLocal(ctx context.Context, keepLastBackup bool, disks []clickhouse.Disk) error {
+// Retention - delete old local or remote backups according to `retention` policy or `backups_to_keep_*`, dryRun only print backups which will be deleted
+func (b *Backuper) Retention(location string, dryRun bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	b.setCommandLog(commandId)
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	log := b.log.WithFields(apexLog.Fields{
+		"location":  location,
+		"operation": "retention",
+		"dry_run":   dryRun,
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	switch location {
+	case "local":
+		if err = b.ch.Connect(); err != nil {
+			return fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		defer b.ch.Close()
+		backupsToDelete, disks, err := b.getOldBackupsLocal(ctx, true, nil)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			if err = printBackupsLocal(ctx, w, backupsToDelete, "all"); err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+		for _, backup := range backupsToDelete {
+			if err = b.RemoveBackupLocal(ctx, backup.BackupName, disks); err != nil {
+				return err
+			}
+			b.notifyRetention("local", backup.BackupName)
+		}
+		log.WithField("deleted", len(backupsToDelete)).Info("done")
+	case "remote":
+		if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+			return fmt.Errorf("general->remote_storage: %s doesn't support retention", b.cfg.General.RemoteStorage)
+		}
+		if err = b.ch.Connect(); err != nil {
+			return fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		defer b.ch.Close()
+		bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+		if err != nil {
+			return err
+		}
+		if err = bd.Connect(ctx); err != nil {
+			return fmt.Errorf("can't connect to remote storage: %v", err)
+		}
+		defer func() {
+			if err := bd.Close(ctx); err != nil {
+				b.log.Warnf("can't close BackupDestination error: %v", err)
+			}
+		}()
+		backupsToDelete, err := bd.GetOldBackups(ctx, b.cfg.General.BackupsToKeepRemote, b.cfg.Retention.Remote, b.retentionBackupNameRE)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			if err = printBackupsRemote(w, backupsToDelete, "all"); err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+		for _, backup := range backupsToDelete {
+			if err = b.RemoveBackupRemote(ctx, backup.BackupName); err != nil {
+				return err
+			}
+			b.notifyRetention("remote", backup.BackupName)
+		}
+		log.WithField("deleted", len(backupsToDelete)).Info("done")
+	default:
+		return fmt.Errorf("unknown backup type")
+	}
+	return nil
+}
+
+// getOldBackupsLocal return local backups which shall be deleted according to `retention->local` policy or `backups_to_keep_local`, except pinned
+func (b *Backuper) getOldBackupsLocal(ctx context.Context, keepLastBackup bool, disks []clickhouse.Disk) ([]LocalBackup, []clickhouse.Disk, error) {
 	keep := b.cfg.General.BackupsToKeepLocal
-	if keep == 0 {
-		return nil
+	policy := b.cfg.Retention.Local
+	if keep == 0 && !policy.Enabled() {
+		return []LocalBackup{}, disks, nil
 	}
 	// fix https://github.com/Altinity/clickhouse-backup/issues/698
 	if keep < 0 {
@@ -96,14 +181,37 @@ func (b *Backuper) RemoveOldBackupsLocal(ctx context.Context, keepLastBackup boo
 	}
 
 	backupList, disks, err := b.GetLocalBackups(ctx, disks)
+	if err != nil {
+		return nil, nil, err
+	}
+	retainedList := backupList
+	if b.retentionBackupNameRE != nil {
+		retainedList = make([]LocalBackup, 0, len(backupList))
+		for _, backup := range backupList {
+			if b.retentionBackupNameRE.MatchString(backup.BackupName) {
+				retainedList = append(retainedList, backup)
+			}
+		}
+	}
+	var backupsToDelete []LocalBackup
+	if policy.Enabled() {
+		backupsToDelete = GetBackupsToDeleteByRetention(retainedList, policy)
+	} else {
+		backupsToDelete = GetBackupsToDelete(retainedList, keep)
+	}
+	return b.excludeProtectedBackupsLocal(backupList, backupsToDelete, disks), disks, nil
+}
+
+func (b *Backuper) RemoveOldBackupsLocal(ctx context.Context, keepLastBackup bool, disks []clickhouse.Disk) error {
+	backupsToDelete, disks, err := b.getOldBackupsLocal(ctx, keepLastBackup, disks)
 	if err != nil {
 		return err
 	}
-	backupsToDelete := GetBackupsToDelete(backupList, keep)
 	for _, backup := range backupsToDelete {
 		if err := b.RemoveBackupLocal(ctx, backup.BackupName, disks); err != nil {
 			return err
 		}
+		b.notifyRetention("local", backup.BackupName)
 	}
 	return nil
 }
@@ -147,6 +255,9 @@ func (b *Backuper) RemoveBackupLocal(ctx context.Context, backupName string, dis
 
 	for _, backup := range backupList {
 		if backup.BackupName == backupName {
+			if err = checkLocalBackupIsNotPinned(backupList, backupName, disks); err != nil {
+				return err
+			}
 			var skip bool
 			skip, err = b.skipIfTheSameRemoteBackupPresent(ctx, backup.BackupName, backup.Tags)
 			if err != nil {
@@ -318,6 +429,9 @@ func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) er
 	}
 	for _, backup := range backupList {
 		if backup.BackupName == backupName {
+			if err = checkRemoteBackupIsNotPinned(ctx, bd, backupName); err != nil {
+				return err
+			}
 			if skip, err := b.skipIfSameLocalBackupPresent(ctx, backup.BackupName, backup.Tags); err != nil {
 				return err
 			} else if !skip {
@@ -393,7 +507,7 @@ func (b *Backuper) cleanRemoteBackupObjectDisks(ctx context.Context, backup stor
 					}
 				} else if regexp.MustCompile(`/shadow/[^/]+/[^/]+/` + diskName + `/.+$`).MatchString(fName) {
 					// non compressed remote object disk part
-					objMetaReader, err := b.dst.GetFileReader(ctx, fName)
+					objMetaReader, err := b.dst.GetDataFileReader(ctx, fName)
 					if err != nil {
 						return err
 					}
@@ -457,6 +571,7 @@ func (b *Backuper) CleanRemoteBroken(commandId int) error {
 	if err != nil {
 		return err
 	}
+	b.setCommandLog(commandId)
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 
@@ -467,6 +582,10 @@ func (b *Backuper) CleanRemoteBroken(commandId int) error {
 	for _, backup := range remoteBackups {
 		if backup.Broken != "" {
 			if err = b.RemoveBackupRemote(ctx, backup.BackupName); err != nil {
+				if errors.Is(err, ErrBackupIsPinned) {
+					b.log.WithField("backup", backup.BackupName).Warnf("skip clean broken backup: %v", err)
+					continue
+				}
 				return err
 			}
 		}
@@ -0,0 +1,400 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	DiffStatusAdded   = "added"
+	DiffStatusRemoved = "removed"
+	DiffStatusChanged = "changed"
+)
+
+// BackupDiff - difference between two backups, BackupA is baseline, so `added` means present only in BackupB
+type BackupDiff struct {
+	Location  string         `json:"location"`
+	BackupA   string         `json:"backup_a"`
+	BackupB   string         `json:"backup_b"`
+	Databases []DatabaseDiff `json:"databases"`
+	Tables    []TableDiff    `json:"tables"`
+	SizeA     int64          `json:"size_a"`
+	SizeB     int64          `json:"size_b"`
+	SizeDelta int64          `json:"size_delta"`
+}
+
+type DatabaseDiff struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	EngineA string `json:"engine_a,omitempty"`
+	EngineB string `json:"engine_b,omitempty"`
+	QueryA  string `json:"query_a,omitempty"`
+	QueryB  string `json:"query_b,omitempty"`
+}
+
+type TableDiff struct {
+	Database          string   `json:"database"`
+	Table             string   `json:"table"`
+	Status            string   `json:"status"`
+	QueryA            string   `json:"query_a,omitempty"`
+	QueryB            string   `json:"query_b,omitempty"`
+	PartsOnlyInA      []string `json:"parts_only_in_a,omitempty"`
+	PartsOnlyInB      []string `json:"parts_only_in_b,omitempty"`
+	PartitionsOnlyInA []string `json:"partitions_only_in_a,omitempty"`
+	PartitionsOnlyInB []string `json:"partitions_only_in_b,omitempty"`
+	SizeA             int64    `json:"size_a"`
+	SizeB             int64    `json:"size_b"`
+	SizeDelta         int64    `json:"size_delta"`
+}
+
+// backupForDiff - backup metadata with all table metadata
+type backupForDiff struct {
+	metadata.BackupMetadata
+	tables map[metadata.TableTitle]metadata.TableMetadata
+}
+
+// Diff - print difference between two local or remote backups in text or json format
+func (b *Backuper) Diff(location, backupA, backupB, format string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	b.setCommandLog(commandId)
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown diff format '%s', use 'text' or 'json'", format)
+	}
+	diff, err := b.GetBackupDiff(ctx, location, backupA, backupB)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		return encoder.Encode(diff)
+	}
+	return printBackupDiff(os.Stdout, diff)
+}
+
+// GetBackupDiff - compare two local or remote backups based on backup and table metadata, data is not downloaded
+func (b *Backuper) GetBackupDiff(ctx context.Context, location, backupA, backupB string) (*BackupDiff, error) {
+	backupA = utils.CleanBackupNameRE.ReplaceAllString(backupA, "")
+	backupB = utils.CleanBackupNameRE.ReplaceAllString(backupB, "")
+	if backupA == "" || backupB == "" {
+		return nil, fmt.Errorf("two backup names required for diff")
+	}
+	if err := b.ch.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	var a, bb *backupForDiff
+	var err error
+	switch location {
+	case "local":
+		if a, err = b.getBackupForDiffLocal(ctx, backupA); err != nil {
+			return nil, err
+		}
+		if bb, err = b.getBackupForDiffLocal(ctx, backupB); err != nil {
+			return nil, err
+		}
+	case "remote":
+		if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+			return nil, fmt.Errorf("general->remote_storage: %s doesn't support diff", b.cfg.General.RemoteStorage)
+		}
+		bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+		if err != nil {
+			return nil, err
+		}
+		if err = bd.Connect(ctx); err != nil {
+			return nil, fmt.Errorf("can't connect to remote storage: %v", err)
+		}
+		defer func() {
+			if err := bd.Close(ctx); err != nil {
+				b.log.Warnf("can't close BackupDestination error: %v", err)
+			}
+		}()
+		b.dst = bd
+		if a, err = b.getBackupForDiffRemote(ctx, backupA); err != nil {
+			return nil, err
+		}
+		if bb, err = b.getBackupForDiffRemote(ctx, backupB); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown backup type")
+	}
+	return diffBackups(location, a, bb), nil
+}
+
+func (b *Backuper) getBackupForDiffLocal(ctx context.Context, backupName string) (*backupForDiff, error) {
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if b.DefaultDataPath, err = b.ch.GetDefaultPath(disks); err != nil {
+		return nil, ErrUnknownClickhouseDataPath
+	}
+	for _, disk := range disks {
+		if b.cfg.ClickHouse.UseEmbeddedBackupRestore && (disk.IsBackup || disk.Name == b.cfg.ClickHouse.EmbeddedBackupDisk) {
+			b.EmbeddedBackupDataPath = disk.Path
+		}
+	}
+	backupMetadata, err := b.ReadBackupMetadataLocal(ctx, backupName)
+	if err != nil {
+		return nil, fmt.Errorf("can't read '%s' local backup metadata: %v", backupName, err)
+	}
+	metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
+	if strings.Contains(backupMetadata.Tags, "embedded") {
+		metadataPath = path.Join(b.EmbeddedBackupDataPath, backupName, "metadata")
+	}
+	result := &backupForDiff{BackupMetadata: *backupMetadata, tables: make(map[metadata.TableTitle]metadata.TableMetadata, len(backupMetadata.Tables))}
+	for _, tableTitle := range backupMetadata.Tables {
+		var table metadata.TableMetadata
+		tableMetadataFile := path.Join(metadataPath, common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
+		if _, err = table.Load(tableMetadataFile); err != nil {
+			return nil, err
+		}
+		result.tables[tableTitle] = table
+	}
+	return result, nil
+}
+
+func (b *Backuper) getBackupForDiffRemote(ctx context.Context, backupName string) (*backupForDiff, error) {
+	backupList, err := b.dst.BackupList(ctx, true, backupName)
+	if err != nil {
+		return nil, err
+	}
+	var remoteBackup *storage.Backup
+	for i := range backupList {
+		if backupList[i].BackupName == backupName {
+			remoteBackup = &backupList[i]
+			break
+		}
+	}
+	if remoteBackup == nil {
+		return nil, fmt.Errorf("'%s' is not found on remote storage", backupName)
+	}
+	if remoteBackup.Broken != "" {
+		return nil, fmt.Errorf("'%s' is %s", backupName, remoteBackup.Broken)
+	}
+	if remoteBackup.Legacy {
+		return nil, fmt.Errorf("'%s' is old-format backup, diff is not supported", backupName)
+	}
+	result := &backupForDiff{BackupMetadata: remoteBackup.BackupMetadata, tables: make(map[metadata.TableTitle]metadata.TableMetadata, len(remoteBackup.Tables))}
+	tablesLock := sync.Mutex{}
+	diffSemaphore := semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
+	diffGroup, diffCtx := errgroup.WithContext(ctx)
+	for _, t := range remoteBackup.Tables {
+		if err := diffSemaphore.Acquire(diffCtx, 1); err != nil {
+			b.log.Errorf("can't acquire semaphore during Diff: %v", err)
+			break
+		}
+		tableTitle := t
+		diffGroup.Go(func() error {
+			defer diffSemaphore.Release(1)
+			table, err := b.readTableMetadataRemote(diffCtx, backupName, tableTitle)
+			if err != nil {
+				return err
+			}
+			tablesLock.Lock()
+			result.tables[tableTitle] = *table
+			tablesLock.Unlock()
+			return nil
+		})
+	}
+	if err := diffGroup.Wait(); err != nil {
+		return nil, fmt.Errorf("one of Diff go-routine return error: %v", err)
+	}
+	return result, nil
+}
+
+func diffBackups(location string, a, b *backupForDiff) *BackupDiff {
+	diff := &BackupDiff{
+		Location:  location,
+		BackupA:   a.BackupName,
+		BackupB:   b.BackupName,
+		Databases: make([]DatabaseDiff, 0),
+		Tables:    make([]TableDiff, 0),
+	}
+	databasesA := getDatabasesForDiff(a)
+	databasesB := getDatabasesForDiff(b)
+	for name, dbA := range databasesA {
+		dbB, exists := databasesB[name]
+		if !exists {
+			diff.Databases = append(diff.Databases, DatabaseDiff{Name: name, Status: DiffStatusRemoved, EngineA: dbA.Engine})
+			continue
+		}
+		// old backups don't contain databases metadata, so compare only when both present
+		if dbA.Query != "" && dbB.Query != "" && (dbA.Engine != dbB.Engine || dbA.Query != dbB.Query) {
+			diff.Databases = append(diff.Databases, DatabaseDiff{Name: name, Status: DiffStatusChanged, EngineA: dbA.Engine, EngineB: dbB.Engine, QueryA: dbA.Query, QueryB: dbB.Query})
+		}
+	}
+	for name, dbB := range databasesB {
+		if _, exists := databasesA[name]; !exists {
+			diff.Databases = append(diff.Databases, DatabaseDiff{Name: name, Status: DiffStatusAdded, EngineB: dbB.Engine})
+		}
+	}
+	sort.Slice(diff.Databases, func(i, j int) bool {
+		return diff.Databases[i].Name < diff.Databases[j].Name
+	})
+
+	for title, tableA := range a.tables {
+		sizeA := getTableSizeForDiff(tableA)
+		diff.SizeA += sizeA
+		tableB, exists := b.tables[title]
+		if !exists {
+			diff.Tables = append(diff.Tables, TableDiff{Database: title.Database, Table: title.Table, Status: DiffStatusRemoved, SizeA: sizeA, SizeDelta: -sizeA})
+			continue
+		}
+		sizeB := getTableSizeForDiff(tableB)
+		tableDiff := TableDiff{Database: title.Database, Table: title.Table, Status: DiffStatusChanged, SizeA: sizeA, SizeB: sizeB, SizeDelta: sizeB - sizeA}
+		if tableA.Query != tableB.Query {
+			tableDiff.QueryA = tableA.Query
+			tableDiff.QueryB = tableB.Query
+		}
+		partsA, partitionsA := getPartsForDiff(tableA)
+		partsB, partitionsB := getPartsForDiff(tableB)
+		tableDiff.PartsOnlyInA = subtractSortedKeys(partsA, partsB)
+		tableDiff.PartsOnlyInB = subtractSortedKeys(partsB, partsA)
+		tableDiff.PartitionsOnlyInA = subtractSortedKeys(partitionsA, partitionsB)
+		tableDiff.PartitionsOnlyInB = subtractSortedKeys(partitionsB, partitionsA)
+		if tableDiff.QueryA != "" || tableDiff.QueryB != "" || len(tableDiff.PartsOnlyInA) > 0 || len(tableDiff.PartsOnlyInB) > 0 || tableDiff.SizeDelta != 0 {
+			diff.Tables = append(diff.Tables, tableDiff)
+		}
+	}
+	for title, tableB := range b.tables {
+		if _, exists := a.tables[title]; !exists {
+			sizeB := getTableSizeForDiff(tableB)
+			diff.Tables = append(diff.Tables, TableDiff{Database: title.Database, Table: title.Table, Status: DiffStatusAdded, SizeB: sizeB, SizeDelta: sizeB})
+		}
+		diff.SizeB += getTableSizeForDiff(tableB)
+	}
+	sort.Slice(diff.Tables, func(i, j int) bool {
+		if diff.Tables[i].Database != diff.Tables[j].Database {
+			return diff.Tables[i].Database < diff.Tables[j].Database
+		}
+		return diff.Tables[i].Table < diff.Tables[j].Table
+	})
+	diff.SizeDelta = diff.SizeB - diff.SizeA
+	return diff
+}
+
+func getDatabasesForDiff(backup *backupForDiff) map[string]metadata.DatabasesMeta {
+	databases := make(map[string]metadata.DatabasesMeta)
+	for _, db := range backup.Databases {
+		databases[db.Name] = db
+	}
+	for title := range backup.tables {
+		if _, exists := databases[title.Database]; !exists {
+			databases[title.Database] = metadata.DatabasesMeta{Name: title.Database}
+		}
+	}
+	return databases
+}
+
+func getTableSizeForDiff(table metadata.TableMetadata) int64 {
+	size := int64(0)
+	for _, diskSize := range table.Size {
+		size += diskSize
+	}
+	return size
+}
+
+// getPartsForDiff return part names and partition ids, disk is ignored cause part could be moved between disks
+func getPartsForDiff(table metadata.TableMetadata) (map[string]struct{}, map[string]struct{}) {
+	parts := make(map[string]struct{})
+	partitions := make(map[string]struct{})
+	for _, diskParts := range table.Parts {
+		for _, part := range diskParts {
+			parts[part.Name] = struct{}{}
+			partitions[strings.Split(part.Name, "_")[0]] = struct{}{}
+		}
+	}
+	return parts, partitions
+}
+
+func subtractSortedKeys(a, b map[string]struct{}) []string {
+	result := make([]string, 0)
+	for key := range a {
+		if _, exists := b[key]; !exists {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func formatSizeDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + utils.FormatBytes(uint64(-delta))
+	}
+	return "+" + utils.FormatBytes(uint64(delta))
+}
+
+func printBackupDiff(w io.Writer, diff *BackupDiff) error {
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	statusSign := map[string]string{DiffStatusAdded: "+", DiffStatusRemoved: "-", DiffStatusChanged: "~"}
+	printf("%s backup diff: %s -> %s\n", diff.Location, diff.BackupA, diff.BackupB)
+	printf("size: %s -> %s (%s)\n", utils.FormatBytes(uint64(diff.SizeA)), utils.FormatBytes(uint64(diff.SizeB)), formatSizeDelta(diff.SizeDelta))
+	if len(diff.Databases) > 0 {
+		printf("databases:\n")
+	}
+	for _, db := range diff.Databases {
+		switch db.Status {
+		case DiffStatusChanged:
+			printf("  %s %s engine: %s -> %s\n", statusSign[db.Status], db.Name, db.EngineA, db.EngineB)
+			if db.QueryA != db.QueryB {
+				printf("    - %s\n    + %s\n", db.QueryA, db.QueryB)
+			}
+		default:
+			printf("  %s %s %s\n", statusSign[db.Status], db.Name, db.EngineA+db.EngineB)
+		}
+	}
+	if len(diff.Tables) > 0 {
+		printf("tables:\n")
+	}
+	for _, table := range diff.Tables {
+		printf("  %s %s.%s size: %s -> %s (%s)\n", statusSign[table.Status], table.Database, table.Table, utils.FormatBytes(uint64(table.SizeA)), utils.FormatBytes(uint64(table.SizeB)), formatSizeDelta(table.SizeDelta))
+		if table.QueryA != table.QueryB {
+			printf("    schema:\n      - %s\n      + %s\n", table.QueryA, table.QueryB)
+		}
+		for _, item := range []struct {
+			title string
+			names []string
+		}{
+			{"partitions only in " + diff.BackupA, table.PartitionsOnlyInA},
+			{"partitions only in " + diff.BackupB, table.PartitionsOnlyInB},
+			{"parts only in " + diff.BackupA, table.PartsOnlyInA},
+			{"parts only in " + diff.BackupB, table.PartsOnlyInB},
+		} {
+			if len(item.names) > 0 {
+				printf("    %s: %s\n", item.title, strings.Join(item.names, ", "))
+			}
+		}
+	}
+	if len(diff.Databases) == 0 && len(diff.Tables) == 0 {
+		printf("no differences found\n")
+	}
+	return err
+}
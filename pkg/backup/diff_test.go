@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffBackups(t *testing.T) {
+	a := &backupForDiff{
+		BackupMetadata: metadata.BackupMetadata{
+			BackupName: "a",
+			Databases: []metadata.DatabasesMeta{
+				{Name: "db1", Engine: "Atomic", Query: "CREATE DATABASE db1 ENGINE=Atomic"},
+				{Name: "db2", Engine: "Atomic", Query: "CREATE DATABASE db2 ENGINE=Atomic"},
+			},
+		},
+		tables: map[metadata.TableTitle]metadata.TableMetadata{
+			{Database: "db1", Table: "same"}: {
+				Query: "CREATE TABLE db1.same (id UInt64) ENGINE=MergeTree ORDER BY id",
+				Size:  map[string]int64{"default": 100},
+				Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}},
+			},
+			{Database: "db1", Table: "changed"}: {
+				Query: "CREATE TABLE db1.changed (id UInt64) ENGINE=MergeTree PARTITION BY id ORDER BY id",
+				Size:  map[string]int64{"default": 100, "hdd": 50},
+				Parts: map[string][]metadata.Part{"default": {{Name: "1_1_1_0"}}, "hdd": {{Name: "2_2_2_0"}}},
+			},
+			{Database: "db2", Table: "removed"}: {
+				Size: map[string]int64{"default": 10},
+			},
+		},
+	}
+	b := &backupForDiff{
+		BackupMetadata: metadata.BackupMetadata{
+			BackupName: "b",
+			Databases: []metadata.DatabasesMeta{
+				{Name: "db1", Engine: "Replicated", Query: "CREATE DATABASE db1 ENGINE=Replicated('/db1','{shard}','{replica}')"},
+				{Name: "db3", Engine: "Atomic", Query: "CREATE DATABASE db3 ENGINE=Atomic"},
+			},
+		},
+		tables: map[metadata.TableTitle]metadata.TableMetadata{
+			{Database: "db1", Table: "same"}: {
+				Query: "CREATE TABLE db1.same (id UInt64) ENGINE=MergeTree ORDER BY id",
+				Size:  map[string]int64{"default": 100},
+				Parts: map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}},
+			},
+			{Database: "db1", Table: "changed"}: {
+				Query: "CREATE TABLE db1.changed (id UInt64, v String) ENGINE=MergeTree PARTITION BY id ORDER BY id",
+				Size:  map[string]int64{"default": 120},
+				Parts: map[string][]metadata.Part{"default": {{Name: "2_2_2_0"}, {Name: "3_3_3_0"}}},
+			},
+			{Database: "db3", Table: "added"}: {
+				Size: map[string]int64{"default": 30},
+			},
+		},
+	}
+
+	diff := diffBackups("local", a, b)
+	require.Len(t, diff.Databases, 3)
+	assert.Equal(t, DatabaseDiff{Name: "db1", Status: DiffStatusChanged, EngineA: "Atomic", EngineB: "Replicated", QueryA: a.Databases[0].Query, QueryB: b.Databases[0].Query}, diff.Databases[0])
+	assert.Equal(t, DatabaseDiff{Name: "db2", Status: DiffStatusRemoved, EngineA: "Atomic"}, diff.Databases[1])
+	assert.Equal(t, DatabaseDiff{Name: "db3", Status: DiffStatusAdded, EngineB: "Atomic"}, diff.Databases[2])
+
+	require.Len(t, diff.Tables, 3)
+	changed := diff.Tables[0]
+	assert.Equal(t, "changed", changed.Table)
+	assert.Equal(t, DiffStatusChanged, changed.Status)
+	assert.NotEmpty(t, changed.QueryA)
+	assert.NotEmpty(t, changed.QueryB)
+	assert.Equal(t, []string{"1_1_1_0"}, changed.PartsOnlyInA)
+	assert.Equal(t, []string{"3_3_3_0"}, changed.PartsOnlyInB)
+	assert.Equal(t, []string{"1"}, changed.PartitionsOnlyInA)
+	assert.Equal(t, []string{"3"}, changed.PartitionsOnlyInB)
+	assert.Equal(t, int64(-30), changed.SizeDelta)
+	assert.Equal(t, TableDiff{Database: "db2", Table: "removed", Status: DiffStatusRemoved, SizeA: 10, SizeDelta: -10}, diff.Tables[1])
+	assert.Equal(t, TableDiff{Database: "db3", Table: "added", Status: DiffStatusAdded, SizeB: 30, SizeDelta: 30}, diff.Tables[2])
+
+	assert.Equal(t, int64(260), diff.SizeA)
+	assert.Equal(t, int64(250), diff.SizeB)
+	assert.Equal(t, int64(-10), diff.SizeDelta)
+
+	out := bytes.Buffer{}
+	require.NoError(t, printBackupDiff(&out, diff))
+	assert.Contains(t, out.String(), "~ db1.changed")
+	assert.Contains(t, out.String(), "parts only in b: 3_3_3_0")
+
+	same := diffBackups("remote", a, a)
+	assert.Empty(t, same.Databases)
+	assert.Empty(t, same.Tables)
+}
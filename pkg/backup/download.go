@@ -9,6 +9,7 @@ import (
 	"github.com/Altinity/clickhouse-backup/pkg/config"
 	"github.com/Altinity/clickhouse-backup/pkg/custom"
 	"github.com/Altinity/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/Altinity/clickhouse-backup/pkg/metrics"
 	"github.com/Altinity/clickhouse-backup/pkg/partition"
 	"github.com/Altinity/clickhouse-backup/pkg/resumable"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
@@ -18,6 +19,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -56,7 +58,7 @@ func (b *Backuper) legacyDownload(ctx context.Context, backupName string) error
 			b.log.Warnf("can't close BackupDestination error: %v", err)
 		}
 	}()
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := metrics.NewRetrier("download", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
 		return bd.DownloadCompressedStream(ctx, backupName, path.Join(b.DefaultDataPath, "backup", backupName))
 	})
@@ -67,14 +69,20 @@ func (b *Backuper) legacyDownload(ctx context.Context, backupName string) error
 	return nil
 }
 
-func (b *Backuper) Download(backupName string, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) error {
+func (b *Backuper) Download(backupName string, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) (err error) {
+	notify := b.startNotification("download")
+	defer func() {
+		b.finishNotification(notify, backupName, err)
+	}()
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
 	}
+	b.setCommandLog(commandId)
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	status.Current.SetBackupName(commandId, backupName)
 	if err := b.ch.Connect(); err != nil {
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
 	}
@@ -157,6 +165,10 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	if len(remoteBackup.Tables) == 0 && !b.cfg.General.AllowEmptyBackups {
 		return fmt.Errorf("'%s' is empty backup", backupName)
 	}
+	if remoteBackup.EncryptionKeyID != "" && !schemaOnly && !b.dst.HasEncryptionKey(remoteBackup.EncryptionKeyID) {
+		return fmt.Errorf("'%s' encrypted with %s key id=%s, which is not found in `encryption` config section", backupName, remoteBackup.EncryptionAlgorithm, remoteBackup.EncryptionKeyID)
+	}
+	ctx = storage.WithEncryptionRequired(ctx, remoteBackup.EncryptionKeyID != "")
 	tablesForDownload := parseTablePatternForDownload(remoteBackup.Tables, tablePattern)
 
 	if !schemaOnly && !b.cfg.General.DownloadByPart && remoteBackup.RequiredBackup != "" {
@@ -216,6 +228,20 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	if err := metadataGroup.Wait(); err != nil {
 		return fmt.Errorf("one of Download Metadata go-routine return error: %v", err)
 	}
+	tablesTotal, dataTablesTotal, bytesTotal := uint64(0), uint64(0), uint64(0)
+	for _, t := range tableMetadataAfterDownload {
+		// skipped by engine
+		if t.Table == "" {
+			continue
+		}
+		tablesTotal++
+		if !schemaOnly && !t.MetadataOnly {
+			dataTablesTotal++
+			bytesTotal += getTableDownloadSize(t)
+		}
+	}
+	status.Current.StartProgress(ctx, "download", tablesTotal, bytesTotal)
+	status.Current.AddProgress(ctx, tablesTotal-dataTablesTotal, 0)
 	if !schemaOnly {
 		for _, t := range tableMetadataAfterDownload {
 			for disk := range t.Parts {
@@ -244,6 +270,14 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 				if err := b.downloadTableData(dataCtx, remoteBackup.BackupMetadata, tableMetadataAfterDownload[idx]); err != nil {
 					return err
 				}
+				if !b.isEmbedded {
+					if err := b.checkTableChecksums(dataCtx, backupName, tableMetadataAfterDownload[idx], disks); err != nil {
+						return err
+					}
+				}
+				if tableMetadataAfterDownload[idx].Table != "" {
+					status.Current.AddProgress(dataCtx, 1, 0)
+				}
 				log.
 					WithField("operation", "download_data").
 					WithField("table", fmt.Sprintf("%s.%s", tableMetadataAfterDownload[idx].Database, tableMetadataAfterDownload[idx].Table)).
@@ -308,6 +342,7 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 		b.resumableState.Close()
 	}
 
+	status.Current.AddBytesTransferred(commandId, dataSize+metadataSize+rbacSize+configSize)
 	log.
 		WithField("duration", utils.HumanizeDuration(time.Since(startDownload))).
 		WithField("size", utils.FormatBytes(dataSize+metadataSize+rbacSize+configSize)).
@@ -358,7 +393,7 @@ func (b *Backuper) downloadTableMetadata(ctx context.Context, backupName string,
 			}
 		}
 		var tmBody []byte
-		retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+		retry := metrics.NewRetrier("download", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 		err := retry.RunCtx(ctx, func(ctx context.Context) error {
 			tmReader, err := b.dst.GetFileReader(ctx, remoteMetadataFile)
 			if err != nil {
@@ -473,7 +508,7 @@ func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup st
 		log.Debugf("%s not exists on remote storage, skip download", remoteSource)
 		return 0, nil
 	}
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := metrics.NewRetrier("download", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
 		return b.dst.DownloadCompressedStream(ctx, remoteSource, localDir)
 	})
@@ -491,7 +526,8 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 
 	s := semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
-	g, dataCtx := errgroup.WithContext(ctx)
+	// progress bytes are counted while remote files are read, required parts of diff backups are not a part of progress total
+	g, dataCtx := errgroup.WithContext(status.WithBytesProgress(ctx))
 
 	if remoteBackup.DataFormat != DirectoryFormat {
 		capacity := 0
@@ -519,10 +555,12 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 					defer s.Release(1)
 					log.Debugf("start download %s", tableRemoteFile)
 					if b.resume && b.resumableState.IsAlreadyProcessedBool(tableRemoteFile) {
+						status.Current.AddProgress(dataCtx, 0, uint64(table.FilesSize[archiveFile]))
 						return nil
 					}
-					retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
-					err := retry.RunCtx(dataCtx, func(dataCtx context.Context) error {
+					retry := metrics.NewRetrier("download", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
+					// each attempt reads archive from the beginning, progress counts only bytes beyond previous attempts
+					err := retry.RunCtx(storage.WithRetriedReadProgress(dataCtx), func(dataCtx context.Context) error {
 						return b.dst.DownloadCompressedStream(dataCtx, tableRemoteFile, tableLocalDir)
 					})
 					if err != nil {
@@ -561,10 +599,12 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 					break breakByErrorDirectory
 				}
 				partLocalPath := path.Join(tableLocalPath, part.Name)
+				partSize := uint64(part.Size)
 				g.Go(func() error {
 					defer s.Release(1)
 					log.Debugf("start %s -> %s", partRemotePath, partLocalPath)
 					if b.resume && b.resumableState.IsAlreadyProcessedBool(partRemotePath) {
+						status.Current.AddProgress(dataCtx, 0, partSize)
 						return nil
 					}
 					if err := b.dst.DownloadPath(dataCtx, 0, partRemotePath, partLocalPath, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration); err != nil {
@@ -593,8 +633,95 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 	return nil
 }
 
+// checkTableChecksums compare downloaded files with checksums manifest, to avoid attach corrupted data, backups without manifest are skipped
+func (b *Backuper) checkTableChecksums(ctx context.Context, backupName string, table metadata.TableMetadata, disks []clickhouse.Disk) error {
+	log := b.log.WithFields(apexLog.Fields{
+		"logger": "checkTableChecksums",
+		"table":  fmt.Sprintf("%s.%s", table.Database, table.Table),
+	})
+	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	checksumsFileName := fmt.Sprintf("%s.%s", common.TablePathEncode(table.Table), metadata.ChecksumsFileExtension)
+	remoteChecksumsFile := path.Join(backupName, "metadata", common.TablePathEncode(table.Database), checksumsFileName)
+	if _, err := b.dst.StatFile(ctx, remoteChecksumsFile); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Debugf("%s not found, skip checksums verification", remoteChecksumsFile)
+			return nil
+		}
+		return err
+	}
+	var body []byte
+	retry := metrics.NewRetrier("download", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		r, err := b.dst.GetFileReader(ctx, remoteChecksumsFile)
+		if err != nil {
+			return err
+		}
+		if body, err = io.ReadAll(r); err != nil {
+			_ = r.Close()
+			return err
+		}
+		return r.Close()
+	})
+	if err != nil {
+		return err
+	}
+	tableChecksums := metadata.TableChecksums{}
+	if err = tableChecksums.Unmarshal(body); err != nil {
+		return fmt.Errorf("can't parse %s: %v", remoteChecksumsFile, err)
+	}
+	downloadedParts := map[string]map[string]struct{}{}
+	for disk, parts := range table.Parts {
+		downloadedParts[disk] = map[string]struct{}{}
+		for _, part := range parts {
+			downloadedParts[disk][part.Name] = struct{}{}
+		}
+	}
+	start := time.Now()
+	checkedFiles := 0
+	problems := make([]string, 0)
+	for key, expectedChecksum := range tableChecksums {
+		disk, partName := metadata.SplitChecksumKey(key)
+		if _, exists := downloadedParts[disk][partName]; !exists {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		localFile := path.Join(b.getLocalBackupDataPathForTable(backupName, disk, dbAndTableDir), strings.TrimPrefix(key, disk+"/"))
+		checksum, err := metadata.CalculateFileChecksum(localFile)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", localFile, err))
+			continue
+		}
+		if checksum != expectedChecksum {
+			problems = append(problems, fmt.Sprintf("%s: sha256 %s not equal %s from %s", localFile, checksum, expectedChecksum, remoteChecksumsFile))
+		}
+		checkedFiles++
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s.%s data corrupted, %d files not match with checksums:\n%s", table.Database, table.Table, len(problems), strings.Join(problems, "\n"))
+	}
+	localChecksumsFile := path.Join(b.DefaultDataPath, "backup", backupName, "metadata", common.TablePathEncode(table.Database), checksumsFileName)
+	if _, err = tableChecksums.Save(localChecksumsFile); err != nil {
+		return err
+	}
+	if err = filesystemhelper.Chown(localChecksumsFile, b.ch, disks, false); err != nil {
+		return err
+	}
+	log.WithFields(apexLog.Fields{
+		"files":    checkedFiles,
+		"duration": utils.HumanizeDuration(time.Since(start)),
+	}).Debug("checksums verified")
+	return nil
+}
+
 func (b *Backuper) downloadDiffParts(ctx context.Context, remoteBackup metadata.BackupMetadata, table metadata.TableMetadata, dbAndTableDir string) error {
 	log := b.log.WithField("operation", "downloadDiffParts")
+	// parts belong to required backups, which have own encryption_key_id
+	ctx = storage.WithEncryptionRequired(ctx, false)
 	log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Table)).Debug("start")
 	start := time.Now()
 	downloadedDiffParts := uint32(0)
@@ -696,7 +823,7 @@ func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLo
 		namedLock.Lock()
 		diffRemoteFilesLock.Unlock()
 		if path.Ext(tableRemoteFile) != "" {
-			retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+			retry := metrics.NewRetrier("download", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 			err := retry.RunCtx(ctx, func(ctx context.Context) error {
 				return b.dst.DownloadCompressedStream(ctx, tableRemoteFile, tableLocalDir)
 			})
@@ -922,7 +1049,7 @@ func (b *Backuper) downloadSingleBackupFile(ctx context.Context, remoteFile stri
 		return nil
 	}
 	log := b.log.WithField("logger", "downloadSingleBackupFile")
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := metrics.NewRetrier("download", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 	err := retry.RunCtx(ctx, func(ctx context.Context) error {
 		remoteReader, err := b.dst.GetFileReader(ctx, remoteFile)
 		if err != nil {
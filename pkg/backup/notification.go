@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/notification"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+)
+
+// operationNotification - nil when operation is a part of create_remote or restore_remote
+type operationNotification struct {
+	operation string
+	start     time.Time
+}
+
+// startNotification - only the outer operation notifies, watch notifies after each create_remote
+func (b *Backuper) startNotification(operation string) *operationNotification {
+	if b.isNotifying {
+		return nil
+	}
+	b.isNotifying = true
+	return &operationNotification{operation: operation, start: time.Now()}
+}
+
+func (b *Backuper) finishNotification(n *operationNotification, backupName string, err error) {
+	if n == nil {
+		return
+	}
+	b.isNotifying = false
+	event := notification.Notification{
+		Event:      config.NotificationEventSuccess,
+		Operation:  n.operation,
+		BackupName: backupName,
+		WatchJob:   b.watchJob,
+		Start:      n.start.Format(common.TimeFormat),
+		Duration:   utils.HumanizeDuration(time.Since(n.start)),
+	}
+	if err != nil {
+		event.Event = config.NotificationEventFailure
+		event.Error = err.Error()
+	}
+	notification.Send(b.cfg, event)
+}
+
+// notifyRetention - backup is deleted by backups_to_keep_local, backups_to_keep_remote or retention policy
+func (b *Backuper) notifyRetention(location, backupName string) {
+	notification.Send(b.cfg, notification.Notification{
+		Event:      config.NotificationEventRetention,
+		Operation:  "delete",
+		BackupName: backupName,
+		Location:   location,
+		WatchJob:   b.watchJob,
+	})
+}
@@ -0,0 +1,219 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+
+	apexLog "github.com/apex/log"
+)
+
+var (
+	ErrBackupIsPinned = errors.New("backup is pinned")
+)
+
+// Pin - protect local or remote backup from retention and delete commands
+func (b *Backuper) Pin(location, backupName string, commandId int) error {
+	return b.setPinned(location, backupName, true, commandId)
+}
+
+// Unpin - remove protection added by Pin
+func (b *Backuper) Unpin(location, backupName string, commandId int) error {
+	return b.setPinned(location, backupName, false, commandId)
+}
+
+func (b *Backuper) setPinned(location, backupName string, pinned bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	b.setCommandLog(commandId)
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	operation := "unpin"
+	if pinned {
+		operation = "pin"
+	}
+	start := time.Now()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	switch location {
+	case "local":
+		err = b.setPinnedLocal(ctx, backupName, pinned)
+	case "remote":
+		err = b.setPinnedRemote(ctx, backupName, pinned)
+	default:
+		err = fmt.Errorf("unknown backup type")
+	}
+	if err != nil {
+		return err
+	}
+	b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"location":  location,
+		"operation": operation,
+		"duration":  utils.HumanizeDuration(time.Since(start)),
+	}).Info("done")
+	return nil
+}
+
+func (b *Backuper) setPinnedLocal(ctx context.Context, backupName string, pinned bool) error {
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	disks, err := b.ch.GetDisks(ctx, true)
+	if err != nil {
+		return err
+	}
+	backupPath := ""
+	for _, p := range getLocalBackupPaths(backupName, disks) {
+		if _, err := os.Stat(path.Join(p, "metadata.json")); err == nil {
+			backupPath = p
+			break
+		}
+	}
+	if backupPath == "" {
+		return fmt.Errorf("'%s' is not found on local storage", backupName)
+	}
+	if !pinned {
+		for _, p := range getLocalBackupPaths(backupName, disks) {
+			if err = os.Remove(path.Join(p, storage.PinFileName)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	body, err := storage.NewPinMarker()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(backupPath, storage.PinFileName), body, 0640)
+}
+
+func (b *Backuper) setPinnedRemote(ctx context.Context, backupName string, pinned bool) error {
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("general->remote_storage: %s doesn't support pin", b.cfg.General.RemoteStorage)
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	// full list required to mark whole RequiredBackup chain
+	backupList, err := bd.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	for _, backup := range backupList {
+		if backup.BackupName != backupName {
+			continue
+		}
+		if backup.Legacy {
+			return fmt.Errorf("'%s' is legacy backup, pin is not supported", backupName)
+		}
+		requiredBackups := storage.GetRequiredBackups(storage.GetRetentionItems(backupList), backupName)
+		if !pinned {
+			return bd.UnpinBackup(ctx, backupName, requiredBackups)
+		}
+		return bd.PinBackup(ctx, backupName, requiredBackups)
+	}
+	return fmt.Errorf("'%s' is not found on remote storage", backupName)
+}
+
+// getLocalBackupPaths return all local paths where backupName could be stored, the same which cleaned by RemoveBackupLocal
+func getLocalBackupPaths(backupName string, disks []clickhouse.Disk) []string {
+	paths := make([]string, len(disks))
+	for i, disk := range disks {
+		if disk.IsBackup {
+			paths[i] = path.Join(disk.Path, backupName)
+		} else {
+			paths[i] = path.Join(disk.Path, "backup", backupName)
+		}
+	}
+	return paths
+}
+
+func isLocalBackupPinned(backupName string, disks []clickhouse.Disk) bool {
+	for _, p := range getLocalBackupPaths(backupName, disks) {
+		if _, err := os.Stat(path.Join(p, storage.PinFileName)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeProtectedBackupsLocal remove pinned local backups and their RequiredBackup chain from backupsToDelete
+func (b *Backuper) excludeProtectedBackupsLocal(backupList, backupsToDelete []LocalBackup, disks []clickhouse.Disk) []LocalBackup {
+	pinned := make(map[string]struct{})
+	for _, backup := range backupList {
+		if isLocalBackupPinned(backup.BackupName, disks) {
+			pinned[backup.BackupName] = struct{}{}
+		}
+	}
+	protected := storage.GetProtectedBackups(getLocalRetentionItems(backupList), pinned)
+	result := make([]LocalBackup, 0, len(backupsToDelete))
+	for _, backup := range backupsToDelete {
+		if _, isProtected := protected[backup.BackupName]; isProtected {
+			b.log.WithField("backup", backup.BackupName).Info("skip delete, local backup is pinned or required for pinned backup")
+			continue
+		}
+		result = append(result, backup)
+	}
+	return result
+}
+
+// checkLocalBackupIsNotPinned return ErrBackupIsPinned when backupName or any incremental backup which depends on it is pinned
+func checkLocalBackupIsNotPinned(backupList []LocalBackup, backupName string, disks []clickhouse.Disk) error {
+	if isLocalBackupPinned(backupName, disks) {
+		return fmt.Errorf("'%s' %w, execute `unpin local %s` before delete", backupName, ErrBackupIsPinned, backupName)
+	}
+	for _, dependent := range storage.GetDependentBackups(getLocalRetentionItems(backupList), backupName) {
+		if isLocalBackupPinned(dependent, disks) {
+			return fmt.Errorf("'%s' required for pinned '%s', %w, execute `unpin local %s` before delete", backupName, dependent, ErrBackupIsPinned, dependent)
+		}
+	}
+	return nil
+}
+
+// checkRemoteBackupIsNotPinned the same as checkLocalBackupIsNotPinned for remote storage, pinned dependent backups are read from storage.RequiredByPinnedFileName to avoid list all backups
+func checkRemoteBackupIsNotPinned(ctx context.Context, bd *storage.BackupDestination, backupName string) error {
+	if isPinned, err := bd.IsPinned(ctx, backupName); err != nil {
+		return err
+	} else if isPinned {
+		return fmt.Errorf("'%s' %w, execute `unpin remote %s` before delete", backupName, ErrBackupIsPinned, backupName)
+	}
+	requiredBy, err := bd.GetRequiredByPinned(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	for _, dependent := range requiredBy {
+		if isPinned, err := bd.IsPinned(ctx, dependent); err != nil {
+			return err
+		} else if isPinned {
+			return fmt.Errorf("'%s' required for pinned '%s', %w, execute `unpin remote %s` before delete", backupName, dependent, ErrBackupIsPinned, dependent)
+		}
+	}
+	return nil
+}
@@ -17,9 +17,12 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 
 	"github.com/mattn/go-shellwords"
 
@@ -34,24 +37,69 @@ import (
 
 var CreateDatabaseRE = regexp.MustCompile(`(?m)^CREATE DATABASE (\s*)(\S+)(\s*)`)
 
-// Restore - restore tables matched by tablePattern from backupName
-func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, partitions []string, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly bool, commandId int) error {
+// RestoreOptions - flags of restore and restore_remote commands and POST /backup/restore, /backup/restore_remote
+type RestoreOptions struct {
+	TablePattern         string
+	ReplicatedConversion string
+	Where                string
+	DatabaseMapping      []string
+	TableMapping         []string
+	ZookeeperPathMapping []string
+	Partitions           []string
+	SchemaOnly           bool
+	DataOnly             bool
+	DropTable            bool
+	IgnoreDependencies   bool
+	RBAC                 bool
+	RBACOnly             bool
+	Configs              bool
+	ConfigsOnly          bool
+	// Resume - used only by restore_remote for download
+	Resume bool
+	Atomic bool
+	DryRun bool
+}
+
+// Restore - restore tables matched by opts.TablePattern from backupName
+func (b *Backuper) Restore(backupName string, opts RestoreOptions, commandId int) (err error) {
+	if !opts.DryRun {
+		notify := b.startNotification("restore")
+		defer func() {
+			b.finishNotification(notify, backupName, err)
+		}()
+	}
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
 	}
+	b.setCommandLog(commandId)
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
+	b.restoreValidations = nil
+	defer func() {
+		status.Current.AddRestoreValidation(commandId, b.restoreValidations)
+	}()
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
-	if err := b.prepareRestoreDatabaseMapping(databaseMapping); err != nil {
+	status.Current.SetBackupName(commandId, backupName)
+	if err := b.prepareRestoreDatabaseMapping(opts.DatabaseMapping); err != nil {
+		return err
+	}
+	if err := b.prepareRestoreTableMapping(opts.TableMapping); err != nil {
+		return err
+	}
+	if err := b.prepareRestoreReplicatedEngine(opts.ZookeeperPathMapping, opts.ReplicatedConversion); err != nil {
 		return err
 	}
+	if err := b.validateRestoreOptions(opts); err != nil {
+		return err
+	}
+	isMaskingRequired := b.isRestoreMaskingRequired(opts)
 
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore",
 	})
-	doRestoreData := !schemaOnly || dataOnly
+	doRestoreData := !opts.SchemaOnly || opts.DataOnly
 
 	if err := b.ch.Connect(); err != nil {
 		return fmt.Errorf("can't connect to clickhouse: %v", err)
@@ -88,6 +136,15 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 			break
 		}
 	}
+	if opts.Atomic && b.isEmbedded {
+		return fmt.Errorf("--atomic is not supported for use_embedded_backup_restore: true")
+	}
+	if opts.Where != "" && b.isEmbedded {
+		return fmt.Errorf("--where is not supported for use_embedded_backup_restore: true")
+	}
+	if isMaskingRequired && b.isEmbedded {
+		return fmt.Errorf("restore_masking is not supported for use_embedded_backup_restore: true")
+	}
 	if b.cfg.General.RestoreSchemaOnCluster != "" {
 		b.cfg.General.RestoreSchemaOnCluster, err = b.ch.ApplyMacros(ctx, b.cfg.General.RestoreSchemaOnCluster)
 	}
@@ -96,19 +153,26 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 		if err := json.Unmarshal(backupMetadataBody, &backupMetadata); err != nil {
 			return err
 		}
+		if opts.DryRun {
+			plan, err := b.getRestorePlanLocal(ctx, &backupMetadata, opts.Partitions, disks, newRestorePlanOptions(opts))
+			if err != nil {
+				return err
+			}
+			return outputRestorePlan(commandId, plan)
+		}
 
-		if schemaOnly || doRestoreData {
+		if opts.SchemaOnly || doRestoreData {
 			for _, database := range backupMetadata.Databases {
 				targetDB := database.Name
 				if !IsInformationSchema(targetDB) {
-					if err = b.restoreEmptyDatabase(ctx, targetDB, tablePattern, database, dropTable, schemaOnly, ignoreDependencies); err != nil {
+					if err = b.restoreEmptyDatabase(ctx, targetDB, opts.TablePattern, database, opts.DropTable, opts.SchemaOnly, opts.IgnoreDependencies); err != nil {
 						return err
 					}
 				}
 			}
 		}
 		// do not create UDF when use --data, --rbac-only, --configs-only flags, https://github.com/Altinity/clickhouse-backup/issues/697
-		if schemaOnly || (schemaOnly == dataOnly && !rbacOnly && !configsOnly) {
+		if opts.SchemaOnly || (opts.SchemaOnly == opts.DataOnly && !opts.RBACOnly && !opts.ConfigsOnly) {
 			for _, function := range backupMetadata.Functions {
 				if err = b.ch.CreateUserDefinedFunction(function.Name, function.CreateQuery, b.cfg.General.RestoreSchemaOnCluster); err != nil {
 					return err
@@ -117,21 +181,24 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 		}
 		if len(backupMetadata.Tables) == 0 {
 			log.Warnf("'%s' doesn't contains tables for restore", backupName)
-			if (!restoreRBAC) && (!restoreConfigs) {
+			if (!opts.RBAC) && (!opts.Configs) {
 				return nil
 			}
 		}
 	} else if !os.IsNotExist(err) { // Legacy backups don't contain metadata.json
 		return err
 	}
+	if opts.DryRun {
+		return fmt.Errorf("'%s' is old-format backup, --dry-run is not supported", backupName)
+	}
 	needRestart := false
-	if (rbacOnly || restoreRBAC) && !b.isEmbedded {
+	if (opts.RBACOnly || opts.RBAC) && !b.isEmbedded {
 		if err := b.restoreRBAC(ctx, backupName, disks); err != nil {
 			return err
 		}
 		needRestart = true
 	}
-	if (configsOnly || restoreConfigs) && !b.isEmbedded {
+	if (opts.ConfigsOnly || opts.Configs) && !b.isEmbedded {
 		if err := b.restoreConfigs(backupName, disks); err != nil {
 			return err
 		}
@@ -142,18 +209,39 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 		if err := b.restartClickHouse(ctx, backupName, log); err != nil {
 			return err
 		}
-		if rbacOnly || configsOnly {
+		if opts.RBACOnly || opts.ConfigsOnly {
 			return nil
 		}
 	}
 
-	if schemaOnly || (schemaOnly == dataOnly) {
-		if err := b.RestoreSchema(ctx, backupName, tablePattern, dropTable, ignoreDependencies); err != nil {
+	if opts.Atomic {
+		if err := b.restoreAtomic(ctx, backupName, opts.TablePattern, opts.Partitions, disks, opts.DataOnly, opts.IgnoreDependencies, log); err != nil {
+			return err
+		}
+		log.Info("done")
+		return nil
+	}
+	if opts.Where != "" {
+		if err := b.restoreWhere(ctx, backupName, opts.TablePattern, opts.Where, opts.Partitions, disks, opts.DataOnly, opts.IgnoreDependencies, log); err != nil {
+			return err
+		}
+		log.Info("done")
+		return nil
+	}
+	if isMaskingRequired {
+		if err := b.restoreMasking(ctx, backupName, opts.TablePattern, opts.Partitions, disks, opts.DataOnly, opts.DropTable, opts.IgnoreDependencies, log); err != nil {
+			return err
+		}
+		log.Info("done")
+		return nil
+	}
+	if opts.SchemaOnly || (opts.SchemaOnly == opts.DataOnly) {
+		if err := b.RestoreSchema(ctx, backupName, opts.TablePattern, opts.DropTable, opts.IgnoreDependencies); err != nil {
 			return err
 		}
 	}
-	if dataOnly || (schemaOnly == dataOnly) {
-		if err := b.RestoreData(ctx, backupName, tablePattern, partitions, disks); err != nil {
+	if opts.DataOnly || (opts.SchemaOnly == opts.DataOnly) {
+		if err := b.RestoreData(ctx, backupName, opts.TablePattern, opts.Partitions, disks); err != nil {
 			return err
 		}
 	}
@@ -255,7 +343,11 @@ func (b *Backuper) restoreEmptyDatabase(ctx context.Context, targetDB, tablePatt
 
 	}
 	substitution := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS ${1}`%s`${3}", targetDB)
-	if err := b.ch.CreateDatabaseFromQuery(ctx, CreateDatabaseRE.ReplaceAllString(database.Query, substitution), b.cfg.General.RestoreSchemaOnCluster); err != nil {
+	databaseQuery := CreateDatabaseRE.ReplaceAllString(database.Query, substitution)
+	if len(b.cfg.General.RestoreZookeeperPathMapping) > 0 || b.cfg.General.RestoreReplicatedConversion != "" {
+		databaseQuery = changeDatabaseQueryToAdjustReplicatedEngine(databaseQuery, b.cfg.General.RestoreZookeeperPathMapping, b.cfg.General.RestoreReplicatedConversion)
+	}
+	if err := b.ch.CreateDatabaseFromQuery(ctx, databaseQuery, b.cfg.General.RestoreSchemaOnCluster); err != nil {
 		return err
 	}
 	return nil
@@ -275,6 +367,97 @@ func (b *Backuper) prepareRestoreDatabaseMapping(databaseMapping []string) error
 	return nil
 }
 
+func (b *Backuper) prepareRestoreTableMapping(tableMapping []string) error {
+	for i := 0; i < len(tableMapping); i++ {
+		splitByCommas := strings.Split(tableMapping[i], ",")
+		for _, m := range splitByCommas {
+			splitByColon := strings.Split(m, ":")
+			if len(splitByColon) != 2 {
+				return fmt.Errorf("restore-table-mapping %s should only have srcDatabase.srcTable:dstDatabase.dstTable format for each map rule", m)
+			}
+			b.cfg.General.RestoreTableMapping[splitByColon[0]] = splitByColon[1]
+		}
+	}
+	for src, dst := range b.cfg.General.RestoreTableMapping {
+		if _, _, _, _, err := splitTableMappingRule(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) prepareRestoreReplicatedEngine(zookeeperPathMapping []string, replicatedConversion string) error {
+	for i := 0; i < len(zookeeperPathMapping); i++ {
+		splitByCommas := strings.Split(zookeeperPathMapping[i], ",")
+		for _, m := range splitByCommas {
+			splitByColon := strings.Split(m, ":")
+			if len(splitByColon) != 2 || splitByColon[0] == "" {
+				return fmt.Errorf("restore-zookeeper-path-mapping %s should only have srcPathPrefix:dstPathPrefix format for each map rule", m)
+			}
+			b.cfg.General.RestoreZookeeperPathMapping[splitByColon[0]] = splitByColon[1]
+		}
+	}
+	if replicatedConversion != "" {
+		b.cfg.General.RestoreReplicatedConversion = replicatedConversion
+	}
+	switch b.cfg.General.RestoreReplicatedConversion {
+	case "", ReplicatedConversionToMergeTree, ReplicatedConversionToReplicated:
+		return nil
+	default:
+		return fmt.Errorf("restore-replicated-conversion %s is unsupported, only %s or %s allowed", b.cfg.General.RestoreReplicatedConversion, ReplicatedConversionToMergeTree, ReplicatedConversionToReplicated)
+	}
+}
+
+// validateRestoreOptions - flags which can't be combined, checked before anything is changed and for --dry-run too
+func (b *Backuper) validateRestoreOptions(opts RestoreOptions) error {
+	if opts.Atomic && (opts.SchemaOnly || opts.RBACOnly || opts.ConfigsOnly) {
+		return fmt.Errorf("--atomic can't be used with --schema, --rbac-only or --configs-only")
+	}
+	if opts.Atomic && b.cfg.General.RestoreSchemaOnCluster != "" {
+		return fmt.Errorf("--atomic is not compatible with restore_schema_on_cluster: %s, tables are exchanged only on current host", b.cfg.General.RestoreSchemaOnCluster)
+	}
+	if opts.Where != "" && (opts.Atomic || opts.SchemaOnly || opts.RBACOnly || opts.ConfigsOnly) {
+		return fmt.Errorf("--where can't be used with --atomic, --schema, --rbac-only or --configs-only")
+	}
+	if opts.Where != "" {
+		if err := ValidateRestoreWhere(opts.Where); err != nil {
+			return err
+		}
+	}
+	if opts.Where != "" && b.cfg.General.RestoreSchemaOnCluster != "" {
+		return fmt.Errorf("--where is not compatible with restore_schema_on_cluster: %s, rows are inserted only on current host", b.cfg.General.RestoreSchemaOnCluster)
+	}
+	isMaskingRequired := b.isRestoreMaskingRequired(opts)
+	if isMaskingRequired && (opts.Atomic || opts.Where != "") {
+		return fmt.Errorf("restore_masking can't be used with --atomic or --where")
+	}
+	if isMaskingRequired && b.cfg.General.RestoreSchemaOnCluster != "" {
+		return fmt.Errorf("restore_masking is not compatible with restore_schema_on_cluster: %s, masked tables are replaced only on current host", b.cfg.General.RestoreSchemaOnCluster)
+	}
+	return nil
+}
+
+func (b *Backuper) isRestoreMaskingRequired(opts RestoreOptions) bool {
+	return len(b.cfg.General.RestoreMasking) > 0 && (opts.DataOnly || !opts.SchemaOnly) && !opts.RBACOnly && !opts.ConfigsOnly
+}
+
+// getRestoreTargetTitle - where table from backup will be restored, restore_table_mapping is applied before restore_database_mapping, staging table is used for restore --atomic and --where
+func (b *Backuper) getRestoreTargetTitle(database, table string) metadata.TableTitle {
+	target := metadata.TableTitle{Database: database, Table: table}
+	if dst, isMapped := b.cfg.General.RestoreTableMapping[database+"."+table]; isMapped {
+		if _, _, dstDb, dstTable, err := splitTableMappingRule(database+"."+table, dst); err == nil {
+			target = metadata.TableTitle{Database: dstDb, Table: dstTable}
+		}
+	}
+	if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[target.Database]; isMapped {
+		target.Database = targetDB
+	}
+	if t, isStaging := b.stagingTables[target]; isStaging {
+		return t.Staging
+	}
+	return target
+}
+
 // restoreRBAC - copy backup_name>/rbac folder to access_data_path
 func (b *Backuper) restoreRBAC(ctx context.Context, backupName string, disks []clickhouse.Disk) error {
 	log := b.log.WithField("logger", "restoreRBAC")
@@ -417,7 +600,7 @@ func (b *Backuper) restoreBackupRelatedDir(backupName, backupPrefixDir, destinat
 
 // RestoreSchema - restore schemas matched by tablePattern from backupName
 func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern string, dropTable, ignoreDependencies bool) error {
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore",
 	})
@@ -444,6 +627,13 @@ func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern s
 	if err != nil {
 		return err
 	}
+	// if restore-table-mapping specified, create tables with new names, database mapping will apply after it
+	// embedded backup applies table mapping via RESTORE ... AS
+	if len(b.cfg.General.RestoreTableMapping) > 0 && !b.isEmbedded {
+		if err = changeTableQueryToAdjustTableMapping(&tablesForRestore, b.cfg.General.RestoreTableMapping); err != nil {
+			return err
+		}
+	}
 	// if restore-database-mapping specified, create database in mapping rules instead of in backup files.
 	if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
 		err = changeTableQueryToAdjustDatabaseMapping(&tablesForRestore, b.cfg.General.RestoreDatabaseMapping)
@@ -451,6 +641,15 @@ func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern s
 			return err
 		}
 	}
+	// embedded backup rewrites .sql files in restoreSchemaEmbedded
+	if (len(b.cfg.General.RestoreZookeeperPathMapping) > 0 || b.cfg.General.RestoreReplicatedConversion != "") && !b.isEmbedded {
+		changeTableQueryToAdjustReplicatedEngine(&tablesForRestore, b.cfg.General.RestoreZookeeperPathMapping, b.cfg.General.RestoreReplicatedConversion)
+	}
+	if len(b.stagingTables) > 0 {
+		if err = b.changeTableQueryToAdjustStagingTables(&tablesForRestore); err != nil {
+			return err
+		}
+	}
 	if len(tablesForRestore) == 0 {
 		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
 	}
@@ -491,6 +690,10 @@ func (b *Backuper) restoreSchemaEmbedded(ctx context.Context, backupName string,
 			return err
 		}
 		sqlQuery := string(sqlBytes)
+		if len(b.cfg.General.RestoreZookeeperPathMapping) > 0 || b.cfg.General.RestoreReplicatedConversion != "" {
+			sqlQuery = adjustReplicatedEngineInQuery(sqlQuery, b.cfg.General.RestoreZookeeperPathMapping, b.cfg.General.RestoreReplicatedConversion)
+			sqlQuery = changeDatabaseQueryToAdjustReplicatedEngine(sqlQuery, b.cfg.General.RestoreZookeeperPathMapping, b.cfg.General.RestoreReplicatedConversion)
+		}
 		if strings.Contains(sqlQuery, "{uuid}") {
 			if UUIDWithMergeTreeRE.Match(sqlBytes) {
 				sqlQuery = UUIDWithMergeTreeRE.ReplaceAllString(sqlQuery, "$1$2$3'$4'$5$4$7")
@@ -507,6 +710,8 @@ func (b *Backuper) restoreSchemaEmbedded(ctx context.Context, backupName string,
 				}
 				sqlQuery = strings.Replace(sqlQuery, "{uuid}", database+"/"+table, 1)
 			}
+		}
+		if sqlQuery != string(sqlBytes) {
 			if err = object_disk.WriteFileContent(ctx, b.ch, b.cfg, b.cfg.ClickHouse.EmbeddedBackupDisk, filePath, []byte(sqlQuery)); err != nil {
 				return err
 			}
@@ -661,7 +866,7 @@ func (b *Backuper) dropExistsTables(tablesForDrop ListOfTables, ignoreDependenci
 // RestoreData - restore data for tables matched by tablePattern from backupName
 func (b *Backuper) RestoreData(ctx context.Context, backupName string, tablePattern string, partitions []string, disks []clickhouse.Disk) error {
 	startRestore := time.Now()
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "restore",
 	})
@@ -707,8 +912,17 @@ func (b *Backuper) RestoreData(ctx context.Context, backupName string, tablePatt
 		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
 	}
 	log.Debugf("found %d tables with data in backup", len(tablesForRestore))
+	bytesTotal := uint64(0)
+	for _, table := range tablesForRestore {
+		bytesTotal += table.TotalBytes
+	}
+	status.Current.StartProgress(ctx, "restore", uint64(len(tablesForRestore)), bytesTotal)
 	if b.isEmbedded {
 		err = b.restoreDataEmbedded(ctx, backupName, tablesForRestore, partitionsNameList)
+		if err == nil {
+			// RESTORE query doesn't report progress for each table
+			status.Current.AddProgress(ctx, uint64(len(tablesForRestore)), bytesTotal)
+		}
 	} else {
 		err = b.restoreDataRegular(ctx, backupName, tablePattern, tablesForRestore, diskMap, diskTypes, disks, log)
 	}
@@ -724,9 +938,15 @@ func (b *Backuper) restoreDataEmbedded(ctx context.Context, backupName string, t
 }
 
 func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, tablePattern string, tablesForRestore ListOfTables, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, log *apexLog.Entry) error {
+	if b.cfg.General.RestoreConcurrency == 0 {
+		return fmt.Errorf("`restore_concurrency` shall be more than zero")
+	}
 	if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
 		tablePattern = b.changeTablePatternFromRestoreDatabaseMapping(tablePattern)
 	}
+	if len(b.cfg.General.RestoreTableMapping) > 0 {
+		tablePattern = b.changeTablePatternFromRestoreTableMapping(tablePattern)
+	}
 	chTables, err := b.ch.GetTables(ctx, tablePattern)
 	if err != nil {
 		return err
@@ -739,40 +959,102 @@ func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, ta
 		return fmt.Errorf("%s is not created. Restore schema first or create missing tables manually", strings.Join(missingTables, ", "))
 	}
 
-	for i, table := range tablesForRestore {
-		// need mapped database path and original table.Database for HardlinkBackupPartsToStorage
-		dstDatabase := table.Database
-		if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
-			if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[table.Database]; isMapped {
-				dstDatabase = targetDB
-				tablesForRestore[i].Database = targetDB
+	if err = b.initObjectDisksConnections(ctx, tablesForRestore, diskTypes); err != nil {
+		return err
+	}
+	restoreSemaphore := semaphore.NewWeighted(int64(b.cfg.General.RestoreConcurrency))
+	restoredTables := int64(0)
+	for _, tablesGroup := range groupTablesByRestoreOrder(tablesForRestore) {
+		log.Debugf("prepare table DATA concurrent semaphore with concurrency=%d len(tablesGroup)=%d", b.cfg.General.RestoreConcurrency, len(tablesGroup))
+		restoreGroup, restoreCtx := errgroup.WithContext(ctx)
+		for _, i := range tablesGroup {
+			if err = restoreSemaphore.Acquire(restoreCtx, 1); err != nil {
+				log.Errorf("can't acquire semaphore during restore table data: %v", err)
+				break
 			}
+			idx := i
+			restoreGroup.Go(func() error {
+				defer restoreSemaphore.Release(1)
+				return b.restoreTableDataRegular(restoreCtx, backupName, tablesForRestore, idx, dstTablesMap, diskMap, diskTypes, disks, &restoredTables, log)
+			})
 		}
-		log := log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, table.Table))
-		dstTable, ok := dstTablesMap[metadata.TableTitle{
-			Database: dstDatabase,
-			Table:    table.Table}]
-		if !ok {
-			return fmt.Errorf("can't find '%s.%s' in current system.tables", dstDatabase, table.Table)
+		if err = restoreGroup.Wait(); err != nil {
+			return fmt.Errorf("one of restoreDataRegular go-routine return error: %v", err)
 		}
-		// https://github.com/Altinity/clickhouse-backup/issues/529
-		if b.cfg.ClickHouse.RestoreAsAttach {
-			if err = b.restoreDataRegularByAttach(ctx, backupName, table, diskMap, diskTypes, disks, dstTable, log, tablesForRestore, i); err != nil {
-				return err
+	}
+	return b.validateRestoredTables(ctx, tablesForRestore, dstTablesMap, log)
+}
+
+// groupTablesByRestoreOrder - tables inside one group have the same engine priority and could restore concurrently, groups shall restore sequentially
+func groupTablesByRestoreOrder(tablesForRestore ListOfTables) [][]int {
+	groups := make([][]int, 0)
+	groupOrder := int64(-1)
+	for i, table := range tablesForRestore {
+		order := getOrderByEngine(table.Query, false)
+		if len(groups) == 0 || order != groupOrder {
+			groups = append(groups, make([]int, 0))
+			groupOrder = order
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], i)
+	}
+	return groups
+}
+
+// initObjectDisksConnections - object_disk connections are global maps, shall be initialized before concurrent restore
+func (b *Backuper) initObjectDisksConnections(ctx context.Context, tablesForRestore ListOfTables, diskTypes map[string]string) error {
+	isInitialized := common.EmptyMap{}
+	for _, table := range tablesForRestore {
+		for diskName := range table.Parts {
+			if _, exists := isInitialized[diskName]; exists {
+				continue
 			}
-		} else {
-			if err = b.restoreDataRegularByParts(ctx, backupName, table, diskMap, diskTypes, disks, dstTable, log, tablesForRestore, i); err != nil {
-				return err
+			if diskType, exists := diskTypes[diskName]; exists && (diskType == "s3" || diskType == "azure_blob_storage") {
+				if err := config.ValidateObjectDiskConfig(b.cfg); err != nil {
+					return err
+				}
+				if err := object_disk.InitCredentialsAndConnections(ctx, b.ch, b.cfg, diskName); err != nil {
+					return err
+				}
 			}
+			isInitialized[diskName] = struct{}{}
 		}
-		// https://github.com/Altinity/clickhouse-backup/issues/529
-		for _, mutation := range table.Mutations {
-			if err := b.ch.ApplyMutation(ctx, tablesForRestore[i], mutation); err != nil {
-				log.Warnf("can't apply mutation %s for table `%s`.`%s`	: %v", mutation.Command, tablesForRestore[i].Database, tablesForRestore[i].Table, err)
-			}
+	}
+	return nil
+}
+
+func (b *Backuper) restoreTableDataRegular(ctx context.Context, backupName string, tablesForRestore ListOfTables, i int, dstTablesMap map[metadata.TableTitle]clickhouse.Table, diskMap, diskTypes map[string]string, disks []clickhouse.Disk, restoredTables *int64, log *apexLog.Entry) error {
+	start := time.Now()
+	table := tablesForRestore[i]
+	// need mapped database and table path and original table.Database, table.Table for HardlinkBackupPartsToStorage
+	dstTitle := b.getRestoreTargetTitle(table.Database, table.Table)
+	tablesForRestore[i].Database = dstTitle.Database
+	tablesForRestore[i].Table = dstTitle.Table
+	log = log.WithField("table", fmt.Sprintf("%s.%s", dstTitle.Database, dstTitle.Table))
+	dstTable, ok := dstTablesMap[dstTitle]
+	if !ok {
+		return fmt.Errorf("can't find '%s.%s' in current system.tables", dstTitle.Database, dstTitle.Table)
+	}
+	// https://github.com/Altinity/clickhouse-backup/issues/529
+	if b.cfg.ClickHouse.RestoreAsAttach {
+		if err := b.restoreDataRegularByAttach(ctx, backupName, table, diskMap, diskTypes, disks, dstTable, log, tablesForRestore, i); err != nil {
+			return err
+		}
+	} else {
+		if err := b.restoreDataRegularByParts(ctx, backupName, table, diskMap, diskTypes, disks, dstTable, log, tablesForRestore, i); err != nil {
+			return err
+		}
+	}
+	// https://github.com/Altinity/clickhouse-backup/issues/529
+	for _, mutation := range table.Mutations {
+		if err := b.ch.ApplyMutation(ctx, tablesForRestore[i], mutation); err != nil {
+			log.Warnf("can't apply mutation %s for table `%s`.`%s`	: %v", mutation.Command, tablesForRestore[i].Database, tablesForRestore[i].Table, err)
 		}
-		log.Info("done")
 	}
+	status.Current.AddProgress(ctx, 1, table.TotalBytes)
+	log.
+		WithField("progress", fmt.Sprintf("%d/%d", atomic.AddInt64(restoredTables, 1), len(tablesForRestore))).
+		WithField("duration", utils.HumanizeDuration(time.Since(start))).
+		Info("done")
 	return nil
 }
 
@@ -824,15 +1106,16 @@ func (b *Backuper) downloadObjectDiskParts(ctx context.Context, backupName strin
 	if !needToDownloadObjectDisk {
 		return nil
 	}
-	b.dst, err = storage.NewBackupDestination(ctx, b.cfg, b.ch, false, backupName)
+	// local variable, tables could restore concurrently
+	dst, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, backupName)
 	if err != nil {
 		return err
 	}
-	if err = b.dst.Connect(ctx); err != nil {
-		return fmt.Errorf("can't connect to %s: %v", b.dst.Kind(), err)
+	if err = dst.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to %s: %v", dst.Kind(), err)
 	}
 	defer func() {
-		if err := b.dst.Close(ctx); err != nil {
+		if err := dst.Close(ctx); err != nil {
 			b.log.Warnf("downloadObjectDiskParts: can't close BackupDestination error: %v", err)
 		}
 	}()
@@ -897,21 +1180,16 @@ func (b *Backuper) downloadObjectDiskParts(ctx context.Context, backupName strin
 func (b *Backuper) checkMissingTables(tablesForRestore ListOfTables, chTables []clickhouse.Table) []string {
 	var missingTables []string
 	for _, table := range tablesForRestore {
-		dstDatabase := table.Database
-		if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
-			if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[table.Database]; isMapped {
-				dstDatabase = targetDB
-			}
-		}
+		dstTitle := b.getRestoreTargetTitle(table.Database, table.Table)
 		found := false
 		for _, chTable := range chTables {
-			if (dstDatabase == chTable.Database) && (table.Table == chTable.Name) {
+			if (dstTitle.Database == chTable.Database) && (dstTitle.Table == chTable.Name) {
 				found = true
 				break
 			}
 		}
 		if !found {
-			missingTables = append(missingTables, fmt.Sprintf("'%s.%s'", dstDatabase, table.Table))
+			missingTables = append(missingTables, fmt.Sprintf("'%s.%s'", dstTitle.Database, dstTitle.Table))
 		}
 	}
 	return missingTables
@@ -978,6 +1256,21 @@ func (b *Backuper) changeTablePatternFromRestoreDatabaseMapping(tablePattern str
 	return tablePattern
 }
 
+func (b *Backuper) changeTablePatternFromRestoreTableMapping(tablePattern string) string {
+	if tablePattern == "" {
+		return tablePattern
+	}
+	for src := range b.cfg.General.RestoreTableMapping {
+		srcTitle := strings.SplitN(src, ".", 2)
+		if len(srcTitle) != 2 {
+			continue
+		}
+		dstTitle := b.getRestoreTargetTitle(srcTitle[0], srcTitle[1])
+		tablePattern += fmt.Sprintf(",%s.%s", dstTitle.Database, dstTitle.Table)
+	}
+	return tablePattern
+}
+
 func (b *Backuper) restoreEmbedded(ctx context.Context, backupName string, restoreOnlySchema bool, tablesForRestore ListOfTables, partitionsNameList map[metadata.TableTitle][]string) error {
 	restoreSQL := "Disk(?,?)"
 	tablesSQL := ""
@@ -988,8 +1281,8 @@ func (b *Backuper) restoreEmbedded(ctx context.Context, backupName string, resto
 			if strings.Contains(t.Query, " DICTIONARY ") {
 				kind = "DICTIONARY"
 			}
-			if newDb, isMapped := b.cfg.General.RestoreDatabaseMapping[t.Database]; isMapped {
-				tablesSQL += fmt.Sprintf("%s `%s`.`%s` AS `%s`.`%s`", kind, t.Database, t.Table, newDb, t.Table)
+			if dstTitle := b.getRestoreTargetTitle(t.Database, t.Table); dstTitle.Database != t.Database || dstTitle.Table != t.Table {
+				tablesSQL += fmt.Sprintf("%s `%s`.`%s` AS `%s`.`%s`", kind, t.Database, t.Table, dstTitle.Database, dstTitle.Table)
 			} else {
 				tablesSQL += fmt.Sprintf("%s `%s`.`%s`", kind, t.Database, t.Table)
 			}
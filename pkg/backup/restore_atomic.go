@@ -0,0 +1,210 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+)
+
+// restoreAtomic - the same as RestoreSchema + RestoreData, but existing tables with data are not dropped,
+// data restores into staging tables, which replace live tables via EXCHANGE TABLES or REPLACE PARTITION,
+// existing tables which are not staged, like views, distributed and inner tables of materialized views, are kept as is
+func (b *Backuper) restoreAtomic(ctx context.Context, backupName, tablePattern string, partitions []string, disks []clickhouse.Disk, dataOnly, ignoreDependencies bool, log *apexLog.Entry) error {
+	stagingTables, schemaTablePattern, err := b.prepareStagingTables(ctx, backupName, tablePattern, partitions, getStagingTableSuffix("restore"), false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		b.stagingTables = nil
+	}()
+	if len(partitions) == 0 {
+		for _, t := range stagingTables {
+			if !t.LiveReplicated && !t.isExchangeable() {
+				return fmt.Errorf("atomic restore of '%s.%s' require Atomic or Replicated database engine for EXCHANGE TABLES, got %s, use --partitions or restore without --atomic", t.Live.Database, t.Live.Table, t.LiveDatabaseEngine)
+			}
+		}
+	}
+	// existing tables which are not staged keep their data, parts from backup would duplicate it
+	dataTablePattern := schemaTablePattern
+	restoreErr := func() error {
+		// only staging tables shall be created for --data
+		if dataOnly {
+			schemaTablePattern = getStagingTablesPattern(stagingTables)
+		}
+		if schemaTablePattern != "" {
+			if err := b.RestoreSchema(ctx, backupName, schemaTablePattern, false, ignoreDependencies); err != nil {
+				return err
+			}
+		}
+		if dataTablePattern == "" {
+			return nil
+		}
+		return b.restoreStagingTablesData(ctx, backupName, dataTablePattern, stagingTables, partitions, disks)
+	}()
+	if restoreErr != nil {
+		b.dropStagingTables(stagingTables, log)
+		return restoreErr
+	}
+	return b.applyStagingTables(ctx, stagingTables, len(partitions) > 0, log)
+}
+
+// applyStagingTables - staging tables become live one by one, via EXCHANGE TABLES when possible, otherwise via REPLACE PARTITION,
+// when replacePartitionsOnly is false, partitions absent in staging table are dropped from live table,
+// when one of tables fails, already exchanged tables are exchanged back and the rest staging tables are dropped,
+// REPLACE PARTITION can't be rolled back, so already replaced tables are listed in error
+func (b *Backuper) applyStagingTables(ctx context.Context, stagingTables []stagingTable, replacePartitionsOnly bool, log *apexLog.Entry) error {
+	exchangedTables := make([]stagingTable, 0)
+	replacedTables := make([]string, 0)
+	for i, t := range stagingTables {
+		var err error
+		if replacePartitionsOnly || !t.isExchangeable() {
+			if err = b.replaceStagingTablePartitions(ctx, t, !replacePartitionsOnly, log); err == nil {
+				replacedTables = append(replacedTables, fmt.Sprintf("%s.%s", t.Live.Database, t.Live.Table))
+			}
+		} else if err = b.exchangeStagingTable(ctx, t, log); err == nil {
+			exchangedTables = append(exchangedTables, t)
+		}
+		if err == nil {
+			continue
+		}
+		b.dropStagingTables(stagingTables[i:], log)
+		state := make([]string, 0, 3)
+		if rolledBack, notRolledBack := b.rollbackExchangedTables(ctx, exchangedTables, log); len(notRolledBack) > 0 {
+			state = append(state, fmt.Sprintf("exchanged back: [%s], can't exchange back, previous data stays in staging tables: [%s]", strings.Join(rolledBack, ", "), strings.Join(notRolledBack, ", ")))
+		} else if len(rolledBack) > 0 {
+			state = append(state, fmt.Sprintf("exchanged back: [%s]", strings.Join(rolledBack, ", ")))
+		}
+		if len(replacedTables) > 0 {
+			state = append(state, fmt.Sprintf("already restored via REPLACE PARTITION: [%s]", strings.Join(replacedTables, ", ")))
+		}
+		if len(state) > 0 {
+			return fmt.Errorf("%v, %s", err, strings.Join(state, ", "))
+		}
+		return err
+	}
+	// staging tables contain previous data after EXCHANGE
+	for _, t := range exchangedTables {
+		if err := b.dropStagingTable(t.Staging); err != nil {
+			log.Warnf("can't drop previous data '%s.%s': %v", t.Staging.Database, t.Staging.Table, err)
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) exchangeStagingTable(ctx context.Context, t stagingTable, log *apexLog.Entry) error {
+	log = log.WithField("table", fmt.Sprintf("%s.%s", t.Live.Database, t.Live.Table))
+	if err := b.ch.QueryContext(ctx, fmt.Sprintf("EXCHANGE TABLES `%s`.`%s` AND `%s`.`%s`", t.Live.Database, t.Live.Table, t.Staging.Database, t.Staging.Table)); err != nil {
+		return fmt.Errorf("can't exchange '%s.%s' and '%s.%s': %v", t.Live.Database, t.Live.Table, t.Staging.Database, t.Staging.Table, err)
+	}
+	if err := b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM START MERGES `%s`.`%s`", t.Live.Database, t.Live.Table)); err != nil {
+		log.Warnf("can't start merges: %v", err)
+	}
+	if err := b.reattachMaterializedViews(ctx, t.Live, log); err != nil {
+		return err
+	}
+	log.Info("exchanged")
+	return nil
+}
+
+// rollbackExchangedTables - live tables get previous data back, staging tables with restored data are dropped
+func (b *Backuper) rollbackExchangedTables(ctx context.Context, exchangedTables []stagingTable, log *apexLog.Entry) ([]string, []string) {
+	rolledBack := make([]string, 0, len(exchangedTables))
+	notRolledBack := make([]string, 0)
+	// ctx could be already canceled, but previous data shall be returned anyway
+	ctx = context.WithoutCancel(ctx)
+	for i := len(exchangedTables) - 1; i >= 0; i-- {
+		t := exchangedTables[i]
+		liveName := fmt.Sprintf("%s.%s", t.Live.Database, t.Live.Table)
+		if err := b.ch.QueryContext(ctx, fmt.Sprintf("EXCHANGE TABLES `%s`.`%s` AND `%s`.`%s`", t.Live.Database, t.Live.Table, t.Staging.Database, t.Staging.Table)); err != nil {
+			log.Errorf("can't exchange back '%s' and '%s.%s': %v", liveName, t.Staging.Database, t.Staging.Table, err)
+			notRolledBack = append(notRolledBack, fmt.Sprintf("%s.%s", t.Staging.Database, t.Staging.Table))
+			continue
+		}
+		if err := b.reattachMaterializedViews(ctx, t.Live, log); err != nil {
+			log.Warnf("%v", err)
+		}
+		if err := b.dropStagingTable(t.Staging); err != nil {
+			log.Warnf("can't drop staging table '%s.%s': %v", t.Staging.Database, t.Staging.Table, err)
+		}
+		rolledBack = append(rolledBack, liveName)
+	}
+	return rolledBack, notRolledBack
+}
+
+// reattachMaterializedViews - materialized views resolve TO table by UUID, re-attach will resolve it by name again
+func (b *Backuper) reattachMaterializedViews(ctx context.Context, live metadata.TableTitle, log *apexLog.Entry) error {
+	views := make([]struct {
+		Database string `ch:"database"`
+		Name     string `ch:"name"`
+	}, 0)
+	toClause := fmt.Sprintf("\\sTO\\s+`?%s`?\\.`?%s`?(\\s|\\(|$)", regexp.QuoteMeta(live.Database), regexp.QuoteMeta(live.Table))
+	if err := b.ch.SelectContext(ctx, &views, "SELECT database, name FROM system.tables WHERE engine='MaterializedView' AND match(create_table_query, ?)", toClause); err != nil {
+		log.Warnf("can't get materialized views: %v", err)
+	}
+	for _, view := range views {
+		if err := b.ch.QueryContext(ctx, fmt.Sprintf("DETACH TABLE `%s`.`%s`", view.Database, view.Name)); err != nil {
+			log.Warnf("can't detach materialized view '%s.%s': %v", view.Database, view.Name, err)
+			continue
+		}
+		if err := b.ch.QueryContext(ctx, fmt.Sprintf("ATTACH TABLE `%s`.`%s`", view.Database, view.Name)); err != nil {
+			return fmt.Errorf("can't attach materialized view '%s.%s': %v", view.Database, view.Name, err)
+		}
+	}
+	return nil
+}
+
+// replaceStagingTablePartitions - each partition is replaced atomically, when dropMissing is true, partitions which are absent in staging table are dropped from live table
+func (b *Backuper) replaceStagingTablePartitions(ctx context.Context, t stagingTable, dropMissing bool, log *apexLog.Entry) error {
+	log = log.WithField("table", fmt.Sprintf("%s.%s", t.Live.Database, t.Live.Table))
+	partitionIds, err := b.getActivePartitionIds(ctx, t.Staging)
+	if err != nil {
+		return err
+	}
+	for _, partitionId := range partitionIds {
+		query := fmt.Sprintf("ALTER TABLE `%s`.`%s` REPLACE PARTITION ID '%s' FROM `%s`.`%s`", t.Live.Database, t.Live.Table, partitionId, t.Staging.Database, t.Staging.Table)
+		if err := b.ch.QueryContext(ctx, query); err != nil {
+			return fmt.Errorf("can't replace partition %s in '%s.%s': %v", partitionId, t.Live.Database, t.Live.Table, err)
+		}
+	}
+	droppedPartitions := 0
+	if dropMissing {
+		livePartitionIds, err := b.getActivePartitionIds(ctx, t.Live)
+		if err != nil {
+			return err
+		}
+		for _, partitionId := range livePartitionIds {
+			if slices.Contains(partitionIds, partitionId) {
+				continue
+			}
+			if err := b.ch.QueryContext(ctx, fmt.Sprintf("ALTER TABLE `%s`.`%s` DROP PARTITION ID '%s'", t.Live.Database, t.Live.Table, partitionId)); err != nil {
+				return fmt.Errorf("can't drop partition %s which is absent in backup from '%s.%s': %v", partitionId, t.Live.Database, t.Live.Table, err)
+			}
+			droppedPartitions++
+		}
+	}
+	if err := b.dropStagingTable(t.Staging); err != nil {
+		log.Warnf("can't drop staging table '%s.%s': %v", t.Staging.Database, t.Staging.Table, err)
+	}
+	log.WithField("partitions", len(partitionIds)).WithField("dropped_partitions", droppedPartitions).Info("replaced")
+	return nil
+}
+
+func (b *Backuper) getActivePartitionIds(ctx context.Context, table metadata.TableTitle) ([]string, error) {
+	partitions := make([]struct {
+		PartitionId string `ch:"partition_id"`
+	}, 0)
+	if err := b.ch.SelectContext(ctx, &partitions, "SELECT DISTINCT partition_id FROM system.parts WHERE active AND database=? AND table=?", table.Database, table.Table); err != nil {
+		return nil, err
+	}
+	partitionIds := make([]string, len(partitions))
+	for i, p := range partitions {
+		partitionIds[i] = p.PartitionId
+	}
+	return partitionIds, nil
+}
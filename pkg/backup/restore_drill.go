@@ -0,0 +1,212 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	apexLog "github.com/apex/log"
+)
+
+// RestoreDrillOptions - api.restore_drill_* settings
+type RestoreDrillOptions struct {
+	// TablePattern - empty means all tables
+	TablePattern string
+	// DatabasePrefix - scratch database name is `<DatabasePrefix><database>`
+	DatabasePrefix string
+}
+
+// RestoreDrill - restore tables from the latest remote backup into scratch `<opts.DatabasePrefix><database>` databases,
+// run CHECK TABLE and compare rows count via restore_validation, scratch databases are dropped after, returns creation date of restored backup
+func (b *Backuper) RestoreDrill(opts RestoreDrillOptions, commandId int) (time.Time, error) {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return time.Time{}, err
+	}
+	b.setCommandLog(commandId)
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	tablePattern, databasePrefix := opts.TablePattern, opts.DatabasePrefix
+	if databasePrefix == "" {
+		return time.Time{}, fmt.Errorf("restore drill database prefix shall not be empty")
+	}
+	if tablePattern == "" {
+		tablePattern = "*.*"
+	}
+	backupList, err := b.GetRemoteBackups(ctx, true)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latestBackup, err := getLatestRemoteBackup(backupList)
+	if err != nil {
+		return time.Time{}, err
+	}
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    latestBackup.BackupName,
+		"operation": "restore_drill",
+	})
+	databaseMapping, scratchDatabases := getRestoreDrillDatabaseMapping(latestBackup.Tables, tablePattern, databasePrefix)
+	if len(databaseMapping) == 0 {
+		return latestBackup.CreationDate, fmt.Errorf("%s doesn't contain tables matched %s", latestBackup.BackupName, tablePattern)
+	}
+	// scratch databases are restored only on current host and ZooKeeper paths of source tables shall not be touched
+	b.cfg.General.RestoreDatabaseMapping = map[string]string{}
+	b.cfg.General.RestoreTableMapping = map[string]string{}
+	b.cfg.General.RestoreSchemaOnCluster = ""
+	b.cfg.General.RestoreValidation = "fail"
+
+	if err = b.ch.Connect(); err != nil {
+		return latestBackup.CreationDate, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	isLocalBackupExists := false
+	if localBackups, _, err := b.GetLocalBackups(ctx, nil); err == nil {
+		for _, localBackup := range localBackups {
+			if localBackup.BackupName == latestBackup.BackupName {
+				isLocalBackupExists = true
+			}
+		}
+	}
+	b.ch.Close()
+	defer func() {
+		// drill could be canceled, scratch databases shall be dropped anyway
+		if err := b.cleanRestoreDrill(context.Background(), latestBackup.BackupName, scratchDatabases, isLocalBackupExists, log); err != nil {
+			log.Warnf("can't clean after restore drill: %v", err)
+		}
+	}()
+
+	start := time.Now()
+	if err = b.RestoreFromRemote(latestBackup.BackupName, RestoreOptions{
+		TablePattern:         tablePattern,
+		ReplicatedConversion: ReplicatedConversionToMergeTree,
+		DatabaseMapping:      databaseMapping,
+		DropTable:            true,
+		IgnoreDependencies:   true,
+	}, commandId); err != nil {
+		return latestBackup.CreationDate, err
+	}
+	if err = b.ch.Connect(); err != nil {
+		return latestBackup.CreationDate, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if err = b.checkRestoreDrillTables(ctx, scratchDatabases); err != nil {
+		return latestBackup.CreationDate, err
+	}
+	log.WithFields(apexLog.Fields{
+		"databases": strings.Join(scratchDatabases, ","),
+		"age":       time.Since(latestBackup.CreationDate).Round(time.Second).String(),
+		"duration":  time.Since(start).Round(time.Millisecond).String(),
+	}).Info("done")
+	return latestBackup.CreationDate, nil
+}
+
+func getLatestRemoteBackup(backupList []storage.Backup) (storage.Backup, error) {
+	latestIdx := -1
+	for i, backup := range backupList {
+		if backup.Broken != "" || backup.Legacy {
+			continue
+		}
+		if latestIdx == -1 || backup.CreationDate.After(backupList[latestIdx].CreationDate) {
+			latestIdx = i
+		}
+	}
+	if latestIdx == -1 {
+		return storage.Backup{}, fmt.Errorf("no one valid remote backup found for restore drill")
+	}
+	return backupList[latestIdx], nil
+}
+
+// getRestoreDrillDatabaseMapping - `src_db:<prefix>src_db` rules for each database with matched tables
+func getRestoreDrillDatabaseMapping(tables []metadata.TableTitle, tablePattern, databasePrefix string) ([]string, []string) {
+	databaseMapping := make([]string, 0)
+	scratchDatabases := make([]string, 0)
+	isMapped := map[string]struct{}{}
+	for _, t := range tables {
+		if _, exists := isMapped[t.Database]; exists {
+			continue
+		}
+		for _, pattern := range strings.Split(tablePattern, ",") {
+			if isMatched, _ := filepath.Match(strings.Trim(pattern, " \t\r\n"), t.Database+"."+t.Table); isMatched {
+				isMapped[t.Database] = struct{}{}
+				databaseMapping = append(databaseMapping, t.Database+":"+databasePrefix+t.Database)
+				scratchDatabases = append(scratchDatabases, databasePrefix+t.Database)
+				break
+			}
+		}
+	}
+	return databaseMapping, scratchDatabases
+}
+
+func (b *Backuper) checkRestoreDrillTables(ctx context.Context, scratchDatabases []string) error {
+	for _, database := range scratchDatabases {
+		tables := make([]struct {
+			Name string `ch:"name"`
+		}, 0)
+		if err := b.ch.SelectContext(ctx, &tables, "SELECT name FROM system.tables WHERE database=? AND engine LIKE '%MergeTree'", database); err != nil {
+			return err
+		}
+		for _, table := range tables {
+			checkResult := make([]struct {
+				Result uint8 `ch:"result"`
+			}, 0)
+			if err := b.ch.SelectContext(ctx, &checkResult, fmt.Sprintf("CHECK TABLE `%s`.`%s`", database, table.Name)); err != nil {
+				return fmt.Errorf("can't check '%s.%s': %v", database, table.Name, err)
+			}
+			for _, r := range checkResult {
+				if r.Result != 1 {
+					return fmt.Errorf("CHECK TABLE '%s.%s' failed", database, table.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cleanRestoreDrill - drop tables one by one to use force_drop_table flag for big tables, local backup is removed when it was downloaded by drill
+func (b *Backuper) cleanRestoreDrill(ctx context.Context, backupName string, scratchDatabases []string, isLocalBackupExists bool, log *apexLog.Entry) error {
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return fmt.Errorf("can't connect to clickhouse: %v", err)
+		}
+		defer b.ch.Close()
+	}
+	version, err := b.ch.GetVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if b.DefaultDataPath == "" {
+		disks, err := b.ch.GetDisks(ctx, true)
+		if err != nil {
+			return err
+		}
+		if b.DefaultDataPath, err = b.ch.GetDefaultPath(disks); err != nil {
+			return err
+		}
+	}
+	for _, database := range scratchDatabases {
+		tables := make([]struct {
+			Name string `ch:"name"`
+		}, 0)
+		if err = b.ch.SelectContext(ctx, &tables, "SELECT name FROM system.tables WHERE database=?", database); err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if err = b.ch.DropTable(clickhouse.Table{Database: database, Name: table.Name}, "", "", true, version, b.DefaultDataPath); err != nil {
+				log.Warnf("can't drop '%s.%s': %v", database, table.Name, err)
+			}
+		}
+		if err = b.ch.QueryContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", database)); err != nil {
+			return err
+		}
+		log.WithField("database", database).Debug("dropped")
+	}
+	if !isLocalBackupExists {
+		return b.RemoveBackupLocal(ctx, backupName, nil)
+	}
+	return nil
+}
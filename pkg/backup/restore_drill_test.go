@@ -0,0 +1,39 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRestoreDrillDatabaseMapping(t *testing.T) {
+	tables := []metadata.TableTitle{
+		{Database: "db1", Table: "t1"},
+		{Database: "db1", Table: "t2"},
+		{Database: "db2", Table: "t1"},
+		{Database: "db3", Table: "events"},
+	}
+	databaseMapping, scratchDatabases := getRestoreDrillDatabaseMapping(tables, "db1.*, db3.events", "drill_")
+	assert.Equal(t, []string{"db1:drill_db1", "db3:drill_db3"}, databaseMapping)
+	assert.Equal(t, []string{"drill_db1", "drill_db3"}, scratchDatabases)
+	databaseMapping, _ = getRestoreDrillDatabaseMapping(tables, "other.*", "drill_")
+	assert.Empty(t, databaseMapping)
+}
+
+func TestGetLatestRemoteBackup(t *testing.T) {
+	now := time.Now()
+	backupList := []storage.Backup{
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "old", CreationDate: now.Add(-2 * time.Hour)}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "broken", CreationDate: now}, Broken: "broken (can't stat metadata.json)"},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "latest", CreationDate: now.Add(-1 * time.Hour)}},
+	}
+	latestBackup, err := getLatestRemoteBackup(backupList)
+	require.NoError(t, err)
+	assert.Equal(t, "latest", latestBackup.BackupName)
+	_, err = getLatestRemoteBackup(backupList[1:2])
+	assert.Error(t, err)
+}
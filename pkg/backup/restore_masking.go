@@ -0,0 +1,209 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+)
+
+// maskingTable - staging table with column -> expression rules from restore_masking
+type maskingTable struct {
+	stagingTable
+	Columns map[string]string
+}
+
+// restoreMasking - tables matched by restore_masking rules restore into staging tables without ZooKeeper path,
+// which replace live tables the same way as restore --atomic only after all columns masked, other tables restore as usual
+func (b *Backuper) restoreMasking(ctx context.Context, backupName, tablePattern string, partitions []string, disks []clickhouse.Disk, dataOnly, dropTable, ignoreDependencies bool, log *apexLog.Entry) error {
+	maskingTables, err := b.prepareMaskingTables(ctx, backupName, tablePattern, partitions, dataOnly)
+	if err != nil {
+		return err
+	}
+	stagingTables := make([]stagingTable, len(maskingTables))
+	for i, t := range maskingTables {
+		stagingTables[i] = t.stagingTable
+	}
+	defer func() {
+		b.stagingTables = nil
+	}()
+	restoreErr := func() error {
+		// live tables with masked columns are kept until staging tables are masked
+		if !dataOnly {
+			if err := b.RestoreSchema(ctx, backupName, tablePattern, dropTable, ignoreDependencies); err != nil {
+				return err
+			}
+		} else if len(stagingTables) > 0 {
+			if err := b.RestoreSchema(ctx, backupName, getStagingTablesPattern(stagingTables), false, ignoreDependencies); err != nil {
+				return err
+			}
+		}
+		if err := b.restoreStagingTablesData(ctx, backupName, tablePattern, stagingTables, partitions, disks); err != nil {
+			return err
+		}
+		for _, t := range maskingTables {
+			// mutations will not execute while merges stopped
+			if err := b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM START MERGES `%s`.`%s`", t.Staging.Database, t.Staging.Table)); err != nil {
+				return err
+			}
+			if err := b.ch.QueryContext(ctx, getMaskingUpdateQuery(t)); err != nil {
+				return fmt.Errorf("can't mask '%s.%s': %v", t.Staging.Database, t.Staging.Table, err)
+			}
+			log.WithField("table", fmt.Sprintf("%s.%s", t.Live.Database, t.Live.Table)).WithField("columns", len(t.Columns)).Info("masked")
+		}
+		return nil
+	}()
+	if restoreErr != nil {
+		b.dropStagingTables(stagingTables, log)
+		return restoreErr
+	}
+	if !dataOnly {
+		return b.applyStagingTables(ctx, stagingTables, false, log)
+	}
+	defer b.dropStagingTables(stagingTables, log)
+	for _, t := range maskingTables {
+		if err = b.attachMaskedPartitions(ctx, t); err != nil {
+			return fmt.Errorf("can't move masked data from '%s.%s' to '%s.%s': %v", t.Staging.Database, t.Staging.Table, t.Live.Database, t.Live.Table, err)
+		}
+	}
+	return nil
+}
+
+// prepareMaskingTables - rules are matched by database and table name from backup, the same as restore_table_mapping,
+// for --data live table shall exist, otherwise it is created empty together with staging table
+func (b *Backuper) prepareMaskingTables(ctx context.Context, backupName, tablePattern string, partitions []string, dataOnly bool) ([]maskingTable, error) {
+	metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
+	if tablePattern == "" {
+		tablePattern = "*"
+	}
+	tablesForRestore, _, err := b.getTableListByPatternLocal(ctx, metadataPath, tablePattern, false, partitions)
+	if err != nil {
+		return nil, err
+	}
+	maskingColumns := make(map[metadata.TableTitle]map[string]string)
+	for _, table := range tablesForRestore {
+		columns := getMaskingColumns(b.cfg.General.RestoreMasking, table.Database, table.Table)
+		if len(columns) == 0 || table.MetadataOnly {
+			continue
+		}
+		if !stagingTableEngineRE.MatchString(table.Query) || strings.HasPrefix(table.Table, ".inner") {
+			return nil, fmt.Errorf("restore_masking matched '%s.%s', only MergeTree tables could be masked, for materialized views mask the TO table", table.Database, table.Table)
+		}
+		// ALTER TABLE ... UPDATE can't change key columns, it shall fail before anything is restored
+		keyColumns := getTableKeyColumns(table.Query)
+		for column := range columns {
+			if key, isKeyColumn := keyColumns[column]; isKeyColumn {
+				return nil, fmt.Errorf("restore_masking rule for '%s.%s.%s' can't be applied, column is used in %s", table.Database, table.Table, column, key)
+			}
+		}
+		maskingColumns[metadata.TableTitle{Database: table.Database, Table: table.Table}] = columns
+	}
+	stagingTables, _, err := b.prepareStagingTables(ctx, backupName, tablePattern, partitions, getStagingTableSuffix("restore_masking"), !dataOnly)
+	if err != nil {
+		return nil, err
+	}
+	maskingTables := make([]maskingTable, 0, len(maskingColumns))
+	for _, t := range stagingTables {
+		columns, isMasked := maskingColumns[t.Source]
+		if !isMasked {
+			continue
+		}
+		delete(maskingColumns, t.Source)
+		maskingTables = append(maskingTables, maskingTable{stagingTable: t, Columns: columns})
+	}
+	for source := range maskingColumns {
+		live := b.getRestoreTargetTitle(source.Database, source.Table)
+		return nil, fmt.Errorf("restore_masking matched '%s.%s', but '%s.%s' doesn't exist, restore schema first or restore without --data", source.Database, source.Table, live.Database, live.Table)
+	}
+	stagingTables = make([]stagingTable, len(maskingTables))
+	for i, t := range maskingTables {
+		stagingTables[i] = t.stagingTable
+	}
+	b.setStagingTables(stagingTables)
+	return maskingTables, nil
+}
+
+func (b *Backuper) attachMaskedPartitions(ctx context.Context, t maskingTable) error {
+	partitionIds, err := b.getActivePartitionIds(ctx, t.Staging)
+	if err != nil {
+		return err
+	}
+	for _, partitionId := range partitionIds {
+		query := fmt.Sprintf("ALTER TABLE `%s`.`%s` ATTACH PARTITION ID '%s' FROM `%s`.`%s`", t.Live.Database, t.Live.Table, partitionId, t.Staging.Database, t.Staging.Table)
+		if err := b.ch.QueryContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var tableKeyClauseRE = regexp.MustCompile(`\b(PARTITION BY|PRIMARY KEY|ORDER BY|SAMPLE BY|TTL|SETTINGS|COMMENT)\b`)
+
+// getTableKeyColumns - identifiers from PARTITION BY, PRIMARY KEY, ORDER BY and SAMPLE BY clauses after ENGINE, column -> clause
+func getTableKeyColumns(query string) map[string]string {
+	keyColumns := make(map[string]string)
+	engineIdx := stagingTableEngineRE.FindStringIndex(query)
+	if engineIdx == nil {
+		return keyColumns
+	}
+	engineClause := query[engineIdx[1]:]
+	clauses := tableKeyClauseRE.FindAllStringSubmatchIndex(engineClause, -1)
+	for i, clause := range clauses {
+		key := engineClause[clause[2]:clause[3]]
+		if key == "TTL" || key == "SETTINGS" || key == "COMMENT" {
+			continue
+		}
+		expressionEnd := len(engineClause)
+		if i+1 < len(clauses) {
+			expressionEnd = clauses[i+1][0]
+		}
+		for _, identifier := range strings.FieldsFunc(engineClause[clause[1]:expressionEnd], func(r rune) bool {
+			return !(r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r))
+		}) {
+			if _, exists := keyColumns[identifier]; !exists {
+				keyColumns[identifier] = key
+			}
+		}
+	}
+	return keyColumns
+}
+
+// getMaskingColumns - `*` and `?` wildcards are allowed in database and table part of `db.table.column` rule
+func getMaskingColumns(maskingRules map[string]string, database, table string) map[string]string {
+	columns := make(map[string]string)
+	for rule, expression := range maskingRules {
+		ruleParts := strings.SplitN(rule, ".", 3)
+		if len(ruleParts) != 3 {
+			continue
+		}
+		if isMatched, _ := filepath.Match(ruleParts[0], database); !isMatched {
+			continue
+		}
+		if isMatched, _ := filepath.Match(ruleParts[1], table); !isMatched {
+			continue
+		}
+		columns[ruleParts[2]] = expression
+	}
+	return columns
+}
+
+func getMaskingUpdateQuery(t maskingTable) string {
+	columnNames := make([]string, 0, len(t.Columns))
+	for column := range t.Columns {
+		columnNames = append(columnNames, column)
+	}
+	sort.Strings(columnNames)
+	assignments := make([]string, len(columnNames))
+	for i, column := range columnNames {
+		assignments[i] = fmt.Sprintf("`%s` = %s", column, t.Columns[column])
+	}
+	return fmt.Sprintf("ALTER TABLE `%s`.`%s` UPDATE %s WHERE 1 SETTINGS mutations_sync=2", t.Staging.Database, t.Staging.Table, strings.Join(assignments, ", "))
+}
@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMaskingColumns(t *testing.T) {
+	rules := map[string]string{
+		"db.users.email":  "'user' || toString(id) || '@example.com'",
+		"db.users.phone":  "''",
+		"*.logs_*.ip":     "toIPv4('0.0.0.0')",
+		"other.users.ssn": "''",
+	}
+	assert.Equal(t, map[string]string{"email": rules["db.users.email"], "phone": "''"}, getMaskingColumns(rules, "db", "users"))
+	assert.Equal(t, map[string]string{"ip": "toIPv4('0.0.0.0')"}, getMaskingColumns(rules, "db2", "logs_2024"))
+	assert.Empty(t, getMaskingColumns(rules, "db", "orders"))
+
+	table := maskingTable{
+		stagingTable: stagingTable{Staging: metadata.TableTitle{Database: "db", Table: "users__restore_masking_20240102030405"}},
+		Columns:      getMaskingColumns(rules, "db", "users"),
+	}
+	assert.Equal(t, "ALTER TABLE `db`.`users__restore_masking_20240102030405` UPDATE `email` = 'user' || toString(id) || '@example.com', `phone` = '' WHERE 1 SETTINGS mutations_sync=2", getMaskingUpdateQuery(table))
+}
+
+func TestGetTableKeyColumns(t *testing.T) {
+	query := "CREATE TABLE db.users (id UInt64, email String, created DateTime, phone String) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/users', '{replica}') PARTITION BY toYYYYMM(created) ORDER BY (id, cityHash64(email)) SAMPLE BY cityHash64(email) TTL created + INTERVAL 1 YEAR SETTINGS index_granularity = 8192"
+	keyColumns := getTableKeyColumns(query)
+	assert.Equal(t, "PARTITION BY", keyColumns["created"])
+	assert.Equal(t, "ORDER BY", keyColumns["id"])
+	assert.Equal(t, "ORDER BY", keyColumns["email"])
+	assert.NotContains(t, keyColumns, "phone")
+	assert.NotContains(t, keyColumns, "index_granularity")
+	assert.Empty(t, getTableKeyColumns("CREATE TABLE db.t (id UInt64) ENGINE = Memory"))
+}
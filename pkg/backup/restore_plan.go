@@ -0,0 +1,512 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/partition"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+)
+
+// RestorePlan - what `restore` will do with current ClickHouse, result of `--dry-run`
+type RestorePlan struct {
+	BackupName        string             `json:"backup_name"`
+	Location          string             `json:"location"`
+	DropDatabases     []string           `json:"drop_databases"`
+	CreateDatabases   []string           `json:"create_databases"`
+	CreateFunctions   []string           `json:"create_functions"`
+	DropTables        []string           `json:"drop_tables"`
+	Tables            []RestorePlanTable `json:"tables"`
+	MissingTables     []string           `json:"missing_tables"`
+	ConflictingTables []string           `json:"conflicting_tables"`
+	DownloadBytes     uint64             `json:"download_bytes"`
+	RestoreRBAC       bool               `json:"restore_rbac"`
+	RestoreConfigs    bool               `json:"restore_configs"`
+	Atomic            bool               `json:"atomic,omitempty"`
+	Where             string             `json:"where,omitempty"`
+}
+
+// RestorePlanTable - table in restore order, Database and Table are target names after restore_table_mapping and restore_database_mapping
+type RestorePlanTable struct {
+	Database        string              `json:"database"`
+	Table           string              `json:"table"`
+	SourceDatabase  string              `json:"source_database,omitempty"`
+	SourceTable     string              `json:"source_table,omitempty"`
+	Order           int64               `json:"order"`
+	Drop            bool                `json:"drop"`
+	Create          bool                `json:"create"`
+	Parts           map[string][]string `json:"parts,omitempty"`
+	DiskMapping     map[string]string   `json:"disk_mapping,omitempty"`
+	ObjectDiskParts int                 `json:"object_disk_parts,omitempty"`
+	Size            uint64              `json:"size"`
+	// Staging - data restores into staging table first, Apply is how it moves into live table: exchange, replace partitions, insert where or attach partitions
+	Staging       bool     `json:"staging,omitempty"`
+	Apply         string   `json:"apply,omitempty"`
+	MaskedColumns []string `json:"masked_columns,omitempty"`
+	// ZookeeperPath - for created replicated tables, after restore_zookeeper_path_mapping and restore_replicated_conversion
+	ZookeeperPath string `json:"zookeeper_path,omitempty"`
+}
+
+var tableEngineRE = regexp.MustCompile(`ENGINE\s*=\s*(\w+)`)
+
+// restorePlanOptions - which restore stages will be executed, calculated from restore command flags
+type restorePlanOptions struct {
+	tablePattern   string
+	dropTable      bool
+	schemaOnly     bool
+	dataOnly       bool
+	restoreSchema  bool
+	restoreData    bool
+	restoreRBAC    bool
+	restoreConfigs bool
+	atomic         bool
+	where          string
+	partitions     bool
+}
+
+func newRestorePlanOptions(opts RestoreOptions) restorePlanOptions {
+	onlyAccessOrConfigs := opts.RBACOnly || opts.ConfigsOnly
+	return restorePlanOptions{
+		tablePattern:   opts.TablePattern,
+		dropTable:      opts.DropTable,
+		schemaOnly:     opts.SchemaOnly,
+		dataOnly:       opts.DataOnly,
+		restoreSchema:  !onlyAccessOrConfigs && (opts.SchemaOnly || opts.SchemaOnly == opts.DataOnly),
+		restoreData:    !onlyAccessOrConfigs && (opts.DataOnly || opts.SchemaOnly == opts.DataOnly),
+		restoreRBAC:    opts.RBAC || opts.RBACOnly,
+		restoreConfigs: opts.Configs || opts.ConfigsOnly,
+		atomic:         opts.Atomic,
+		where:          opts.Where,
+		partitions:     len(opts.Partitions) > 0,
+	}
+}
+
+// getRestorePlanLocal - plan for backup which already present in local storage
+func (b *Backuper) getRestorePlanLocal(ctx context.Context, backupMetadata *metadata.BackupMetadata, partitions []string, disks []clickhouse.Disk, opts restorePlanOptions) (*RestorePlan, error) {
+	metadataPath := path.Join(b.DefaultDataPath, "backup", backupMetadata.BackupName, "metadata")
+	if b.isEmbedded {
+		metadataPath = path.Join(b.EmbeddedBackupDataPath, backupMetadata.BackupName, "metadata")
+	}
+	tablesForRestore, _, err := b.getTableListByPatternLocal(ctx, metadataPath, opts.tablePattern, opts.dropTable, partitions)
+	if err != nil {
+		return nil, err
+	}
+	return b.getRestorePlan(ctx, "local", backupMetadata, tablesForRestore, disks, opts)
+}
+
+// getRestorePlan - compare tablesForRestore, already filtered by table pattern and partitions, with current ClickHouse state, nothing is changed
+func (b *Backuper) getRestorePlan(ctx context.Context, location string, backupMetadata *metadata.BackupMetadata, tablesForRestore ListOfTables, disks []clickhouse.Disk, opts restorePlanOptions) (*RestorePlan, error) {
+	plan := &RestorePlan{
+		BackupName:        backupMetadata.BackupName,
+		Location:          location,
+		DropDatabases:     make([]string, 0),
+		CreateDatabases:   make([]string, 0),
+		CreateFunctions:   make([]string, 0),
+		DropTables:        make([]string, 0),
+		Tables:            make([]RestorePlanTable, 0, len(tablesForRestore)),
+		MissingTables:     make([]string, 0),
+		ConflictingTables: make([]string, 0),
+		RestoreRBAC:       opts.restoreRBAC && !b.isEmbedded,
+		RestoreConfigs:    opts.restoreConfigs && !b.isEmbedded,
+		Atomic:            opts.atomic,
+		Where:             opts.where,
+	}
+	existsDatabases := make([]struct {
+		Name   string `ch:"name"`
+		Engine string `ch:"engine"`
+	}, 0)
+	if err := b.ch.SelectContext(ctx, &existsDatabases, "SELECT name, engine FROM system.databases"); err != nil {
+		return nil, err
+	}
+	isDatabaseExists := make(map[string]bool, len(existsDatabases))
+	databaseEngines := make(map[string]string, len(existsDatabases))
+	for _, db := range existsDatabases {
+		isDatabaseExists[db.Name] = true
+		databaseEngines[db.Name] = db.Engine
+	}
+	chTables, err := b.ch.GetTables(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	dstTablesMap := b.prepareDstTablesMap(chTables)
+	isDatabaseDropped := make(map[string]bool)
+
+	if opts.restoreSchema || opts.restoreData {
+		for _, database := range backupMetadata.Databases {
+			if IsInformationSchema(database.Name) {
+				continue
+			}
+			targetDB := database.Name
+			if mappedDB, isMapped := b.cfg.General.RestoreDatabaseMapping[database.Name]; isMapped {
+				targetDB = mappedDB
+			}
+			if ShallSkipDatabase(b.cfg, targetDB, opts.tablePattern) {
+				continue
+			}
+			if opts.schemaOnly && opts.dropTable && isDatabaseExists[targetDB] {
+				plan.DropDatabases = append(plan.DropDatabases, targetDB)
+				isDatabaseExists[targetDB] = false
+				isDatabaseDropped[targetDB] = true
+			}
+			if !isDatabaseExists[targetDB] {
+				plan.CreateDatabases = append(plan.CreateDatabases, targetDB)
+				isDatabaseExists[targetDB] = true
+			}
+		}
+	}
+	if opts.restoreSchema {
+		for _, function := range backupMetadata.Functions {
+			plan.CreateFunctions = append(plan.CreateFunctions, function.Name)
+		}
+	}
+
+	diskTypes := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		diskTypes[disk.Name] = disk.Type
+	}
+	targetTables := make(map[metadata.TableTitle]string, len(tablesForRestore))
+	for _, table := range tablesForRestore {
+		targetTitle := b.getRestoreTargetTitle(table.Database, table.Table)
+		targetDB := targetTitle.Database
+		targetName := fmt.Sprintf("%s.%s", targetDB, targetTitle.Table)
+		sourceName := fmt.Sprintf("%s.%s", table.Database, table.Table)
+		if previousSource, exists := targetTables[targetTitle]; exists {
+			plan.ConflictingTables = append(plan.ConflictingTables, fmt.Sprintf("'%s' and '%s' will restore to the same '%s'", previousSource, sourceName, targetName))
+			continue
+		}
+		targetTables[targetTitle] = sourceName
+		dstTable, tableExists := dstTablesMap[targetTitle]
+		tableExists = tableExists && !isDatabaseDropped[targetDB]
+		planTable := RestorePlanTable{
+			Database: targetDB,
+			Table:    targetTitle.Table,
+			Order:    getOrderByEngine(table.Query, opts.dropTable),
+		}
+		if targetDB != table.Database || targetTitle.Table != table.Table {
+			planTable.SourceDatabase = table.Database
+			planTable.SourceTable = table.Table
+		}
+		b.fillRestorePlanTableStaging(&planTable, table, dstTable, tableExists, databaseEngines[targetDB], opts, plan)
+		if opts.restoreSchema {
+			// restore --atomic and --where keep all existing tables, staged tables are not dropped either
+			keepExisting := opts.atomic || opts.where != "" || planTable.Staging
+			planTable.Drop = tableExists && !keepExisting
+			planTable.Create = !tableExists || !keepExisting
+			if planTable.Drop {
+				plan.DropTables = append(plan.DropTables, targetName)
+			}
+			if planTable.Create {
+				planTable.ZookeeperPath = getReplicatedZookeeperPath(adjustReplicatedEngineInQuery(table.Query, b.cfg.General.RestoreZookeeperPathMapping, b.cfg.General.RestoreReplicatedConversion))
+			}
+			if !isDatabaseExists[targetDB] {
+				plan.CreateDatabases = append(plan.CreateDatabases, targetDB)
+				isDatabaseExists[targetDB] = true
+			}
+		}
+		if opts.restoreData && !table.MetadataOnly {
+			if !opts.restoreSchema && !tableExists {
+				plan.MissingTables = append(plan.MissingTables, targetName)
+			}
+			if !opts.restoreSchema && tableExists {
+				if backupEngine := getTableEngineFromQuery(table.Query); backupEngine != "" && backupEngine != dstTable.Engine {
+					plan.ConflictingTables = append(plan.ConflictingTables, fmt.Sprintf("'%s' has engine %s in backup and %s in clickhouse", targetName, backupEngine, dstTable.Engine))
+				}
+			}
+			b.fillRestorePlanTableParts(&planTable, table, diskTypes, backupMetadata.DiskTypes, plan)
+		}
+		plan.Tables = append(plan.Tables, planTable)
+	}
+	return plan, nil
+}
+
+// fillRestorePlanTableStaging - the same choice as prepareStagingTables, prepareMaskingTables and applyStagingTables do
+func (b *Backuper) fillRestorePlanTableStaging(planTable *RestorePlanTable, table metadata.TableMetadata, dstTable clickhouse.Table, tableExists bool, databaseEngine string, opts restorePlanOptions, plan *RestorePlan) {
+	if !opts.restoreData || table.MetadataOnly {
+		return
+	}
+	if !stagingTableEngineRE.MatchString(table.Query) || strings.HasPrefix(table.Table, ".inner") {
+		if !opts.atomic && opts.where == "" && len(getMaskingColumns(b.cfg.General.RestoreMasking, table.Database, table.Table)) > 0 {
+			plan.ConflictingTables = append(plan.ConflictingTables, fmt.Sprintf("'%s.%s' matched restore_masking, only MergeTree tables could be masked", planTable.Database, planTable.Table))
+		}
+		return
+	}
+	t := stagingTable{CreateLive: !tableExists, LiveReplicated: strings.HasPrefix(dstTable.Engine, "Replicated"), LiveDatabaseEngine: databaseEngine}
+	if !tableExists {
+		t.LiveReplicated = replicatedEngineRE.MatchString(adjustReplicatedEngineInQuery(table.Query, b.cfg.General.RestoreZookeeperPathMapping, b.cfg.General.RestoreReplicatedConversion))
+	}
+	apply := "exchange"
+	if !t.isExchangeable() {
+		apply = "replace partitions"
+	}
+	switch {
+	case opts.atomic:
+		if !tableExists {
+			return
+		}
+		if opts.partitions {
+			apply = "replace partitions"
+		} else if !t.LiveReplicated && !t.isExchangeable() {
+			plan.ConflictingTables = append(plan.ConflictingTables, fmt.Sprintf("'%s.%s' require Atomic or Replicated database engine for --atomic without --partitions, got %s", planTable.Database, planTable.Table, databaseEngine))
+		}
+	case opts.where != "":
+		if !tableExists && opts.dataOnly {
+			return
+		}
+		apply = "insert where"
+	default:
+		columns := getMaskingColumns(b.cfg.General.RestoreMasking, table.Database, table.Table)
+		if len(columns) == 0 || (!tableExists && opts.dataOnly) {
+			return
+		}
+		if opts.dataOnly {
+			apply = "attach partitions"
+		}
+		keyColumns := getTableKeyColumns(table.Query)
+		for column := range columns {
+			if key, isKeyColumn := keyColumns[column]; isKeyColumn {
+				plan.ConflictingTables = append(plan.ConflictingTables, fmt.Sprintf("'%s.%s' column %s can't be masked, it is used in %s", planTable.Database, planTable.Table, column, key))
+			}
+		}
+		for column := range columns {
+			planTable.MaskedColumns = append(planTable.MaskedColumns, column)
+		}
+		sort.Strings(planTable.MaskedColumns)
+	}
+	planTable.Staging = true
+	planTable.Apply = apply
+}
+
+// fillRestorePlanTableParts - parts which will attach and disks where they will be placed, the same way as adjustDisksFromTablesWithSystemDisks
+func (b *Backuper) fillRestorePlanTableParts(planTable *RestorePlanTable, table metadata.TableMetadata, diskTypes, backupDiskTypes map[string]string, plan *RestorePlan) {
+	for disk, parts := range table.Parts {
+		if len(parts) == 0 {
+			continue
+		}
+		if planTable.Parts == nil {
+			planTable.Parts = make(map[string][]string)
+		}
+		diskType, diskExists := diskTypes[disk]
+		if !diskExists {
+			diskType = backupDiskTypes[disk]
+			if diskType != diskTypes["default"] {
+				plan.ConflictingTables = append(plan.ConflictingTables, fmt.Sprintf("'%s.%s' require disk '%s' with type %s which not found in system.disks", planTable.Database, planTable.Table, disk, diskType))
+			} else {
+				if planTable.DiskMapping == nil {
+					planTable.DiskMapping = make(map[string]string)
+				}
+				planTable.DiskMapping[disk] = "default"
+			}
+		}
+		for _, part := range parts {
+			planTable.Parts[disk] = append(planTable.Parts[disk], part.Name)
+		}
+		if diskType == "s3" || diskType == "azure_blob_storage" {
+			planTable.ObjectDiskParts += len(parts)
+		}
+	}
+	for _, size := range table.Size {
+		planTable.Size += uint64(size)
+	}
+}
+
+func getTableEngineFromQuery(query string) string {
+	if matches := tableEngineRE.FindStringSubmatch(query); len(matches) > 1 {
+		return matches[1]
+	}
+	if strings.HasPrefix(query, "CREATE MATERIALIZED VIEW") || strings.HasPrefix(query, "ATTACH MATERIALIZED VIEW") {
+		return "MaterializedView"
+	}
+	return ""
+}
+
+// getTableListForRestoreRemote - remote analog of getTableListByPatternLocal, table metadata is read without download
+func (b *Backuper) getTableListForRestoreRemote(ctx context.Context, remoteBackup *storage.Backup, tablePattern string, dropTable bool, partitions []string) (ListOfTables, error) {
+	remoteTables, err := getTableListByPatternRemote(ctx, b, &remoteBackup.BackupMetadata, tablePattern, dropTable)
+	if err != nil {
+		return nil, err
+	}
+	tablesForRestore := ListOfTables{}
+	for _, t := range remoteTables {
+		if b.shouldSkipByTableEngine(t) {
+			continue
+		}
+		partitionsIdMap, _ := partition.ConvertPartitionsToIdsMapAndNamesList(ctx, b.ch, nil, []metadata.TableMetadata{t}, partitions)
+		filterPartsAndFilesByPartitionsFilter(t, partitionsIdMap[metadata.TableTitle{Database: t.Database, Table: t.Table}])
+		tablesForRestore = append(tablesForRestore, t)
+	}
+	return tablesForRestore, nil
+}
+
+// getDownloadSizeForRestore - how much data `download` will fetch for required backup, when download_by_part: false whole required backups chain is downloaded as well
+func (b *Backuper) getDownloadSizeForRestore(ctx context.Context, remoteBackups []storage.Backup, localBackups []LocalBackup, backupName, tablePattern string, partitions []string) (uint64, error) {
+	for _, localBackup := range localBackups {
+		if localBackup.BackupName == backupName {
+			return 0, nil
+		}
+	}
+	var remoteBackup *storage.Backup
+	for i := range remoteBackups {
+		if remoteBackups[i].BackupName == backupName {
+			remoteBackup = &remoteBackups[i]
+			break
+		}
+	}
+	if remoteBackup == nil {
+		return 0, fmt.Errorf("'%s' is not found on remote storage", backupName)
+	}
+	tables, err := b.getTableListForRestoreRemote(ctx, remoteBackup, tablePattern, false, partitions)
+	if err != nil {
+		return 0, err
+	}
+	size := uint64(0)
+	for _, t := range tables {
+		size += getTableDownloadSize(t)
+	}
+	if remoteBackup.RequiredBackup != "" && !b.cfg.General.DownloadByPart {
+		requiredSize, err := b.getDownloadSizeForRestore(ctx, remoteBackups, localBackups, remoteBackup.RequiredBackup, tablePattern, partitions)
+		if err != nil {
+			return 0, err
+		}
+		size += requiredSize
+	}
+	return size, nil
+}
+
+func getTableDownloadSize(t metadata.TableMetadata) uint64 {
+	if t.MetadataOnly {
+		return 0
+	}
+	size := uint64(0)
+	if len(t.FilesSize) > 0 {
+		for _, files := range t.Files {
+			for _, file := range files {
+				size += uint64(t.FilesSize[file])
+			}
+		}
+		return size
+	}
+	for _, parts := range t.Parts {
+		for _, part := range parts {
+			if !part.Required {
+				size += uint64(part.Size)
+			}
+		}
+	}
+	if size == 0 {
+		size = t.TotalBytes
+	}
+	return size
+}
+
+// outputRestorePlan - CLI prints plan, for API plan is stored as command result
+func outputRestorePlan(commandId int, plan *RestorePlan) error {
+	if commandId == status.NotFromAPI {
+		return printRestorePlan(os.Stdout, plan)
+	}
+	body, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	status.Current.SetRestorePlan(commandId, body)
+	return nil
+}
+
+func printRestorePlan(w io.Writer, plan *RestorePlan) error {
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	printList := func(title string, items []string) {
+		if len(items) > 0 {
+			printf("%s:\n", title)
+			for _, item := range items {
+				printf("  %s\n", item)
+			}
+		}
+	}
+	printf("restore plan for %s backup %s, nothing will be changed\n", plan.Location, plan.BackupName)
+	if plan.DownloadBytes > 0 {
+		printf("download: %s\n", utils.FormatBytes(plan.DownloadBytes))
+	}
+	if plan.RestoreRBAC {
+		printf("restore RBAC objects and restart clickhouse-server\n")
+	}
+	if plan.RestoreConfigs {
+		printf("restore configs and restart clickhouse-server\n")
+	}
+	if plan.Atomic {
+		printf("atomic: existing tables are kept, data restores into staging tables\n")
+	}
+	if plan.Where != "" {
+		printf("where: %s\n", plan.Where)
+	}
+	printList("drop databases", plan.DropDatabases)
+	printList("create databases", plan.CreateDatabases)
+	printList("create functions", plan.CreateFunctions)
+	printList("drop tables", plan.DropTables)
+	if len(plan.Tables) > 0 {
+		printf("tables in restore order:\n")
+	}
+	for _, t := range plan.Tables {
+		action := make([]string, 0, 3)
+		if t.Drop {
+			action = append(action, "drop")
+		}
+		if t.Create {
+			action = append(action, "create")
+		}
+		partsCount := 0
+		for _, parts := range t.Parts {
+			partsCount += len(parts)
+		}
+		if partsCount > 0 {
+			action = append(action, fmt.Sprintf("attach %d parts", partsCount))
+		}
+		if t.Staging {
+			action = append(action, fmt.Sprintf("%s from staging table", t.Apply))
+		}
+		source := ""
+		if t.SourceDatabase != "" {
+			source = fmt.Sprintf(" from %s.%s", t.SourceDatabase, t.SourceTable)
+		}
+		printf("  %d %s.%s%s: %s, size: %s\n", t.Order, t.Database, t.Table, source, strings.Join(action, ", "), utils.FormatBytes(t.Size))
+		planDisks := make([]string, 0, len(t.Parts))
+		for disk := range t.Parts {
+			planDisks = append(planDisks, disk)
+		}
+		sort.Strings(planDisks)
+		for _, disk := range planDisks {
+			parts := t.Parts[disk]
+			diskInfo := disk
+			if mappedDisk, isMapped := t.DiskMapping[disk]; isMapped {
+				diskInfo = fmt.Sprintf("%s -> %s", disk, mappedDisk)
+			}
+			printf("    disk %s: %s\n", diskInfo, strings.Join(parts, ", "))
+		}
+		if t.ObjectDiskParts > 0 {
+			printf("    object disk parts copy: %d\n", t.ObjectDiskParts)
+		}
+		if len(t.MaskedColumns) > 0 {
+			printf("    masked columns: %s\n", strings.Join(t.MaskedColumns, ", "))
+		}
+		if t.ZookeeperPath != "" {
+			printf("    zookeeper path: %s\n", t.ZookeeperPath)
+		}
+	}
+	printList("missing tables", plan.MissingTables)
+	printList("conflicting tables", plan.ConflictingTables)
+	return err
+}
@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRestorePlanOptions(t *testing.T) {
+	opts := newRestorePlanOptions(RestoreOptions{TablePattern: "db.*", DropTable: true})
+	assert.True(t, opts.restoreSchema)
+	assert.True(t, opts.restoreData)
+
+	opts = newRestorePlanOptions(RestoreOptions{SchemaOnly: true})
+	assert.True(t, opts.restoreSchema)
+	assert.False(t, opts.restoreData)
+
+	opts = newRestorePlanOptions(RestoreOptions{DataOnly: true})
+	assert.False(t, opts.restoreSchema)
+	assert.True(t, opts.restoreData)
+
+	opts = newRestorePlanOptions(RestoreOptions{RBACOnly: true})
+	assert.False(t, opts.restoreSchema)
+	assert.False(t, opts.restoreData)
+	assert.True(t, opts.restoreRBAC)
+
+	opts = newRestorePlanOptions(RestoreOptions{Atomic: true, Where: "id > 1", Partitions: []string{"202401"}})
+	assert.True(t, opts.atomic)
+	assert.Equal(t, "id > 1", opts.where)
+	assert.True(t, opts.partitions)
+}
+
+func TestFillRestorePlanTableStaging(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RestoreMasking = map[string]string{"db.users.email": "''", "db.users.id": "0"}
+	b := NewBackuper(cfg)
+	mergeTree := metadata.TableMetadata{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"}
+	replicated := metadata.TableMetadata{Database: "db", Table: "t", Query: "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/db/t', '{replica}') ORDER BY id"}
+
+	fill := func(table metadata.TableMetadata, dstTable clickhouse.Table, tableExists bool, databaseEngine string, opts restorePlanOptions) (RestorePlanTable, *RestorePlan) {
+		planTable := RestorePlanTable{Database: table.Database, Table: table.Table}
+		plan := &RestorePlan{}
+		b.fillRestorePlanTableStaging(&planTable, table, dstTable, tableExists, databaseEngine, opts, plan)
+		return planTable, plan
+	}
+	planTable, plan := fill(mergeTree, clickhouse.Table{Engine: "MergeTree"}, true, "Atomic", restorePlanOptions{restoreData: true, atomic: true})
+	assert.Equal(t, "exchange", planTable.Apply)
+	assert.Empty(t, plan.ConflictingTables)
+
+	planTable, _ = fill(replicated, clickhouse.Table{Engine: "ReplicatedMergeTree"}, true, "Atomic", restorePlanOptions{restoreData: true, atomic: true})
+	assert.Equal(t, "replace partitions", planTable.Apply)
+
+	_, plan = fill(mergeTree, clickhouse.Table{Engine: "MergeTree"}, true, "Ordinary", restorePlanOptions{restoreData: true, atomic: true})
+	assert.Len(t, plan.ConflictingTables, 1)
+
+	planTable, _ = fill(mergeTree, clickhouse.Table{}, false, "", restorePlanOptions{restoreData: true, atomic: true})
+	assert.False(t, planTable.Staging)
+
+	planTable, _ = fill(mergeTree, clickhouse.Table{}, false, "Atomic", restorePlanOptions{restoreData: true, where: "id > 1"})
+	assert.Equal(t, "insert where", planTable.Apply)
+
+	users := metadata.TableMetadata{Database: "db", Table: "users", Query: "CREATE TABLE db.users (id UInt64, email String) ENGINE = MergeTree ORDER BY id"}
+	planTable, plan = fill(users, clickhouse.Table{Engine: "MergeTree"}, true, "Atomic", restorePlanOptions{restoreData: true, dataOnly: true})
+	assert.Equal(t, "attach partitions", planTable.Apply)
+	assert.Equal(t, []string{"email", "id"}, planTable.MaskedColumns)
+	assert.Equal(t, []string{"'db.users' column id can't be masked, it is used in ORDER BY"}, plan.ConflictingTables)
+}
+
+func TestGetTableEngineFromQuery(t *testing.T) {
+	assert.Equal(t, "ReplicatedMergeTree", getTableEngineFromQuery("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/{shard}/t', '{replica}') ORDER BY id"))
+	assert.Equal(t, "MaterializedView", getTableEngineFromQuery("CREATE MATERIALIZED VIEW db.mv TO db.t AS SELECT * FROM db.src"))
+	assert.Equal(t, "", getTableEngineFromQuery("CREATE VIEW db.v AS SELECT 1"))
+}
+
+func TestGetTableDownloadSize(t *testing.T) {
+	archived := metadata.TableMetadata{
+		Files:      map[string][]string{"default": {"default_1.tar", "default_2.tar"}},
+		FilesSize:  map[string]int64{"default_1.tar": 100, "default_2.tar": 50, "default_3.tar": 1000},
+		TotalBytes: 5000,
+	}
+	assert.Equal(t, uint64(150), getTableDownloadSize(archived))
+
+	directory := metadata.TableMetadata{
+		Parts:      map[string][]metadata.Part{"default": {{Name: "1_1_1_0", Size: 10}, {Name: "2_2_2_0", Size: 20, Required: true}}},
+		TotalBytes: 5000,
+	}
+	assert.Equal(t, uint64(10), getTableDownloadSize(directory))
+
+	directory.Parts["default"][0].Size = 0
+	assert.Equal(t, uint64(5000), getTableDownloadSize(directory))
+
+	assert.Equal(t, uint64(0), getTableDownloadSize(metadata.TableMetadata{MetadataOnly: true, TotalBytes: 5000}))
+}
+
+func TestPrintRestorePlan(t *testing.T) {
+	plan := &RestorePlan{
+		BackupName:      "backup",
+		Location:        "remote",
+		CreateDatabases: []string{"db2"},
+		DropTables:      []string{"db2.t1"},
+		Tables: []RestorePlanTable{
+			{Database: "db2", Table: "t1", SourceDatabase: "db1", SourceTable: "t1", Drop: true, Create: true, Parts: map[string][]string{"hdd": {"1_1_1_0"}, "default": {"2_2_2_0", "3_3_3_0"}}, DiskMapping: map[string]string{"hdd": "default"}, Size: 1024},
+		},
+		MissingTables:     []string{},
+		ConflictingTables: []string{"'db2.t2' has engine MergeTree in backup and Log in clickhouse"},
+		DownloadBytes:     2048,
+		Atomic:            true,
+	}
+	plan.Tables = append(plan.Tables, RestorePlanTable{Database: "db2", Table: "t3", Create: true, Staging: true, Apply: "replace partitions", MaskedColumns: []string{"email"}, ZookeeperPath: "/clickhouse/tables/db2/t3"})
+	out := bytes.Buffer{}
+	require.NoError(t, printRestorePlan(&out, plan))
+	assert.Contains(t, out.String(), "download: 2.00KiB")
+	assert.Contains(t, out.String(), "0 db2.t1 from db1.t1: drop, create, attach 3 parts, size: 1.00KiB")
+	assert.Contains(t, out.String(), "    disk default: 2_2_2_0, 3_3_3_0\n    disk hdd -> default: 1_1_1_0\n")
+	assert.Contains(t, out.String(), "conflicting tables:\n  'db2.t2' has engine MergeTree")
+	assert.NotContains(t, out.String(), "missing tables")
+	assert.Contains(t, out.String(), "atomic: existing tables are kept")
+	assert.Contains(t, out.String(), "0 db2.t3: create, replace partitions from staging table, size: 0B\n    masked columns: email\n    zookeeper path: /clickhouse/tables/db2/t3\n")
+}
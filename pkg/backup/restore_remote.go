@@ -1,11 +1,124 @@
 package backup
 
-func (b *Backuper) RestoreFromRemote(backupName, tablePattern string, databaseMapping, partitions []string, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly, resume bool, commandId int) error {
-	if err := b.Download(backupName, tablePattern, partitions, schemaOnly, resume, commandId); err != nil {
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+)
+
+func (b *Backuper) RestoreFromRemote(backupName string, opts RestoreOptions, commandId int) (err error) {
+	if opts.DryRun {
+		return b.restoreFromRemoteDryRun(backupName, opts, commandId)
+	}
+	notify := b.startNotification("restore_remote")
+	defer func() {
+		b.finishNotification(notify, backupName, err)
+	}()
+	if err := b.Download(backupName, opts.TablePattern, opts.Partitions, opts.SchemaOnly, opts.Resume, commandId); err != nil {
 		// https://github.com/Altinity/clickhouse-backup/issues/625
 		if err != ErrBackupIsAlreadyExists {
 			return err
 		}
 	}
-	return b.Restore(backupName, tablePattern, databaseMapping, partitions, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, rbacOnly, restoreConfigs, configsOnly, commandId)
+	opts.DryRun = false
+	return b.Restore(backupName, opts, commandId)
+}
+
+// restoreFromRemoteDryRun - print restore plan based on remote metadata, nothing is downloaded, local backup is used when already exists the same as download does
+func (b *Backuper) restoreFromRemoteDryRun(backupName string, opts RestoreOptions, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	b.setCommandLog(commandId)
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("select backup for restore")
+	}
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("general->remote_storage: %s doesn't support restore_remote --dry-run", b.cfg.General.RemoteStorage)
+	}
+	if err = b.prepareRestoreDatabaseMapping(opts.DatabaseMapping); err != nil {
+		return err
+	}
+	if err = b.prepareRestoreTableMapping(opts.TableMapping); err != nil {
+		return err
+	}
+	if err = b.prepareRestoreReplicatedEngine(opts.ZookeeperPathMapping, opts.ReplicatedConversion); err != nil {
+		return err
+	}
+	if err = b.validateRestoreOptions(opts); err != nil {
+		return err
+	}
+	if err = b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	localBackups, disks, err := b.GetLocalBackups(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, localBackup := range localBackups {
+		if localBackup.BackupName == backupName {
+			b.log.Warnf("'%s' already exists locally, download will be skipped", backupName)
+			return b.Restore(backupName, opts, commandId)
+		}
+	}
+	if err = b.init(ctx, disks, ""); err != nil {
+		return err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	remoteBackups, err := b.dst.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	var remoteBackup *storage.Backup
+	for i := range remoteBackups {
+		if remoteBackups[i].BackupName == backupName {
+			remoteBackup = &remoteBackups[i]
+			break
+		}
+	}
+	if remoteBackup == nil {
+		return fmt.Errorf("'%s' is not found on remote storage", backupName)
+	}
+	if remoteBackup.Legacy {
+		return fmt.Errorf("'%s' is old-format backup, --dry-run is not supported", backupName)
+	}
+	if remoteBackup.Broken != "" {
+		return fmt.Errorf("'%s' is %s", backupName, remoteBackup.Broken)
+	}
+	planOpts := newRestorePlanOptions(opts)
+	b.isEmbedded = strings.Contains(remoteBackup.Tags, "embedded")
+	tablesForRestore, err := b.getTableListForRestoreRemote(ctx, remoteBackup, opts.TablePattern, opts.DropTable, opts.Partitions)
+	if err != nil {
+		return err
+	}
+	plan, err := b.getRestorePlan(ctx, "remote", &remoteBackup.BackupMetadata, tablesForRestore, disks, planOpts)
+	if err != nil {
+		return err
+	}
+	if !opts.SchemaOnly {
+		for _, t := range tablesForRestore {
+			plan.DownloadBytes += getTableDownloadSize(t)
+		}
+		if remoteBackup.RequiredBackup != "" && !b.cfg.General.DownloadByPart {
+			requiredSize, err := b.getDownloadSizeForRestore(ctx, remoteBackups, localBackups, remoteBackup.RequiredBackup, opts.TablePattern, opts.Partitions)
+			if err != nil {
+				return err
+			}
+			plan.DownloadBytes += requiredSize
+		}
+	}
+	return outputRestorePlan(commandId, plan)
 }
@@ -0,0 +1,210 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+)
+
+// stagingTable - data for live table restores into staging table first, used by restore --atomic and restore --where
+type stagingTable struct {
+	Source        metadata.TableTitle
+	Live          metadata.TableTitle
+	Staging       metadata.TableTitle
+	ExpectedParts uint64
+	// CreateLive - live table doesn't exist, it will be created empty during restore schema together with staging table
+	CreateLive bool
+	// LiveReplicated - staging table is created without ZooKeeper path, so data moves into replicated live table via REPLACE PARTITION, EXCHANGE TABLES would take live table out of its replicas
+	LiveReplicated bool
+	// LiveDatabaseEngine - EXCHANGE TABLES is available only for Atomic and Replicated databases, empty when database doesn't exist yet
+	LiveDatabaseEngine string
+}
+
+// isExchangeable - live table could be replaced by staging table with EXCHANGE TABLES
+func (t stagingTable) isExchangeable() bool {
+	return !t.CreateLive && !t.LiveReplicated && (t.LiveDatabaseEngine == "Atomic" || t.LiveDatabaseEngine == "Replicated")
+}
+
+var stagingTableEngineRE = regexp.MustCompile(`ENGINE = [a-zA-Z]*MergeTree`)
+
+// prepareStagingTables - existing MergeTree tables with data will restore into `<table><suffix>`, other existing tables are not changed,
+// when stageMissing is true, not existing MergeTree tables restore into staging tables too and live tables are created empty,
+// returns table pattern for RestoreSchema which contains only staged and not existing tables
+func (b *Backuper) prepareStagingTables(ctx context.Context, backupName, tablePattern string, partitions []string, suffix string, stageMissing bool) ([]stagingTable, string, error) {
+	b.stagingTables = nil
+	metadataPath := path.Join(b.DefaultDataPath, "backup", backupName, "metadata")
+	if tablePattern == "" {
+		tablePattern = "*"
+	}
+	tablesForRestore, _, err := b.getTableListByPatternLocal(ctx, metadataPath, tablePattern, false, partitions)
+	if err != nil {
+		return nil, "", err
+	}
+	chTablePattern := tablePattern
+	if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
+		chTablePattern = b.changeTablePatternFromRestoreDatabaseMapping(chTablePattern)
+	}
+	if len(b.cfg.General.RestoreTableMapping) > 0 {
+		chTablePattern = b.changeTablePatternFromRestoreTableMapping(chTablePattern)
+	}
+	chTables, err := b.ch.GetTables(ctx, chTablePattern)
+	if err != nil {
+		return nil, "", err
+	}
+	dstTablesMap := b.prepareDstTablesMap(chTables)
+	databases := make([]struct {
+		Name   string `ch:"name"`
+		Engine string `ch:"engine"`
+	}, 0)
+	if err = b.ch.SelectContext(ctx, &databases, "SELECT name, engine FROM system.databases"); err != nil {
+		return nil, "", err
+	}
+	databaseEngines := make(map[string]string, len(databases))
+	for _, db := range databases {
+		databaseEngines[db.Name] = db.Engine
+	}
+	stagingTables, schemaTablePatterns := b.getStagingTables(tablesForRestore, dstTablesMap, databaseEngines, suffix, stageMissing)
+	b.setStagingTables(stagingTables)
+	return stagingTables, strings.Join(schemaTablePatterns, ","), nil
+}
+
+// getStagingTables - schema patterns contain staged and not existing tables, existing tables which are not staged,
+// like inner tables of materialized views or not MergeTree tables, are kept as is and their data shall not be restored
+func (b *Backuper) getStagingTables(tablesForRestore ListOfTables, dstTablesMap map[metadata.TableTitle]clickhouse.Table, databaseEngines map[string]string, suffix string, stageMissing bool) ([]stagingTable, []string) {
+	stagingTables := make([]stagingTable, 0)
+	schemaTablePatterns := make([]string, 0)
+	for _, table := range tablesForRestore {
+		live := b.getRestoreTargetTitle(table.Database, table.Table)
+		dstTable, exists := dstTablesMap[live]
+		// inner tables will restore together with their materialized views
+		if table.MetadataOnly || !stagingTableEngineRE.MatchString(table.Query) || strings.HasPrefix(table.Table, ".inner") || (!exists && !stageMissing) {
+			if !exists {
+				schemaTablePatterns = append(schemaTablePatterns, table.Database+"."+table.Table)
+			} else if !table.MetadataOnly {
+				b.log.WithField("table", fmt.Sprintf("%s.%s", live.Database, live.Table)).Info("table exists and can't be staged, keep it as is")
+			}
+			continue
+		}
+		isLiveReplicated := strings.HasPrefix(dstTable.Engine, "Replicated")
+		if !exists {
+			isLiveReplicated = replicatedEngineRE.MatchString(adjustReplicatedEngineInQuery(table.Query, b.cfg.General.RestoreZookeeperPathMapping, b.cfg.General.RestoreReplicatedConversion))
+		}
+		stagingTables = append(stagingTables, stagingTable{
+			Source:             metadata.TableTitle{Database: table.Database, Table: table.Table},
+			Live:               live,
+			Staging:            metadata.TableTitle{Database: live.Database, Table: live.Table + suffix},
+			ExpectedParts:      getStagingTableExpectedParts(table),
+			CreateLive:         !exists,
+			LiveReplicated:     isLiveReplicated,
+			LiveDatabaseEngine: databaseEngines[live.Database],
+		})
+		schemaTablePatterns = append(schemaTablePatterns, table.Database+"."+table.Table)
+	}
+	return stagingTables, schemaTablePatterns
+}
+
+func (b *Backuper) setStagingTables(stagingTables []stagingTable) {
+	b.stagingTables = make(map[metadata.TableTitle]stagingTable, len(stagingTables))
+	for _, t := range stagingTables {
+		b.stagingTables[t.Live] = t
+	}
+}
+
+func getStagingTableSuffix(kind string) string {
+	return fmt.Sprintf("__%s_%s", kind, time.Now().Format("20060102150405"))
+}
+
+// restoreStagingTablesData - attach data parts to already created staging tables and check all parts are active
+func (b *Backuper) restoreStagingTablesData(ctx context.Context, backupName, dataTablePattern string, stagingTables []stagingTable, partitions []string, disks []clickhouse.Disk) error {
+	// background merges will change active parts count
+	for _, t := range stagingTables {
+		if err := b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM STOP MERGES `%s`.`%s`", t.Staging.Database, t.Staging.Table)); err != nil {
+			return err
+		}
+	}
+	if err := b.RestoreData(ctx, backupName, dataTablePattern, partitions, disks); err != nil {
+		return err
+	}
+	for _, t := range stagingTables {
+		if err := b.checkStagingTableParts(ctx, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changeTableQueryToAdjustStagingTables - rename only staging table itself, references from other objects shall point to live table,
+// live table which doesn't exist yet is created by origin query just before staging table,
+// staging table is converted to not replicated engine, so its parts are not visible to other replicas and ZooKeeper path of live table is not touched
+func (b *Backuper) changeTableQueryToAdjustStagingTables(originTables *ListOfTables) error {
+	adjustedTables := make(ListOfTables, 0, len(*originTables))
+	for _, table := range *originTables {
+		t, isStaging := b.stagingTables[metadata.TableTitle{Database: table.Database, Table: table.Table}]
+		if !isStaging {
+			adjustedTables = append(adjustedTables, table)
+			continue
+		}
+		if t.CreateLive {
+			adjustedTables = append(adjustedTables, table)
+		}
+		renamedTables := ListOfTables{table}
+		if err := changeTableQueryToAdjustTableMapping(&renamedTables, map[string]string{table.Database + "." + table.Table: t.Staging.Database + "." + t.Staging.Table}); err != nil {
+			return err
+		}
+		renamedTables[0].Query = adjustReplicatedEngineInQuery(renamedTables[0].Query, nil, ReplicatedConversionToMergeTree)
+		adjustedTables = append(adjustedTables, renamedTables[0])
+	}
+	*originTables = adjustedTables
+	return nil
+}
+
+func getStagingTableExpectedParts(table metadata.TableMetadata) uint64 {
+	expectedParts := uint64(0)
+	for _, parts := range table.Parts {
+		for _, part := range parts {
+			if !strings.HasSuffix(part.Name, ".proj") {
+				expectedParts += 1
+			}
+		}
+	}
+	return expectedParts
+}
+
+func getStagingTablesPattern(stagingTables []stagingTable) string {
+	tablePatterns := make([]string, len(stagingTables))
+	for i, t := range stagingTables {
+		tablePatterns[i] = t.Source.Database + "." + t.Source.Table
+	}
+	return strings.Join(tablePatterns, ",")
+}
+
+func (b *Backuper) checkStagingTableParts(ctx context.Context, t stagingTable) error {
+	var activeParts uint64
+	if err := b.ch.SelectSingleRow(ctx, &activeParts, "SELECT count() FROM system.parts WHERE active AND database=? AND table=?", t.Staging.Database, t.Staging.Table); err != nil {
+		return err
+	}
+	if activeParts != t.ExpectedParts {
+		return fmt.Errorf("'%s.%s' contains %d active parts after restore, expected %d, '%s.%s' will not change", t.Staging.Database, t.Staging.Table, activeParts, t.ExpectedParts, t.Live.Database, t.Live.Table)
+	}
+	return nil
+}
+
+func (b *Backuper) dropStagingTables(stagingTables []stagingTable, log *apexLog.Entry) {
+	for _, t := range stagingTables {
+		if err := b.dropStagingTable(t.Staging); err != nil {
+			log.Warnf("can't drop staging table '%s.%s': %v", t.Staging.Database, t.Staging.Table, err)
+		}
+	}
+}
+
+// dropStagingTable - staging tables are not compatible with restore_schema_on_cluster, so drop without ON CLUSTER
+func (b *Backuper) dropStagingTable(table metadata.TableTitle) error {
+	return b.ch.DropTable(clickhouse.Table{Database: table.Database, Name: table.Table}, "", "", false, 0, b.DefaultDataPath)
+}
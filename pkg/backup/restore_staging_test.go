@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeTableQueryToAdjustStagingTables(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RestoreDatabaseMapping = map[string]string{"db": "db2"}
+	b := NewBackuper(cfg)
+	live := metadata.TableTitle{Database: "db2", Table: "events"}
+	staging := metadata.TableTitle{Database: "db2", Table: "events__restore_20240102030405"}
+	b.setStagingTables([]stagingTable{{Live: live, Staging: staging}})
+	assert.Equal(t, staging, b.getRestoreTargetTitle("db", "events"))
+	assert.Equal(t, metadata.TableTitle{Database: "db2", Table: "other"}, b.getRestoreTargetTitle("db", "other"))
+
+	tables := ListOfTables{
+		{Database: "db2", Table: "events", Query: "CREATE TABLE db2.events UUID '00000000-0000-0000-0000-000000000001' (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db2/events', '{replica}') ORDER BY id"},
+		{Database: "db2", Table: "events_mv", Query: "CREATE MATERIALIZED VIEW db2.events_mv TO db2.events (id UInt64) AS SELECT id FROM db2.events_raw"},
+	}
+	require.NoError(t, b.changeTableQueryToAdjustStagingTables(&tables))
+	assert.Equal(t, staging.Table, tables[0].Table)
+	assert.Contains(t, tables[0].Query, "CREATE TABLE db2.events__restore_20240102030405 UUID ")
+	assert.NotContains(t, tables[0].Query, "00000000-0000-0000-0000-000000000001")
+	// staging table doesn't use ZooKeeper, parts are not replicated before they are moved into live table
+	assert.Contains(t, tables[0].Query, "ENGINE = MergeTree() ORDER BY id")
+	assert.NotContains(t, tables[0].Query, "/clickhouse/tables")
+	// references from other objects keep live table
+	assert.Equal(t, "CREATE MATERIALIZED VIEW db2.events_mv TO db2.events (id UInt64) AS SELECT id FROM db2.events_raw", tables[1].Query)
+}
+
+func TestChangeTableQueryToAdjustStagingTablesCreateLive(t *testing.T) {
+	b := NewBackuper(config.DefaultConfig())
+	live := metadata.TableTitle{Database: "db", Table: "events"}
+	staging := metadata.TableTitle{Database: "db", Table: "events__restore_where_20240102030405"}
+	b.setStagingTables([]stagingTable{{Live: live, Staging: staging, CreateLive: true}})
+	tables := ListOfTables{{Database: "db", Table: "events", Query: "CREATE TABLE db.events (id UInt64) ENGINE = MergeTree ORDER BY id"}}
+	require.NoError(t, b.changeTableQueryToAdjustStagingTables(&tables))
+	require.Len(t, tables, 2)
+	assert.Equal(t, "CREATE TABLE db.events (id UInt64) ENGINE = MergeTree ORDER BY id", tables[0].Query)
+	assert.Equal(t, "CREATE TABLE db.events__restore_where_20240102030405 (id UInt64) ENGINE = MergeTree ORDER BY id", tables[1].Query)
+}
+
+func TestGetStagingTableExpectedParts(t *testing.T) {
+	table := metadata.TableMetadata{Parts: map[string][]metadata.Part{
+		"default": {{Name: "1_1_1_0"}, {Name: "2_2_2_0"}, {Name: "p1.proj"}},
+		"hdd":     {{Name: "3_3_3_0"}},
+	}}
+	assert.Equal(t, uint64(3), getStagingTableExpectedParts(table))
+	stagingTables := []stagingTable{
+		{Source: metadata.TableTitle{Database: "db", Table: "t1"}},
+		{Source: metadata.TableTitle{Database: "db", Table: "t2"}},
+	}
+	assert.Equal(t, "db.t1,db.t2", getStagingTablesPattern(stagingTables))
+}
+
+func TestGetStagingTableSuffix(t *testing.T) {
+	assert.Regexp(t, `^__restore_where_\d{14}$`, getStagingTableSuffix("restore_where"))
+	assert.Regexp(t, `^__restore_\d{14}$`, getStagingTableSuffix("restore"))
+}
+
+func TestGetStagingTables(t *testing.T) {
+	b := NewBackuper(config.DefaultConfig())
+	tables := ListOfTables{
+		{Database: "db", Table: "events", Query: "CREATE TABLE db.events (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "events_mv", Query: "CREATE MATERIALIZED VIEW db.events_mv (id UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.events", MetadataOnly: true},
+		{Database: "db", Table: ".inner.events_mv", Query: "CREATE TABLE db.`.inner.events_mv` (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "log", Query: "CREATE TABLE db.log (id UInt64) ENGINE = Log"},
+		{Database: "db", Table: "new_mv", Query: "CREATE MATERIALIZED VIEW db.new_mv (id UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.events", MetadataOnly: true},
+		{Database: "db", Table: ".inner.new_mv", Query: "CREATE TABLE db.`.inner.new_mv` (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "new", Query: "CREATE TABLE db.new (id UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	dstTablesMap := map[metadata.TableTitle]clickhouse.Table{}
+	for _, name := range []string{"events", "events_mv", ".inner.events_mv", "log"} {
+		dstTablesMap[metadata.TableTitle{Database: "db", Table: name}] = clickhouse.Table{Database: "db", Name: name, Engine: "MergeTree"}
+	}
+	stagingTables, schemaTablePatterns := b.getStagingTables(tables, dstTablesMap, map[string]string{"db": "Atomic"}, "__restore_20240102030405", false)
+	require.Len(t, stagingTables, 1)
+	assert.Equal(t, metadata.TableTitle{Database: "db", Table: "events__restore_20240102030405"}, stagingTables[0].Staging)
+	// existing inner table of materialized view and existing Log table keep their data, not existing tables are created and restored as is
+	assert.Equal(t, []string{"db.events", "db.new_mv", "db..inner.new_mv", "db.new"}, schemaTablePatterns)
+
+	stagingTables, schemaTablePatterns = b.getStagingTables(tables, dstTablesMap, map[string]string{"db": "Atomic"}, "__restore_where_20240102030405", true)
+	require.Len(t, stagingTables, 2)
+	assert.True(t, stagingTables[1].CreateLive)
+	assert.Equal(t, []string{"db.events", "db.new_mv", "db..inner.new_mv", "db.new"}, schemaTablePatterns)
+}
@@ -0,0 +1,20 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupTablesByRestoreOrder(t *testing.T) {
+	tables := ListOfTables{
+		{Database: "db", Table: "t1", Query: "CREATE TABLE db.t1 (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "t2", Query: "CREATE TABLE db.t2 (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: ".inner_id.mv", Query: "CREATE TABLE db.`.inner_id.mv` (id UInt64) ENGINE = MergeTree ORDER BY id"},
+		{Database: "db", Table: "mv", Query: "CREATE MATERIALIZED VIEW db.mv (id UInt64) ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.t1"},
+		{Database: "db", Table: "dict", Query: "CREATE DICTIONARY db.dict (id UInt64) PRIMARY KEY id SOURCE(CLICKHOUSE(TABLE 't2')) LAYOUT(FLAT()) LIFETIME(0)"},
+		{Database: "db", Table: "t3", Query: "CREATE TABLE db.t3 (id UInt64) ENGINE = MergeTree ORDER BY id"},
+	}
+	assert.Equal(t, [][]int{{0, 1}, {2}, {3}, {4}, {5}}, groupTablesByRestoreOrder(tables))
+	assert.Equal(t, [][]int{}, groupTablesByRestoreOrder(ListOfTables{}))
+}
@@ -0,0 +1,122 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	apexLog "github.com/apex/log"
+)
+
+const (
+	RestoreValidationOK       = "ok"
+	RestoreValidationMismatch = "mismatch"
+	RestoreValidationSkipped  = "skipped"
+)
+
+// validateRestoredTables - compare rows count of restored partitions with rows count stored in backup metadata, restore_validation: fail returns error when rows are missing
+func (b *Backuper) validateRestoredTables(ctx context.Context, tablesForRestore ListOfTables, dstTablesMap map[metadata.TableTitle]clickhouse.Table, log *apexLog.Entry) error {
+	if b.cfg.General.RestoreValidation == "none" {
+		return nil
+	}
+	mismatchedTables := make([]string, 0)
+	for _, table := range tablesForRestore {
+		expectedStats := getExpectedPartitionsStats(table)
+		if len(expectedStats) == 0 {
+			continue
+		}
+		dstTitle := b.getRestoreTargetTitle(table.Database, table.Table)
+		restoredStats, err := b.getRestoredPartitionsStats(ctx, dstTitle)
+		if err != nil {
+			return err
+		}
+		validation := getRestoreValidation(dstTitle, dstTablesMap[dstTitle].Engine, expectedStats, restoredStats)
+		b.restoreValidations = append(b.restoreValidations, validation)
+		tableLog := log.WithField("table", fmt.Sprintf("%s.%s", dstTitle.Database, dstTitle.Table)).WithField("expected_rows", validation.ExpectedRows).WithField("restored_rows", validation.RestoredRows)
+		switch validation.Status {
+		case RestoreValidationMismatch:
+			tableLog.Warnf("validation failed: %s", validation.Error)
+			mismatchedTables = append(mismatchedTables, fmt.Sprintf("%s.%s", dstTitle.Database, dstTitle.Table))
+		case RestoreValidationSkipped:
+			tableLog.Debugf("validation skipped: %s", validation.Error)
+		default:
+			tableLog.Debug("validated")
+		}
+	}
+	if len(mismatchedTables) > 0 && b.cfg.General.RestoreValidation == "fail" {
+		return fmt.Errorf("restore validation failed, %s contains less rows than backup", strings.Join(mismatchedTables, ", "))
+	}
+	return nil
+}
+
+func (b *Backuper) getRestoredPartitionsStats(ctx context.Context, table metadata.TableTitle) (map[string]metadata.PartitionStats, error) {
+	partitionsStats := make([]struct {
+		PartitionId       string `ch:"partition_id"`
+		Rows              uint64 `ch:"rows"`
+		UncompressedBytes uint64 `ch:"uncompressed_bytes"`
+	}, 0)
+	query := "SELECT partition_id, sum(rows) AS rows, sum(data_uncompressed_bytes) AS uncompressed_bytes FROM system.parts WHERE active AND database=? AND table=? GROUP BY partition_id"
+	if err := b.ch.SelectContext(ctx, &partitionsStats, query, table.Database, table.Table); err != nil {
+		return nil, err
+	}
+	result := make(map[string]metadata.PartitionStats, len(partitionsStats))
+	for _, p := range partitionsStats {
+		result[p.PartitionId] = metadata.PartitionStats{Rows: p.Rows, UncompressedBytes: p.UncompressedBytes}
+	}
+	return result, nil
+}
+
+// getExpectedPartitionsStats - only partitions with restored parts, --partitions could restore part of backup
+func getExpectedPartitionsStats(table metadata.TableMetadata) map[string]metadata.PartitionStats {
+	expectedStats := make(map[string]metadata.PartitionStats)
+	if len(table.Partitions) == 0 {
+		return expectedStats
+	}
+	for _, parts := range table.Parts {
+		for _, part := range parts {
+			partitionId := strings.Split(part.Name, "_")[0]
+			if stats, exists := table.Partitions[partitionId]; exists {
+				expectedStats[partitionId] = stats
+			}
+		}
+	}
+	return expectedStats
+}
+
+// getRestoreValidation - restored rows could be more than expected when data restores into table with existing rows
+func getRestoreValidation(table metadata.TableTitle, engine string, expectedStats, restoredStats map[string]metadata.PartitionStats) status.RestoreValidation {
+	validation := status.RestoreValidation{
+		Database: table.Database,
+		Table:    table.Table,
+		Status:   RestoreValidationOK,
+	}
+	partitionIds := make([]string, 0, len(expectedStats))
+	for partitionId, expected := range expectedStats {
+		partitionIds = append(partitionIds, partitionId)
+		validation.ExpectedRows += expected.Rows
+		validation.ExpectedUncompressedBytes += expected.UncompressedBytes
+		validation.RestoredRows += restoredStats[partitionId].Rows
+		validation.RestoredUncompressedBytes += restoredStats[partitionId].UncompressedBytes
+	}
+	if engine != "MergeTree" && engine != "ReplicatedMergeTree" {
+		validation.Status = RestoreValidationSkipped
+		validation.Error = fmt.Sprintf("rows in %s engine could be changed by background merges", engine)
+		return validation
+	}
+	sort.Strings(partitionIds)
+	mismatchedPartitions := make([]string, 0)
+	for _, partitionId := range partitionIds {
+		if restoredStats[partitionId].Rows < expectedStats[partitionId].Rows {
+			mismatchedPartitions = append(mismatchedPartitions, fmt.Sprintf("%s (%d of %d rows)", partitionId, restoredStats[partitionId].Rows, expectedStats[partitionId].Rows))
+		}
+	}
+	if len(mismatchedPartitions) > 0 {
+		validation.Status = RestoreValidationMismatch
+		validation.Error = fmt.Sprintf("missing rows in partitions: %s", strings.Join(mismatchedPartitions, ", "))
+	}
+	return validation
+}
@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRestoreValidation(t *testing.T) {
+	table := metadata.TableMetadata{
+		Parts: map[string][]metadata.Part{
+			"default": {{Name: "202401_1_1_0"}, {Name: "202401_2_2_0"}},
+			"hdd":     {{Name: "202402_3_3_0"}},
+		},
+		Partitions: map[string]metadata.PartitionStats{
+			"202401": {Rows: 100, UncompressedBytes: 1000},
+			"202402": {Rows: 50, UncompressedBytes: 500},
+			// filtered by --partitions
+			"202403": {Rows: 10, UncompressedBytes: 100},
+		},
+	}
+	expected := getExpectedPartitionsStats(table)
+	assert.Equal(t, map[string]metadata.PartitionStats{"202401": {Rows: 100, UncompressedBytes: 1000}, "202402": {Rows: 50, UncompressedBytes: 500}}, expected)
+	assert.Empty(t, getExpectedPartitionsStats(metadata.TableMetadata{Parts: table.Parts}))
+
+	title := metadata.TableTitle{Database: "db", Table: "t"}
+	validation := getRestoreValidation(title, "MergeTree", expected, map[string]metadata.PartitionStats{"202401": {Rows: 120, UncompressedBytes: 1200}, "202402": {Rows: 50, UncompressedBytes: 500}})
+	assert.Equal(t, RestoreValidationOK, validation.Status)
+	assert.Equal(t, uint64(150), validation.ExpectedRows)
+	assert.Equal(t, uint64(170), validation.RestoredRows)
+	assert.Equal(t, uint64(1700), validation.RestoredUncompressedBytes)
+
+	validation = getRestoreValidation(title, "ReplicatedMergeTree", expected, map[string]metadata.PartitionStats{"202401": {Rows: 60, UncompressedBytes: 600}})
+	assert.Equal(t, RestoreValidationMismatch, validation.Status)
+	assert.Equal(t, "missing rows in partitions: 202401 (60 of 100 rows), 202402 (0 of 50 rows)", validation.Error)
+
+	validation = getRestoreValidation(title, "ReplacingMergeTree", expected, map[string]metadata.PartitionStats{"202401": {Rows: 60}})
+	assert.Equal(t, RestoreValidationSkipped, validation.Status)
+}
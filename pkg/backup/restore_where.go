@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
+	apexLog "github.com/apex/log"
+)
+
+// restoreWhere - restore data into staging tables and copy only rows which match `where` expression into live tables,
+// use --partitions to download and attach only relevant partitions
+func (b *Backuper) restoreWhere(ctx context.Context, backupName, tablePattern, where string, partitions []string, disks []clickhouse.Disk, dataOnly, ignoreDependencies bool, log *apexLog.Entry) error {
+	// existing tables keep their rows, not existing tables are created empty together with staging tables
+	stagingTables, schemaTablePattern, err := b.prepareStagingTables(ctx, backupName, tablePattern, partitions, getStagingTableSuffix("restore_where"), !dataOnly)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		b.dropStagingTables(stagingTables, log)
+		b.stagingTables = nil
+	}()
+	if len(stagingTables) == 0 {
+		return fmt.Errorf("--where requires MergeTree tables, nothing matched %s, for --data tables shall exist", tablePattern)
+	}
+	if dataOnly {
+		schemaTablePattern = getStagingTablesPattern(stagingTables)
+	}
+	if err = b.RestoreSchema(ctx, backupName, schemaTablePattern, false, ignoreDependencies); err != nil {
+		return err
+	}
+	if err = b.restoreStagingTablesData(ctx, backupName, getStagingTablesPattern(stagingTables), stagingTables, partitions, disks); err != nil {
+		return err
+	}
+	for _, t := range stagingTables {
+		query := fmt.Sprintf("INSERT INTO `%s`.`%s` SELECT * FROM `%s`.`%s` WHERE (%s)", t.Live.Database, t.Live.Table, t.Staging.Database, t.Staging.Table, where)
+		if err = b.ch.QueryContext(ctx, query); err != nil {
+			return fmt.Errorf("can't copy rows from '%s.%s' to '%s.%s': %v", t.Staging.Database, t.Staging.Table, t.Live.Database, t.Live.Table, err)
+		}
+		log.WithField("table", fmt.Sprintf("%s.%s", t.Live.Database, t.Live.Table)).Info("inserted")
+	}
+	return nil
+}
+
+// ValidateRestoreWhere - `where` is placed into INSERT ... SELECT as is, so it shall be a single expression,
+// parentheses shall be balanced outside of literals and quoted identifiers, `;`, comments, SETTINGS, FORMAT and set operations are not allowed
+func ValidateRestoreWhere(where string) error {
+	if strings.TrimSpace(where) == "" {
+		return fmt.Errorf("--where expression is empty")
+	}
+	depth := 0
+	outside := strings.Builder{}
+	for i := 0; i < len(where); i++ {
+		c := where[i]
+		switch c {
+		case '\'', '"', '`':
+			closed := false
+			for i++; i < len(where); i++ {
+				if where[i] == '\\' {
+					i++
+					continue
+				}
+				if where[i] == c {
+					closed = true
+					break
+				}
+			}
+			if !closed {
+				return fmt.Errorf("--where expression contains unclosed %c", c)
+			}
+			outside.WriteByte(' ')
+		case '(':
+			depth++
+			outside.WriteByte(c)
+		case ')':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("--where expression contains unbalanced ')'")
+			}
+			outside.WriteByte(c)
+		case ';':
+			return fmt.Errorf("--where expression shall not contain ';'")
+		case '-', '/', '#':
+			if c == '#' || (i+1 < len(where) && ((c == '-' && where[i+1] == '-') || (c == '/' && where[i+1] == '*'))) {
+				return fmt.Errorf("--where expression shall not contain comments")
+			}
+			outside.WriteByte(c)
+		default:
+			outside.WriteByte(c)
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("--where expression contains unbalanced '('")
+	}
+	for _, word := range strings.FieldsFunc(strings.ToUpper(outside.String()), func(r rune) bool {
+		return !(r == '_' || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'))
+	}) {
+		switch word {
+		case "SETTINGS", "FORMAT", "UNION", "EXCEPT", "INTERSECT", "INTO":
+			return fmt.Errorf("--where expression shall not contain %s", word)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRestoreWhere(t *testing.T) {
+	for _, where := range []string{
+		"customer_id = 42",
+		"customer_id IN (SELECT id FROM db.customers WHERE name = 'a;b')",
+		"name = 'it''s' OR name = 'x\\'y' OR `select` = 1",
+		"toDate(ts) BETWEEN '2024-01-01' AND '2024-01-31'",
+	} {
+		assert.NoError(t, ValidateRestoreWhere(where), where)
+	}
+	for _, where := range []string{
+		"",
+		"1) UNION ALL SELECT * FROM db.other WHERE (1",
+		"1; DROP TABLE db.t",
+		"1 SETTINGS max_threads=1",
+		"1 -- comment",
+		"1 /* comment */",
+		"(1",
+		"name = 'unclosed",
+	} {
+		assert.Error(t, ValidateRestoreWhere(where), where)
+	}
+}
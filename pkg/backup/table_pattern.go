@@ -66,9 +66,8 @@ func (b *Backuper) getTableListByPatternLocal(ctx context.Context, metadataPath
 		if err != nil {
 			return err
 		}
-		if !strings.HasSuffix(filePath, ".sql") &&
-			!strings.HasSuffix(filePath, ".json") &&
-			!info.Mode().IsRegular() {
+		// metadata directory could contain other files, for example `<table>.sha256` checksums manifest
+		if !info.Mode().IsRegular() || (!strings.HasSuffix(filePath, ".sql") && !strings.HasSuffix(filePath, ".json")) {
 			return nil
 		}
 		p := filepath.ToSlash(filePath)
@@ -340,6 +339,276 @@ func changeTableQueryToAdjustDatabaseMapping(originTables *ListOfTables, dbMapRu
 	return nil
 }
 
+const tableMappingQueryKinds = "TABLE|VIEW|LIVE VIEW|WINDOW VIEW|MATERIALIZED VIEW|DICTIONARY"
+
+// changeTableQueryToAdjustTableMapping - rename tables by `db.table:db.table` rules, shall be applied before changeTableQueryToAdjustDatabaseMapping
+func changeTableQueryToAdjustTableMapping(originTables *ListOfTables, tableMapRule map[string]string) error {
+	for i := 0; i < len(*originTables); i++ {
+		originTable := (*originTables)[i]
+		// compare with backup names, to avoid chained renames
+		originDb, originTableName := originTable.Database, originTable.Table
+		for src, dst := range tableMapRule {
+			srcDb, srcTable, dstDb, dstTable, err := splitTableMappingRule(src, dst)
+			if err != nil {
+				return err
+			}
+			// references to renamed table from other objects
+			if originTable.Query != "" {
+				refRE := regexp.MustCompile(fmt.Sprintf(`(\s(?:TO|FROM|JOIN)\s+)(\x60?)%s(\x60?)\.(\x60?)%s(\x60?)([\s(),;]|$)`, regexp.QuoteMeta(srcDb), regexp.QuoteMeta(srcTable)))
+				originTable.Query = refRE.ReplaceAllString(originTable.Query, fmt.Sprintf("${1}${2}%s${3}.${4}%s${5}${6}", escapeReplacement(dstDb), escapeReplacement(dstTable)))
+				// https://github.com/Altinity/clickhouse-backup/issues/547
+				if distributedRE.MatchString(originTable.Query) {
+					matches := distributedRE.FindAllStringSubmatch(originTable.Query, -1)
+					underlyingDB := matches[0][3]
+					underlyingArgs := strings.SplitN(matches[0][4], ",", 2)
+					cleanArg := strings.NewReplacer(" ", "", "'", "", "\x60", "")
+					if cleanArg.Replace(underlyingDB) == srcDb && cleanArg.Replace(underlyingArgs[0]) == srcTable {
+						underlyingArgs[0] = strings.Replace(underlyingArgs[0], srcTable, dstTable, 1)
+						substitution := fmt.Sprintf("${1}(${2},%s,%s)", escapeReplacement(strings.Replace(underlyingDB, srcDb, dstDb, 1)), escapeReplacement(strings.Join(underlyingArgs, ",")))
+						originTable.Query = distributedRE.ReplaceAllString(originTable.Query, substitution)
+					}
+				}
+			}
+			if originDb != srcDb || originTableName != srcTable {
+				continue
+			}
+			if originTable.Query != "" {
+				nameRE := regexp.MustCompile(fmt.Sprintf(`(?m)^(CREATE|ATTACH) (%s) (\x60?)%s(\x60?)\.(\x60?)%s(\x60?)([\s(]|$)`, tableMappingQueryKinds, regexp.QuoteMeta(srcDb), regexp.QuoteMeta(srcTable)))
+				if !nameRE.MatchString(originTable.Query) {
+					return fmt.Errorf("error when try to replace table `%s`.`%s` to `%s`.`%s` in query: %s", srcDb, srcTable, dstDb, dstTable, originTable.Query)
+				}
+				originTable.Query = nameRE.ReplaceAllString(originTable.Query, fmt.Sprintf("${1} ${2} ${3}%s${4}.${5}%s${6}${7}", escapeReplacement(dstDb), escapeReplacement(dstTable)))
+				// restored table will exist next to the source table, so UUID shall be unique
+				if len(uuidRE.FindAllString(originTable.Query, -1)) > 0 {
+					newUUID, _ := uuid.NewUUID()
+					originTable.Query = uuidRE.ReplaceAllString(originTable.Query, fmt.Sprintf("UUID '%s'", newUUID.String()))
+				}
+				if replicatedRE.MatchString(originTable.Query) {
+					matches := replicatedRE.FindAllStringSubmatch(originTable.Query, -1)
+					originPath := matches[0][2]
+					newPath := originPath
+					if strings.Contains(originPath, "/"+srcTable+"/") {
+						newPath = strings.Replace(originPath, "/"+srcTable+"/", "/"+dstTable+"/", 1)
+					} else if strings.HasSuffix(originPath, "/"+srcTable) {
+						newPath = strings.TrimSuffix(originPath, "/"+srcTable) + "/" + dstTable
+					}
+					if newPath != originPath {
+						originTable.Query = replicatedRE.ReplaceAllString(originTable.Query, fmt.Sprintf("${1}('%s'${3})", escapeReplacement(newPath)))
+					}
+				}
+			}
+			originTable.Database = dstDb
+			originTable.Table = dstTable
+		}
+		(*originTables)[i] = originTable
+	}
+	return nil
+}
+
+// splitTableMappingRule - validate and split `db.table:db.table` rule
+func splitTableMappingRule(src, dst string) (string, string, string, string, error) {
+	srcParts := strings.SplitN(src, ".", 2)
+	dstParts := strings.SplitN(dst, ".", 2)
+	if len(srcParts) != 2 || len(dstParts) != 2 || srcParts[0] == "" || srcParts[1] == "" || dstParts[0] == "" || dstParts[1] == "" {
+		return "", "", "", "", fmt.Errorf("restore-table-mapping %s:%s should only have srcDatabase.srcTable:dstDatabase.dstTable format", src, dst)
+	}
+	return srcParts[0], srcParts[1], dstParts[0], dstParts[1], nil
+}
+
+func escapeReplacement(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+const (
+	ReplicatedConversionToMergeTree  = config.ReplicatedConversionToMergeTree
+	ReplicatedConversionToReplicated = config.ReplicatedConversionToReplicated
+)
+
+const mergeTreeEngineKinds = "MergeTree|ReplacingMergeTree|SummingMergeTree|AggregatingMergeTree|CollapsingMergeTree|VersionedCollapsingMergeTree|GraphiteMergeTree"
+
+// engine arguments could contain nested parentheses, so they are scanned by replaceEngineInQuery instead of regexp
+var replicatedEngineRE = regexp.MustCompile(`(ENGINE\s*=\s*)Replicated(` + mergeTreeEngineKinds + `)\b`)
+var mergeTreeEngineRE = regexp.MustCompile(`(ENGINE\s*=\s*)(` + mergeTreeEngineKinds + `)\b`)
+var replicatedDatabaseEngineRE = regexp.MustCompile(`(ENGINE\s*=\s*)Replicated\b`)
+
+// changeTableQueryToAdjustReplicatedEngine - apply restore_zookeeper_path_mapping and restore_replicated_conversion, shall be applied after changeTableQueryToAdjustDatabaseMapping
+func changeTableQueryToAdjustReplicatedEngine(originTables *ListOfTables, zkPathMapRule map[string]string, replicatedConversion string) {
+	for i := range *originTables {
+		(*originTables)[i].Query = adjustReplicatedEngineInQuery((*originTables)[i].Query, zkPathMapRule, replicatedConversion)
+	}
+}
+
+// adjustReplicatedEngineInQuery - to_merge_tree drops ZooKeeper path and replica name arguments,
+// to_replicated doesn't add them, so default_replica_path and default_replica_name from server config will use
+func adjustReplicatedEngineInQuery(query string, zkPathMapRule map[string]string, replicatedConversion string) string {
+	query = replaceEngineInQuery(query, replicatedEngineRE, func(matches []string, args string, hasArgs bool) string {
+		engine := "Replicated" + matches[2]
+		argList := splitEngineArguments(args)
+		hasZookeeperArgs := len(argList) >= 2 && isQuotedString(argList[0]) && isQuotedString(argList[1])
+		if replicatedConversion == ReplicatedConversionToMergeTree {
+			if !hasArgs {
+				return matches[1] + matches[2]
+			}
+			if hasZookeeperArgs {
+				argList = argList[2:]
+			}
+			return fmt.Sprintf("%s%s(%s)", matches[1], matches[2], strings.Join(argList, ", "))
+		}
+		if !hasArgs {
+			return matches[1] + engine
+		}
+		if hasZookeeperArgs {
+			zkPath := strings.Trim(argList[0], "'")
+			if newZkPath := changeZookeeperPathPrefix(zkPath, zkPathMapRule); newZkPath != zkPath {
+				args = strings.Replace(args, argList[0], "'"+newZkPath+"'", 1)
+			}
+		}
+		return fmt.Sprintf("%s%s(%s)", matches[1], engine, args)
+	})
+	if replicatedConversion == ReplicatedConversionToReplicated {
+		query = replaceEngineInQuery(query, mergeTreeEngineRE, func(matches []string, args string, hasArgs bool) string {
+			return fmt.Sprintf("%sReplicated%s(%s)", matches[1], matches[2], args)
+		})
+	}
+	return query
+}
+
+// getReplicatedZookeeperPath - ZooKeeper path argument of Replicated*MergeTree engine, empty when it is not passed and default_replica_path will use
+func getReplicatedZookeeperPath(query string) string {
+	zkPath := ""
+	replaceEngineInQuery(query, replicatedEngineRE, func(matches []string, args string, hasArgs bool) string {
+		if argList := splitEngineArguments(args); zkPath == "" && len(argList) >= 2 && isQuotedString(argList[0]) && isQuotedString(argList[1]) {
+			zkPath = strings.Trim(argList[0], "'")
+		}
+		return matches[0]
+	})
+	return zkPath
+}
+
+// changeDatabaseQueryToAdjustReplicatedEngine - `Replicated` database follows the same rules as tables inside it, to_merge_tree converts it to `Atomic`
+func changeDatabaseQueryToAdjustReplicatedEngine(query string, zkPathMapRule map[string]string, replicatedConversion string) string {
+	return replaceEngineInQuery(query, replicatedDatabaseEngineRE, func(matches []string, args string, hasArgs bool) string {
+		if replicatedConversion == ReplicatedConversionToMergeTree {
+			return matches[1] + "Atomic"
+		}
+		if !hasArgs {
+			return matches[0]
+		}
+		argList := splitEngineArguments(args)
+		if len(argList) > 0 && isQuotedString(argList[0]) {
+			zkPath := strings.Trim(argList[0], "'")
+			if newZkPath := changeZookeeperPathPrefix(zkPath, zkPathMapRule); newZkPath != zkPath {
+				args = strings.Replace(args, argList[0], "'"+newZkPath+"'", 1)
+			}
+		}
+		return fmt.Sprintf("%s(%s)", matches[0], args)
+	})
+}
+
+// replaceEngineInQuery - replace each engineRE match together with arguments in parentheses right after it,
+// arguments are scanned with balanced parentheses outside of string literals, so `ver, tuple(a, b)` is passed as is
+func replaceEngineInQuery(query string, engineRE *regexp.Regexp, replace func(matches []string, args string, hasArgs bool) string) string {
+	result := strings.Builder{}
+	pos := 0
+	for _, loc := range engineRE.FindAllStringSubmatchIndex(query, -1) {
+		if loc[0] < pos {
+			continue
+		}
+		matches := make([]string, len(loc)/2)
+		for i := range matches {
+			if loc[2*i] >= 0 {
+				matches[i] = query[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		end := loc[1]
+		args, hasArgs := "", false
+		if argsEnd := findClosingParenthesis(query, end); argsEnd > end {
+			args, hasArgs = query[end+1:argsEnd], true
+			end = argsEnd + 1
+		}
+		result.WriteString(query[pos:loc[0]])
+		result.WriteString(replace(matches, args, hasArgs))
+		pos = end
+	}
+	result.WriteString(query[pos:])
+	return result.String()
+}
+
+// findClosingParenthesis - index of `)` which closes `(` at start position, -1 when query[start] is not `(` or parentheses are not balanced
+func findClosingParenthesis(query string, start int) int {
+	if start >= len(query) || query[start] != '(' {
+		return -1
+	}
+	depth := 0
+	inQuote := false
+	for i := start; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if inQuote {
+				i++
+			}
+		case '\'':
+			inQuote = !inQuote
+		case '(':
+			if !inQuote {
+				depth++
+			}
+		case ')':
+			if !inQuote {
+				depth--
+				if depth == 0 {
+					return i
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// changeZookeeperPathPrefix - the longest matched prefix wins
+func changeZookeeperPathPrefix(zkPath string, zkPathMapRule map[string]string) string {
+	matchedPrefix := ""
+	for prefix := range zkPathMapRule {
+		if strings.HasPrefix(zkPath, prefix) && len(prefix) > len(matchedPrefix) {
+			matchedPrefix = prefix
+		}
+	}
+	if matchedPrefix == "" {
+		return zkPath
+	}
+	return zkPathMapRule[matchedPrefix] + strings.TrimPrefix(zkPath, matchedPrefix)
+}
+
+// splitEngineArguments - split by commas which are not inside string literals
+func splitEngineArguments(args string) []string {
+	result := make([]string, 0)
+	if strings.TrimSpace(args) == "" {
+		return result
+	}
+	inQuote := false
+	start := 0
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case '\\':
+			if inQuote {
+				i++
+			}
+		case '\'':
+			inQuote = !inQuote
+		case ',':
+			if !inQuote {
+				result = append(result, strings.TrimSpace(args[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(result, strings.TrimSpace(args[start:]))
+}
+
+func isQuotedString(arg string) bool {
+	return len(arg) >= 2 && strings.HasPrefix(arg, "'") && strings.HasSuffix(arg, "'")
+}
+
 func filterPartsAndFilesByPartitionsFilter(tableMetadata metadata.TableMetadata, partitionsFilter common.EmptyMap) {
 	if len(partitionsFilter) > 0 {
 		for disk, parts := range tableMetadata.Parts {
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeTableQueryToAdjustTableMapping(t *testing.T) {
+	tables := ListOfTables{
+		{
+			Database: "db",
+			Table:    "events",
+			Query:    "CREATE TABLE db.events UUID '00000000-0000-0000-0000-000000000001' (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/events', '{replica}') ORDER BY id",
+		},
+		{
+			Database: "db",
+			Table:    "events_mv",
+			Query:    "CREATE MATERIALIZED VIEW db.events_mv TO db.events (id UInt64) AS SELECT id FROM db.events_raw",
+		},
+		{
+			Database: "db",
+			Table:    "events_distributed",
+			Query:    "CREATE TABLE db.events_distributed (id UInt64) ENGINE = Distributed('cluster', 'db', 'events', rand())",
+		},
+		{
+			Database: "db",
+			Table:    "events_other",
+			Query:    "CREATE TABLE `db`.`events_other` (id UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+	}
+	err := changeTableQueryToAdjustTableMapping(&tables, map[string]string{"db.events": "db.events_restored"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "db", tables[0].Database)
+	assert.Equal(t, "events_restored", tables[0].Table)
+	assert.Contains(t, tables[0].Query, "CREATE TABLE db.events_restored UUID ")
+	assert.NotContains(t, tables[0].Query, "00000000-0000-0000-0000-000000000001")
+	assert.Contains(t, tables[0].Query, "ReplicatedMergeTree('/clickhouse/tables/{shard}/db/events_restored', '{replica}')")
+
+	assert.Equal(t, "events_mv", tables[1].Table)
+	assert.Equal(t, "CREATE MATERIALIZED VIEW db.events_mv TO db.events_restored (id UInt64) AS SELECT id FROM db.events_raw", tables[1].Query)
+
+	assert.Contains(t, tables[2].Query, "Distributed('cluster', 'db', 'events_restored', rand())")
+
+	assert.Equal(t, "events_other", tables[3].Table)
+	assert.Equal(t, "CREATE TABLE `db`.`events_other` (id UInt64) ENGINE = MergeTree ORDER BY id", tables[3].Query)
+
+	quoted := ListOfTables{{Database: "db", Table: "events", Query: "CREATE TABLE `db`.`events` (id UInt64) ENGINE = MergeTree ORDER BY id"}}
+	require.NoError(t, changeTableQueryToAdjustTableMapping(&quoted, map[string]string{"db.events": "db2.events_restored"}))
+	assert.Equal(t, "CREATE TABLE `db2`.`events_restored` (id UInt64) ENGINE = MergeTree ORDER BY id", quoted[0].Query)
+	assert.Equal(t, "db2", quoted[0].Database)
+
+	invalid := ListOfTables{{Database: "db", Table: "events", Query: "CREATE TABLE db.events (id UInt64) ENGINE = Memory"}}
+	assert.Error(t, changeTableQueryToAdjustTableMapping(&invalid, map[string]string{"db.events": "events_restored"}))
+}
+
+func TestGetRestoreTargetTitle(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.RestoreTableMapping = map[string]string{"db.events": "db.events_restored"}
+	cfg.General.RestoreDatabaseMapping = map[string]string{"db": "db2"}
+	b := NewBackuper(cfg)
+	assert.Equal(t, metadata.TableTitle{Database: "db2", Table: "events_restored"}, b.getRestoreTargetTitle("db", "events"))
+	assert.Equal(t, metadata.TableTitle{Database: "db2", Table: "other"}, b.getRestoreTargetTitle("db", "other"))
+	assert.Equal(t, metadata.TableTitle{Database: "db3", Table: "events"}, b.getRestoreTargetTitle("db3", "events"))
+	assert.Equal(t, "db.*,db2.events_restored", b.changeTablePatternFromRestoreTableMapping("db.*"))
+
+	assert.Error(t, b.prepareRestoreTableMapping([]string{"db.events:db.events2,wrong"}))
+}
+
+func TestAdjustReplicatedEngineInQuery(t *testing.T) {
+	zkPathMapping := map[string]string{"/clickhouse/tables": "/staging/tables", "/clickhouse/tables/{shard}/db": "/staging/db"}
+	query := "CREATE TABLE db.t UUID '00000000-0000-0000-0000-000000000001' (id UInt64, ver UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}', ver) ORDER BY id"
+	assert.Equal(t, "CREATE TABLE db.t UUID '00000000-0000-0000-0000-000000000001' (id UInt64, ver UInt64) ENGINE = ReplicatedReplacingMergeTree('/staging/db/t', '{replica}', ver) ORDER BY id", adjustReplicatedEngineInQuery(query, zkPathMapping, ""))
+	assert.Equal(t, "CREATE TABLE db.t UUID '00000000-0000-0000-0000-000000000001' (id UInt64, ver UInt64) ENGINE = ReplacingMergeTree(ver) ORDER BY id", adjustReplicatedEngineInQuery(query, zkPathMapping, ReplicatedConversionToMergeTree))
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree() ORDER BY id", adjustReplicatedEngineInQuery("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{uuid}/{shard}', '{replica}') ORDER BY id", nil, ReplicatedConversionToMergeTree))
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id", adjustReplicatedEngineInQuery("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id", nil, ReplicatedConversionToMergeTree))
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree() ORDER BY id", adjustReplicatedEngineInQuery("CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id", nil, ReplicatedConversionToReplicated))
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64, sign Int8) ENGINE = ReplicatedCollapsingMergeTree(sign) ORDER BY id", adjustReplicatedEngineInQuery("CREATE TABLE db.t (id UInt64, sign Int8) ENGINE = CollapsingMergeTree(sign) ORDER BY id", nil, ReplicatedConversionToReplicated))
+	// already replicated tables keep arguments, only ZooKeeper path is mapped
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/staging/tables/{shard}/t', '{replica}') ORDER BY id", adjustReplicatedEngineInQuery("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/t', '{replica}') ORDER BY id", zkPathMapping, ReplicatedConversionToReplicated))
+	mv := "CREATE MATERIALIZED VIEW db.mv (id UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/mv', '{replica}') ORDER BY id AS SELECT id FROM db.t"
+	assert.Equal(t, "CREATE MATERIALIZED VIEW db.mv (id UInt64) ENGINE = MergeTree() ORDER BY id AS SELECT id FROM db.t", adjustReplicatedEngineInQuery(mv, nil, ReplicatedConversionToMergeTree))
+	distributed := "CREATE TABLE db.d (id UInt64) ENGINE = Distributed('cluster', 'db', 't', rand())"
+	assert.Equal(t, distributed, adjustReplicatedEngineInQuery(distributed, zkPathMapping, ReplicatedConversionToReplicated))
+
+	// arguments with nested parentheses
+	nested := "CREATE TABLE db.t (id UInt64, ver UInt64, a UInt8, b UInt8) ENGINE = ReplicatedSummingMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}', (a, b)) PARTITION BY toYYYYMM(now()) ORDER BY (id, ver)"
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64, ver UInt64, a UInt8, b UInt8) ENGINE = SummingMergeTree((a, b)) PARTITION BY toYYYYMM(now()) ORDER BY (id, ver)", adjustReplicatedEngineInQuery(nested, nil, ReplicatedConversionToMergeTree))
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64, ver UInt64, a UInt8, b UInt8) ENGINE = ReplicatedSummingMergeTree('/staging/db/t', '{replica}', (a, b)) PARTITION BY toYYYYMM(now()) ORDER BY (id, ver)", adjustReplicatedEngineInQuery(nested, zkPathMapping, ""))
+	assert.Equal(t, nested, adjustReplicatedEngineInQuery("CREATE TABLE db.t (id UInt64, ver UInt64, a UInt8, b UInt8) ENGINE = SummingMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}', (a, b)) PARTITION BY toYYYYMM(now()) ORDER BY (id, ver)", nil, ReplicatedConversionToReplicated))
+	assert.Equal(t, "CREATE TABLE db.t (id UInt64) ENGINE = ReplacingMergeTree(if(id > 0, id, 1)) ORDER BY id", adjustReplicatedEngineInQuery("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/t)', '{replica}', if(id > 0, id, 1)) ORDER BY id", nil, ReplicatedConversionToMergeTree))
+
+	tables := ListOfTables{{Database: "db", Table: "t", Query: query}}
+	changeTableQueryToAdjustReplicatedEngine(&tables, nil, ReplicatedConversionToMergeTree)
+	assert.Contains(t, tables[0].Query, "ENGINE = ReplacingMergeTree(ver)")
+}
+
+func TestGetReplicatedZookeeperPath(t *testing.T) {
+	assert.Equal(t, "/clickhouse/tables/{shard}/db/t", getReplicatedZookeeperPath("CREATE TABLE db.t (id UInt64, ver UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}', ver) ORDER BY id"))
+	assert.Equal(t, "", getReplicatedZookeeperPath("CREATE TABLE db.t (id UInt64) ENGINE = ReplicatedMergeTree ORDER BY id"))
+	assert.Equal(t, "", getReplicatedZookeeperPath("CREATE TABLE db.t (id UInt64) ENGINE = MergeTree ORDER BY id"))
+}
+
+func TestChangeDatabaseQueryToAdjustReplicatedEngine(t *testing.T) {
+	query := "CREATE DATABASE db ENGINE = Replicated('/clickhouse/databases/db', '{shard}', '{replica}')"
+	assert.Equal(t, "CREATE DATABASE db ENGINE = Replicated('/staging/databases/db', '{shard}', '{replica}')", changeDatabaseQueryToAdjustReplicatedEngine(query, map[string]string{"/clickhouse": "/staging"}, ""))
+	assert.Equal(t, "CREATE DATABASE db ENGINE = Atomic", changeDatabaseQueryToAdjustReplicatedEngine(query, nil, ReplicatedConversionToMergeTree))
+	assert.Equal(t, query, changeDatabaseQueryToAdjustReplicatedEngine(query, nil, ReplicatedConversionToReplicated))
+	assert.Equal(t, "CREATE DATABASE db ENGINE = Atomic", changeDatabaseQueryToAdjustReplicatedEngine("CREATE DATABASE db ENGINE = Atomic", map[string]string{"/clickhouse": "/staging"}, ReplicatedConversionToMergeTree))
+}
+
+func TestPrepareRestoreReplicatedEngine(t *testing.T) {
+	b := NewBackuper(config.DefaultConfig())
+	require.NoError(t, b.prepareRestoreReplicatedEngine([]string{"/clickhouse/tables:/staging/tables,/clickhouse/databases:/staging/databases"}, ReplicatedConversionToMergeTree))
+	assert.Equal(t, map[string]string{"/clickhouse/tables": "/staging/tables", "/clickhouse/databases": "/staging/databases"}, b.cfg.General.RestoreZookeeperPathMapping)
+	assert.Equal(t, ReplicatedConversionToMergeTree, b.cfg.General.RestoreReplicatedConversion)
+	assert.Error(t, b.prepareRestoreReplicatedEngine([]string{"/clickhouse/tables"}, ""))
+	assert.Error(t, b.prepareRestoreReplicatedEngine(nil, "to_log"))
+}
@@ -12,11 +12,13 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
 	"github.com/Altinity/clickhouse-backup/pkg/custom"
+	"github.com/Altinity/clickhouse-backup/pkg/metrics"
 	"github.com/Altinity/clickhouse-backup/pkg/resumable"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
 	"github.com/eapache/go-resiliency/retrier"
@@ -32,16 +34,22 @@ import (
 	"github.com/yargevad/filepathx"
 )
 
-func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) error {
+func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) (err error) {
+	notify := b.startNotification("upload")
+	defer func() {
+		b.finishNotification(notify, backupName, err)
+	}()
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
 	}
+	b.setCommandLog(commandId)
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 
 	startUpload := time.Now()
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	status.Current.SetBackupName(commandId, backupName)
 	var disks []clickhouse.Disk
 	if !resume && b.cfg.General.UseResumableState {
 		resume = true
@@ -57,7 +65,7 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 	if b.cfg.General.RemoteStorage == "custom" {
 		return custom.Upload(ctx, b.cfg, backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly)
 	}
-	log := apexLog.WithFields(apexLog.Fields{
+	log := b.log.WithFields(apexLog.Fields{
 		"backup":    backupName,
 		"operation": "upload",
 	})
@@ -128,6 +136,13 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 
 	compressedDataSize := int64(0)
 	metadataSize := int64(0)
+	bytesTotal := uint64(0)
+	if !schemaOnly {
+		for _, table := range tablesForUpload {
+			bytesTotal += getTableLocalSize(table)
+		}
+	}
+	status.Current.StartProgress(ctx, "upload", uint64(len(tablesForUpload)), bytesTotal)
 
 	log.Debugf("prepare table concurrent semaphore with concurrency=%d len(tablesForUpload)=%d", b.cfg.General.UploadConcurrency, len(tablesForUpload))
 	uploadSemaphore := semaphore.NewWeighted(int64(b.cfg.General.UploadConcurrency))
@@ -154,19 +169,29 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 			var uploadedBytes int64
 			if !schemaOnly {
 				var files map[string][]string
+				var filesSize map[string]int64
 				var err error
-				files, uploadedBytes, err = b.uploadTableData(uploadCtx, backupName, tablesForUpload[idx])
+				files, filesSize, uploadedBytes, err = b.uploadTableData(uploadCtx, backupName, tablesForUpload[idx])
 				if err != nil {
 					return err
 				}
 				atomic.AddInt64(&compressedDataSize, uploadedBytes)
 				tablesForUpload[idx].Files = files
+				tablesForUpload[idx].FilesSize = filesSize
 			}
 			tableMetadataSize, err := b.uploadTableMetadata(uploadCtx, backupName, tablesForUpload[idx])
 			if err != nil {
 				return err
 			}
+			if !schemaOnly && !b.isEmbedded {
+				checksumsSize, err := b.uploadTableChecksums(uploadCtx, backupName, tablesForUpload[idx])
+				if err != nil {
+					return err
+				}
+				tableMetadataSize += checksumsSize
+			}
 			atomic.AddInt64(&metadataSize, tableMetadataSize)
+			status.Current.AddProgress(uploadCtx, 1, 0)
 			log.
 				WithField("table", fmt.Sprintf("%s.%s", tablesForUpload[idx].Database, tablesForUpload[idx].Table)).
 				WithField("duration", utils.HumanizeDuration(time.Since(start))).
@@ -202,6 +227,8 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		}
 	}
 	backupMetadata.Tables = tt
+	backupMetadata.EncryptionAlgorithm = b.dst.EncryptionAlgorithm()
+	backupMetadata.EncryptionKeyID = b.dst.EncryptionKeyID()
 	if b.cfg.GetCompressionFormat() != "none" {
 		backupMetadata.DataFormat = b.cfg.GetCompressionFormat()
 	} else {
@@ -213,7 +240,7 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 	}
 	remoteBackupMetaFile := path.Join(backupName, "metadata.json")
 	if !b.resume || (b.resume && !b.resumableState.IsAlreadyProcessedBool(remoteBackupMetaFile)) {
-		retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+		retry := metrics.NewRetrier("upload", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 		err = retry.RunCtx(ctx, func(ctx context.Context) error {
 			return b.dst.PutFile(ctx, remoteBackupMetaFile, io.NopCloser(bytes.NewReader(newBackupMetadataBody)))
 		})
@@ -231,15 +258,21 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 	if b.resume {
 		b.resumableState.Close()
 	}
+	uploadedSize := uint64(compressedDataSize) + uint64(metadataSize) + uint64(len(newBackupMetadataBody)) + backupMetadata.RBACSize + backupMetadata.ConfigSize
+	status.Current.AddBytesTransferred(commandId, uploadedSize)
 	log.
 		WithField("duration", utils.HumanizeDuration(time.Since(startUpload))).
-		WithField("size", utils.FormatBytes(uint64(compressedDataSize)+uint64(metadataSize)+uint64(len(newBackupMetadataBody))+backupMetadata.RBACSize+backupMetadata.ConfigSize)).
+		WithField("size", utils.FormatBytes(uploadedSize)).
 		Info("done")
 
 	// Clean
-	if err = b.dst.RemoveOldBackups(ctx, b.cfg.General.BackupsToKeepRemote); err != nil {
+	deletedBackups, err := b.dst.RemoveOldBackups(ctx, b.cfg.General.BackupsToKeepRemote, b.cfg.Retention.Remote, b.retentionBackupNameRE)
+	if err != nil {
 		return fmt.Errorf("can't remove old backups on remote storage: %v", err)
 	}
+	for _, backup := range deletedBackups {
+		b.notifyRetention("remote", backup.BackupName)
+	}
 	return nil
 }
 
@@ -257,7 +290,7 @@ func (b *Backuper) uploadSingleBackupFile(ctx context.Context, localFile, remote
 			log.Warnf("can't close %v: %v", f, err)
 		}
 	}()
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := metrics.NewRetrier("upload", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
 		return b.dst.PutFile(ctx, remoteFile, f)
 	})
@@ -394,6 +427,9 @@ func (b *Backuper) validateUploadParams(ctx context.Context, backupName string,
 		if b.cfg.General.RemoteStorage == "cos" && b.cfg.COS.CompressionFormat != "none" {
 			log.Fatalf(fatalMsg, b.cfg.COS.CompressionFormat)
 		}
+		if b.cfg.General.RemoteStorage == "fs" && b.cfg.FS.CompressionFormat != "none" {
+			log.Fatalf(fatalMsg, b.cfg.FS.CompressionFormat)
+		}
 	}
 	if b.cfg.General.RemoteStorage == "custom" && b.resume {
 		return fmt.Errorf("can't resume for `remote_storage: custom`")
@@ -472,7 +508,7 @@ func (b *Backuper) uploadBackupRelatedDir(ctx context.Context, localBackupRelate
 		}
 		return uint64(remoteUploadedBytes), nil
 	}
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := metrics.NewRetrier("upload", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
 		return b.dst.UploadCompressedStream(ctx, localBackupRelatedDir, localFiles, destinationRemote)
 	})
@@ -489,9 +525,20 @@ func (b *Backuper) uploadBackupRelatedDir(ctx context.Context, localBackupRelate
 	return uint64(remoteUploaded.Size()), nil
 }
 
-func (b *Backuper) uploadTableData(ctx context.Context, backupName string, table metadata.TableMetadata) (map[string][]string, int64, error) {
+// getTableLocalSize - size of table data in local backup
+func getTableLocalSize(table metadata.TableMetadata) uint64 {
+	size := uint64(0)
+	for _, diskSize := range table.Size {
+		size += uint64(diskSize)
+	}
+	return size
+}
+
+func (b *Backuper) uploadTableData(ctx context.Context, backupName string, table metadata.TableMetadata) (map[string][]string, map[string]int64, int64, error) {
 	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 	uploadedFiles := map[string][]string{}
+	uploadedFilesSize := map[string]int64{}
+	uploadedFilesSizeMutex := sync.Mutex{}
 	capacity := 0
 	for disk := range table.Parts {
 		capacity += len(table.Parts[disk])
@@ -505,15 +552,19 @@ func (b *Backuper) uploadTableData(ctx context.Context, backupName string, table
 	splitParts := make(map[string][]metadata.SplitPartFiles, 0)
 	splitPartsOffset := make(map[string]int, 0)
 	splitPartsCapacity := 0
+	splitPartsSize := uint64(0)
 	for disk := range table.Parts {
 		backupPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
 		splitPartsList, err := b.splitPartFiles(backupPath, table.Parts[disk])
 		if err != nil {
-			return nil, 0, err
+			return nil, nil, 0, err
 		}
 		splitParts[disk] = splitPartsList
 		splitPartsOffset[disk] = 0
 		splitPartsCapacity += len(splitPartsList)
+		for _, splitPart := range splitPartsList {
+			splitPartsSize += uint64(splitPart.Size)
+		}
 	}
 breakByError:
 	for common.SumMapValuesInt(splitPartsOffset) < splitPartsCapacity {
@@ -529,6 +580,7 @@ breakByError:
 			splitPart := splitParts[disk][splitPartsOffset[disk]]
 			partSuffix := splitPart.Prefix
 			partFiles := splitPart.Files
+			partSize := uint64(splitPart.Size)
 			splitPartsOffset[disk] += 1
 			baseRemoteDataPath := path.Join(backupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 			if b.cfg.GetCompressionFormat() == "none" {
@@ -539,6 +591,7 @@ breakByError:
 					if b.resume {
 						if isProcessed, processedSize := b.resumableState.IsAlreadyProcessed(remotePathFull); isProcessed {
 							atomic.AddInt64(&uploadedBytes, processedSize)
+							status.Current.AddProgress(ctx, 0, partSize)
 							return nil
 						}
 					}
@@ -548,6 +601,7 @@ breakByError:
 						return fmt.Errorf("can't upload: %v", err)
 					} else {
 						atomic.AddInt64(&uploadedBytes, uploadPathBytes)
+						status.Current.AddProgress(ctx, 0, partSize)
 						if b.resume {
 							b.resumableState.AppendToState(remotePathFull, uploadPathBytes)
 						}
@@ -565,11 +619,15 @@ breakByError:
 					if b.resume {
 						if isProcessed, processedSize := b.resumableState.IsAlreadyProcessed(remoteDataFile); isProcessed {
 							atomic.AddInt64(&uploadedBytes, processedSize)
+							status.Current.AddProgress(ctx, 0, partSize)
+							uploadedFilesSizeMutex.Lock()
+							uploadedFilesSize[fileName] = processedSize
+							uploadedFilesSizeMutex.Unlock()
 							return nil
 						}
 					}
 					log.Debugf("start upload %d files to %s", len(localFiles), remoteDataFile)
-					retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+					retry := metrics.NewRetrier("upload", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 					err := retry.RunCtx(ctx, func(ctx context.Context) error {
 						return b.dst.UploadCompressedStream(ctx, backupPath, localFiles, remoteDataFile)
 					})
@@ -582,6 +640,10 @@ breakByError:
 						return fmt.Errorf("can't check uploaded remoteDataFile: %s, error: %v", remoteDataFile, err)
 					}
 					atomic.AddInt64(&uploadedBytes, remoteFile.Size())
+					status.Current.AddProgress(ctx, 0, partSize)
+					uploadedFilesSizeMutex.Lock()
+					uploadedFilesSize[fileName] = remoteFile.Size()
+					uploadedFilesSizeMutex.Unlock()
 					if b.resume {
 						b.resumableState.AppendToState(remoteDataFile, remoteFile.Size())
 					}
@@ -592,10 +654,14 @@ breakByError:
 		}
 	}
 	if err := g.Wait(); err != nil {
-		return nil, 0, fmt.Errorf("one of uploadTableData go-routine return error: %v", err)
+		return nil, nil, 0, fmt.Errorf("one of uploadTableData go-routine return error: %v", err)
+	}
+	// required parts of incremental backup are not uploaded
+	if tableSize := getTableLocalSize(table); tableSize > splitPartsSize {
+		status.Current.AddProgress(ctx, 0, tableSize-splitPartsSize)
 	}
 	log.Debugf("finish %s.%s with concurrency=%d len(table.Parts[...])=%d uploadedFiles=%v, uploadedBytes=%v", table.Database, table.Table, b.cfg.General.UploadConcurrency, capacity, uploadedFiles, uploadedBytes)
-	return uploadedFiles, uploadedBytes, nil
+	return uploadedFiles, uploadedFilesSize, uploadedBytes, nil
 }
 
 func (b *Backuper) uploadTableMetadata(ctx context.Context, backupName string, tableMetadata metadata.TableMetadata) (int64, error) {
@@ -621,7 +687,7 @@ func (b *Backuper) uploadTableMetadataRegular(ctx context.Context, backupName st
 			return processedSize, nil
 		}
 	}
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := metrics.NewRetrier("upload", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
 		return b.dst.PutFile(ctx, remoteTableMetaFile, io.NopCloser(bytes.NewReader(content)))
 	})
@@ -634,6 +700,36 @@ func (b *Backuper) uploadTableMetadataRegular(ctx context.Context, backupName st
 	return int64(len(content)), nil
 }
 
+// uploadTableChecksums upload checksums manifest when it was created with `data_checksums: true`
+func (b *Backuper) uploadTableChecksums(ctx context.Context, backupName string, tableMetadata metadata.TableMetadata) (int64, error) {
+	checksumsFileName := fmt.Sprintf("%s.%s", common.TablePathEncode(tableMetadata.Table), metadata.ChecksumsFileExtension)
+	localChecksumsFile := path.Join(b.DefaultDataPath, "backup", backupName, "metadata", common.TablePathEncode(tableMetadata.Database), checksumsFileName)
+	content, err := os.ReadFile(localChecksumsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	remoteChecksumsFile := path.Join(backupName, "metadata", common.TablePathEncode(tableMetadata.Database), checksumsFileName)
+	if b.resume {
+		if isProcessed, processedSize := b.resumableState.IsAlreadyProcessed(remoteChecksumsFile); isProcessed {
+			return processedSize, nil
+		}
+	}
+	retry := metrics.NewRetrier("upload", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
+	err = retry.RunCtx(ctx, func(ctx context.Context) error {
+		return b.dst.PutFile(ctx, remoteChecksumsFile, io.NopCloser(bytes.NewReader(content)))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("can't upload %s: %v", remoteChecksumsFile, err)
+	}
+	if b.resume {
+		b.resumableState.AppendToState(remoteChecksumsFile, int64(len(content)))
+	}
+	return int64(len(content)), nil
+}
+
 func (b *Backuper) uploadTableMetadataEmbedded(ctx context.Context, backupName string, tableMetadata metadata.TableMetadata) (int64, error) {
 	remoteTableMetaFile := path.Join(backupName, "metadata", common.TablePathEncode(tableMetadata.Database), fmt.Sprintf("%s.sql", common.TablePathEncode(tableMetadata.Table)))
 	if b.resume {
@@ -652,7 +748,7 @@ func (b *Backuper) uploadTableMetadataEmbedded(ctx context.Context, backupName s
 			log.Warnf("can't close %v: %v", localReader, err)
 		}
 	}()
-	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	retry := metrics.NewRetrier("upload", retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration))
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
 		return b.dst.PutFile(ctx, remoteTableMetaFile, localReader)
 	})
@@ -757,6 +853,7 @@ func (b *Backuper) splitFilesByName(basePath string, parts []metadata.Part) ([]m
 			continue
 		}
 		var files []string
+		var size int64
 		partPath := path.Join(basePath, parts[i].Name)
 		err := filepath.Walk(partPath, func(filePath string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -767,6 +864,7 @@ func (b *Backuper) splitFilesByName(basePath string, parts []metadata.Part) ([]m
 			}
 			relativePath := strings.TrimPrefix(filePath, basePath)
 			files = append(files, relativePath)
+			size += info.Size()
 			return nil
 		})
 		if err != nil {
@@ -775,6 +873,7 @@ func (b *Backuper) splitFilesByName(basePath string, parts []metadata.Part) ([]m
 		result = append(result, metadata.SplitPartFiles{
 			Prefix: parts[i].Name,
 			Files:  files,
+			Size:   size,
 		})
 	}
 	return result, nil
@@ -803,6 +902,7 @@ func (b *Backuper) splitFilesBySize(basePath string, parts []metadata.Part) ([]m
 				result = append(result, metadata.SplitPartFiles{
 					Prefix: strconv.Itoa(partSuffix),
 					Files:  files,
+					Size:   size,
 				})
 				files = []string{}
 				size = 0
@@ -821,6 +921,7 @@ func (b *Backuper) splitFilesBySize(basePath string, parts []metadata.Part) ([]m
 		result = append(result, metadata.SplitPartFiles{
 			Prefix: strconv.Itoa(partSuffix),
 			Files:  files,
+			Size:   size,
 		})
 	}
 	return result, nil
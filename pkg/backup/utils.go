@@ -2,6 +2,9 @@ package backup
 
 import (
 	"sort"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
 )
 
 func GetBackupsToDelete(backups []LocalBackup, keep int) []LocalBackup {
@@ -13,3 +16,24 @@ func GetBackupsToDelete(backups []LocalBackup, keep int) []LocalBackup {
 	}
 	return []LocalBackup{}
 }
+
+// GetBackupsToDeleteByRetention the same as GetBackupsToDelete, but use retention policy instead of backups count
+func GetBackupsToDeleteByRetention(backups []LocalBackup, policy config.RetentionPolicy) []LocalBackup {
+	backupsByName := make(map[string]LocalBackup, len(backups))
+	for _, b := range backups {
+		backupsByName[b.BackupName] = b
+	}
+	deletedBackups := make([]LocalBackup, 0)
+	for _, item := range storage.GetRetentionItemsToDelete(getLocalRetentionItems(backups), policy) {
+		deletedBackups = append(deletedBackups, backupsByName[item.Name])
+	}
+	return deletedBackups
+}
+
+func getLocalRetentionItems(backups []LocalBackup) []storage.RetentionItem {
+	items := make([]storage.RetentionItem, len(backups))
+	for i, b := range backups {
+		items[i] = storage.RetentionItem{Name: b.BackupName, Date: b.CreationDate, RequiredBackup: b.RequiredBackup}
+	}
+	return items
+}
@@ -0,0 +1,393 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/checksums"
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/encryption"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/Altinity/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+	"github.com/mholt/archiver/v4"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// verifyProblems - thread safe list of problems found during verify
+type verifyProblems struct {
+	mu    sync.Mutex
+	items []string
+}
+
+func (p *verifyProblems) Add(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items = append(p.items, fmt.Sprintf(format, args...))
+}
+
+func (p *verifyProblems) List() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sort.Strings(p.items)
+	return p.items
+}
+
+// partFilesSums - actual size and hash for each file in each data part directory, key is directory relative to table disk path
+type partFilesSums struct {
+	mu           sync.Mutex
+	files        map[string]map[string]checksums.FileSum
+	checksumsTxt map[string][]byte
+}
+
+func newPartFilesSums() *partFilesSums {
+	return &partFilesSums{
+		files:        map[string]map[string]checksums.FileSum{},
+		checksumsTxt: map[string][]byte{},
+	}
+}
+
+func (s *partFilesSums) add(name string, r io.Reader) error {
+	name = strings.TrimPrefix(name, "/")
+	dir, file := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	if file == "checksums.txt" {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.checksumsTxt[dir] = body
+		s.mu.Unlock()
+		return nil
+	}
+	sum, err := checksums.SumReader(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if _, exists := s.files[dir]; !exists {
+		s.files[dir] = map[string]checksums.FileSum{}
+	}
+	s.files[dir][file] = sum
+	s.mu.Unlock()
+	return nil
+}
+
+// Verify check remote backup consistency without download, all problems will return as one error
+func (b *Backuper) Verify(backupName string, deep bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	b.setCommandLog(commandId)
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	status.Current.SetBackupName(commandId, backupName)
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "verify",
+	})
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("general->remote_storage: %s doesn't support verify", b.cfg.General.RemoteStorage)
+	}
+	if backupName == "" {
+		_ = b.PrintRemoteBackups(ctx, "all")
+		return fmt.Errorf("select backup for verify")
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false, "")
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	b.dst = bd
+
+	start := time.Now()
+	remoteBackups, err := bd.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	remoteBackupsMap := make(map[string]storage.Backup, len(remoteBackups))
+	for _, backup := range remoteBackups {
+		remoteBackupsMap[backup.BackupName] = backup
+	}
+	remoteBackup, found := remoteBackupsMap[backupName]
+	if !found {
+		return fmt.Errorf("'%s' is not found on remote storage", backupName)
+	}
+	if remoteBackup.Broken != "" {
+		return fmt.Errorf("'%s' is %s", backupName, remoteBackup.Broken)
+	}
+	if remoteBackup.Legacy {
+		log.Warnf("'%s' is old-format backup, only archive existence was checked", backupName)
+		return nil
+	}
+
+	problems := &verifyProblems{}
+	for requiredBackup := remoteBackup.RequiredBackup; requiredBackup != ""; {
+		required, exists := remoteBackupsMap[requiredBackup]
+		if !exists {
+			problems.Add("required backup '%s' not found on remote storage", requiredBackup)
+			break
+		}
+		if required.Broken != "" {
+			problems.Add("required backup '%s' is %s", requiredBackup, required.Broken)
+			break
+		}
+		requiredBackup = required.RequiredBackup
+	}
+	isEmbedded := strings.Contains(remoteBackup.Tags, "embedded")
+	if deep && isEmbedded {
+		log.Warnf("'%s' is embedded backup, --deep will check only data existence and size", backupName)
+		deep = false
+	}
+	if deep && remoteBackup.EncryptionKeyID != "" && !bd.HasEncryptionKey(remoteBackup.EncryptionKeyID) {
+		return fmt.Errorf("'%s' encrypted with %s key id=%s, which is not found in `encryption` config section, can't verify with --deep", backupName, remoteBackup.EncryptionAlgorithm, remoteBackup.EncryptionKeyID)
+	}
+	ctx = storage.WithEncryptionRequired(ctx, remoteBackup.EncryptionKeyID != "")
+	if isEmbedded {
+		if _, err := bd.StatFile(ctx, path.Join(backupName, ".backup")); err != nil {
+			problems.Add("%s/.backup: %v", backupName, err)
+		}
+	}
+
+	verifySemaphore := semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
+	verifyGroup, verifyCtx := errgroup.WithContext(ctx)
+	for _, t := range remoteBackup.Tables {
+		if err := verifySemaphore.Acquire(verifyCtx, 1); err != nil {
+			log.Errorf("can't acquire semaphore during Verify: %v", err)
+			break
+		}
+		tableTitle := t
+		verifyGroup.Go(func() error {
+			defer verifySemaphore.Release(1)
+			tableStart := time.Now()
+			if err := b.verifyTable(verifyCtx, remoteBackup.BackupMetadata, tableTitle, deep, problems); err != nil {
+				return err
+			}
+			log.WithFields(apexLog.Fields{
+				"table":    fmt.Sprintf("%s.%s", tableTitle.Database, tableTitle.Table),
+				"duration": utils.HumanizeDuration(time.Since(tableStart)),
+			}).Debug("verified")
+			return nil
+		})
+	}
+	if err := verifyGroup.Wait(); err != nil {
+		return fmt.Errorf("one of Verify go-routine return error: %v", err)
+	}
+	if problemList := problems.List(); len(problemList) > 0 {
+		return fmt.Errorf("'%s' verification failed, %d problems found:\n%s", backupName, len(problemList), strings.Join(problemList, "\n"))
+	}
+	log.WithFields(apexLog.Fields{
+		"duration": utils.HumanizeDuration(time.Since(start)),
+		"deep":     deep,
+	}).Info("done")
+	return nil
+}
+
+func (b *Backuper) verifyTable(ctx context.Context, remoteBackup metadata.BackupMetadata, tableTitle metadata.TableTitle, deep bool, problems *verifyProblems) error {
+	dbAndTableDir := path.Join(common.TablePathEncode(tableTitle.Database), common.TablePathEncode(tableTitle.Table))
+	remoteTableMetadata := path.Join(remoteBackup.BackupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
+	tmReader, err := b.dst.GetFileReader(ctx, remoteTableMetadata)
+	if err != nil {
+		problems.Add("%s: can't open: %v", remoteTableMetadata, err)
+		return nil
+	}
+	data, err := io.ReadAll(tmReader)
+	if err != nil {
+		problems.Add("%s: can't read: %v", remoteTableMetadata, err)
+		return nil
+	}
+	if err = tmReader.Close(); err != nil {
+		return err
+	}
+	var table metadata.TableMetadata
+	if err = json.Unmarshal(data, &table); err != nil {
+		problems.Add("%s: can't parse: %v", remoteTableMetadata, err)
+		return nil
+	}
+	if table.MetadataOnly {
+		return nil
+	}
+	tableRemotePath := path.Join(remoteBackup.BackupName, "shadow", dbAndTableDir)
+	isEncrypted := remoteBackup.EncryptionKeyID != ""
+	if !deep && isEncrypted && !b.dst.HasEncryptionKey(remoteBackup.EncryptionKeyID) {
+		b.log.Warnf("%s encrypted with key id=%s, which is not found in `encryption` config section, only checksums.txt existence will check", tableRemotePath, remoteBackup.EncryptionKeyID)
+	}
+	if remoteBackup.DataFormat != DirectoryFormat {
+		for disk, archives := range table.Files {
+			sums := newPartFilesSums()
+			for _, archiveFile := range archives {
+				if err := b.verifyArchive(ctx, path.Join(tableRemotePath, archiveFile), table.FilesSize, archiveFile, deep, sums, problems); err != nil {
+					return err
+				}
+			}
+			if deep {
+				b.verifyPartsChecksums(path.Join(tableRemotePath, disk), table.Parts[disk], sums, problems)
+			}
+		}
+		return nil
+	}
+	for disk, parts := range table.Parts {
+		for _, part := range parts {
+			if part.Required {
+				continue
+			}
+			partRemotePath := path.Join(tableRemotePath, disk, part.Name)
+			if !deep && isEncrypted && !b.dst.HasEncryptionKey(remoteBackup.EncryptionKeyID) {
+				if _, err := b.dst.StatFile(ctx, path.Join(partRemotePath, "checksums.txt")); err != nil {
+					problems.Add("%s/checksums.txt: %v", partRemotePath, err)
+				}
+				continue
+			}
+			if !deep {
+				if err := b.verifyPartFilesSize(ctx, partRemotePath, isEncrypted, problems); err != nil {
+					return err
+				}
+				continue
+			}
+			sums := newPartFilesSums()
+			err := b.dst.Walk(ctx, partRemotePath, true, func(ctx context.Context, f storage.RemoteFile) error {
+				r, err := b.dst.GetDataFileReader(ctx, path.Join(partRemotePath, f.Name()))
+				if err != nil {
+					return err
+				}
+				if err = sums.add(path.Join(part.Name, f.Name()), r); err != nil {
+					_ = r.Close()
+					return err
+				}
+				return r.Close()
+			})
+			if err != nil {
+				problems.Add("%s: %v", partRemotePath, err)
+				continue
+			}
+			b.verifyPartsChecksums(path.Join(tableRemotePath, disk), []metadata.Part{part}, sums, problems)
+		}
+	}
+	return nil
+}
+
+// verifyPartFilesSize check each file from checksums.txt exists and has expected size, projections have own checksums.txt
+func (b *Backuper) verifyPartFilesSize(ctx context.Context, partRemotePath string, isEncrypted bool, problems *verifyProblems) error {
+	checksumsTxt := path.Join(partRemotePath, "checksums.txt")
+	r, err := b.dst.GetDataFileReader(ctx, checksumsTxt)
+	if err != nil {
+		problems.Add("%s: %v", checksumsTxt, err)
+		return nil
+	}
+	expected, err := checksums.Parse(r)
+	if closeErr := r.Close(); closeErr != nil {
+		return closeErr
+	}
+	if err != nil {
+		problems.Add("%s: %v", checksumsTxt, err)
+		return nil
+	}
+	for name, c := range expected {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if strings.HasSuffix(name, ".proj") {
+			if err = b.verifyPartFilesSize(ctx, path.Join(partRemotePath, name), isEncrypted, problems); err != nil {
+				return err
+			}
+			continue
+		}
+		expectedSize := int64(c.FileSize)
+		if isEncrypted {
+			expectedSize = encryption.EncryptedSize(expectedSize)
+		}
+		stat, err := b.dst.StatFile(ctx, path.Join(partRemotePath, name))
+		if err != nil {
+			problems.Add("%s/%s: %v", partRemotePath, name, err)
+			continue
+		}
+		if stat.Size() != expectedSize {
+			problems.Add("%s/%s: size %d not equal %d from checksums.txt", partRemotePath, name, stat.Size(), expectedSize)
+		}
+	}
+	return nil
+}
+
+func (b *Backuper) verifyArchive(ctx context.Context, remoteFile string, filesSize map[string]int64, archiveFile string, deep bool, sums *partFilesSums, problems *verifyProblems) error {
+	stat, err := b.dst.StatFile(ctx, remoteFile)
+	if err != nil {
+		problems.Add("%s: %v", remoteFile, err)
+		return nil
+	}
+	if expectedSize, exists := filesSize[archiveFile]; exists && expectedSize != stat.Size() {
+		problems.Add("%s: size %d not equal %d from table metadata", remoteFile, stat.Size(), expectedSize)
+		return nil
+	}
+	if !deep {
+		return nil
+	}
+	err = b.dst.ReadCompressedStream(ctx, remoteFile, func(ctx context.Context, file archiver.File) error {
+		if file.IsDir() {
+			return nil
+		}
+		f, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("can't open %s", file.NameInArchive)
+		}
+		if err = sums.add(file.NameInArchive, f); err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		problems.Add("%s: %v", remoteFile, err)
+	}
+	return nil
+}
+
+// verifyPartsChecksums compare each part and projection directory with own checksums.txt
+func (b *Backuper) verifyPartsChecksums(remotePath string, parts []metadata.Part, sums *partFilesSums, problems *verifyProblems) {
+	for _, part := range parts {
+		if part.Required {
+			continue
+		}
+		if _, exists := sums.checksumsTxt[part.Name]; !exists {
+			problems.Add("%s/checksums.txt not found", path.Join(remotePath, part.Name))
+		}
+	}
+	for dir, body := range sums.checksumsTxt {
+		expected, err := checksums.Parse(bytes.NewReader(body))
+		if err != nil {
+			problems.Add("%s/checksums.txt: %v", path.Join(remotePath, dir), err)
+			continue
+		}
+		for _, problem := range checksums.Compare(expected, sums.files[dir]) {
+			problems.Add("%s/%s", path.Join(remotePath, dir), problem)
+		}
+	}
+}
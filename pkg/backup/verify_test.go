@@ -0,0 +1,45 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	recursiveCopy "github.com/otiai10/copy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/verify/all_1_1_0 - wide part with projection, checksums.txt has format version 4 with LZ4 compressed body
+func TestVerifyPartFilesSize(t *testing.T) {
+	ctx := context.Background()
+	remotePath := t.TempDir()
+	partRemotePath := "test_backup/shadow/default/test/default/all_1_1_0"
+	require.NoError(t, recursiveCopy.Copy(path.Join("testdata", "verify", "all_1_1_0"), path.Join(remotePath, partRemotePath)))
+	b := &Backuper{dst: &storage.BackupDestination{RemoteStorage: &storage.FS{Config: &config.FSConfig{Path: remotePath}}}}
+
+	problems := &verifyProblems{}
+	require.NoError(t, b.verifyPartFilesSize(ctx, partRemotePath, false, problems))
+	assert.Empty(t, problems.List())
+
+	encryptedProblems := &verifyProblems{}
+	require.NoError(t, b.verifyPartFilesSize(ctx, partRemotePath, true, encryptedProblems))
+	assert.Len(t, encryptedProblems.List(), 13, "plain files have wrong size for encrypted backup")
+
+	require.NoError(t, os.Truncate(path.Join(remotePath, partRemotePath, "name.bin"), 100))
+	require.NoError(t, os.Remove(path.Join(remotePath, partRemotePath, "p.proj", "id.mrk2")))
+	problems = &verifyProblems{}
+	require.NoError(t, b.verifyPartFilesSize(ctx, partRemotePath, false, problems))
+	problemList := problems.List()
+	require.Len(t, problemList, 2)
+	assert.Contains(t, problemList[0], "all_1_1_0/name.bin: size 100 not equal 1213 from checksums.txt")
+	assert.Contains(t, problemList[1], "all_1_1_0/p.proj/id.mrk2")
+
+	require.NoError(t, os.Remove(path.Join(remotePath, partRemotePath, "checksums.txt")))
+	problems = &verifyProblems{}
+	require.NoError(t, b.verifyPartFilesSize(ctx, partRemotePath, false, problems))
+	assert.Len(t, problems.List(), 1)
+}
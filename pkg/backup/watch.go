@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/schedule"
 	"github.com/Altinity/clickhouse-backup/pkg/server/metrics"
 	"github.com/Altinity/clickhouse-backup/pkg/status"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
 	apexLog "github.com/apex/log"
 	"github.com/urfave/cli"
 	"regexp"
@@ -14,6 +17,21 @@ import (
 )
 
 var watchBackupTemplateTimeRE = regexp.MustCompile(`{time:([^}]+)}`)
+var watchBackupTemplatePlaceholderRE = regexp.MustCompile(`{type}|{time:[^}]+}`)
+
+// watchSchedule - nil cron means the backup type is scheduled by watch_interval or full_interval
+type watchSchedule struct {
+	full      *schedule.Cron
+	increment *schedule.Cron
+	blackout  schedule.Windows
+}
+
+// watchPlan - when and which backup watch shall create next
+type watchPlan struct {
+	At         time.Time
+	BackupType string
+	DiffFrom   string
+}
 
 func (b *Backuper) NewBackupWatchName(ctx context.Context, backupType string) (string, error) {
 	backupName, err := b.ch.ApplyMacros(ctx, b.cfg.General.WatchBackupNameTemplate)
@@ -47,40 +65,64 @@ func (b *Backuper) ValidateWatchParams(watchInterval, fullInterval, watchBackupN
 			return fmt.Errorf("fullInterval `%s` parsing error: %v", fullInterval, err)
 		}
 	}
-	if b.cfg.General.FullDuration <= b.cfg.General.WatchDuration {
-		return fmt.Errorf("fullInterval `%s` should be more than watchInterval `%s`", b.cfg.General.FullInterval, b.cfg.General.WatchInterval)
-	}
 	if watchBackupNameTemplate != "" {
 		b.cfg.General.WatchBackupNameTemplate = watchBackupNameTemplate
 	}
+	if _, err = b.getWatchSchedule(); err != nil {
+		return err
+	}
+	// intervals are not comparable with cron schedules
+	if b.cfg.General.WatchFullSchedule != "" || b.cfg.General.WatchIncrementSchedule != "" {
+		return nil
+	}
+	if b.cfg.General.FullDuration <= b.cfg.General.WatchDuration {
+		return fmt.Errorf("fullInterval `%s` should be more than watchInterval `%s`", b.cfg.General.FullInterval, b.cfg.General.WatchInterval)
+	}
 	if b.cfg.General.BackupsToKeepRemote > 0 && b.cfg.General.WatchDuration.Seconds()*float64(b.cfg.General.BackupsToKeepRemote) < b.cfg.General.FullDuration.Seconds() {
 		return fmt.Errorf("fullInterval `%s` is too long to keep %d remote backups with watchInterval `%s`", b.cfg.General.FullInterval, b.cfg.General.BackupsToKeepRemote, b.cfg.General.WatchInterval)
 	}
 	return nil
 }
 
+func (b *Backuper) getWatchSchedule() (watchSchedule, error) {
+	var s watchSchedule
+	var err error
+	if b.cfg.General.WatchFullSchedule != "" {
+		if s.full, err = schedule.ParseCron(b.cfg.General.WatchFullSchedule); err != nil {
+			return s, fmt.Errorf("watch_full_schedule: %v", err)
+		}
+	}
+	if b.cfg.General.WatchIncrementSchedule != "" {
+		if s.increment, err = schedule.ParseCron(b.cfg.General.WatchIncrementSchedule); err != nil {
+			return s, fmt.Errorf("watch_increment_schedule: %v", err)
+		}
+	}
+	if s.blackout, err = schedule.ParseWindows(b.cfg.General.WatchBlackoutWindows); err != nil {
+		return s, fmt.Errorf("watch_blackout_windows: %v", err)
+	}
+	return s, nil
+}
+
 // Watch
-// - run create_remote full + delete local full, even when upload failed
-//   - if success save backup type full, next will increment, until reach full interval
-//   - if fail save previous backup type empty, next try will also full
-//
-// - each watch-interval, run create_remote increment --diff-from=prev-name + delete local increment, even when upload failed
-//   - save previous backup type incremental, next try will also incremental, until reach full interval
+// - each iteration calculates next backup type and time from existing remote backups which match watch_backup_name_template,
+// so restart doesn't force full backup, when list remote backups failed, backups created by current watch process are used
+// - full backup created when no one full backup exists, when watch_full_schedule tick was missed or full_interval passed after the last full backup
+// - increment backup created by watch_increment_schedule or every watch_interval, use the latest backup as --diff-from-remote
+// - backups are not started inside watch_blackout_windows
+// - run create_remote + delete local, even when upload failed
 func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, tablePattern string, partitions []string, schemaOnly, backupRBAC, backupConfigs, skipCheckPartsColumns bool, version string, commandId int, metrics metrics.APIMetricsInterface, cliCtx *cli.Context) error {
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
 	}
+	b.setCommandLog(commandId)
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 
 	if err := b.ValidateWatchParams(watchInterval, fullInterval, watchBackupNameTemplate); err != nil {
 		return err
 	}
-	backupType := "full"
-	prevBackupName := ""
-	prevBackupType := ""
-	lastBackup := time.Now()
+	createdBackups := make([]storage.Backup, 0)
 	lastFullBackup := time.Now()
 	createRemoteErrCount := 0
 	deleteLocalErrCount := 0
@@ -97,7 +139,7 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 			return ctx.Err()
 		default:
 			if cliCtx != nil {
-				if cfg, err := config.LoadConfig(config.GetConfigPath(cliCtx)); err == nil {
+				if cfg, err := b.loadWatchConfig(config.GetConfigPath(cliCtx)); err == nil {
 					b.cfg = cfg
 				} else {
 					b.log.Warnf("watch config.LoadConfig error: %v", err)
@@ -106,7 +148,32 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 					return err
 				}
 			}
-			backupName, err := b.NewBackupWatchName(ctx, backupType)
+			plan, err := b.getNextWatchBackup(ctx, createdBackups)
+			if err != nil {
+				return err
+			}
+			if wait := time.Until(plan.At); wait > 0 {
+				b.log.WithFields(apexLog.Fields{
+					"operation": "watch",
+					"type":      plan.BackupType,
+					"diff_from": plan.DiffFrom,
+				}).Infof("next backup at %s", plan.At.Format(time.RFC3339))
+				if b.ch.IsOpen {
+					b.ch.Close()
+				}
+				// queued API commands could run while watch waits for the next backup
+				status.Current.SetIdle(commandId, true)
+				select {
+				case <-ctx.Done(): //context cancelled
+					return ctx.Err()
+				case <-time.After(wait): //timeout
+				}
+				status.Current.SetIdle(commandId, false)
+				if err = b.ch.Connect(); err != nil {
+					return err
+				}
+			}
+			backupName, err := b.NewBackupWatchName(ctx, plan.BackupType)
 			log := b.log.WithFields(apexLog.Fields{
 				"backup":    backupName,
 				"operation": "watch",
@@ -114,20 +181,16 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 			if err != nil {
 				return err
 			}
-			diffFromRemote := ""
-			if backupType == "increment" {
-				diffFromRemote = prevBackupName
-			}
 			if metrics != nil {
 				createRemoteErr, createRemoteErrCount = metrics.ExecuteWithMetrics("create_remote", createRemoteErrCount, func() error {
-					return b.CreateToRemote(backupName, "", diffFromRemote, tablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, version, commandId)
+					return b.CreateToRemote(backupName, "", plan.DiffFrom, tablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, version, commandId)
 				})
 				deleteLocalErr, deleteLocalErrCount = metrics.ExecuteWithMetrics("delete", deleteLocalErrCount, func() error {
 					return b.RemoveBackupLocal(ctx, backupName, nil)
 				})
 
 			} else {
-				createRemoteErr = b.CreateToRemote(backupName, "", diffFromRemote, tablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, version, commandId)
+				createRemoteErr = b.CreateToRemote(backupName, "", plan.DiffFrom, tablePattern, partitions, schemaOnly, backupRBAC, false, backupConfigs, false, skipCheckPartsColumns, false, version, commandId)
 				if createRemoteErr != nil {
 					log.Errorf("create_remote %s return error: %v", backupName, createRemoteErr)
 					createRemoteErrCount += 1
@@ -151,25 +214,15 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 				return fmt.Errorf("too many errors during watch full_interval: %s, abort watching", b.cfg.General.FullInterval)
 			}
 			if createRemoteErr == nil {
-				prevBackupName = backupName
-				prevBackupType = backupType
-				if prevBackupType == "full" {
-					backupType = "increment"
-				}
 				now := time.Now()
-				if b.cfg.General.WatchDuration.Seconds()-now.Sub(lastBackup).Seconds() > 0 {
-					select {
-					case <-ctx.Done(): //context cancelled
-						return ctx.Err()
-					case <-time.After(b.cfg.General.WatchDuration - now.Sub(lastBackup)): //timeout
-					}
-				}
-				now = time.Now()
-				lastBackup = now
-				if b.cfg.General.FullDuration.Seconds()-now.Sub(lastFullBackup).Seconds() <= 0 {
-					backupType = "full"
+				if plan.BackupType == "full" {
 					lastFullBackup = now
 				}
+				createdBackups = append(createdBackups, storage.Backup{BackupMetadata: metadata.BackupMetadata{
+					BackupName:     backupName,
+					CreationDate:   now,
+					RequiredBackup: plan.DiffFrom,
+				}})
 			}
 		}
 		if b.ch.IsOpen {
@@ -177,3 +230,145 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 		}
 	}
 }
+
+// getNextWatchBackup - plan from remote backups, when remote storage is unavailable then from backups created by current process
+func (b *Backuper) getNextWatchBackup(ctx context.Context, createdBackups []storage.Backup) (watchPlan, error) {
+	s, err := b.getWatchSchedule()
+	if err != nil {
+		return watchPlan{}, err
+	}
+	backupNameTemplate, err := b.ch.ApplyMacros(ctx, b.cfg.General.WatchBackupNameTemplate)
+	if err != nil {
+		return watchPlan{}, err
+	}
+	backupNameRE := getWatchBackupNameRE(backupNameTemplate)
+	if b.watchJob != "" {
+		b.retentionBackupNameRE = backupNameRE
+	}
+	backupList := createdBackups
+	if remoteBackups, err := b.GetRemoteBackups(ctx, true); err == nil {
+		backupList = filterWatchBackups(remoteBackups, backupNameRE)
+	} else {
+		b.log.WithField("operation", "watch").Warnf("can't get remote backups, will use backups created by current watch: %v", err)
+	}
+	return getWatchPlan(time.Now(), backupList, b.cfg.General.WatchDuration, b.cfg.General.FullDuration, s)
+}
+
+// loadWatchConfig - config of watch job is reloaded by job name
+func (b *Backuper) loadWatchConfig(configPath string) (*config.Config, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil || b.watchJob == "" {
+		return cfg, err
+	}
+	cfg, _, err = cfg.GetWatchJob(b.watchJob)
+	return cfg, err
+}
+
+// getWatchBackupNameRE - match backups created with watch_backup_name_template, macros shall be applied before
+func getWatchBackupNameRE(backupNameTemplate string) *regexp.Regexp {
+	pattern := strings.Builder{}
+	pattern.WriteString("^")
+	prevEnd := 0
+	for _, loc := range watchBackupTemplatePlaceholderRE.FindAllStringIndex(backupNameTemplate, -1) {
+		pattern.WriteString(regexp.QuoteMeta(backupNameTemplate[prevEnd:loc[0]]))
+		if placeholder := backupNameTemplate[loc[0]:loc[1]]; placeholder == "{type}" {
+			pattern.WriteString("(full|increment)")
+		} else {
+			pattern.WriteString(getWatchBackupTimePattern(watchBackupTemplateTimeRE.FindStringSubmatch(placeholder)[1]))
+		}
+		prevEnd = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(backupNameTemplate[prevEnd:]))
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String())
+}
+
+// getWatchBackupTimePattern - digits and letters of time layout could change, other characters are kept,
+// so `job-a` template doesn't match backups of `job-a-full` template
+func getWatchBackupTimePattern(layout string) string {
+	pattern := strings.Builder{}
+	for i := 0; i < len(layout); {
+		j := i + 1
+		switch {
+		case isDigit(layout[i]):
+			for j < len(layout) && isDigit(layout[j]) {
+				j++
+			}
+			pattern.WriteString(`\d+`)
+		case isLetter(layout[i]):
+			for j < len(layout) && isLetter(layout[j]) {
+				j++
+			}
+			pattern.WriteString(`[A-Za-z]+`)
+		default:
+			pattern.WriteString(regexp.QuoteMeta(layout[i:j]))
+		}
+		i = j
+	}
+	return pattern.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func filterWatchBackups(backupList []storage.Backup, backupNameRE *regexp.Regexp) []storage.Backup {
+	watchBackups := make([]storage.Backup, 0)
+	for _, backup := range backupList {
+		if backup.Broken != "" || backup.Legacy || !backupNameRE.MatchString(backup.BackupName) {
+			continue
+		}
+		watchBackups = append(watchBackups, backup)
+	}
+	return watchBackups
+}
+
+// getWatchPlan - full backup wins when full and increment backups are planned at the same time
+func getWatchPlan(now time.Time, watchBackups []storage.Backup, watchDuration, fullDuration time.Duration, s watchSchedule) (watchPlan, error) {
+	var lastBackup, lastFullBackup *storage.Backup
+	for i := range watchBackups {
+		if lastBackup == nil || watchBackups[i].CreationDate.After(lastBackup.CreationDate) {
+			lastBackup = &watchBackups[i]
+		}
+		if watchBackups[i].RequiredBackup == "" && (lastFullBackup == nil || watchBackups[i].CreationDate.After(lastFullBackup.CreationDate)) {
+			lastFullBackup = &watchBackups[i]
+		}
+	}
+	if lastFullBackup == nil {
+		return watchPlan{At: s.blackout.Postpone(now), BackupType: "full"}, nil
+	}
+	var fullAt, incrementAt time.Time
+	if s.full != nil {
+		// full backup tick was missed, when watch was stopped or inside blackout window
+		if prev := s.full.PrevOutside(now, s.blackout); !prev.IsZero() && prev.After(lastFullBackup.CreationDate) {
+			fullAt = s.blackout.Postpone(now)
+		} else {
+			fullAt = s.full.NextOutside(now, s.blackout)
+		}
+	} else if fullDuration > 0 {
+		fullAt = s.blackout.Postpone(laterTime(now, lastFullBackup.CreationDate.Add(fullDuration)))
+	}
+	if s.increment != nil {
+		incrementAt = s.increment.NextOutside(now, s.blackout)
+	} else if watchDuration > 0 {
+		incrementAt = s.blackout.Postpone(laterTime(now, lastBackup.CreationDate.Add(watchDuration)))
+	}
+	if fullAt.IsZero() && incrementAt.IsZero() {
+		return watchPlan{}, fmt.Errorf("can't plan next watch backup, schedules never match outside blackout windows")
+	}
+	if !fullAt.IsZero() && (incrementAt.IsZero() || !incrementAt.Before(fullAt)) {
+		return watchPlan{At: fullAt, BackupType: "full"}, nil
+	}
+	return watchPlan{At: incrementAt, BackupType: "increment", DiffFrom: lastBackup.BackupName}, nil
+}
+
+func laterTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/Altinity/clickhouse-backup/pkg/schedule"
+	"github.com/Altinity/clickhouse-backup/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWatchBackup(name, requiredBackup string, creationDate time.Time) storage.Backup {
+	return storage.Backup{BackupMetadata: metadata.BackupMetadata{BackupName: name, RequiredBackup: requiredBackup, CreationDate: creationDate}}
+}
+
+func TestGetWatchBackupNameRE(t *testing.T) {
+	backupNameRE := getWatchBackupNameRE("shard1-{type}-{time:20060102150405}")
+	backupList := []storage.Backup{
+		newWatchBackup("shard1-full-20240105020000", "", time.Now()),
+		newWatchBackup("shard1-increment-20240105060000", "shard1-full-20240105020000", time.Now()),
+		newWatchBackup("shard2-full-20240105020000", "", time.Now()),
+		newWatchBackup("manual", "", time.Now()),
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "shard1-full-20240104020000"}, Broken: "broken (can't stat metadata.json)"},
+	}
+	watchBackups := filterWatchBackups(backupList, backupNameRE)
+	require.Len(t, watchBackups, 2)
+	assert.Equal(t, "shard1-full-20240105020000", watchBackups[0].BackupName)
+	assert.Equal(t, "shard1-increment-20240105060000", watchBackups[1].BackupName)
+
+	// watch jobs `a` and `a-full` shall not see backups of each other
+	backupNameRE = getWatchBackupNameRE("a-{type}-{time:2006-01-02T15-04-05}")
+	assert.True(t, backupNameRE.MatchString("a-full-2024-01-05T02-00-00"))
+	assert.False(t, backupNameRE.MatchString("a-full-increment-2024-01-05T02-00-00"))
+	assert.False(t, backupNameRE.MatchString("a-full-20240105020000"))
+}
+
+func TestGetWatchPlanIntervals(t *testing.T) {
+	now := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+	// no one full backup
+	plan, err := getWatchPlan(now, nil, time.Hour, 24*time.Hour, watchSchedule{})
+	require.NoError(t, err)
+	assert.Equal(t, watchPlan{At: now, BackupType: "full"}, plan)
+
+	// restart shall continue increments sequence
+	backupList := []storage.Backup{
+		newWatchBackup("full1", "", now.Add(-3*time.Hour)),
+		newWatchBackup("increment1", "full1", now.Add(-2*time.Hour)),
+		newWatchBackup("increment2", "increment1", now.Add(-30*time.Minute)),
+	}
+	plan, err = getWatchPlan(now, backupList, time.Hour, 24*time.Hour, watchSchedule{})
+	require.NoError(t, err)
+	assert.Equal(t, watchPlan{At: now.Add(30 * time.Minute), BackupType: "increment", DiffFrom: "increment2"}, plan)
+
+	// full_interval passed
+	plan, err = getWatchPlan(now, backupList, time.Hour, 2*time.Hour, watchSchedule{})
+	require.NoError(t, err)
+	assert.Equal(t, watchPlan{At: now, BackupType: "full"}, plan)
+
+	// blackout window postpones backup
+	blackout, err := schedule.ParseWindows([]string{"* 12:00-14:00"})
+	require.NoError(t, err)
+	plan, err = getWatchPlan(now, backupList, time.Hour, 24*time.Hour, watchSchedule{blackout: blackout})
+	require.NoError(t, err)
+	assert.Equal(t, watchPlan{At: now.Add(2 * time.Hour), BackupType: "increment", DiffFrom: "increment2"}, plan)
+}
+
+func TestGetWatchPlanSchedules(t *testing.T) {
+	// friday
+	now := time.Date(2024, 1, 5, 12, 10, 0, 0, time.UTC)
+	fullCron, err := schedule.ParseCron("0 2 * * sun")
+	require.NoError(t, err)
+	incrementCron, err := schedule.ParseCron("0 */4 * * 1-5")
+	require.NoError(t, err)
+	s := watchSchedule{full: fullCron, increment: incrementCron}
+	backupList := []storage.Backup{
+		newWatchBackup("full1", "", time.Date(2023, 12, 31, 2, 0, 10, 0, time.UTC)),
+		newWatchBackup("increment1", "full1", time.Date(2024, 1, 5, 12, 0, 10, 0, time.UTC)),
+	}
+	plan, err := getWatchPlan(now, backupList, time.Hour, 24*time.Hour, s)
+	require.NoError(t, err)
+	assert.Equal(t, watchPlan{At: time.Date(2024, 1, 5, 16, 0, 0, 0, time.UTC), BackupType: "increment", DiffFrom: "increment1"}, plan)
+
+	// full backup tick on sunday
+	now = time.Date(2024, 1, 5, 20, 10, 0, 0, time.UTC)
+	plan, err = getWatchPlan(now, backupList, time.Hour, 24*time.Hour, s)
+	require.NoError(t, err)
+	assert.Equal(t, watchPlan{At: time.Date(2024, 1, 7, 2, 0, 0, 0, time.UTC), BackupType: "full"}, plan)
+
+	// missed full backup tick
+	now = time.Date(2024, 1, 8, 1, 0, 0, 0, time.UTC)
+	plan, err = getWatchPlan(now, backupList, time.Hour, 24*time.Hour, s)
+	require.NoError(t, err)
+	assert.Equal(t, watchPlan{At: now, BackupType: "full"}, plan)
+
+	// increment schedule only, full backup by full_interval
+	now = time.Date(2024, 1, 5, 12, 10, 0, 0, time.UTC)
+	plan, err = getWatchPlan(now, backupList, time.Hour, 24*time.Hour, watchSchedule{increment: incrementCron})
+	require.NoError(t, err)
+	assert.Equal(t, watchPlan{At: now, BackupType: "full"}, plan)
+}
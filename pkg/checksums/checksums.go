@@ -0,0 +1,179 @@
+package checksums
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ClickHouse/ch-go/compress"
+	"github.com/go-faster/city"
+)
+
+// hashingBlockSize - DBMS_DEFAULT_HASHING_BLOCK_SIZE, look HashingWriteBuffer.h in ClickHouse sources
+const hashingBlockSize = 2048
+
+// Checksum - one row from data part checksums.txt
+type Checksum struct {
+	FileSize         uint64
+	FileHash         city.U128
+	IsCompressed     bool
+	UncompressedSize uint64
+	UncompressedHash city.U128
+}
+
+// FileSum - actual size and hash of file which present in backup
+type FileSum struct {
+	Size uint64
+	Hash city.U128
+}
+
+// Parse read checksums.txt, support binary format version 3 and compressed format version 4
+func Parse(r io.Reader) (map[string]Checksum, error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("can't read checksums.txt header: %v", err)
+	}
+	var version int
+	if _, err = fmt.Sscanf(strings.TrimSpace(header), "checksums format version: %d", &version); err != nil {
+		return nil, fmt.Errorf("unexpected checksums.txt header %q: %v", header, err)
+	}
+	var body *bufio.Reader
+	switch version {
+	case 3:
+		body = reader
+	case 4:
+		body = bufio.NewReader(compress.NewReader(reader))
+	default:
+		return nil, fmt.Errorf("unsupported checksums.txt format version %d", version)
+	}
+	count, err := binary.ReadUvarint(body)
+	if err != nil {
+		return nil, fmt.Errorf("can't read checksums.txt files count: %v", err)
+	}
+	result := make(map[string]Checksum, count)
+	for i := uint64(0); i < count; i++ {
+		nameLen, err := binary.ReadUvarint(body)
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, nameLen)
+		if _, err = io.ReadFull(body, name); err != nil {
+			return nil, err
+		}
+		var c Checksum
+		if c.FileSize, err = binary.ReadUvarint(body); err != nil {
+			return nil, err
+		}
+		if c.FileHash, err = readU128(body); err != nil {
+			return nil, err
+		}
+		isCompressed, err := body.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if isCompressed != 0 {
+			c.IsCompressed = true
+			if c.UncompressedSize, err = binary.ReadUvarint(body); err != nil {
+				return nil, err
+			}
+			if c.UncompressedHash, err = readU128(body); err != nil {
+				return nil, err
+			}
+		}
+		result[string(name)] = c
+	}
+	return result, nil
+}
+
+func readU128(r io.Reader) (city.U128, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return city.U128{}, err
+	}
+	return city.U128{
+		Low:  binary.LittleEndian.Uint64(buf[0:8]),
+		High: binary.LittleEndian.Uint64(buf[8:16]),
+	}, nil
+}
+
+// Hasher calculate file_hash the same way as ClickHouse HashingWriteBuffer
+type Hasher struct {
+	state city.U128
+	block []byte
+	size  uint64
+}
+
+func NewHasher() *Hasher {
+	return &Hasher{
+		block: make([]byte, 0, hashingBlockSize),
+	}
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	h.size += uint64(n)
+	if len(h.block)+len(p) < hashingBlockSize {
+		h.block = append(h.block, p...)
+		return n, nil
+	}
+	if len(h.block) > 0 {
+		fill := hashingBlockSize - len(h.block)
+		h.block = append(h.block, p[:fill]...)
+		h.state = city.CH128Seed(h.block, h.state)
+		h.block = h.block[:0]
+		p = p[fill:]
+	}
+	for len(p) >= hashingBlockSize {
+		h.state = city.CH128Seed(p[:hashingBlockSize], h.state)
+		p = p[hashingBlockSize:]
+	}
+	h.block = append(h.block, p...)
+	return n, nil
+}
+
+// Sum return size and hash of all written data
+func (h *Hasher) Sum() FileSum {
+	hash := h.state
+	if len(h.block) > 0 {
+		hash = city.CH128Seed(h.block, h.state)
+	}
+	return FileSum{Size: h.size, Hash: hash}
+}
+
+// SumReader calculate FileSum for whole reader content
+func SumReader(r io.Reader) (FileSum, error) {
+	h := NewHasher()
+	if _, err := io.Copy(h, r); err != nil {
+		return FileSum{}, err
+	}
+	return h.Sum(), nil
+}
+
+// Compare expected checksums with actual files, projections `*.proj` contains own checksums.txt and shall check separately
+// when actual hash is empty, compare only file size
+func Compare(expected map[string]Checksum, actual map[string]FileSum) []string {
+	problems := make([]string, 0)
+	for name, c := range expected {
+		if strings.HasSuffix(name, ".proj") {
+			continue
+		}
+		sum, exists := actual[name]
+		if !exists {
+			problems = append(problems, fmt.Sprintf("%s not found", name))
+			continue
+		}
+		if sum.Size != c.FileSize {
+			problems = append(problems, fmt.Sprintf("%s size %d not equal %d from checksums.txt", name, sum.Size, c.FileSize))
+			continue
+		}
+		if sum.Hash != (city.U128{}) && sum.Hash != c.FileHash {
+			problems = append(problems, fmt.Sprintf("%s hash not equal with checksums.txt", name))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
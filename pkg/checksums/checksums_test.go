@@ -0,0 +1,80 @@
+package checksums
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/ClickHouse/ch-go/compress"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeChecksumsBody(files map[string][]byte) []byte {
+	var body []byte
+	body = binary.AppendUvarint(body, uint64(len(files)))
+	for name, content := range files {
+		sum, _ := SumReader(bytes.NewReader(content))
+		body = binary.AppendUvarint(body, uint64(len(name)))
+		body = append(body, name...)
+		body = binary.AppendUvarint(body, sum.Size)
+		body = binary.LittleEndian.AppendUint64(body, sum.Hash.Low)
+		body = binary.LittleEndian.AppendUint64(body, sum.Hash.High)
+		body = append(body, 0)
+	}
+	return body
+}
+
+func TestParse(t *testing.T) {
+	files := map[string][]byte{
+		"count.txt":   []byte("100"),
+		"data.bin":    bytes.Repeat([]byte{1, 2, 3}, 3000),
+		"primary.idx": {},
+	}
+	body := writeChecksumsBody(files)
+
+	v3 := append([]byte("checksums format version: 3\n"), body...)
+	parsed, err := Parse(bytes.NewReader(v3))
+	require.NoError(t, err)
+	assert.Len(t, parsed, 3)
+	assert.Equal(t, uint64(9000), parsed["data.bin"].FileSize)
+
+	w := compress.NewWriter()
+	require.NoError(t, w.Compress(compress.LZ4, body))
+	v4 := append([]byte("checksums format version: 4\n"), w.Data...)
+	parsed, err = Parse(bytes.NewReader(v4))
+	require.NoError(t, err)
+	assert.Len(t, parsed, 3)
+
+	actual := map[string]FileSum{}
+	for name, content := range files {
+		actual[name], _ = SumReader(bytes.NewReader(content))
+	}
+	assert.Empty(t, Compare(parsed, actual))
+
+	actual["data.bin"] = FileSum{Size: 9000, Hash: actual["count.txt"].Hash}
+	delete(actual, "count.txt")
+	assert.Equal(t, []string{"count.txt not found", "data.bin hash not equal with checksums.txt"}, Compare(parsed, actual))
+
+	_, err = Parse(bytes.NewReader([]byte("checksums format version: 1\n")))
+	assert.Error(t, err)
+}
+
+func TestHasherNotDependOnWriteSize(t *testing.T) {
+	data := make([]byte, 3*hashingBlockSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	expected, err := SumReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	for _, writeSize := range []int{1, 7, hashingBlockSize - 1, hashingBlockSize, hashingBlockSize + 1} {
+		h := NewHasher()
+		for offset := 0; offset < len(data); offset += writeSize {
+			end := offset + writeSize
+			if end > len(data) {
+				end = len(data)
+			}
+			_, _ = h.Write(data[offset:end])
+		}
+		assert.Equal(t, expected, h.Sum(), "writeSize=%d", writeSize)
+	}
+}
@@ -2,15 +2,20 @@ package config
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"math"
+	"net/url"
 	"os"
+	"regexp"
 	"runtime"
 	"strings"
+	"text/template"
 	"time"
 
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 
+	"github.com/Altinity/clickhouse-backup/pkg/schedule"
 	"github.com/apex/log"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/urfave/cli"
@@ -23,45 +28,60 @@ const (
 
 // Config - config file format
 type Config struct {
-	General    GeneralConfig    `yaml:"general" envconfig:"_"`
-	ClickHouse ClickHouseConfig `yaml:"clickhouse" envconfig:"_"`
-	S3         S3Config         `yaml:"s3" envconfig:"_"`
-	GCS        GCSConfig        `yaml:"gcs" envconfig:"_"`
-	COS        COSConfig        `yaml:"cos" envconfig:"_"`
-	API        APIConfig        `yaml:"api" envconfig:"_"`
-	FTP        FTPConfig        `yaml:"ftp" envconfig:"_"`
-	SFTP       SFTPConfig       `yaml:"sftp" envconfig:"_"`
-	AzureBlob  AzureBlobConfig  `yaml:"azblob" envconfig:"_"`
-	Custom     CustomConfig     `yaml:"custom" envconfig:"_"`
+	General       GeneralConfig       `yaml:"general" envconfig:"_"`
+	ClickHouse    ClickHouseConfig    `yaml:"clickhouse" envconfig:"_"`
+	S3            S3Config            `yaml:"s3" envconfig:"_"`
+	GCS           GCSConfig           `yaml:"gcs" envconfig:"_"`
+	COS           COSConfig           `yaml:"cos" envconfig:"_"`
+	API           APIConfig           `yaml:"api" envconfig:"_"`
+	FTP           FTPConfig           `yaml:"ftp" envconfig:"_"`
+	SFTP          SFTPConfig          `yaml:"sftp" envconfig:"_"`
+	FS            FSConfig            `yaml:"fs" envconfig:"_"`
+	AzureBlob     AzureBlobConfig     `yaml:"azblob" envconfig:"_"`
+	Custom        CustomConfig        `yaml:"custom" envconfig:"_"`
+	Encryption    EncryptionConfig    `yaml:"encryption" envconfig:"_"`
+	Retention     RetentionConfig     `yaml:"retention" envconfig:"RETENTION"`
+	WatchJobs     []WatchJobConfig    `yaml:"watch_jobs" ignored:"true"`
+	Notifications NotificationsConfig `yaml:"notifications" ignored:"true"`
 }
 
 // GeneralConfig - general setting section
 type GeneralConfig struct {
-	RemoteStorage           string            `yaml:"remote_storage" envconfig:"REMOTE_STORAGE"`
-	MaxFileSize             int64             `yaml:"max_file_size" envconfig:"MAX_FILE_SIZE"`
-	DisableProgressBar      bool              `yaml:"disable_progress_bar" envconfig:"DISABLE_PROGRESS_BAR"`
-	BackupsToKeepLocal      int               `yaml:"backups_to_keep_local" envconfig:"BACKUPS_TO_KEEP_LOCAL"`
-	BackupsToKeepRemote     int               `yaml:"backups_to_keep_remote" envconfig:"BACKUPS_TO_KEEP_REMOTE"`
-	LogLevel                string            `yaml:"log_level" envconfig:"LOG_LEVEL"`
-	AllowEmptyBackups       bool              `yaml:"allow_empty_backups" envconfig:"ALLOW_EMPTY_BACKUPS"`
-	DownloadConcurrency     uint8             `yaml:"download_concurrency" envconfig:"DOWNLOAD_CONCURRENCY"`
-	UploadConcurrency       uint8             `yaml:"upload_concurrency" envconfig:"UPLOAD_CONCURRENCY"`
-	UseResumableState       bool              `yaml:"use_resumable_state" envconfig:"USE_RESUMABLE_STATE"`
-	RestoreSchemaOnCluster  string            `yaml:"restore_schema_on_cluster" envconfig:"RESTORE_SCHEMA_ON_CLUSTER"`
-	UploadByPart            bool              `yaml:"upload_by_part" envconfig:"UPLOAD_BY_PART"`
-	DownloadByPart          bool              `yaml:"download_by_part" envconfig:"DOWNLOAD_BY_PART"`
-	RestoreDatabaseMapping  map[string]string `yaml:"restore_database_mapping" envconfig:"RESTORE_DATABASE_MAPPING"`
-	RetriesOnFailure        int               `yaml:"retries_on_failure" envconfig:"RETRIES_ON_FAILURE"`
-	RetriesPause            string            `yaml:"retries_pause" envconfig:"RETRIES_PAUSE"`
-	WatchInterval           string            `yaml:"watch_interval" envconfig:"WATCH_INTERVAL"`
-	FullInterval            string            `yaml:"full_interval" envconfig:"FULL_INTERVAL"`
-	WatchBackupNameTemplate string            `yaml:"watch_backup_name_template" envconfig:"WATCH_BACKUP_NAME_TEMPLATE"`
-	ShardedOperationMode    string            `yaml:"sharded_operation_mode" envconfig:"SHARDED_OPERATION_MODE"`
-	CPUNicePriority         int               `yaml:"cpu_nice_priority" envconfig:"CPU_NICE_PRIORITY"`
-	IONicePriority          string            `yaml:"io_nice_priority" envconfig:"IO_NICE_PRIORITY"`
-	RetriesDuration         time.Duration
-	WatchDuration           time.Duration
-	FullDuration            time.Duration
+	RemoteStorage               string            `yaml:"remote_storage" envconfig:"REMOTE_STORAGE"`
+	MaxFileSize                 int64             `yaml:"max_file_size" envconfig:"MAX_FILE_SIZE"`
+	DisableProgressBar          bool              `yaml:"disable_progress_bar" envconfig:"DISABLE_PROGRESS_BAR"`
+	BackupsToKeepLocal          int               `yaml:"backups_to_keep_local" envconfig:"BACKUPS_TO_KEEP_LOCAL"`
+	BackupsToKeepRemote         int               `yaml:"backups_to_keep_remote" envconfig:"BACKUPS_TO_KEEP_REMOTE"`
+	LogLevel                    string            `yaml:"log_level" envconfig:"LOG_LEVEL"`
+	AllowEmptyBackups           bool              `yaml:"allow_empty_backups" envconfig:"ALLOW_EMPTY_BACKUPS"`
+	DownloadConcurrency         uint8             `yaml:"download_concurrency" envconfig:"DOWNLOAD_CONCURRENCY"`
+	UploadConcurrency           uint8             `yaml:"upload_concurrency" envconfig:"UPLOAD_CONCURRENCY"`
+	RestoreConcurrency          uint8             `yaml:"restore_concurrency" envconfig:"RESTORE_CONCURRENCY"`
+	UseResumableState           bool              `yaml:"use_resumable_state" envconfig:"USE_RESUMABLE_STATE"`
+	RestoreSchemaOnCluster      string            `yaml:"restore_schema_on_cluster" envconfig:"RESTORE_SCHEMA_ON_CLUSTER"`
+	UploadByPart                bool              `yaml:"upload_by_part" envconfig:"UPLOAD_BY_PART"`
+	DownloadByPart              bool              `yaml:"download_by_part" envconfig:"DOWNLOAD_BY_PART"`
+	RestoreDatabaseMapping      map[string]string `yaml:"restore_database_mapping" envconfig:"RESTORE_DATABASE_MAPPING"`
+	RestoreTableMapping         map[string]string `yaml:"restore_table_mapping" envconfig:"RESTORE_TABLE_MAPPING"`
+	RestoreZookeeperPathMapping map[string]string `yaml:"restore_zookeeper_path_mapping" envconfig:"RESTORE_ZOOKEEPER_PATH_MAPPING"`
+	RestoreReplicatedConversion string            `yaml:"restore_replicated_conversion" envconfig:"RESTORE_REPLICATED_CONVERSION"`
+	RestoreMasking              map[string]string `yaml:"restore_masking" envconfig:"RESTORE_MASKING"`
+	RestoreValidation           string            `yaml:"restore_validation" envconfig:"RESTORE_VALIDATION"`
+	RetriesOnFailure            int               `yaml:"retries_on_failure" envconfig:"RETRIES_ON_FAILURE"`
+	RetriesPause                string            `yaml:"retries_pause" envconfig:"RETRIES_PAUSE"`
+	WatchInterval               string            `yaml:"watch_interval" envconfig:"WATCH_INTERVAL"`
+	FullInterval                string            `yaml:"full_interval" envconfig:"FULL_INTERVAL"`
+	WatchBackupNameTemplate     string            `yaml:"watch_backup_name_template" envconfig:"WATCH_BACKUP_NAME_TEMPLATE"`
+	WatchFullSchedule           string            `yaml:"watch_full_schedule" envconfig:"WATCH_FULL_SCHEDULE"`
+	WatchIncrementSchedule      string            `yaml:"watch_increment_schedule" envconfig:"WATCH_INCREMENT_SCHEDULE"`
+	WatchBlackoutWindows        []string          `yaml:"watch_blackout_windows" envconfig:"WATCH_BLACKOUT_WINDOWS"`
+	ShardedOperationMode        string            `yaml:"sharded_operation_mode" envconfig:"SHARDED_OPERATION_MODE"`
+	CPUNicePriority             int               `yaml:"cpu_nice_priority" envconfig:"CPU_NICE_PRIORITY"`
+	IONicePriority              string            `yaml:"io_nice_priority" envconfig:"IO_NICE_PRIORITY"`
+	DataChecksums               bool              `yaml:"data_checksums" envconfig:"DATA_CHECKSUMS"`
+	RetriesDuration             time.Duration
+	WatchDuration               time.Duration
+	FullDuration                time.Duration
 }
 
 // GCSConfig - GCS settings section
@@ -180,6 +200,14 @@ type SFTPConfig struct {
 	Debug             bool   `yaml:"debug" envconfig:"SFTP_DEBUG"`
 }
 
+// FSConfig - local filesystem settings section, path could be a mounted NFS / CIFS share
+type FSConfig struct {
+	Path              string `yaml:"path" envconfig:"FS_PATH"`
+	CompressionFormat string `yaml:"compression_format" envconfig:"FS_COMPRESSION_FORMAT"`
+	CompressionLevel  int    `yaml:"compression_level" envconfig:"FS_COMPRESSION_LEVEL"`
+	Debug             bool   `yaml:"debug" envconfig:"FS_DEBUG"`
+}
+
 // CustomConfig - custom CLI storage settings section
 type CustomConfig struct {
 	UploadCommand          string `yaml:"upload_command" envconfig:"CUSTOM_UPLOAD_COMMAND"`
@@ -190,6 +218,262 @@ type CustomConfig struct {
 	CommandTimeoutDuration time.Duration
 }
 
+// EncryptionConfig - client-side encryption settings section, applied for backup data for any remote_storage
+type EncryptionConfig struct {
+	Algorithm          string   `yaml:"algorithm" envconfig:"ENCRYPTION_ALGORITHM"`
+	KeyFile            string   `yaml:"key_file" envconfig:"ENCRYPTION_KEY_FILE"`
+	Passphrase         string   `yaml:"passphrase" envconfig:"ENCRYPTION_PASSPHRASE"`
+	DecryptionKeyFiles []string `yaml:"decryption_key_files" envconfig:"ENCRYPTION_DECRYPTION_KEY_FILES"`
+}
+
+// RetentionConfig - grandfather-father-son retention policy section, when policy is enabled, it used instead of backups_to_keep_local / backups_to_keep_remote
+// environment variables names are RETENTION_LOCAL_KEEP_DAILY, RETENTION_REMOTE_KEEP_WEEKLY, etc.
+type RetentionConfig struct {
+	Local  RetentionPolicy `yaml:"local" envconfig:"LOCAL"`
+	Remote RetentionPolicy `yaml:"remote" envconfig:"REMOTE"`
+}
+
+// RetentionPolicy - how many backups to keep, keep_last latest backups and the newest backup for each of latest keep_hourly hours, keep_daily days, etc.
+type RetentionPolicy struct {
+	KeepLast    int `yaml:"keep_last" split_words:"true"`
+	KeepHourly  int `yaml:"keep_hourly" split_words:"true"`
+	KeepDaily   int `yaml:"keep_daily" split_words:"true"`
+	KeepWeekly  int `yaml:"keep_weekly" split_words:"true"`
+	KeepMonthly int `yaml:"keep_monthly" split_words:"true"`
+	KeepYearly  int `yaml:"keep_yearly" split_words:"true"`
+}
+
+// Enabled - policy replace backups_to_keep_* when any keep_* is defined
+func (p RetentionPolicy) Enabled() bool {
+	return p.KeepLast > 0 || p.KeepHourly > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0 || p.KeepYearly > 0
+}
+
+// WatchJobConfig - named watch job which runs inside `server` command, empty fields are inherited from general and retention sections,
+// backups_to_keep_* are pointers, so explicit 0 keeps all backups of job
+type WatchJobConfig struct {
+	Name                    string          `yaml:"name"`
+	Config                  string          `yaml:"config"`
+	RemoteStorage           string          `yaml:"remote_storage"`
+	Tables                  string          `yaml:"tables"`
+	Partitions              []string        `yaml:"partitions"`
+	Schema                  bool            `yaml:"schema"`
+	RBAC                    bool            `yaml:"rbac"`
+	Configs                 bool            `yaml:"configs"`
+	SkipCheckPartsColumns   bool            `yaml:"skip_check_parts_columns"`
+	WatchInterval           string          `yaml:"watch_interval"`
+	FullInterval            string          `yaml:"full_interval"`
+	WatchFullSchedule       string          `yaml:"watch_full_schedule"`
+	WatchIncrementSchedule  string          `yaml:"watch_increment_schedule"`
+	WatchBlackoutWindows    []string        `yaml:"watch_blackout_windows"`
+	WatchBackupNameTemplate string          `yaml:"watch_backup_name_template"`
+	BackupsToKeepLocal      *int            `yaml:"backups_to_keep_local"`
+	BackupsToKeepRemote     *int            `yaml:"backups_to_keep_remote"`
+	Retention               RetentionConfig `yaml:"retention"`
+}
+
+var watchJobNameRE = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// NotificationsConfig - webhooks are called after create, upload, download, restore, create_remote and restore_remote finish and after retention deletes backup,
+// from CLI, API and watch, operations inside create_remote, restore_remote and watch don't send own notifications
+type NotificationsConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// WebhookConfig - POST rendered template to url, the delay between retries is doubled after each failed attempt
+type WebhookConfig struct {
+	Name                 string             `yaml:"name"`
+	URL                  string             `yaml:"url"`
+	Events               []string           `yaml:"events"`
+	Headers              map[string]string  `yaml:"headers"`
+	Template             string             `yaml:"template"`
+	Secret               string             `yaml:"secret"`
+	Timeout              string             `yaml:"timeout"`
+	Retries              int                `yaml:"retries"`
+	RetriesPause         string             `yaml:"retries_pause"`
+	BodyTemplate         *template.Template `yaml:"-"`
+	TimeoutDuration      time.Duration
+	RetriesPauseDuration time.Duration
+}
+
+// restore_replicated_conversion values
+const (
+	ReplicatedConversionToMergeTree  = "to_merge_tree"
+	ReplicatedConversionToReplicated = "to_replicated"
+)
+
+const (
+	NotificationEventSuccess   = "success"
+	NotificationEventFailure   = "failure"
+	NotificationEventRetention = "retention"
+)
+
+// NotificationTemplateFuncs - `json` quotes value, so error messages and backup names can't break JSON body
+var NotificationTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		body, err := json.Marshal(v)
+		return string(body), err
+	},
+}
+
+// IsEnabledFor - empty events means all events
+func (webhook *WebhookConfig) IsEnabledFor(event string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// GetWatchJob - job config is built from `config` file of the job when defined, otherwise from current config
+func (cfg *Config) GetWatchJob(name string) (*Config, *WatchJobConfig, error) {
+	for i := range cfg.WatchJobs {
+		if cfg.WatchJobs[i].Name != name {
+			continue
+		}
+		job := cfg.WatchJobs[i]
+		jobCfg := *cfg
+		if job.Config != "" {
+			baseCfg, err := LoadConfig(job.Config)
+			if err != nil {
+				return nil, nil, fmt.Errorf("watch_jobs->%s: %v", name, err)
+			}
+			jobCfg = *baseCfg
+		}
+		jobCfg.WatchJobs = nil
+		job.apply(&jobCfg)
+		if err := ValidateConfig(&jobCfg); err != nil {
+			return nil, nil, fmt.Errorf("watch_jobs->%s: %v", name, err)
+		}
+		return &jobCfg, &job, nil
+	}
+	return nil, nil, fmt.Errorf("watch_jobs->%s not found", name)
+}
+
+// apply - `{job}` in backup name template is replaced by job name, template without `{job}` would mix up backups of different jobs
+func (job *WatchJobConfig) apply(cfg *Config) {
+	if job.RemoteStorage != "" {
+		cfg.General.RemoteStorage = job.RemoteStorage
+	}
+	if job.WatchInterval != "" {
+		cfg.General.WatchInterval = job.WatchInterval
+	}
+	if job.FullInterval != "" {
+		cfg.General.FullInterval = job.FullInterval
+	}
+	if job.WatchFullSchedule != "" || job.WatchIncrementSchedule != "" {
+		cfg.General.WatchFullSchedule = job.WatchFullSchedule
+		cfg.General.WatchIncrementSchedule = job.WatchIncrementSchedule
+	}
+	if len(job.WatchBlackoutWindows) > 0 {
+		cfg.General.WatchBlackoutWindows = job.WatchBlackoutWindows
+	}
+	backupNameTemplate := job.WatchBackupNameTemplate
+	if backupNameTemplate == "" {
+		backupNameTemplate = "{job}-" + cfg.General.WatchBackupNameTemplate
+	}
+	cfg.General.WatchBackupNameTemplate = strings.ReplaceAll(backupNameTemplate, "{job}", job.Name)
+	if job.BackupsToKeepLocal != nil {
+		cfg.General.BackupsToKeepLocal = *job.BackupsToKeepLocal
+	}
+	if job.BackupsToKeepRemote != nil {
+		cfg.General.BackupsToKeepRemote = *job.BackupsToKeepRemote
+	}
+	if job.Retention.Local.Enabled() {
+		cfg.Retention.Local = job.Retention.Local
+	}
+	if job.Retention.Remote.Enabled() {
+		cfg.Retention.Remote = job.Retention.Remote
+	}
+}
+
+func validateNotifications(cfg *Config) error {
+	for i := range cfg.Notifications.Webhooks {
+		webhook := &cfg.Notifications.Webhooks[i]
+		if webhook.Name == "" {
+			webhook.Name = fmt.Sprintf("webhook_%d", i)
+		}
+		if u, err := url.Parse(webhook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid notifications->webhooks->%s->url `%s`, expected http or https URL", webhook.Name, webhook.URL)
+		}
+		for _, event := range webhook.Events {
+			if event != NotificationEventSuccess && event != NotificationEventFailure && event != NotificationEventRetention {
+				return fmt.Errorf("invalid notifications->webhooks->%s->events `%s`, expected %s, %s or %s", webhook.Name, event, NotificationEventSuccess, NotificationEventFailure, NotificationEventRetention)
+			}
+		}
+		if webhook.Template != "" {
+			bodyTemplate, err := template.New(webhook.Name).Funcs(NotificationTemplateFuncs).Parse(webhook.Template)
+			if err != nil {
+				return fmt.Errorf("invalid notifications->webhooks->%s->template: %v", webhook.Name, err)
+			}
+			webhook.BodyTemplate = bodyTemplate
+		}
+		if webhook.Retries < 0 {
+			return fmt.Errorf("notifications->webhooks->%s->retries shall not be negative", webhook.Name)
+		}
+		webhook.TimeoutDuration = 10 * time.Second
+		if webhook.Timeout != "" {
+			duration, err := time.ParseDuration(webhook.Timeout)
+			if err != nil {
+				return fmt.Errorf("invalid notifications->webhooks->%s->timeout: %v", webhook.Name, err)
+			}
+			webhook.TimeoutDuration = duration
+		}
+		webhook.RetriesPauseDuration = time.Second
+		if webhook.RetriesPause != "" {
+			duration, err := time.ParseDuration(webhook.RetriesPause)
+			if err != nil {
+				return fmt.Errorf("invalid notifications->webhooks->%s->retries_pause: %v", webhook.Name, err)
+			}
+			webhook.RetriesPauseDuration = duration
+		}
+	}
+	return nil
+}
+
+func validateWatchJobs(cfg *Config) error {
+	backupNameTemplates := map[string]string{}
+	names := map[string]struct{}{}
+	for _, job := range cfg.WatchJobs {
+		if !watchJobNameRE.MatchString(job.Name) {
+			return fmt.Errorf("invalid watch_jobs->name '%s', only letters, digits, '_' and '-' allowed", job.Name)
+		}
+		if _, exists := names[job.Name]; exists {
+			return fmt.Errorf("watch_jobs->%s defined twice", job.Name)
+		}
+		names[job.Name] = struct{}{}
+		jobCfg := *cfg
+		job.apply(&jobCfg)
+		if otherJob, exists := backupNameTemplates[jobCfg.General.WatchBackupNameTemplate]; exists {
+			return fmt.Errorf("watch_jobs->%s and watch_jobs->%s have the same watch_backup_name_template, use {job} in template", otherJob, job.Name)
+		}
+		backupNameTemplates[jobCfg.General.WatchBackupNameTemplate] = job.Name
+		for _, interval := range []string{job.WatchInterval, job.FullInterval} {
+			if interval == "" {
+				continue
+			}
+			if _, err := time.ParseDuration(interval); err != nil {
+				return fmt.Errorf("invalid watch_jobs->%s interval: %v", job.Name, err)
+			}
+		}
+		for _, watchSchedule := range []string{job.WatchFullSchedule, job.WatchIncrementSchedule} {
+			if watchSchedule == "" {
+				continue
+			}
+			if _, err := schedule.ParseCron(watchSchedule); err != nil {
+				return fmt.Errorf("invalid watch_jobs->%s schedule: %v", job.Name, err)
+			}
+		}
+		if _, err := schedule.ParseWindows(job.WatchBlackoutWindows); err != nil {
+			return fmt.Errorf("invalid watch_jobs->%s watch_blackout_windows: %v", job.Name, err)
+		}
+	}
+	return nil
+}
+
 // ClickHouseConfig - clickhouse settings section
 type ClickHouseConfig struct {
 	Username                         string            `yaml:"username" envconfig:"CLICKHOUSE_USERNAME"`
@@ -235,7 +519,17 @@ type APIConfig struct {
 	CreateIntegrationTables       bool   `yaml:"create_integration_tables" envconfig:"API_CREATE_INTEGRATION_TABLES"`
 	IntegrationTablesHost         string `yaml:"integration_tables_host" envconfig:"API_INTEGRATION_TABLES_HOST"`
 	AllowParallel                 bool   `yaml:"allow_parallel" envconfig:"API_ALLOW_PARALLEL"`
+	QueueOperations               bool   `yaml:"queue_operations" envconfig:"API_QUEUE_OPERATIONS"`
 	CompleteResumableAfterRestart bool   `yaml:"complete_resumable_after_restart" envconfig:"API_COMPLETE_RESUMABLE_AFTER_RESTART"`
+	RestoreDrillInterval          string `yaml:"restore_drill_interval" envconfig:"API_RESTORE_DRILL_INTERVAL"`
+	RestoreDrillTables            string `yaml:"restore_drill_tables" envconfig:"API_RESTORE_DRILL_TABLES"`
+	RestoreDrillDatabasePrefix    string `yaml:"restore_drill_database_prefix" envconfig:"API_RESTORE_DRILL_DATABASE_PREFIX"`
+	RestoreDrillDuration          time.Duration
+	ActionsJournal                string `yaml:"actions_journal" envconfig:"API_ACTIONS_JOURNAL"`
+	ActionsJournalMaxSize         int64  `yaml:"actions_journal_max_size" envconfig:"API_ACTIONS_JOURNAL_MAX_SIZE"`
+	ActionsJournalMaxAge          string `yaml:"actions_journal_max_age" envconfig:"API_ACTIONS_JOURNAL_MAX_AGE"`
+	ActionsLogMaxLines            int    `yaml:"actions_log_max_lines" envconfig:"API_ACTIONS_LOG_MAX_LINES"`
+	ActionsJournalMaxAgeDuration  time.Duration
 }
 
 // ArchiveExtensions - list of available compression formats and associated file extensions
@@ -263,6 +557,8 @@ func (cfg *Config) GetArchiveExtension() string {
 		return ArchiveExtensions[cfg.FTP.CompressionFormat]
 	case "sftp":
 		return ArchiveExtensions[cfg.SFTP.CompressionFormat]
+	case "fs":
+		return ArchiveExtensions[cfg.FS.CompressionFormat]
 	case "azblob":
 		return ArchiveExtensions[cfg.AzureBlob.CompressionFormat]
 	default:
@@ -282,6 +578,8 @@ func (cfg *Config) GetCompressionFormat() string {
 		return cfg.FTP.CompressionFormat
 	case "sftp":
 		return cfg.SFTP.CompressionFormat
+	case "fs":
+		return cfg.FS.CompressionFormat
 	case "azblob":
 		return cfg.AzureBlob.CompressionFormat
 	case "none", "custom":
@@ -356,6 +654,39 @@ func ValidateConfig(cfg *Config) error {
 	if cfg.ClickHouse.FreezeByPart && cfg.ClickHouse.UseEmbeddedBackupRestore {
 		return fmt.Errorf("`freeze_by_part: %v` is not compatible with `use_embedded_backup_restore: %v`", cfg.ClickHouse.FreezeByPart, cfg.ClickHouse.UseEmbeddedBackupRestore)
 	}
+	if cfg.General.RemoteStorage == "fs" && cfg.FS.Path == "" {
+		return fmt.Errorf("fs->path shall not be empty for `remote_storage: fs`")
+	}
+	for location, policy := range map[string]RetentionPolicy{"local": cfg.Retention.Local, "remote": cfg.Retention.Remote} {
+		if policy.KeepLast < 0 || policy.KeepHourly < 0 || policy.KeepDaily < 0 || policy.KeepWeekly < 0 || policy.KeepMonthly < 0 || policy.KeepYearly < 0 {
+			return fmt.Errorf("retention->%s: keep_* values shall not be negative", location)
+		}
+	}
+	if cfg.General.RestoreReplicatedConversion != "" && cfg.General.RestoreReplicatedConversion != ReplicatedConversionToMergeTree && cfg.General.RestoreReplicatedConversion != ReplicatedConversionToReplicated {
+		return fmt.Errorf("'%s' is unsupported general->restore_replicated_conversion, only %s or %s allowed", cfg.General.RestoreReplicatedConversion, ReplicatedConversionToMergeTree, ReplicatedConversionToReplicated)
+	}
+	if cfg.General.RestoreValidation != "none" && cfg.General.RestoreValidation != "warn" && cfg.General.RestoreValidation != "fail" {
+		return fmt.Errorf("'%s' is unsupported general->restore_validation, only none, warn or fail allowed", cfg.General.RestoreValidation)
+	}
+	for column, expression := range cfg.General.RestoreMasking {
+		if parts := strings.SplitN(column, ".", 3); len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" || expression == "" {
+			return fmt.Errorf("invalid general->restore_masking rule '%s: %s', expected 'db.table.column: expression'", column, expression)
+		}
+	}
+	if cfg.Encryption.Algorithm != "" && cfg.Encryption.Algorithm != "none" {
+		if cfg.Encryption.Algorithm != "aes-256-gcm" {
+			return fmt.Errorf("'%s' is unsupported encryption->algorithm, only aes-256-gcm allowed", cfg.Encryption.Algorithm)
+		}
+		if cfg.Encryption.KeyFile == "" && cfg.Encryption.Passphrase == "" {
+			return fmt.Errorf("encryption->algorithm: %s require encryption->key_file or encryption->passphrase", cfg.Encryption.Algorithm)
+		}
+		if cfg.Encryption.KeyFile != "" && cfg.Encryption.Passphrase != "" {
+			return fmt.Errorf("encryption->key_file and encryption->passphrase can't be defined both")
+		}
+		if cfg.ClickHouse.UseEmbeddedBackupRestore {
+			return fmt.Errorf("encryption->algorithm: %s is not compatible with `use_embedded_backup_restore: true`", cfg.Encryption.Algorithm)
+		}
+	}
 	if _, err := time.ParseDuration(cfg.COS.Timeout); err != nil {
 		return fmt.Errorf("invalid cos timeout: %v", err)
 	}
@@ -434,6 +765,46 @@ func ValidateConfig(cfg *Config) error {
 			cfg.General.FullDuration = duration
 		}
 	}
+	for _, watchSchedule := range []string{cfg.General.WatchFullSchedule, cfg.General.WatchIncrementSchedule} {
+		if watchSchedule == "" {
+			continue
+		}
+		if _, err := schedule.ParseCron(watchSchedule); err != nil {
+			return fmt.Errorf("invalid watch schedule: %v", err)
+		}
+	}
+	if _, err := schedule.ParseWindows(cfg.General.WatchBlackoutWindows); err != nil {
+		return fmt.Errorf("invalid watch_blackout_windows: %v", err)
+	}
+	if err := validateWatchJobs(cfg); err != nil {
+		return err
+	}
+	if err := validateNotifications(cfg); err != nil {
+		return err
+	}
+	if cfg.API.RestoreDrillInterval != "" {
+		if duration, err := time.ParseDuration(cfg.API.RestoreDrillInterval); err != nil {
+			return fmt.Errorf("invalid api->restore_drill_interval: %v", err)
+		} else {
+			cfg.API.RestoreDrillDuration = duration
+		}
+		if cfg.API.RestoreDrillDatabasePrefix == "" {
+			return fmt.Errorf("api->restore_drill_database_prefix shall not be empty, scratch databases can't have the same names as source databases")
+		}
+	}
+	if cfg.API.ActionsJournalMaxAge != "" {
+		if duration, err := time.ParseDuration(cfg.API.ActionsJournalMaxAge); err != nil {
+			return fmt.Errorf("invalid api->actions_journal_max_age: %v", err)
+		} else {
+			cfg.API.ActionsJournalMaxAgeDuration = duration
+		}
+	}
+	if cfg.API.ActionsJournalMaxSize < 0 {
+		return fmt.Errorf("api->actions_journal_max_size shall not be negative")
+	}
+	if cfg.API.ActionsLogMaxLines < 0 {
+		return fmt.Errorf("api->actions_log_max_lines shall not be negative")
+	}
 	return nil
 }
 
@@ -485,29 +856,36 @@ func DefaultConfig() *Config {
 	}
 	return &Config{
 		General: GeneralConfig{
-			RemoteStorage:           "none",
-			MaxFileSize:             0,
-			BackupsToKeepLocal:      0,
-			BackupsToKeepRemote:     0,
-			LogLevel:                "info",
-			DisableProgressBar:      true,
-			UploadConcurrency:       uploadConcurrency,
-			DownloadConcurrency:     downloadConcurrency,
-			RestoreSchemaOnCluster:  "",
-			UploadByPart:            true,
-			DownloadByPart:          true,
-			UseResumableState:       true,
-			RetriesOnFailure:        3,
-			RetriesPause:            "30s",
-			RetriesDuration:         100 * time.Millisecond,
-			WatchInterval:           "1h",
-			WatchDuration:           1 * time.Hour,
-			FullInterval:            "24h",
-			FullDuration:            24 * time.Hour,
-			WatchBackupNameTemplate: "shard{shard}-{type}-{time:20060102150405}",
-			RestoreDatabaseMapping:  make(map[string]string, 0),
-			IONicePriority:          "idle",
-			CPUNicePriority:         15,
+			RemoteStorage:               "none",
+			MaxFileSize:                 0,
+			BackupsToKeepLocal:          0,
+			BackupsToKeepRemote:         0,
+			LogLevel:                    "info",
+			DisableProgressBar:          true,
+			UploadConcurrency:           uploadConcurrency,
+			DownloadConcurrency:         downloadConcurrency,
+			RestoreConcurrency:          downloadConcurrency,
+			RestoreSchemaOnCluster:      "",
+			UploadByPart:                true,
+			DownloadByPart:              true,
+			UseResumableState:           true,
+			RetriesOnFailure:            3,
+			RetriesPause:                "30s",
+			RetriesDuration:             100 * time.Millisecond,
+			WatchInterval:               "1h",
+			WatchDuration:               1 * time.Hour,
+			FullInterval:                "24h",
+			FullDuration:                24 * time.Hour,
+			WatchBackupNameTemplate:     "shard{shard}-{type}-{time:20060102150405}",
+			WatchBlackoutWindows:        make([]string, 0),
+			RestoreDatabaseMapping:      make(map[string]string, 0),
+			RestoreTableMapping:         make(map[string]string, 0),
+			RestoreZookeeperPathMapping: make(map[string]string, 0),
+			RestoreMasking:              make(map[string]string, 0),
+			RestoreValidation:           "warn",
+			IONicePriority:              "idle",
+			CPUNicePriority:             15,
+			DataChecksums:               true,
 		},
 		ClickHouse: ClickHouseConfig{
 			Username: "default",
@@ -575,6 +953,11 @@ func DefaultConfig() *Config {
 			ListenAddr:                    "localhost:7171",
 			EnableMetrics:                 true,
 			CompleteResumableAfterRestart: true,
+			RestoreDrillTables:            "*.*",
+			RestoreDrillDatabasePrefix:    "restore_drill_",
+			ActionsJournalMaxSize:         10 * 1024 * 1024,
+			ActionsJournalMaxAge:          "720h",
+			ActionsLogMaxLines:            1000,
 		},
 		FTP: FTPConfig{
 			Timeout:           "2m",
@@ -588,6 +971,10 @@ func DefaultConfig() *Config {
 			CompressionLevel:  1,
 			Concurrency:       int(downloadConcurrency + 1),
 		},
+		FS: FSConfig{
+			CompressionFormat: "tar",
+			CompressionLevel:  1,
+		},
 		Custom: CustomConfig{
 			CommandTimeout:         "4h",
 			CommandTimeoutDuration: 4 * time.Hour,
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metrics"
 	"github.com/Altinity/clickhouse-backup/pkg/utils"
 	"github.com/apex/log"
 	"github.com/eapache/go-resiliency/retrier"
@@ -34,7 +35,7 @@ func Download(ctx context.Context, cfg *config.Config, backupName string, tableP
 		"schema":        schemaOnly,
 	}
 	args := ApplyCommandTemplate(cfg.Custom.DownloadCommand, templateData)
-	retry := retrier.New(retrier.ConstantBackoff(cfg.General.RetriesOnFailure, cfg.General.RetriesDuration), nil)
+	retry := metrics.NewRetrier("download", retrier.ConstantBackoff(cfg.General.RetriesOnFailure, cfg.General.RetriesDuration))
 	err := retry.RunCtx(ctx, func(ctx context.Context) error {
 		return utils.ExecCmd(ctx, cfg.Custom.CommandTimeoutDuration, args[0], args[1:]...)
 	})
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metrics"
 	"github.com/Altinity/clickhouse-backup/pkg/utils"
 	"github.com/apex/log"
 	"github.com/eapache/go-resiliency/retrier"
@@ -40,7 +41,7 @@ func Upload(ctx context.Context, cfg *config.Config, backupName, diffFrom, diffF
 		"schema":           schemaOnly,
 	}
 	args := ApplyCommandTemplate(cfg.Custom.UploadCommand, templateData)
-	retry := retrier.New(retrier.ConstantBackoff(cfg.General.RetriesOnFailure, cfg.General.RetriesDuration), nil)
+	retry := metrics.NewRetrier("upload", retrier.ConstantBackoff(cfg.General.RetriesOnFailure, cfg.General.RetriesDuration))
 	err := retry.RunCtx(ctx, func(ctx context.Context) error {
 		return utils.ExecCmd(ctx, cfg.Custom.CommandTimeoutDuration, args[0], args[1:]...)
 	})
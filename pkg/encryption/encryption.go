@@ -0,0 +1,399 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// AlgorithmAES256GCM - chunked AES-256-GCM stream, each chunk authenticated separately
+	AlgorithmAES256GCM = "aes-256-gcm"
+
+	keySize          = 32
+	keyIDSize        = 8
+	noncePrefixSize  = 7
+	saltSize         = 16
+	chunkSize        = 64 * 1024
+	chunkHeaderSize  = 5
+	gcmTagSize       = 16
+	passphraseRounds = 100000
+	// passphraseIDSalt - used only for key ID, encryption keys are derived with random salt stored in stream header
+	passphraseIDSalt = "clickhouse-backup key id"
+	streamVersion    = 2
+)
+
+// streamMagic - first bytes of each encrypted object, allow to distinguish encrypted and plain objects inside one backup chain, last byte is format version
+var streamMagic = []byte{'C', 'H', 'B', 'K', 'E', 'N', 'C', streamVersion}
+
+// headerSize - magic, key ID, salt and nonce prefix, whole header is authenticated as additional data of each chunk
+var headerSize = len(streamMagic) + keyIDSize + saltSize + noncePrefixSize
+
+var (
+	ErrKeyNotFound        = errors.New("encryption key not found")
+	ErrDecryptionFailed   = errors.New("can't decrypt data, wrong encryption key or corrupted data")
+	ErrTruncatedStream    = errors.New("unexpected end of encrypted stream, data truncated")
+	ErrEncryptionDisabled = errors.New("encryption is not configured")
+	ErrUnsupportedFormat  = errors.New("unsupported encrypted stream format version")
+	ErrNotEncrypted       = errors.New("data is not encrypted")
+)
+
+// Key - AES-256 key and its public identifier, ID is safe to store in backup metadata
+type Key struct {
+	ID         string
+	value      []byte
+	passphrase []byte
+	// derived - passphrase keys only, salt -> AES-256 key
+	derived sync.Map
+}
+
+func newKey(value []byte) *Key {
+	h := sha256.New()
+	h.Write([]byte("clickhouse-backup key id"))
+	h.Write(value)
+	return &Key{
+		ID:    hex.EncodeToString(h.Sum(nil)[:keyIDSize]),
+		value: value,
+	}
+}
+
+// newPassphraseKey - the same passphrase has the same ID on each installation, but encryption keys depend on random salt
+func newPassphraseKey(passphrase string) *Key {
+	key := newKey(pbkdf2.Key([]byte(passphrase), []byte(passphraseIDSalt), passphraseRounds, keySize, sha256.New))
+	key.value = nil
+	key.passphrase = []byte(passphrase)
+	return key
+}
+
+// valueForSalt - key files are used as is, passphrase is derived with PBKDF2 once per salt
+func (key *Key) valueForSalt(salt []byte) []byte {
+	if key.passphrase == nil {
+		return key.value
+	}
+	if value, ok := key.derived.Load(string(salt)); ok {
+		return value.([]byte)
+	}
+	value := pbkdf2.Key(key.passphrase, salt, passphraseRounds, keySize, sha256.New)
+	key.derived.Store(string(salt), value)
+	return value
+}
+
+// Keyring - current key for encryption and all known keys for decryption, nil Keyring means encryption disabled
+type Keyring struct {
+	algorithm string
+	current   *Key
+	keys      map[string]*Key
+	// salt - random for each Keyring, written to header of each encrypted object
+	salt []byte
+}
+
+// NewKeyring load keys from `encryption` config section, return nil when no keys configured
+func NewKeyring(cfg *config.EncryptionConfig) (*Keyring, error) {
+	k := &Keyring{
+		algorithm: cfg.Algorithm,
+		keys:      map[string]*Key{},
+		salt:      make([]byte, saltSize),
+	}
+	if _, err := rand.Read(k.salt); err != nil {
+		return nil, err
+	}
+	if cfg.Algorithm != "" && cfg.Algorithm != "none" {
+		if cfg.Algorithm != AlgorithmAES256GCM {
+			return nil, fmt.Errorf("'%s' is unsupported encryption algorithm, only '%s' allowed", cfg.Algorithm, AlgorithmAES256GCM)
+		}
+		var err error
+		if cfg.KeyFile != "" {
+			k.current, err = loadKeyFile(cfg.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+		} else if cfg.Passphrase != "" {
+			k.current = newPassphraseKey(cfg.Passphrase)
+		} else {
+			return nil, fmt.Errorf("encryption->algorithm: %s require encryption->key_file or encryption->passphrase", cfg.Algorithm)
+		}
+		k.keys[k.current.ID] = k.current
+	}
+	for _, keyFile := range cfg.DecryptionKeyFiles {
+		key, err := loadKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[key.ID] = key
+	}
+	if len(k.keys) == 0 {
+		return nil, nil
+	}
+	return k, nil
+}
+
+// loadKeyFile accept 32 raw bytes, 64 hex chars or base64 encoded 32 bytes
+func loadKeyFile(keyFile string) (*Key, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read encryption key file %s: %v", keyFile, err)
+	}
+	if len(content) == keySize {
+		return newKey(content), nil
+	}
+	trimmed := strings.TrimSpace(string(content))
+	if value, err := hex.DecodeString(trimmed); err == nil && len(value) == keySize {
+		return newKey(value), nil
+	}
+	if value, err := base64.StdEncoding.DecodeString(trimmed); err == nil && len(value) == keySize {
+		return newKey(value), nil
+	}
+	return nil, fmt.Errorf("encryption key file %s shall contain %d raw bytes, hex or base64 encoded %d bytes", keyFile, keySize, keySize)
+}
+
+// Algorithm - empty when new data will not be encrypted
+func (k *Keyring) Algorithm() string {
+	if k == nil || k.current == nil {
+		return ""
+	}
+	return k.algorithm
+}
+
+// KeyID - identifier of key which used for encryption, empty when encryption disabled
+func (k *Keyring) KeyID() string {
+	if k == nil || k.current == nil {
+		return ""
+	}
+	return k.current.ID
+}
+
+// HasKey - check key availability before download, to fail fast instead of fail in the middle of download
+func (k *Keyring) HasKey(keyID string) bool {
+	if k == nil {
+		return false
+	}
+	_, ok := k.keys[keyID]
+	return ok
+}
+
+// NewEncryptReader wrap plain source into encrypted stream, return source as is when encryption disabled
+func (k *Keyring) NewEncryptReader(source io.ReadCloser) (io.ReadCloser, error) {
+	if k == nil || k.current == nil {
+		return source, nil
+	}
+	aead, err := newAEAD(k.current.valueForSalt(k.salt))
+	if err != nil {
+		return nil, err
+	}
+	keyID, _ := hex.DecodeString(k.current.ID)
+	header := make([]byte, 0, headerSize)
+	header = append(header, streamMagic...)
+	header = append(header, keyID...)
+	header = append(header, k.salt...)
+	noncePrefix := make([]byte, noncePrefixSize)
+	if _, err = rand.Read(noncePrefix); err != nil {
+		return nil, err
+	}
+	header = append(header, noncePrefix...)
+	return &encryptReader{
+		source: source,
+		aead:   aead,
+		header: header,
+		plain:  make([]byte, chunkSize),
+		out:    bytes.NewBuffer(append([]byte{}, header...)),
+	}, nil
+}
+
+// NewDecryptReader detect encrypted stream by header and decrypt it, plain streams pass as is,
+// when isEncryptionRequired, plain streams are rejected, so plain data can't replace data of encrypted backup
+func (k *Keyring) NewDecryptReader(source io.ReadCloser, isEncryptionRequired bool) (io.ReadCloser, error) {
+	buffered := bufio.NewReaderSize(source, chunkSize)
+	magic, err := buffered.Peek(len(streamMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == len(streamMagic) && bytes.Equal(magic[:len(streamMagic)-1], streamMagic[:len(streamMagic)-1]) && magic[len(streamMagic)-1] != streamVersion {
+		return nil, fmt.Errorf("%w: %d, expected %d", ErrUnsupportedFormat, magic[len(streamMagic)-1], streamVersion)
+	}
+	if !bytes.Equal(magic, streamMagic) {
+		if isEncryptionRequired {
+			return nil, fmt.Errorf("%w: backup metadata contains encryption key id, but data has no encryption header, data could be replaced on remote storage", ErrNotEncrypted)
+		}
+		return &readCloser{Reader: buffered, closer: source}, nil
+	}
+	header := make([]byte, headerSize)
+	if _, err = io.ReadFull(buffered, header); err != nil {
+		return nil, ErrTruncatedStream
+	}
+	keyID := hex.EncodeToString(header[len(streamMagic) : len(streamMagic)+keyIDSize])
+	if k == nil {
+		return nil, fmt.Errorf("%w: data encrypted with key id=%s, but `encryption` config section is empty", ErrEncryptionDisabled, keyID)
+	}
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: data encrypted with key id=%s, add it to encryption->key_file, encryption->passphrase or encryption->decryption_key_files", ErrKeyNotFound, keyID)
+	}
+	salt := header[len(streamMagic)+keyIDSize : len(streamMagic)+keyIDSize+saltSize]
+	aead, err := newAEAD(key.valueForSalt(salt))
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		source: buffered,
+		closer: source,
+		aead:   aead,
+		header: header,
+	}, nil
+}
+
+// EncryptedSize - size of encrypted object, allow to check encrypted files without download
+func EncryptedSize(plainSize int64) int64 {
+	// last chunk always exists and could be empty
+	chunks := plainSize/chunkSize + 1
+	return int64(headerSize) + plainSize + chunks*(chunkHeaderSize+gcmTagSize)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce - noncePrefix + chunk counter + last chunk flag, last flag protect stream from truncation
+func chunkNonce(header []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, header[len(header)-noncePrefixSize:]...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type readCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *readCloser) Close() error {
+	return r.closer.Close()
+}
+
+// encryptReader produce header and sequence of chunks: 1 byte last flag, 4 bytes ciphertext length, ciphertext
+type encryptReader struct {
+	source  io.ReadCloser
+	aead    cipher.AEAD
+	header  []byte
+	plain   []byte
+	out     *bytes.Buffer
+	counter uint32
+	done    bool
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	return r.out.Read(p)
+}
+
+func (r *encryptReader) nextChunk() error {
+	n, err := io.ReadFull(r.source, r.plain)
+	last := false
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		last = true
+	} else if err != nil {
+		return err
+	}
+	sealed := r.aead.Seal(nil, chunkNonce(r.header, r.counter, last), r.plain[:n], r.header)
+	chunkHeader := make([]byte, chunkHeaderSize)
+	if last {
+		chunkHeader[0] = 1
+	}
+	binary.BigEndian.PutUint32(chunkHeader[1:], uint32(len(sealed)))
+	r.out.Reset()
+	r.out.Write(chunkHeader)
+	r.out.Write(sealed)
+	r.counter++
+	r.done = last
+	return nil
+}
+
+func (r *encryptReader) Close() error {
+	return r.source.Close()
+}
+
+type decryptReader struct {
+	source  io.Reader
+	closer  io.Closer
+	aead    cipher.AEAD
+	header  []byte
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *decryptReader) nextChunk() error {
+	chunkHeader := make([]byte, chunkHeaderSize)
+	if _, err := io.ReadFull(r.source, chunkHeader); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncatedStream
+		}
+		return err
+	}
+	last := chunkHeader[0] == 1
+	sealedSize := binary.BigEndian.Uint32(chunkHeader[1:])
+	if sealedSize > chunkSize+uint32(r.aead.Overhead()) {
+		return ErrDecryptionFailed
+	}
+	sealed := make([]byte, sealedSize)
+	if _, err := io.ReadFull(r.source, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncatedStream
+		}
+		return err
+	}
+	plain, err := r.aead.Open(sealed[:0], chunkNonce(r.header, r.counter, last), sealed, r.header)
+	if err != nil {
+		return ErrDecryptionFailed
+	}
+	r.plain = plain
+	r.counter++
+	r.done = last
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.closer.Close()
+}
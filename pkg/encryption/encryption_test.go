@@ -0,0 +1,155 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encrypt(t *testing.T, k *Keyring, plain []byte) []byte {
+	r, err := k.NewEncryptReader(io.NopCloser(bytes.NewReader(plain)))
+	require.NoError(t, err)
+	encrypted, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	return encrypted
+}
+
+func decrypt(k *Keyring, encrypted []byte) ([]byte, error) {
+	return decryptRequired(k, encrypted, false)
+}
+
+func decryptRequired(k *Keyring, encrypted []byte, isEncryptionRequired bool) ([]byte, error) {
+	r, err := k.NewDecryptReader(io.NopCloser(bytes.NewReader(encrypted)), isEncryptionRequired)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k, err := NewKeyring(&config.EncryptionConfig{Algorithm: AlgorithmAES256GCM, Passphrase: "secret"})
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmAES256GCM, k.Algorithm())
+	assert.Len(t, k.KeyID(), keyIDSize*2)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+		encrypted := encrypt(t, k, plain)
+		assert.Equal(t, EncryptedSize(int64(size)), int64(len(encrypted)), "size=%d", size)
+		decrypted, err := decrypt(k, encrypted)
+		require.NoError(t, err, "size=%d", size)
+		assert.Equal(t, plain, decrypted, "size=%d", size)
+	}
+	phrase := []byte("SELECT * FROM default.customers")
+	for _, size := range []int{len(phrase), chunkSize + 1, 3*chunkSize + 17} {
+		plain := bytes.Repeat(phrase, size/len(phrase)+1)[:size]
+		assert.False(t, bytes.Contains(encrypt(t, k, plain), phrase), "size=%d", size)
+	}
+}
+
+func TestPassphraseSalt(t *testing.T) {
+	cfg := &config.EncryptionConfig{Algorithm: AlgorithmAES256GCM, Passphrase: "secret"}
+	first, err := NewKeyring(cfg)
+	require.NoError(t, err)
+	second, err := NewKeyring(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, first.KeyID(), second.KeyID(), "the same passphrase shall have the same key ID")
+	assert.NotEqual(t, first.salt, second.salt)
+
+	plain := bytes.Repeat([]byte("clickhouse"), chunkSize/10)
+	encrypted := encrypt(t, first, plain)
+	assert.NotEqual(t, encrypted[len(streamMagic)+keyIDSize:], encrypt(t, second, plain)[len(streamMagic)+keyIDSize:])
+	decrypted, err := decrypt(second, encrypted)
+	require.NoError(t, err, "key shall be derived from salt in header")
+	assert.Equal(t, plain, decrypted)
+
+	unsupported := append([]byte{}, encrypted...)
+	unsupported[len(streamMagic)-1] = 1
+	_, err = decrypt(first, unsupported)
+	assert.True(t, errors.Is(err, ErrUnsupportedFormat))
+}
+
+func TestDecryptErrors(t *testing.T) {
+	k, err := NewKeyring(&config.EncryptionConfig{Algorithm: AlgorithmAES256GCM, Passphrase: "secret"})
+	require.NoError(t, err)
+	plain := bytes.Repeat([]byte("clickhouse"), chunkSize)
+	encrypted := encrypt(t, k, plain)
+
+	otherKey, err := NewKeyring(&config.EncryptionConfig{Algorithm: AlgorithmAES256GCM, Passphrase: "other"})
+	require.NoError(t, err)
+	_, err = decrypt(otherKey, encrypted)
+	assert.True(t, errors.Is(err, ErrKeyNotFound))
+
+	var disabled *Keyring
+	_, err = decrypt(disabled, encrypted)
+	assert.True(t, errors.Is(err, ErrEncryptionDisabled))
+
+	_, err = decrypt(k, encrypted[:len(encrypted)-chunkSize])
+	assert.True(t, errors.Is(err, ErrTruncatedStream))
+
+	corrupted := append([]byte{}, encrypted...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	_, err = decrypt(k, corrupted)
+	assert.True(t, errors.Is(err, ErrDecryptionFailed))
+}
+
+func TestPlainPassThrough(t *testing.T) {
+	plain := []byte("plain data uploaded without encryption")
+	var disabled *Keyring
+	assert.Equal(t, plain, encrypt(t, disabled, plain))
+	decrypted, err := decrypt(disabled, plain)
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+}
+
+func TestPlainRejectedWhenEncryptionRequired(t *testing.T) {
+	plain := []byte("plain data which replaced encrypted archive")
+	k, err := NewKeyring(&config.EncryptionConfig{Algorithm: AlgorithmAES256GCM, Passphrase: "secret"})
+	require.NoError(t, err)
+	for _, keyring := range []*Keyring{k, nil} {
+		_, err = decryptRequired(keyring, plain, true)
+		assert.ErrorIs(t, err, ErrNotEncrypted)
+		_, err = decryptRequired(keyring, []byte{}, true)
+		assert.ErrorIs(t, err, ErrNotEncrypted)
+	}
+	decrypted, err := decryptRequired(k, encrypt(t, k, plain), true)
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKeyFile := path.Join(dir, "old.key")
+	require.NoError(t, os.WriteFile(oldKeyFile, []byte("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef\n"), 0600))
+	oldKey, err := NewKeyring(&config.EncryptionConfig{Algorithm: AlgorithmAES256GCM, KeyFile: oldKeyFile})
+	require.NoError(t, err)
+	plain := []byte("encrypted with old key")
+	encrypted := encrypt(t, oldKey, plain)
+
+	newKeyFile := path.Join(dir, "new.key")
+	newKeyValue := make([]byte, keySize)
+	_, _ = rand.Read(newKeyValue)
+	require.NoError(t, os.WriteFile(newKeyFile, newKeyValue, 0600))
+	rotated, err := NewKeyring(&config.EncryptionConfig{Algorithm: AlgorithmAES256GCM, KeyFile: newKeyFile, DecryptionKeyFiles: []string{oldKeyFile}})
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKey.KeyID(), rotated.KeyID())
+	assert.True(t, rotated.HasKey(oldKey.KeyID()))
+	decrypted, err := decrypt(rotated, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+
+	badKeyFile := path.Join(dir, "bad.key")
+	require.NoError(t, os.WriteFile(badKeyFile, []byte("short"), 0600))
+	_, err = NewKeyring(&config.EncryptionConfig{Algorithm: AlgorithmAES256GCM, KeyFile: badKeyFile})
+	assert.Error(t, err)
+}
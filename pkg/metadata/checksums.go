@@ -0,0 +1,93 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ChecksumsFileExtension - manifest stored next to `<table>.json`, use not `.json` extension cause all `.json` files inside `metadata` directory are table metadata
+const ChecksumsFileExtension = "sha256"
+
+// TableChecksums - SHA-256 for each backup data file, key is `<disk>/<part>/<file>` relative to `shadow/<db>/<table>`
+type TableChecksums map[string]string
+
+// CalculateFileChecksum return hex encoded SHA-256 of file content
+func CalculateFileChecksum(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SplitChecksumKey return disk and part name from TableChecksums key
+func SplitChecksumKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// Marshal use the same format as `sha256sum` output, sorted by file name
+func (tc TableChecksums) Marshal() []byte {
+	keys := make([]string, 0, len(tc))
+	for key := range tc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, key := range keys {
+		buf.WriteString(tc[key])
+		buf.WriteString("  ")
+		buf.WriteString(key)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+func (tc TableChecksums) Unmarshal(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		if scanner.Text() == "" {
+			continue
+		}
+		checksum, key, found := strings.Cut(scanner.Text(), "  ")
+		if !found || len(checksum) != sha256.Size*2 || key == "" {
+			return fmt.Errorf("wrong checksum format in line %d: %s", line, scanner.Text())
+		}
+		tc[key] = checksum
+	}
+	return scanner.Err()
+}
+
+func (tc TableChecksums) Save(location string) (uint64, error) {
+	if err := os.MkdirAll(path.Dir(location), 0750); err != nil {
+		return 0, err
+	}
+	body := tc.Marshal()
+	return uint64(len(body)), os.WriteFile(location, body, 0640)
+}
+
+func (tc TableChecksums) Load(location string) (uint64, error) {
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(data)), tc.Unmarshal(data)
+}
@@ -0,0 +1,39 @@
+package metadata
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableChecksums(t *testing.T) {
+	dir := t.TempDir()
+	dataFile := path.Join(dir, "data.bin")
+	require.NoError(t, os.WriteFile(dataFile, []byte("clickhouse"), 0640))
+	checksum, err := CalculateFileChecksum(dataFile)
+	require.NoError(t, err)
+	// echo -n clickhouse | sha256sum
+	assert.Equal(t, "7e099f39b84ea79559b3e85ea046804e63725fd1f46b37f281276aae20f86dc3", checksum)
+
+	tc := TableChecksums{
+		"default/all_1_1_0/data.bin":         checksum,
+		"hdd/all_2_2_0/p1.proj/primary.cidx": checksum,
+		"default/all_1_1_0/columns.txt":      checksum,
+	}
+	location := path.Join(dir, "metadata", "db", "table.sha256")
+	_, err = tc.Save(location)
+	require.NoError(t, err)
+	loaded := TableChecksums{}
+	_, err = loaded.Load(location)
+	require.NoError(t, err)
+	assert.Equal(t, tc, loaded)
+
+	disk, part := SplitChecksumKey("hdd/all_2_2_0/p1.proj/primary.cidx")
+	assert.Equal(t, "hdd", disk)
+	assert.Equal(t, "all_2_2_0", part)
+
+	assert.Error(t, TableChecksums{}.Unmarshal([]byte("wrong default/all_1_1_0/data.bin\n")))
+}
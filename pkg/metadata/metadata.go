@@ -27,6 +27,8 @@ type BackupMetadata struct {
 	Functions               []FunctionsMeta   `json:"functions"`
 	DataFormat              string            `json:"data_format"`
 	RequiredBackup          string            `json:"required_backup,omitempty"`
+	EncryptionAlgorithm     string            `json:"encryption_algorithm,omitempty"`
+	EncryptionKeyID         string            `json:"encryption_key_id,omitempty"`
 }
 
 type DatabasesMeta struct {
@@ -41,17 +43,26 @@ type FunctionsMeta struct {
 }
 
 type TableMetadata struct {
-	Files                map[string][]string `json:"files,omitempty"`
-	Table                string              `json:"table"`
-	Database             string              `json:"database"`
-	Parts                map[string][]Part   `json:"parts"`
-	Query                string              `json:"query"`
-	Size                 map[string]int64    `json:"size"`                  // how much size on each disk
-	TotalBytes           uint64              `json:"total_bytes,omitempty"` // total table size
-	DependenciesTable    string              `json:"dependencies_table,omitempty"`
-	DependenciesDatabase string              `json:"dependencies_database,omitempty"`
-	Mutations            []MutationMetadata  `json:"mutations,omitempty"`
-	MetadataOnly         bool                `json:"metadata_only"`
+	Files                map[string][]string       `json:"files,omitempty"`
+	FilesSize            map[string]int64          `json:"files_size,omitempty"` // remote archive name -> size, allow verify backup without download
+	Table                string                    `json:"table"`
+	Database             string                    `json:"database"`
+	Parts                map[string][]Part         `json:"parts"`
+	Query                string                    `json:"query"`
+	Size                 map[string]int64          `json:"size"`                  // how much size on each disk
+	TotalBytes           uint64                    `json:"total_bytes,omitempty"` // total table size
+	DependenciesTable    string                    `json:"dependencies_table,omitempty"`
+	DependenciesDatabase string                    `json:"dependencies_database,omitempty"`
+	Mutations            []MutationMetadata        `json:"mutations,omitempty"`
+	MetadataOnly         bool                      `json:"metadata_only"`
+	TotalRows            uint64                    `json:"total_rows,omitempty"`
+	UncompressedBytes    uint64                    `json:"uncompressed_bytes,omitempty"`
+	Partitions           map[string]PartitionStats `json:"partitions,omitempty"` // partition_id -> rows and uncompressed size of backup parts, allow validate restore
+}
+
+type PartitionStats struct {
+	Rows              uint64 `json:"rows"`
+	UncompressedBytes uint64 `json:"uncompressed_bytes"`
 }
 
 type MutationMetadata struct {
@@ -76,4 +87,6 @@ type Part struct {
 type SplitPartFiles struct {
 	Prefix string
 	Files  []string
+	// Size - local files size, used for upload progress
+	Size int64
 }
@@ -19,9 +19,14 @@ func (tm *TableMetadata) Save(location string, metadataOnly bool) (uint64, error
 
 	if !metadataOnly {
 		newTM.Files = tm.Files
+		newTM.FilesSize = tm.FilesSize
 		newTM.Parts = tm.Parts
 		newTM.Size = tm.Size
 		newTM.TotalBytes = tm.TotalBytes
+		// restore_validation compares restored rows with them
+		newTM.TotalRows = tm.TotalRows
+		newTM.UncompressedBytes = tm.UncompressedBytes
+		newTM.Partitions = tm.Partitions
 		newTM.MetadataOnly = false
 	}
 	if err := os.MkdirAll(path.Dir(location), 0750); err != nil {
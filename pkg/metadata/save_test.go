@@ -0,0 +1,39 @@
+package metadata
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableMetadataSave(t *testing.T) {
+	tm := TableMetadata{
+		Database:          "db",
+		Table:             "events",
+		Query:             "CREATE TABLE db.events (id UInt64) ENGINE = MergeTree ORDER BY id",
+		Files:             map[string][]string{"default": {"default_1.tar"}},
+		FilesSize:         map[string]int64{"default_1.tar": 100},
+		Parts:             map[string][]Part{"default": {{Name: "all_1_1_0"}}},
+		Size:              map[string]int64{"default": 200},
+		TotalBytes:        200,
+		TotalRows:         10,
+		UncompressedBytes: 300,
+		Partitions:        map[string]PartitionStats{"all": {Rows: 10, UncompressedBytes: 300}},
+	}
+	location := path.Join(t.TempDir(), "db", "events.json")
+	_, err := tm.Save(location, false)
+	require.NoError(t, err)
+	loaded := TableMetadata{}
+	_, err = loaded.Load(location)
+	require.NoError(t, err)
+	assert.Equal(t, tm, loaded)
+
+	_, err = tm.Save(location, true)
+	require.NoError(t, err)
+	loaded = TableMetadata{}
+	_, err = loaded.Load(location)
+	require.NoError(t, err)
+	assert.Equal(t, TableMetadata{Database: "db", Table: "events", Query: tm.Query, MetadataOnly: true}, loaded)
+}
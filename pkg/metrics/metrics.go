@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// per-table, transfer, remote storage and retry metrics are updated from backup, storage and custom packages,
+// they don't depend on API server, `server` registers them together with APIMetrics
+var (
+	TableLastBackupSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "table_last_backup_size",
+		Help:      "Data size in bytes of table in last created local backup",
+	}, []string{"database", "table"})
+	TableLastBackupDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "table_last_backup_duration_seconds",
+		Help:      "Data backup duration of table in last created local backup in seconds",
+	}, []string{"database", "table"})
+	UploadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "uploaded_bytes",
+		Help:      "Counter of bytes uploaded to remote storage, updated during upload",
+	})
+	DownloadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "downloaded_bytes",
+		Help:      "Counter of bytes downloaded from remote storage, updated during download",
+	})
+	RemoteStorageRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "remote_storage_request_duration_seconds",
+		Help:      "Remote storage request latency in seconds, GetFileReader measures time to open reader, Walk measures whole listing",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"kind", "operation"})
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "retries",
+		Help:      "Counter of retries after failed attempt in operations which are retried according to retries_on_failure",
+	}, []string{"operation"})
+
+	watchJobLastSuccess = newLastSuccessCollector()
+)
+
+// Collectors - metrics which shall be registered by `server`
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		TableLastBackupSize,
+		TableLastBackupDuration,
+		UploadedBytes,
+		DownloadedBytes,
+		RemoteStorageRequestDuration,
+		Retries,
+		watchJobLastSuccess,
+	}
+}
+
+// ObserveRemoteStorageRequest - use with defer, startTime is evaluated when defer is called
+func ObserveRemoteStorageRequest(kind, operation string, startTime time.Time) {
+	RemoteStorageRequestDuration.WithLabelValues(kind, operation).Observe(time.Since(startTime).Seconds())
+}
+
+// WatchJobSuccess - finish time of successful create_remote for watch job
+func WatchJobSuccess(job string, finishTime time.Time) {
+	watchJobLastSuccess.Success(job, finishTime)
+}
+
+// Retrier - the same as retrier.Retrier with retrier.DefaultClassifier, each attempt after failed one increments Retries{operation},
+// so the last failed attempt is not counted
+type Retrier struct {
+	operation string
+	backoff   []time.Duration
+}
+
+func NewRetrier(operation string, backoff []time.Duration) *Retrier {
+	return &Retrier{operation: operation, backoff: backoff}
+}
+
+func (r *Retrier) RunCtx(ctx context.Context, work func(ctx context.Context) error) error {
+	attempt := 0
+	return retrier.New(r.backoff, nil).RunCtx(ctx, func(ctx context.Context) error {
+		if attempt > 0 {
+			Retries.WithLabelValues(r.operation).Inc()
+		}
+		attempt++
+		return work(ctx)
+	})
+}
+
+// lastSuccessCollector - seconds since last successful create_remote for each watch job, calculated during scrape
+type lastSuccessCollector struct {
+	sync.RWMutex
+	desc        *prometheus.Desc
+	lastSuccess map[string]time.Time
+}
+
+func newLastSuccessCollector() *lastSuccessCollector {
+	return &lastSuccessCollector{
+		desc: prometheus.NewDesc(
+			"clickhouse_backup_watch_job_seconds_since_last_success",
+			"Seconds since last successful create_remote of watch job",
+			[]string{"job"}, nil,
+		),
+		lastSuccess: map[string]time.Time{},
+	}
+}
+
+func (c *lastSuccessCollector) Success(job string, finishTime time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.lastSuccess[job] = finishTime
+}
+
+func (c *lastSuccessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *lastSuccessCollector) Collect(ch chan<- prometheus.Metric) {
+	c.RLock()
+	defer c.RUnlock()
+	for job, finishTime := range c.lastSuccess {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(finishTime).Seconds(), job)
+	}
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrier(t *testing.T) {
+	attempts := 0
+	retry := NewRetrier("test", retrier.ConstantBackoff(2, time.Millisecond))
+	err := retry.RunCtx(context.Background(), func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("attempt %d failed", attempts)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, testutil.ToFloat64(Retries.WithLabelValues("test")))
+
+	// the last failed attempt is not a retry
+	err = NewRetrier("test_failed", retrier.ConstantBackoff(2, time.Millisecond)).RunCtx(context.Background(), func(ctx context.Context) error {
+		return fmt.Errorf("failed")
+	})
+	require.Error(t, err)
+	assert.Equal(t, 2.0, testutil.ToFloat64(Retries.WithLabelValues("test_failed")))
+}
@@ -0,0 +1,144 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+)
+
+// SignatureHeader - hex encoded HMAC-SHA256 of request body with webhook `secret` as key
+const SignatureHeader = "X-Clickhouse-Backup-Signature"
+
+// Notification - default webhook body and data for webhook `template`
+type Notification struct {
+	Event      string `json:"event"`
+	Operation  string `json:"operation"`
+	BackupName string `json:"backup_name"`
+	Location   string `json:"location,omitempty"`
+	WatchJob   string `json:"watch_job,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Start      string `json:"start,omitempty"`
+	Finish     string `json:"finish"`
+	Duration   string `json:"duration,omitempty"`
+	Hostname   string `json:"hostname"`
+}
+
+// deliveries - CLI shall wait deliveries before exit
+var deliveries sync.WaitGroup
+
+// Send - deliver notification to each webhook which subscribed to notification event in background
+func Send(cfg *config.Config, n Notification) {
+	if len(cfg.Notifications.Webhooks) == 0 {
+		return
+	}
+	if n.Finish == "" {
+		n.Finish = time.Now().Format(common.TimeFormat)
+	}
+	if n.Hostname == "" {
+		n.Hostname, _ = os.Hostname()
+	}
+	for _, webhook := range cfg.Notifications.Webhooks {
+		if !webhook.IsEnabledFor(n.Event) {
+			continue
+		}
+		deliveries.Add(1)
+		go func(webhook config.WebhookConfig) {
+			defer deliveries.Done()
+			log := apexLog.WithFields(apexLog.Fields{"logger": "notification", "webhook": webhook.Name, "event": n.Event, "operation": n.Operation})
+			if err := Deliver(context.Background(), webhook, n); err != nil {
+				log.Errorf("can't deliver notification: %v", err)
+				return
+			}
+			log.Debug("delivered")
+		}(webhook)
+	}
+}
+
+// Wait - block until all notifications are delivered or all retries are failed
+func Wait() {
+	deliveries.Wait()
+}
+
+// Deliver - POST notification to webhook, retry with exponential backoff when request fails or response is not 2xx
+func Deliver(ctx context.Context, webhook config.WebhookConfig, n Notification) error {
+	body, err := Render(webhook, n)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhook.TimeoutDuration}
+	pause := webhook.RetriesPauseDuration
+	for attempt := 0; ; attempt++ {
+		err = post(ctx, client, webhook, body)
+		if err == nil || attempt >= webhook.Retries {
+			return err
+		}
+		apexLog.WithField("logger", "notification").Warnf("webhook %s attempt %d/%d failed: %v, next attempt after %s", webhook.Name, attempt+1, webhook.Retries+1, err, pause)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+		pause *= 2
+	}
+}
+
+// Render - notification as JSON when webhook has no template, rendered template shall be valid JSON
+func Render(webhook config.WebhookConfig, n Notification) ([]byte, error) {
+	if webhook.BodyTemplate == nil {
+		return json.Marshal(n)
+	}
+	var body bytes.Buffer
+	if err := webhook.BodyTemplate.Execute(&body, n); err != nil {
+		return nil, fmt.Errorf("can't render template for webhook %s: %v", webhook.Name, err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("template for webhook %s rendered invalid JSON: %s", webhook.Name, body.String())
+	}
+	return body.Bytes(), nil
+}
+
+// Sign - value of SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func post(ctx context.Context, client *http.Client, webhook config.WebhookConfig, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("can't create request to %s: %v", webhook.Name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.Headers {
+		req.Header.Set(name, value)
+	}
+	if webhook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConfig(t *testing.T, webhooks ...config.WebhookConfig) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Notifications.Webhooks = webhooks
+	require.NoError(t, config.ValidateConfig(cfg))
+	return cfg
+}
+
+func TestDeliverRetriesWithSignature(t *testing.T) {
+	var attempts int32
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		assert.Equal(t, "token", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	cfg := newTestConfig(t, config.WebhookConfig{
+		URL:          server.URL,
+		Secret:       "secret",
+		Headers:      map[string]string{"Authorization": "token"},
+		Retries:      2,
+		RetriesPause: "10ms",
+	})
+
+	n := Notification{Event: config.NotificationEventFailure, Operation: "upload", BackupName: "test_backup", Error: "can't upload"}
+	require.NoError(t, Deliver(context.Background(), cfg.Notifications.Webhooks[0], n))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	assert.Equal(t, Sign("secret", body), signature)
+	received := Notification{}
+	require.NoError(t, json.Unmarshal(body, &received))
+	assert.Equal(t, n, received)
+
+	atomic.StoreInt32(&attempts, -10)
+	assert.Error(t, Deliver(context.Background(), cfg.Notifications.Webhooks[0], n), "all retries failed")
+}
+
+func TestRender(t *testing.T) {
+	cfg := newTestConfig(t, config.WebhookConfig{
+		URL:      "https://hooks.slack.com/services/test",
+		Template: `{"text": {{ json (printf "%s %s: %s" .Operation .BackupName .Event) }}, "error": {{ json .Error }}}`,
+	})
+	body, err := Render(cfg.Notifications.Webhooks[0], Notification{Event: "failure", Operation: "create", BackupName: "test_backup", Error: `can't "create"`})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"text": "create test_backup: failure", "error": "can't \"create\""}`, string(body))
+
+	cfg = newTestConfig(t, config.WebhookConfig{URL: "https://example.com", Template: `{"text": "{{ .Error }}"}`})
+	_, err = Render(cfg.Notifications.Webhooks[0], Notification{Error: `"quoted"`})
+	assert.Error(t, err, "template without json function shall not produce invalid body")
+}
+
+func TestSendFiltersEvents(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := Notification{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&n))
+		received <- n.Event
+	}))
+	defer server.Close()
+	cfg := newTestConfig(t, config.WebhookConfig{URL: server.URL, Events: []string{config.NotificationEventRetention}})
+	Send(cfg, Notification{Event: config.NotificationEventSuccess, Operation: "create_remote"})
+	Send(cfg, Notification{Event: config.NotificationEventRetention, Operation: "delete", Location: "remote"})
+	Wait()
+	require.Len(t, received, 1)
+	assert.Equal(t, config.NotificationEventRetention, <-received)
+}
+
+func TestValidateWebhooks(t *testing.T) {
+	for _, webhook := range []config.WebhookConfig{
+		{URL: "ftp://example.com"},
+		{URL: "https://example.com", Events: []string{"started"}},
+		{URL: "https://example.com", Template: "{{ .Unclosed"},
+		{URL: "https://example.com", Timeout: "10"},
+		{URL: "https://example.com", Retries: -1},
+	} {
+		cfg := config.DefaultConfig()
+		cfg.Notifications.Webhooks = []config.WebhookConfig{webhook}
+		assert.Error(t, config.ValidateConfig(cfg), "%+v", webhook)
+	}
+	cfg := newTestConfig(t, config.WebhookConfig{URL: "https://example.com"})
+	assert.Equal(t, 10*time.Second, cfg.Notifications.Webhooks[0].TimeoutDuration)
+	assert.Equal(t, "webhook_0", cfg.Notifications.Webhooks[0].Name)
+}
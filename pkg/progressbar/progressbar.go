@@ -8,8 +8,9 @@ import (
 )
 
 type Bar struct {
-	pb   *progressbar.ProgressBar
-	show bool
+	pb    *progressbar.ProgressBar
+	show  bool
+	onAdd func(int64)
 }
 
 func StartNewByteBar(show bool, total int64) *Bar {
@@ -24,6 +25,12 @@ func StartNewByteBar(show bool, total int64) *Bar {
 	}
 }
 
+// OnAdd - onAdd receives the same bytes as bar, even when bar is not shown
+func (b *Bar) OnAdd(onAdd func(int64)) *Bar {
+	b.onAdd = onAdd
+	return b
+}
+
 func (b *Bar) Finish() {
 	if b.show {
 		b.pb.Finish()
@@ -35,6 +42,9 @@ func (b *Bar) Add64(add int64) {
 	if b.show {
 		b.pb.Add64(add)
 	}
+	if b.onAdd != nil {
+		b.onAdd(add)
+	}
 }
 
 func (b *Bar) Set(current int) {
@@ -50,8 +60,24 @@ func (b *Bar) Increment() {
 }
 
 func (b *Bar) NewProxyReader(r io.Reader) io.Reader {
+	if b.onAdd != nil {
+		r = &callbackReader{Reader: r, onAdd: b.onAdd}
+	}
 	if b.show {
 		return b.pb.NewProxyReader(r)
 	}
 	return r
 }
+
+type callbackReader struct {
+	io.Reader
+	onAdd func(int64)
+}
+
+func (r *callbackReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.onAdd(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,251 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchPeriod - Next and Prev give up when expression doesn't match during this period, like `0 0 30 2 *`
+const maxSearchPeriod = 5 * 366 * 24 * time.Hour
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = cronField{min: 0, max: 59}
+	hourField   = cronField{min: 0, max: 23}
+	domField    = cronField{min: 1, max: 31}
+	monthField  = cronField{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is sunday too
+	dowField = cronField{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Cron - standard 5 fields `minute hour day-of-month month day-of-week` expression, evaluated in local time zone
+type Cron struct {
+	expression string
+	minute     uint64
+	hour       uint64
+	dom        uint64
+	month      uint64
+	dow        uint64
+	isDomStar  bool
+	isDowStar  bool
+}
+
+// ParseCron - supports `*`, lists, ranges, steps, month and day-of-week names and @yearly, @monthly, @weekly, @daily, @hourly macros
+func ParseCron(expression string) (*Cron, error) {
+	fields := strings.Fields(expression)
+	if len(fields) == 1 {
+		if macro, exists := cronMacros[strings.ToLower(fields[0])]; exists {
+			fields = strings.Fields(macro)
+		}
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression `%s`, expected 5 fields `minute hour day-of-month month day-of-week`", expression)
+	}
+	c := &Cron{expression: expression}
+	var err error
+	if c.minute, err = parseCronField(fields[0], minuteField); err != nil {
+		return nil, fmt.Errorf("invalid minute in `%s`: %v", expression, err)
+	}
+	if c.hour, err = parseCronField(fields[1], hourField); err != nil {
+		return nil, fmt.Errorf("invalid hour in `%s`: %v", expression, err)
+	}
+	if c.dom, err = parseCronField(fields[2], domField); err != nil {
+		return nil, fmt.Errorf("invalid day of month in `%s`: %v", expression, err)
+	}
+	if c.month, err = parseCronField(fields[3], monthField); err != nil {
+		return nil, fmt.Errorf("invalid month in `%s`: %v", expression, err)
+	}
+	if c.dow, err = parseDowField(fields[4]); err != nil {
+		return nil, fmt.Errorf("invalid day of week in `%s`: %v", expression, err)
+	}
+	c.isDomStar = strings.HasPrefix(fields[2], "*")
+	c.isDowStar = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+func (c *Cron) String() string {
+	return c.expression
+}
+
+// Next - the first matched minute after t
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearchPeriod)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.isDayMatched(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Prev - the last matched minute not after t
+func (c *Cron) Prev(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute)
+	limit := t.Add(-maxSearchPeriod)
+	for t.After(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc).Add(-time.Minute)
+			continue
+		}
+		if !c.isDayMatched(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).Add(-time.Minute)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(-time.Minute)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(-time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Match - t is matched with minute precision
+func (c *Cron) Match(t time.Time) bool {
+	return c.month&(1<<uint(t.Month())) != 0 && c.isDayMatched(t) && c.hour&(1<<uint(t.Hour())) != 0 && c.minute&(1<<uint(t.Minute())) != 0
+}
+
+// NextOutside - the first matched minute after t which is not inside blackout windows
+func (c *Cron) NextOutside(t time.Time, windows Windows) time.Time {
+	next := c.Next(t)
+	for !next.IsZero() && windows.Contains(next) {
+		if next.Sub(t) > maxSearchPeriod {
+			return time.Time{}
+		}
+		next = c.Next(next)
+	}
+	return next
+}
+
+// PrevOutside - the last matched minute not after t which is not inside blackout windows
+func (c *Cron) PrevOutside(t time.Time, windows Windows) time.Time {
+	prev := c.Prev(t)
+	for !prev.IsZero() && windows.Contains(prev) {
+		if t.Sub(prev) > maxSearchPeriod {
+			return time.Time{}
+		}
+		prev = c.Prev(prev.Add(-time.Minute))
+	}
+	return prev
+}
+
+// isDayMatched - when day-of-month and day-of-week are both restricted, any of them shall match, the same as cron does
+func (c *Cron) isDayMatched(t time.Time) bool {
+	domMatched := c.dom&(1<<uint(t.Day())) != 0
+	dowMatched := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.isDomStar || c.isDowStar {
+		return domMatched && dowMatched
+	}
+	return domMatched || dowMatched
+}
+
+func parseDowField(value string) (uint64, error) {
+	bits, err := parseCronField(value, dowField)
+	if err != nil {
+		return 0, err
+	}
+	if bits&(1<<7) != 0 {
+		bits = bits&^(1<<7) | 1
+	}
+	return bits, nil
+}
+
+func parseCronField(value string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(value, ",") {
+		rangeValue, stepValue, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepValue); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step `%s`", item)
+			}
+		}
+		var start, end int
+		switch {
+		case rangeValue == "*":
+			start, end = field.min, field.max
+		case strings.Contains(rangeValue, "-"):
+			startValue, endValue, _ := strings.Cut(rangeValue, "-")
+			var err error
+			if start, err = parseCronValue(startValue, field); err != nil {
+				return 0, err
+			}
+			if end, err = parseCronValue(endValue, field); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if start, err = parseCronValue(rangeValue, field); err != nil {
+				return 0, err
+			}
+			end = start
+			// `5/10` means from 5 to max with step 10
+			if hasStep {
+				end = field.max
+			}
+		}
+		if start > end {
+			return 0, fmt.Errorf("invalid range `%s`", item)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, field cronField) (int, error) {
+	if i, exists := field.names[strings.ToLower(value)]; exists {
+		return i, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value `%s`", value)
+	}
+	if i < field.min || i > field.max {
+		return 0, fmt.Errorf("value `%d` is out of range %d-%d", i, field.min, field.max)
+	}
+	return i, nil
+}
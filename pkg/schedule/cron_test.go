@@ -0,0 +1,69 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseTime(t *testing.T, value string) time.Time {
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, time.UTC)
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestParseCron(t *testing.T) {
+	for _, expression := range []string{"* * * * *", "0 2 * * sun", "0 */4 * * 1-5", "15,45 9-18 1 jan,jul *", "@daily", "@weekly", "0 0 * * 7", "5/10 * * * *"} {
+		_, err := ParseCron(expression)
+		assert.NoError(t, err, expression)
+	}
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "@never", "a * * * *"} {
+		_, err := ParseCron(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestCronNextPrev(t *testing.T) {
+	testCases := []struct {
+		expression string
+		now        string
+		next       string
+		prev       string
+	}{
+		{"0 2 * * sun", "2024-01-03 10:00", "2024-01-07 02:00", "2023-12-31 02:00"},
+		{"0 */4 * * 1-5", "2024-01-05 21:30", "2024-01-08 00:00", "2024-01-05 20:00"},
+		{"30 9 * * *", "2024-01-05 09:30", "2024-01-06 09:30", "2024-01-05 09:30"},
+		{"@monthly", "2024-01-31 23:59", "2024-02-01 00:00", "2024-01-01 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00", "2024-02-29 00:00"},
+		// day-of-month or day-of-week
+		{"0 0 13 * fri", "2024-01-01 00:00", "2024-01-05 00:00", "2023-12-29 00:00"},
+	}
+	for _, tc := range testCases {
+		c, err := ParseCron(tc.expression)
+		require.NoError(t, err)
+		now := parseTime(t, tc.now)
+		assert.Equal(t, parseTime(t, tc.next), c.Next(now), tc.expression)
+		assert.Equal(t, parseTime(t, tc.prev), c.Prev(now), tc.expression)
+		assert.True(t, c.Match(c.Next(now)), tc.expression)
+	}
+	c, err := ParseCron("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, c.Next(parseTime(t, "2024-01-01 00:00")).IsZero())
+	assert.True(t, c.Prev(parseTime(t, "2024-01-01 00:00")).IsZero())
+}
+
+func TestCronOutsideWindows(t *testing.T) {
+	c, err := ParseCron("0 * * * *")
+	require.NoError(t, err)
+	windows, err := ParseWindows([]string{"1-5 09:00-18:00"})
+	require.NoError(t, err)
+	// friday
+	now := parseTime(t, "2024-01-05 08:30")
+	assert.Equal(t, parseTime(t, "2024-01-05 18:00"), c.NextOutside(now, windows))
+	assert.Equal(t, parseTime(t, "2024-01-05 08:00"), c.PrevOutside(parseTime(t, "2024-01-05 12:30"), windows))
+	allDay, err := ParseWindows([]string{"* 00:00-23:59", "* 23:59-00:00"})
+	require.NoError(t, err)
+	assert.True(t, c.NextOutside(now, allDay).IsZero())
+}
@@ -0,0 +1,120 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window - blackout window `<day-of-week> HH:MM-HH:MM`, day of week uses cron syntax, like `1-5 09:00-18:00` or `* 23:00-01:00`,
+// when the end is less than the start, window continues next day
+type Window struct {
+	expression string
+	dow        uint64
+	start      int
+	end        int
+}
+
+type Windows []*Window
+
+func ParseWindow(expression string) (*Window, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid blackout window `%s`, expected `<day-of-week> HH:MM-HH:MM`", expression)
+	}
+	w := &Window{expression: expression}
+	var err error
+	if w.dow, err = parseDowField(fields[0]); err != nil {
+		return nil, fmt.Errorf("invalid day of week in blackout window `%s`: %v", expression, err)
+	}
+	startValue, endValue, isRange := strings.Cut(fields[1], "-")
+	if !isRange {
+		return nil, fmt.Errorf("invalid time range in blackout window `%s`, expected HH:MM-HH:MM", expression)
+	}
+	if w.start, err = parseWindowTime(startValue); err != nil {
+		return nil, fmt.Errorf("invalid start in blackout window `%s`: %v", expression, err)
+	}
+	if w.end, err = parseWindowTime(endValue); err != nil {
+		return nil, fmt.Errorf("invalid end in blackout window `%s`: %v", expression, err)
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("blackout window `%s` is empty", expression)
+	}
+	return w, nil
+}
+
+func ParseWindows(expressions []string) (Windows, error) {
+	windows := make(Windows, 0, len(expressions))
+	for _, expression := range expressions {
+		if strings.TrimSpace(expression) == "" {
+			continue
+		}
+		w, err := ParseWindow(expression)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func (w *Window) String() string {
+	return w.expression
+}
+
+func (w *Window) Contains(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return w.dow&(1<<uint(t.Weekday())) != 0 && minutes >= w.start && minutes < w.end
+	}
+	if minutes >= w.start {
+		return w.dow&(1<<uint(t.Weekday())) != 0
+	}
+	return minutes < w.end && w.dow&(1<<uint(t.AddDate(0, 0, -1).Weekday())) != 0
+}
+
+// End - when window which contains t will finish, t is returned when window doesn't contain it
+func (w *Window) End(t time.Time) time.Time {
+	if !w.Contains(t) {
+		return t
+	}
+	end := time.Date(t.Year(), t.Month(), t.Day(), w.end/60, w.end%60, 0, 0, t.Location())
+	if w.start > w.end && t.Hour()*60+t.Minute() >= w.start {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+func (windows Windows) Contains(t time.Time) bool {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Postpone - move t to the end of blackout windows, adjacent windows are handled too
+func (windows Windows) Postpone(t time.Time) time.Time {
+	for i := 0; i <= len(windows)*7; i++ {
+		isPostponed := false
+		for _, w := range windows {
+			if w.Contains(t) {
+				t = w.End(t)
+				isPostponed = true
+			}
+		}
+		if !isPostponed {
+			break
+		}
+	}
+	return t
+}
+
+func parseWindowTime(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time `%s`, expected HH:MM", value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
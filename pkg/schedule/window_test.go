@@ -0,0 +1,44 @@
+package schedule
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWindow(t *testing.T) {
+	for _, expression := range []string{"* 23:00-01:00", "mon-fri 09:00-18:00", "0,6 00:00-23:59"} {
+		_, err := ParseWindow(expression)
+		assert.NoError(t, err, expression)
+	}
+	for _, expression := range []string{"", "09:00-18:00", "* 09:00", "* 9-18", "* 25:00-01:00", "8 09:00-18:00", "* 10:00-10:00"} {
+		_, err := ParseWindow(expression)
+		assert.Error(t, err, expression)
+	}
+	windows, err := ParseWindows([]string{"", "* 01:00-02:00"})
+	require.NoError(t, err)
+	assert.Len(t, windows, 1)
+}
+
+func TestWindowContains(t *testing.T) {
+	w, err := ParseWindow("fri 23:00-01:00")
+	require.NoError(t, err)
+	// 2024-01-05 is friday
+	assert.True(t, w.Contains(parseTime(t, "2024-01-05 23:30")))
+	assert.True(t, w.Contains(parseTime(t, "2024-01-06 00:30")))
+	assert.False(t, w.Contains(parseTime(t, "2024-01-06 01:00")))
+	assert.False(t, w.Contains(parseTime(t, "2024-01-05 00:30")))
+	assert.False(t, w.Contains(parseTime(t, "2024-01-04 23:30")))
+	assert.Equal(t, parseTime(t, "2024-01-06 01:00"), w.End(parseTime(t, "2024-01-05 23:30")))
+	assert.Equal(t, parseTime(t, "2024-01-06 01:00"), w.End(parseTime(t, "2024-01-06 00:30")))
+	assert.Equal(t, parseTime(t, "2024-01-05 12:00"), w.End(parseTime(t, "2024-01-05 12:00")))
+}
+
+func TestWindowsPostpone(t *testing.T) {
+	windows, err := ParseWindows([]string{"* 22:00-00:00", "* 00:00-02:00", "sat 09:00-12:00"})
+	require.NoError(t, err)
+	assert.Equal(t, parseTime(t, "2024-01-06 02:00"), windows.Postpone(parseTime(t, "2024-01-05 22:10")))
+	assert.Equal(t, parseTime(t, "2024-01-06 12:00"), windows.Postpone(parseTime(t, "2024-01-06 10:00")))
+	assert.Equal(t, parseTime(t, "2024-01-06 13:00"), windows.Postpone(parseTime(t, "2024-01-06 13:00")))
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"fmt"
+	backupMetrics "github.com/Altinity/clickhouse-backup/pkg/metrics"
 	apexLog "github.com/apex/log"
 	"github.com/prometheus/client_golang/prometheus"
 	"time"
@@ -30,6 +31,14 @@ type APIMetrics struct {
 	NumberBackupsLocal          prometheus.Gauge
 	NumberBackupsRemoteExpected prometheus.Gauge
 	NumberBackupsLocalExpected  prometheus.Gauge
+	LastRestoreDrillBackupAge   prometheus.Gauge
+
+	WatchJobSuccessfulCounter *prometheus.CounterVec
+	WatchJobFailedCounter     *prometheus.CounterVec
+	WatchJobLastStart         *prometheus.GaugeVec
+	WatchJobLastFinish        *prometheus.GaugeVec
+	WatchJobLastDuration      *prometheus.GaugeVec
+	WatchJobLastStatus        *prometheus.GaugeVec
 
 	SubCommands map[string][]string
 	log         *apexLog.Entry
@@ -48,7 +57,7 @@ func NewAPIMetrics() *APIMetrics {
 
 // RegisterMetrics resister prometheus metrics and define allowed measured commands list
 func (m *APIMetrics) RegisterMetrics() {
-	commandList := []string{"create", "upload", "download", "restore", "create_remote", "restore_remote", "delete"}
+	commandList := []string{"create", "upload", "download", "verify", "consolidate", "restore", "create_remote", "restore_remote", "delete", "restore_drill"}
 	successfulCounter := map[string]prometheus.Counter{}
 	failedCounter := map[string]prometheus.Counter{}
 	lastStart := map[string]prometheus.Gauge{}
@@ -137,6 +146,44 @@ func (m *APIMetrics) RegisterMetrics() {
 		Help:      "How many backups expected on local storage",
 	})
 
+	m.LastRestoreDrillBackupAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "last_restore_drill_backup_age",
+		Help:      "Age in seconds of remote backup restored by last restore drill",
+	})
+
+	watchJobLabels := []string{"job", "command"}
+	m.WatchJobSuccessfulCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "watch_job_successful_commands",
+		Help:      "Counter of successful commands executed by watch job",
+	}, watchJobLabels)
+	m.WatchJobFailedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "watch_job_failed_commands",
+		Help:      "Counter of failed commands executed by watch job",
+	}, watchJobLabels)
+	m.WatchJobLastStart = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "watch_job_last_start",
+		Help:      "Last command start timestamp of watch job",
+	}, watchJobLabels)
+	m.WatchJobLastFinish = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "watch_job_last_finish",
+		Help:      "Last command finish timestamp of watch job",
+	}, watchJobLabels)
+	m.WatchJobLastDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "watch_job_last_duration",
+		Help:      "Last command duration of watch job in nanoseconds",
+	}, watchJobLabels)
+	m.WatchJobLastStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "watch_job_last_status",
+		Help:      "Last command status of watch job: 0=failed, 1=success",
+	}, watchJobLabels)
+
 	for _, command := range commandList {
 		prometheus.MustRegister(
 			m.SuccessfulCounter[command],
@@ -155,7 +202,15 @@ func (m *APIMetrics) RegisterMetrics() {
 		m.NumberBackupsLocal,
 		m.NumberBackupsRemoteExpected,
 		m.NumberBackupsLocalExpected,
+		m.LastRestoreDrillBackupAge,
+		m.WatchJobSuccessfulCounter,
+		m.WatchJobFailedCounter,
+		m.WatchJobLastStart,
+		m.WatchJobLastFinish,
+		m.WatchJobLastDuration,
+		m.WatchJobLastStatus,
 	)
+	prometheus.MustRegister(backupMetrics.Collectors()...)
 
 	for _, command := range commandList {
 		m.LastStatus[command].Set(2) // 0=failed, 1=success, 2=unknown
@@ -233,3 +288,33 @@ func (m *APIMetrics) ExecuteWithMetrics(command string, errCounter int, f func()
 	}
 	return err, errCounter
 }
+
+// WatchJobMetrics - metrics labeled by watch job name, common command metrics are updated too
+type WatchJobMetrics struct {
+	*APIMetrics
+	job string
+}
+
+func (m *APIMetrics) ForWatchJob(job string) *WatchJobMetrics {
+	return &WatchJobMetrics{APIMetrics: m, job: job}
+}
+
+func (m *WatchJobMetrics) ExecuteWithMetrics(command string, errCounter int, f func() error) (error, int) {
+	startTime := time.Now()
+	err, errCounter := m.APIMetrics.ExecuteWithMetrics(command, errCounter, f)
+	labels := prometheus.Labels{"job": m.job, "command": command}
+	m.WatchJobLastStart.With(labels).Set(float64(startTime.Unix()))
+	m.WatchJobLastFinish.With(labels).Set(float64(time.Now().Unix()))
+	m.WatchJobLastDuration.With(labels).Set(float64(time.Since(startTime).Nanoseconds()))
+	if err != nil {
+		m.WatchJobFailedCounter.With(labels).Inc()
+		m.WatchJobLastStatus.With(labels).Set(0)
+	} else {
+		m.WatchJobSuccessfulCounter.With(labels).Inc()
+		m.WatchJobLastStatus.With(labels).Set(1)
+		if command == "create_remote" {
+			backupMetrics.WatchJobSuccess(m.job, time.Now())
+		}
+	}
+	return err, errCounter
+}
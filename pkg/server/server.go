@@ -49,6 +49,10 @@ type APIServer struct {
 	log                     *apexLog.Entry
 	routes                  []string
 	clickhouseBackupVersion string
+	// stops RunRestoreDrill loop, Restart starts new loop with reloaded config
+	restoreDrillCancel context.CancelFunc
+	// stops RunWatchJob go-routines, Restart starts jobs from reloaded watch_jobs
+	watchJobsCancel context.CancelFunc
 }
 
 var (
@@ -98,6 +102,12 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 		}
 	}
 	api.metrics.RegisterMetrics()
+	status.Current.SetLogMaxLines(cfg.API.ActionsLogMaxLines)
+	if cfg.API.ActionsJournal != "" {
+		if err := status.Current.OpenJournal(cfg.API.ActionsJournal, cfg.API.ActionsJournalMaxSize, cfg.API.ActionsJournalMaxAgeDuration); err != nil {
+			log.Errorf("can't open actions journal, history will not be persisted: %v", err)
+		}
+	}
 
 	log.Infof("Starting API server on %s", api.config.API.ListenAddr)
 	sigterm := make(chan os.Signal, 1)
@@ -124,7 +134,6 @@ func Run(cliCtx *cli.Context, cliApp *cli.App, configPath string, clickhouseBack
 	if cliCtx.Bool("watch") {
 		go api.RunWatch(cliCtx)
 	}
-
 	for {
 		select {
 		case <-api.restart:
@@ -162,9 +171,132 @@ func (api *APIServer) RunWatch(cliCtx *cli.Context) {
 	status.Current.Stop(commandId, err)
 }
 
+// RunWatchJob - each job from watch_jobs has own command in /backup/status and own `job` label in watch_job_* metrics,
+// job is stopped when ctx is canceled by Restart, job which stops after error is started again only by Restart
+func (api *APIServer) RunWatchJob(ctx context.Context, name string) {
+	cfg, job, err := api.config.GetWatchJob(name)
+	if err != nil {
+		api.log.Errorf("can't start watch job: %v", err)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	api.log.Infof("Starting watch job %s", name)
+	b := backup.NewBackuper(cfg, backup.WithWatchJob(name))
+	commandId, _ := status.Current.Start(fmt.Sprintf("watch --job=\"%s\"", name))
+	if _, cancel, cancelErr := status.Current.GetContextWithCancel(commandId); cancelErr == nil {
+		defer context.AfterFunc(ctx, cancel)()
+	}
+	err = b.Watch(
+		"", "", "",
+		job.Tables, job.Partitions, job.Schema, job.RBAC, job.Configs, job.SkipCheckPartsColumns,
+		api.clickhouseBackupVersion, commandId, api.metrics.ForWatchJob(name), api.cliCtx,
+	)
+	if err != nil {
+		api.log.Errorf("watch job %s error: %v", name, err)
+	}
+	status.Current.Stop(commandId, err)
+}
+
+// startOperation - run operation in background go-routine, when `allow_parallel: false` and another operation is in progress,
+// operation waits in queue with `queue_operations: true`, otherwise ErrAPILocked is returned
+func (api *APIServer) startOperation(command string, priority int, run func(commandId int, ctx context.Context)) (int, string, error) {
+	if !api.config.API.AllowParallel && api.config.API.QueueOperations {
+		commandId, isQueued := status.Current.Enqueue(command, priority, run)
+		if isQueued {
+			api.log.Infof("%s queued, command_id=%d", command, commandId)
+			return commandId, status.QueuedStatus, nil
+		}
+		return commandId, "acknowledged", nil
+	}
+	if !api.config.API.AllowParallel && status.Current.InProgress() {
+		return -1, "", ErrAPILocked
+	}
+	commandId, ctx := status.Current.Start(command)
+	go run(commandId, ctx)
+	return commandId, "acknowledged", nil
+}
+
+// runOperation - the same as startOperation, but waits for operation which starts immediately, so response contains its result,
+// queued operation returns status.QueuedStatus without waiting, run result is passed to status.Current.Stop
+func (api *APIServer) runOperation(command string, priority int, run func(commandId int, ctx context.Context) error) (int, string, error) {
+	done := make(chan error, 1)
+	commandId, operationStatus, err := api.startOperation(command, priority, func(commandId int, ctx context.Context) {
+		runErr := run(commandId, ctx)
+		status.Current.Stop(commandId, runErr)
+		done <- runErr
+	})
+	if err != nil || operationStatus == status.QueuedStatus {
+		return commandId, operationStatus, err
+	}
+	if err = <-done; err != nil {
+		return commandId, "", err
+	}
+	return commandId, "success", nil
+}
+
+// getOperationPriority - queued operations with higher priority start first
+func getOperationPriority(query url.Values) (int, error) {
+	if priority, exist := query["priority"]; exist {
+		value, err := strconv.Atoi(priority[0])
+		if err != nil {
+			return 0, fmt.Errorf("invalid priority `%s`: %v", priority[0], err)
+		}
+		return value, nil
+	}
+	return 0, nil
+}
+
+// RunRestoreDrill - each restore_drill_interval restore the latest remote backup into scratch databases, check it and drop scratch databases,
+// loop is stopped when ctx is canceled by Restart, next drill is skipped while previous one is queued or in progress
+func (api *APIServer) RunRestoreDrill(ctx context.Context, cfg *config.Config) {
+	api.log.Infof("Starting restore drill every %s", cfg.API.RestoreDrillInterval)
+	ticker := time.NewTicker(cfg.API.RestoreDrillDuration)
+	defer ticker.Stop()
+	errCounter := 0
+	lastCommandId := -1
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if lastCommandId != -1 {
+			if lastCommand, err := status.Current.GetCommand(lastCommandId); err == nil && (lastCommand.Status == status.QueuedStatus || lastCommand.Status == status.InProgressStatus) {
+				api.log.Warnf("restore drill skipped, previous restore drill command_id=%d is %s", lastCommandId, lastCommand.Status)
+				continue
+			}
+		}
+		commandId, _, err := api.startOperation("restore_drill", 0, func(commandId int, _ context.Context) {
+			var backupCreationDate time.Time
+			var drillErr error
+			drillErr, errCounter = api.metrics.ExecuteWithMetrics("restore_drill", errCounter, func() error {
+				var err error
+				b := backup.NewBackuper(cfg)
+				backupCreationDate, err = b.RestoreDrill(backup.RestoreDrillOptions{
+					TablePattern:   cfg.API.RestoreDrillTables,
+					DatabasePrefix: cfg.API.RestoreDrillDatabasePrefix,
+				}, commandId)
+				return err
+			})
+			if !backupCreationDate.IsZero() {
+				api.metrics.LastRestoreDrillBackupAge.Set(time.Since(backupCreationDate).Seconds())
+			}
+			status.Current.Stop(commandId, drillErr)
+		})
+		if err != nil {
+			api.log.Warnf("restore drill skipped: %v", err)
+			continue
+		}
+		lastCommandId = commandId
+	}
+}
+
 // Stop cancel all running commands, @todo think about graceful period
 func (api *APIServer) Stop() error {
 	status.Current.CancelAll("canceled during server stop")
+	status.Current.CloseJournal()
 	return api.server.Close()
 }
 
@@ -175,6 +307,26 @@ func (api *APIServer) Restart() error {
 		return err
 	}
 	status.Current.CancelAll("canceled via API /restart")
+	if api.restoreDrillCancel != nil {
+		api.restoreDrillCancel()
+		api.restoreDrillCancel = nil
+	}
+	if api.config.API.RestoreDrillDuration > 0 {
+		var restoreDrillCtx context.Context
+		restoreDrillCtx, api.restoreDrillCancel = context.WithCancel(context.Background())
+		go api.RunRestoreDrill(restoreDrillCtx, api.config)
+	}
+	if api.watchJobsCancel != nil {
+		api.watchJobsCancel()
+		api.watchJobsCancel = nil
+	}
+	if len(api.config.WatchJobs) > 0 {
+		var watchJobsCtx context.Context
+		watchJobsCtx, api.watchJobsCancel = context.WithCancel(context.Background())
+		for _, job := range api.config.WatchJobs {
+			go api.RunWatchJob(watchJobsCtx, job.Name)
+		}
+	}
 	if api.server != nil {
 		_ = api.server.Close()
 	}
@@ -232,10 +384,19 @@ func (api *APIServer) registerHTTPHandlers() *http.Server {
 	r.HandleFunc("/backup/clean/remote_broken", api.httpCleanRemoteBrokenHandler).Methods("POST")
 	r.HandleFunc("/backup/upload/{name}", api.httpUploadHandler).Methods("POST")
 	r.HandleFunc("/backup/download/{name}", api.httpDownloadHandler).Methods("POST")
+	r.HandleFunc("/backup/verify/{name}", api.httpVerifyHandler).Methods("POST")
 	r.HandleFunc("/backup/restore/{name}", api.httpRestoreHandler).Methods("POST")
 	r.HandleFunc("/backup/delete/{where}/{name}", api.httpDeleteHandler).Methods("POST")
+	r.HandleFunc("/backup/pin/{where}/{name}", api.httpPinHandler).Methods("POST")
+	r.HandleFunc("/backup/unpin/{where}/{name}", api.httpUnpinHandler).Methods("POST")
+	r.HandleFunc("/backup/diff/{where}/{backupA}/{backupB}", api.httpDiffHandler).Methods("GET")
 	r.HandleFunc("/backup/status", api.httpBackupStatusHandler).Methods("GET")
+	r.HandleFunc("/backup/queue", api.httpQueueHandler).Methods("GET")
+	r.HandleFunc("/backup/queue/priority/{command_id}", api.httpQueuePriorityHandler).Methods("POST")
+	r.HandleFunc("/backup/queue/cancel/{command_id}", api.httpQueueCancelHandler).Methods("POST")
 
+	r.HandleFunc("/backup/actions/{command_id}/events", api.httpActionEventsHandler).Methods("GET")
+	r.HandleFunc("/backup/actions/{command_id}/log", api.httpActionLogHandler).Methods("GET")
 	r.HandleFunc("/backup/actions", api.actionsLog).Methods("GET", "HEAD")
 	r.HandleFunc("/backup/actions", api.actions).Methods("POST")
 
@@ -307,7 +468,7 @@ type actionsResultsRow struct {
 	Operation string `json:"operation"`
 }
 
-// CREATE TABLE system.backup_actions (command String, start DateTime, finish DateTime, status String, error String) ENGINE=URL('http://127.0.0.1:7171/backup/actions?user=user&pass=pass', JSONEachRow)
+// CREATE TABLE system.backup_actions (command String, start DateTime, finish DateTime, status String, error String, log String) ENGINE=URL('http://127.0.0.1:7171/backup/actions?user=user&pass=pass', JSONEachRow)
 // INSERT INTO system.backup_actions (command) VALUES ('create backup_name')
 // INSERT INTO system.backup_actions (command) VALUES ('upload backup_name')
 func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
@@ -358,7 +519,7 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 				api.writeError(w, http.StatusInternalServerError, row.Command, err)
 				return
 			}
-		case "create", "restore", "upload", "download", "create_remote", "restore_remote", "list":
+		case "create", "restore", "upload", "download", "verify", "consolidate", "create_remote", "restore_remote", "list":
 			actionsResults, err = api.actionsAsyncCommandsHandler(command, args, row, actionsResults)
 			if err != nil {
 				api.writeError(w, http.StatusInternalServerError, row.Command, err)
@@ -370,6 +531,12 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 				api.writeError(w, http.StatusInternalServerError, row.Command, err)
 				return
 			}
+		case "pin", "unpin":
+			actionsResults, err = api.actionsPinHandler(row, args, actionsResults)
+			if err != nil {
+				api.writeError(w, http.StatusInternalServerError, row.Command, err)
+				return
+			}
 		default:
 			api.writeError(w, http.StatusBadRequest, row.Command, fmt.Errorf("unknown command"))
 			return
@@ -379,35 +546,45 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *APIServer) actionsDeleteHandler(row status.ActionRow, args []string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		return actionsResults, ErrAPILocked
+	_, operationStatus, err := api.runOperation(row.Command, 0, func(commandId int, ctx context.Context) error {
+		if err := api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...)); err != nil {
+			return err
+		}
+		api.log.Info("DELETED")
+		go func() {
+			if err := api.UpdateBackupMetrics(context.Background(), len(args) > 1 && args[1] == "local"); err != nil {
+				api.log.Errorf("UpdateBackupMetrics return error: %v", err)
+			}
+		}()
+		return nil
+	})
+	if err != nil {
+		return actionsResults, err
 	}
-	commandId, _ := status.Current.Start(row.Command)
-	err := api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
-	status.Current.Stop(commandId, err)
+	actionsResults = append(actionsResults, actionsResultsRow{
+		Status:    operationStatus,
+		Operation: row.Command,
+	})
+	return actionsResults, nil
+}
+
+func (api *APIServer) actionsPinHandler(row status.ActionRow, args []string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
+	_, operationStatus, err := api.runOperation(row.Command, 0, func(commandId int, ctx context.Context) error {
+		return api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
+	})
 	if err != nil {
 		return actionsResults, err
 	}
-	api.log.Info("DELETED")
-	go func() {
-		if err := api.UpdateBackupMetrics(context.Background(), args[1] == "local"); err != nil {
-			api.log.Errorf("UpdateBackupMetrics return error: %v", err)
-		}
-	}()
 	actionsResults = append(actionsResults, actionsResultsRow{
-		Status:    "success",
+		Status:    operationStatus,
 		Operation: row.Command,
 	})
 	return actionsResults, nil
 }
 
 func (api *APIServer) actionsAsyncCommandsHandler(command string, args []string, row status.ActionRow, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		return actionsResults, ErrAPILocked
-	}
 	// to avoid race condition between GET /backup/actions and POST /backup/actions
-	commandId, _ := status.Current.Start(row.Command)
-	go func() {
+	_, operationStatus, err := api.startOperation(row.Command, 0, func(commandId int, ctx context.Context) {
 		err, _ := api.metrics.ExecuteWithMetrics(command, 0, func() error {
 			return api.cliApp.Run(append([]string{"clickhouse-backup", "-c", api.configPath, "--command-id", strconv.FormatInt(int64(commandId), 10)}, args...))
 		})
@@ -421,9 +598,12 @@ func (api *APIServer) actionsAsyncCommandsHandler(command string, args []string,
 				api.log.Errorf("UpdateBackupMetrics return error: %v", err)
 			}
 		}()
-	}()
+	})
+	if err != nil {
+		return actionsResults, err
+	}
 	actionsResults = append(actionsResults, actionsResultsRow{
-		Status:    "acknowledged",
+		Status:    operationStatus,
 		Operation: row.Command,
 	})
 	return actionsResults, nil
@@ -448,31 +628,27 @@ func (api *APIServer) actionsKillHandler(row status.ActionRow, args []string, ac
 }
 
 func (api *APIServer) actionsCleanRemoteBrokenHandler(w http.ResponseWriter, row status.ActionRow, command string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Warn(ErrAPILocked.Error())
-		return actionsResults, ErrAPILocked
-	}
-	commandId, ctx := status.Current.Start(command)
 	cfg, err := api.ReloadConfig(w, "clean_remote_broken")
 	if err != nil {
-		status.Current.Stop(commandId, err)
 		return actionsResults, err
 	}
-	b := backup.NewBackuper(cfg)
-	err = b.CleanRemoteBroken(commandId)
+	_, operationStatus, err := api.runOperation(command, 0, func(commandId int, ctx context.Context) error {
+		b := backup.NewBackuper(cfg)
+		if err := b.CleanRemoteBroken(commandId); err != nil {
+			api.log.Errorf("Clean remote broken error: %v", err)
+			return err
+		}
+		api.log.Info("CLEANED")
+		if metricsErr := api.UpdateBackupMetrics(ctx, false); metricsErr != nil {
+			api.log.Errorf("UpdateBackupMetrics return error: %v", metricsErr)
+		}
+		return nil
+	})
 	if err != nil {
-		api.log.Errorf("Clean remote broken error: %v", err)
-		status.Current.Stop(commandId, err)
 		return actionsResults, err
 	}
-	api.log.Info("CLEANED")
-	metricsErr := api.UpdateBackupMetrics(ctx, false)
-	if metricsErr != nil {
-		api.log.Errorf("UpdateBackupMetrics return error: %v", metricsErr)
-	}
-	status.Current.Stop(commandId, nil)
 	actionsResults = append(actionsResults, actionsResultsRow{
-		Status:    "success",
+		Status:    operationStatus,
 		Operation: row.Command,
 	})
 	return actionsResults, nil
@@ -586,7 +762,20 @@ func (api *APIServer) actionsLog(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	api.sendJSONEachRow(w, http.StatusOK, status.Current.GetStatus(false, q.Get("filter"), int(last)))
+	commands := status.Current.GetStatus(false, q.Get("filter"), int(last))
+	rows := make([]actionsLogRow, len(commands))
+	for i, command := range commands {
+		rows[i].ActionRowStatus = command
+		rows[i].Log, _ = status.Current.GetCommandLog(command.CommandId)
+	}
+	api.sendJSONEachRow(w, http.StatusOK, rows)
+}
+
+// actionsLogRow - `log` column of system.backup_actions allows to read the cause of failure from ClickHouse,
+// log is added only to /backup/actions response, it is not a part of command status and journal
+type actionsLogRow struct {
+	status.ActionRowStatus
+	Log string `json:"log,omitempty"`
 }
 
 // httpRootHandler - display API index
@@ -795,11 +984,6 @@ func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
 
 // httpCreateHandler - create a backup
 func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "create", ErrAPILocked)
-		return
-	}
 	cfg, err := api.ReloadConfig(w, "create")
 	if err != nil {
 		return
@@ -856,9 +1040,13 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 		api.writeError(w, http.StatusBadRequest, "create", err)
 		return
 	}
+	priority, err := getOperationPriority(query)
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "create", err)
+		return
+	}
 
-	commandId, ctx := status.Current.Start(fullCommand)
-	go func() {
+	commandId, operationStatus, err := api.startOperation(fullCommand, priority, func(commandId int, ctx context.Context) {
 		err, _ := api.metrics.ExecuteWithMetrics("create", 0, func() error {
 			b := backup.NewBackuper(cfg)
 			return b.CreateBackup(backupName, tablePattern, partitionsToBackup, schemaOnly, createRBAC, false, createConfigs, false, checkPartsColumns, api.clickhouseBackupVersion, commandId)
@@ -877,15 +1065,22 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 		}
 		status.Current.Stop(commandId, nil)
 		api.successCallback(context.Background(), callback)
-	}()
+	})
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "create", err)
+		return
+	}
 	api.sendJSONEachRow(w, http.StatusCreated, struct {
 		Status     string `json:"status"`
 		Operation  string `json:"operation"`
 		BackupName string `json:"backup_name"`
+		CommandId  int    `json:"command_id"`
 	}{
-		Status:     "acknowledged",
+		Status:     operationStatus,
 		Operation:  "create",
 		BackupName: backupName,
+		CommandId:  commandId,
 	})
 }
 
@@ -1040,11 +1235,6 @@ func (api *APIServer) httpCleanRemoteBrokenHandler(w http.ResponseWriter, _ *htt
 
 // httpUploadHandler - upload a backup to remote storage
 func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "upload", ErrAPILocked)
-		return
-	}
 	cfg, err := api.ReloadConfig(w, "upload")
 	if err != nil {
 		return
@@ -1093,9 +1283,13 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		api.writeError(w, http.StatusBadRequest, "upload", err)
 		return
 	}
+	priority, err := getOperationPriority(query)
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "upload", err)
+		return
+	}
 
-	commandId, ctx := status.Current.Start(fullCommand)
-	go func() {
+	commandId, operationStatus, err := api.startOperation(fullCommand, priority, func(commandId int, ctx context.Context) {
 		err, _ := api.metrics.ExecuteWithMetrics("upload", 0, func() error {
 			b := backup.NewBackuper(cfg)
 			return b.Upload(name, diffFrom, diffFromRemote, tablePattern, partitionsToBackup, schemaOnly, resume, commandId)
@@ -1114,51 +1308,51 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		}
 		status.Current.Stop(commandId, nil)
 		api.successCallback(context.Background(), callback)
-	}()
+	})
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "upload", err)
+		return
+	}
 	api.sendJSONEachRow(w, http.StatusOK, struct {
 		Status     string `json:"status"`
 		Operation  string `json:"operation"`
 		BackupName string `json:"backup_name"`
 		BackupFrom string `json:"backup_from,omitempty"`
 		Diff       bool   `json:"diff"`
+		CommandId  int    `json:"command_id"`
 	}{
-		Status:     "acknowledged",
+		Status:     operationStatus,
 		Operation:  "upload",
 		BackupName: name,
 		BackupFrom: diffFrom,
 		Diff:       diffFrom != "",
+		CommandId:  commandId,
 	})
 }
 
 var databaseMappingRE = regexp.MustCompile(`[\w+]:[\w+]`)
+var tableMappingRE = regexp.MustCompile(`^[^.:]+\.[^:]+:[^.:]+\.[^:]+$`)
 
 // httpRestoreHandler - restore a backup from local storage
 func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "restore", ErrAPILocked)
-		return
-	}
 	_, err := api.ReloadConfig(w, "restore")
 	if err != nil {
 		return
 	}
 	vars := mux.Vars(r)
-	tablePattern := ""
-	databaseMappingToRestore := make([]string, 0)
-	partitionsToBackup := make([]string, 0)
-	schemaOnly := false
-	dataOnly := false
-	dropTable := false
-	ignoreDependencies := false
-	restoreRBAC := false
-	restoreConfigs := false
+	opts := backup.RestoreOptions{
+		DatabaseMapping:      make([]string, 0),
+		TableMapping:         make([]string, 0),
+		ZookeeperPathMapping: make([]string, 0),
+		Partitions:           make([]string, 0),
+	}
 	fullCommand := "restore"
 
 	query := r.URL.Query()
 	if tp, exist := query["table"]; exist {
-		tablePattern = tp[0]
-		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, tablePattern)
+		opts.TablePattern = tp[0]
+		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, opts.TablePattern)
 	}
 	if databaseMappingQuery, exist := query["restore_database_mapping"]; exist {
 		for _, databaseMapping := range databaseMappingQuery {
@@ -1170,43 +1364,95 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 
 				}
 			}
-			databaseMappingToRestore = append(databaseMappingToRestore, mappingItems...)
+			opts.DatabaseMapping = append(opts.DatabaseMapping, mappingItems...)
+		}
+
+		fullCommand = fmt.Sprintf("%s --restore-database-mapping=\"%s\"", fullCommand, strings.Join(opts.DatabaseMapping, ","))
+	}
+	if tableMappingQuery, exist := query["restore_table_mapping"]; exist {
+		for _, tableMapping := range tableMappingQuery {
+			mappingItems := strings.Split(tableMapping, ",")
+			for _, m := range mappingItems {
+				if strings.Count(m, ":") != 1 || !tableMappingRE.MatchString(m) {
+					api.writeError(w, http.StatusInternalServerError, "restore", fmt.Errorf("invalid values in restore_table_mapping %s", m))
+					return
+
+				}
+			}
+			opts.TableMapping = append(opts.TableMapping, mappingItems...)
 		}
 
-		fullCommand = fmt.Sprintf("%s --restore-database-mapping=\"%s\"", fullCommand, strings.Join(databaseMappingToRestore, ","))
+		fullCommand = fmt.Sprintf("%s --restore-table-mapping=\"%s\"", fullCommand, strings.Join(opts.TableMapping, ","))
+	}
+	if zookeeperPathMappingQuery, exist := query["restore_zookeeper_path_mapping"]; exist {
+		for _, zookeeperPathMapping := range zookeeperPathMappingQuery {
+			mappingItems := strings.Split(zookeeperPathMapping, ",")
+			for _, m := range mappingItems {
+				if strings.Count(m, ":") != 1 || strings.HasPrefix(m, ":") {
+					api.writeError(w, http.StatusInternalServerError, "restore", fmt.Errorf("invalid values in restore_zookeeper_path_mapping %s", m))
+					return
+				}
+			}
+			opts.ZookeeperPathMapping = append(opts.ZookeeperPathMapping, mappingItems...)
+		}
+		fullCommand = fmt.Sprintf("%s --restore-zookeeper-path-mapping=\"%s\"", fullCommand, strings.Join(opts.ZookeeperPathMapping, ","))
+	}
+	if conversion, exist := query["restore_replicated_conversion"]; exist {
+		opts.ReplicatedConversion = conversion[0]
+		if opts.ReplicatedConversion != backup.ReplicatedConversionToMergeTree && opts.ReplicatedConversion != backup.ReplicatedConversionToReplicated {
+			api.writeError(w, http.StatusInternalServerError, "restore", fmt.Errorf("invalid value in restore_replicated_conversion %s", opts.ReplicatedConversion))
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --restore-replicated-conversion=%s", fullCommand, opts.ReplicatedConversion)
 	}
 	if partitions, exist := query["partitions"]; exist {
-		partitionsToBackup = partitions
+		opts.Partitions = partitions
 		fullCommand = fmt.Sprintf("%s --partitions=\"%s\"", fullCommand, strings.Join(partitions, ","))
 	}
 	if _, exist := query["schema"]; exist {
-		schemaOnly = true
+		opts.SchemaOnly = true
 		fullCommand += " --schema"
 	}
 	if _, exist := query["data"]; exist {
-		dataOnly = true
+		opts.DataOnly = true
 		fullCommand += " --data"
 	}
 	if _, exist := query["drop"]; exist {
-		dropTable = true
+		opts.DropTable = true
 		fullCommand += " --drop"
 	}
 	if _, exist := query["rm"]; exist {
-		dropTable = true
+		opts.DropTable = true
 		fullCommand += " --rm"
 	}
 	if _, exists := query["ignore_dependencies"]; exists {
-		ignoreDependencies = true
+		opts.IgnoreDependencies = true
 		fullCommand += " --ignore-dependencies"
 	}
 	if _, exist := query["rbac"]; exist {
-		restoreRBAC = true
+		opts.RBAC = true
 		fullCommand += " --rbac"
 	}
 	if _, exist := query["configs"]; exist {
-		restoreConfigs = true
+		opts.Configs = true
 		fullCommand += " --configs"
 	}
+	if _, exist := query["atomic"]; exist {
+		opts.Atomic = true
+		fullCommand += " --atomic"
+	}
+	if whereQuery, exist := query["where"]; exist {
+		opts.Where = whereQuery[0]
+		if err := backup.ValidateRestoreWhere(opts.Where); err != nil {
+			api.writeError(w, http.StatusBadRequest, "restore", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --where=\"%s\"", fullCommand, opts.Where)
+	}
+	if _, exist := query["dry_run"]; exist {
+		opts.DryRun = true
+		fullCommand += " --dry-run"
+	}
 
 	name := utils.CleanBackupNameRE.ReplaceAllString(vars["name"], "")
 	fullCommand += fmt.Sprintf(" %s", name)
@@ -1217,12 +1463,16 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 		api.writeError(w, http.StatusBadRequest, "restore", err)
 		return
 	}
+	priority, err := getOperationPriority(query)
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "restore", err)
+		return
+	}
 
-	commandId, _ := status.Current.Start(fullCommand)
-	go func() {
+	commandId, operationStatus, err := api.startOperation(fullCommand, priority, func(commandId int, ctx context.Context) {
 		err, _ := api.metrics.ExecuteWithMetrics("restore", 0, func() error {
 			b := backup.NewBackuper(api.config)
-			return b.Restore(name, tablePattern, databaseMappingToRestore, partitionsToBackup, schemaOnly, dataOnly, dropTable, ignoreDependencies, restoreRBAC, false, restoreConfigs, false, commandId)
+			return b.Restore(name, opts, commandId)
 		})
 		status.Current.Stop(commandId, err)
 		if err != nil {
@@ -1231,25 +1481,27 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		api.successCallback(context.Background(), callback)
-	}()
+	})
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "restore", err)
+		return
+	}
 	api.sendJSONEachRow(w, http.StatusOK, struct {
 		Status     string `json:"status"`
 		Operation  string `json:"operation"`
 		BackupName string `json:"backup_name"`
+		CommandId  int    `json:"command_id"`
 	}{
-		Status:     "acknowledged",
+		Status:     operationStatus,
 		Operation:  "restore",
 		BackupName: name,
+		CommandId:  commandId,
 	})
 }
 
 // httpDownloadHandler - download a backup from remote to local storage
 func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "download", ErrAPILocked)
-		return
-	}
 	cfg, err := api.ReloadConfig(w, "download")
 	if err != nil {
 		return
@@ -1288,9 +1540,13 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 		api.writeError(w, http.StatusBadRequest, "download", err)
 		return
 	}
+	priority, err := getOperationPriority(query)
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "download", err)
+		return
+	}
 
-	commandId, ctx := status.Current.Start(fullCommand)
-	go func() {
+	commandId, operationStatus, err := api.startOperation(fullCommand, priority, func(commandId int, ctx context.Context) {
 		err, _ := api.metrics.ExecuteWithMetrics("download", 0, func() error {
 			b := backup.NewBackuper(cfg)
 			return b.Download(name, tablePattern, partitionsToBackup, schemaOnly, resume, commandId)
@@ -1309,69 +1565,365 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 		}
 		status.Current.Stop(commandId, nil)
 		api.successCallback(context.Background(), callback)
-	}()
+	})
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "download", err)
+		return
+	}
 	api.sendJSONEachRow(w, http.StatusOK, struct {
 		Status     string `json:"status"`
 		Operation  string `json:"operation"`
 		BackupName string `json:"backup_name"`
+		CommandId  int    `json:"command_id"`
 	}{
-		Status:     "acknowledged",
+		Status:     operationStatus,
 		Operation:  "download",
 		BackupName: name,
+		CommandId:  commandId,
+	})
+}
+
+// httpVerifyHandler - check remote backup consistency without download
+func (api *APIServer) httpVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, err := api.ReloadConfig(w, "verify")
+	if err != nil {
+		return
+	}
+	vars := mux.Vars(r)
+	name := strings.ReplaceAll(vars["name"], "/", "")
+	query := r.URL.Query()
+	deep := false
+	fullCommand := "verify"
+
+	if _, exist := query["deep"]; exist {
+		deep = true
+		fullCommand += " --deep"
+	}
+	fullCommand += fmt.Sprintf(" %s", name)
+
+	callback, err := parseCallback(query)
+	if err != nil {
+		api.log.Error(err.Error())
+		api.writeError(w, http.StatusBadRequest, "verify", err)
+		return
+	}
+	priority, err := getOperationPriority(query)
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "verify", err)
+		return
+	}
+
+	commandId, operationStatus, err := api.startOperation(fullCommand, priority, func(commandId int, ctx context.Context) {
+		err, _ := api.metrics.ExecuteWithMetrics("verify", 0, func() error {
+			b := backup.NewBackuper(cfg)
+			return b.Verify(name, deep, commandId)
+		})
+		if err != nil {
+			api.log.Errorf("API /backup/verify error: %v", err)
+			status.Current.Stop(commandId, err)
+			api.errorCallback(context.Background(), err, callback)
+			return
+		}
+		status.Current.Stop(commandId, nil)
+		api.successCallback(context.Background(), callback)
+	})
+	if err != nil {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "verify", err)
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, struct {
+		Status     string `json:"status"`
+		Operation  string `json:"operation"`
+		BackupName string `json:"backup_name"`
+		CommandId  int    `json:"command_id"`
+	}{
+		Status:     operationStatus,
+		Operation:  "verify",
+		BackupName: name,
+		CommandId:  commandId,
 	})
 }
 
 // httpDeleteHandler - delete a backup from local or remote storage
 func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	if !api.config.API.AllowParallel && status.Current.InProgress() {
-		api.log.Info(ErrAPILocked.Error())
-		api.writeError(w, http.StatusLocked, "delete", ErrAPILocked)
+	cfg, err := api.ReloadConfig(w, "delete")
+	if err != nil {
 		return
 	}
-	cfg, err := api.ReloadConfig(w, "delete")
+	priority, err := getOperationPriority(r.URL.Query())
 	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "delete", err)
 		return
 	}
 	vars := mux.Vars(r)
 	fullCommand := fmt.Sprintf("delete %s %s", vars["where"], vars["name"])
-	commandId, ctx := status.Current.Start(fullCommand)
-	b := backup.NewBackuper(cfg)
-	switch vars["where"] {
-	case "local":
-		err = b.RemoveBackupLocal(ctx, vars["name"], nil)
-	case "remote":
-		err = b.RemoveBackupRemote(ctx, vars["name"])
-	default:
-		err = fmt.Errorf("backup location must be 'local' or 'remote'")
+	commandId, operationStatus, err := api.runOperation(fullCommand, priority, func(commandId int, ctx context.Context) error {
+		b := backup.NewBackuper(cfg)
+		var deleteErr error
+		switch vars["where"] {
+		case "local":
+			deleteErr = b.RemoveBackupLocal(ctx, vars["name"], nil)
+		case "remote":
+			deleteErr = b.RemoveBackupRemote(ctx, vars["name"])
+		default:
+			deleteErr = fmt.Errorf("backup location must be 'local' or 'remote'")
+		}
+		if deleteErr != nil {
+			return deleteErr
+		}
+		go func() {
+			if err := api.UpdateBackupMetrics(context.Background(), vars["where"] == "local"); err != nil {
+				api.log.Errorf("UpdateBackupMetrics return error: %v", err)
+			}
+		}()
+		return nil
+	})
+	if errors.Is(err, ErrAPILocked) {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, "delete", err)
+		return
 	}
-	status.Current.Stop(commandId, err)
 	if err != nil {
 		api.log.Errorf("delete backup error: %v", err)
 		api.writeError(w, http.StatusInternalServerError, "delete", err)
 		return
 	}
-	go func() {
-		if err := api.UpdateBackupMetrics(context.Background(), vars["where"] == "local"); err != nil {
-			api.log.Errorf("UpdateBackupMetrics return error: %v", err)
-		}
-	}()
 	api.sendJSONEachRow(w, http.StatusOK, struct {
 		Status     string `json:"status"`
 		Operation  string `json:"operation"`
 		BackupName string `json:"backup_name"`
 		Location   string `json:"location"`
+		CommandId  int    `json:"command_id"`
 	}{
-		Status:     "success",
+		Status:     operationStatus,
 		Operation:  "delete",
 		BackupName: vars["name"],
 		Location:   vars["where"],
+		CommandId:  commandId,
+	})
+}
+
+// httpPinHandler - protect local or remote backup from retention and deletion
+func (api *APIServer) httpPinHandler(w http.ResponseWriter, r *http.Request) {
+	api.pinHandler(w, r, "pin")
+}
+
+// httpUnpinHandler - remove protection added by httpPinHandler
+func (api *APIServer) httpUnpinHandler(w http.ResponseWriter, r *http.Request) {
+	api.pinHandler(w, r, "unpin")
+}
+
+func (api *APIServer) pinHandler(w http.ResponseWriter, r *http.Request, operation string) {
+	cfg, err := api.ReloadConfig(w, operation)
+	if err != nil {
+		return
+	}
+	priority, err := getOperationPriority(r.URL.Query())
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, operation, err)
+		return
+	}
+	vars := mux.Vars(r)
+	fullCommand := fmt.Sprintf("%s %s %s", operation, vars["where"], vars["name"])
+	commandId, operationStatus, err := api.runOperation(fullCommand, priority, func(commandId int, ctx context.Context) error {
+		b := backup.NewBackuper(cfg)
+		if operation == "pin" {
+			return b.Pin(vars["where"], vars["name"], commandId)
+		}
+		return b.Unpin(vars["where"], vars["name"], commandId)
+	})
+	if errors.Is(err, ErrAPILocked) {
+		api.log.Info(err.Error())
+		api.writeError(w, http.StatusLocked, operation, err)
+		return
+	}
+	if err != nil {
+		api.log.Errorf("%s backup error: %v", operation, err)
+		api.writeError(w, http.StatusInternalServerError, operation, err)
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, struct {
+		Status     string `json:"status"`
+		Operation  string `json:"operation"`
+		BackupName string `json:"backup_name"`
+		Location   string `json:"location"`
+		CommandId  int    `json:"command_id"`
+	}{
+		Status:     operationStatus,
+		Operation:  operation,
+		BackupName: vars["name"],
+		Location:   vars["where"],
+		CommandId:  commandId,
 	})
 }
 
+// httpDiffHandler - compare two local or remote backups, read only, so could run in parallel independent of allow_parallel=true
+func (api *APIServer) httpDiffHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, err := api.ReloadConfig(w, "diff")
+	if err != nil {
+		return
+	}
+	vars := mux.Vars(r)
+	fullCommand := fmt.Sprintf("diff %s %s %s", vars["where"], vars["backupA"], vars["backupB"])
+	commandId, ctx := status.Current.Start(fullCommand)
+	b := backup.NewBackuper(cfg)
+	diff, err := b.GetBackupDiff(ctx, vars["where"], vars["backupA"], vars["backupB"])
+	status.Current.Stop(commandId, err)
+	if err != nil {
+		api.log.Errorf("diff backup error: %v", err)
+		api.writeError(w, http.StatusInternalServerError, "diff", err)
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, diff)
+}
+
 func (api *APIServer) httpBackupStatusHandler(w http.ResponseWriter, _ *http.Request) {
 	api.sendJSONEachRow(w, http.StatusOK, status.Current.GetStatus(true, "", 0))
 }
 
+// httpQueueHandler - list operations which wait for start when `allow_parallel: false`
+func (api *APIServer) httpQueueHandler(w http.ResponseWriter, _ *http.Request) {
+	api.sendJSONEachRow(w, http.StatusOK, status.Current.GetQueue())
+}
+
+// httpQueuePriorityHandler - change priority of queued operation
+func (api *APIServer) httpQueuePriorityHandler(w http.ResponseWriter, r *http.Request) {
+	commandId, err := strconv.Atoi(mux.Vars(r)["command_id"])
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "queue_priority", err)
+		return
+	}
+	query := r.URL.Query()
+	if _, exist := query["priority"]; !exist {
+		api.writeError(w, http.StatusBadRequest, "queue_priority", fmt.Errorf("require `priority` query argument"))
+		return
+	}
+	priority, err := getOperationPriority(query)
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "queue_priority", err)
+		return
+	}
+	if err = status.Current.SetQueuedPriority(commandId, priority); err != nil {
+		api.writeError(w, http.StatusNotFound, "queue_priority", err)
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, status.Current.GetQueue())
+}
+
+// httpQueueCancelHandler - remove operation from queue before start
+func (api *APIServer) httpQueueCancelHandler(w http.ResponseWriter, r *http.Request) {
+	commandId, err := strconv.Atoi(mux.Vars(r)["command_id"])
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "queue_cancel", err)
+		return
+	}
+	if err = status.Current.CancelQueued(commandId, fmt.Errorf("canceled from API /backup/queue/cancel")); err != nil {
+		api.writeError(w, http.StatusNotFound, "queue_cancel", err)
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, struct {
+		Status    string `json:"status"`
+		Operation string `json:"operation"`
+		CommandId int    `json:"command_id"`
+	}{
+		Status:    "success",
+		Operation: "queue_cancel",
+		CommandId: commandId,
+	})
+}
+
+// httpActionLogHandler - log lines with `command_id` of running or finished command as plain text
+func (api *APIServer) httpActionLogHandler(w http.ResponseWriter, r *http.Request) {
+	commandId, err := strconv.Atoi(mux.Vars(r)["command_id"])
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "log", err)
+		return
+	}
+	commandLog, err := status.Current.GetCommandLog(commandId)
+	if err != nil {
+		api.writeError(w, http.StatusNotFound, "log", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if commandLog != "" {
+		_, _ = fmt.Fprintln(w, commandLog)
+	}
+}
+
+// httpActionEventsHandler - Server-Sent Events with progress and log lines of command, the last event is `status` when command finishes
+func (api *APIServer) httpActionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	commandId, err := strconv.Atoi(mux.Vars(r)["command_id"])
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "events", err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		api.writeError(w, http.StatusInternalServerError, "events", fmt.Errorf("streaming is not supported"))
+		return
+	}
+	events, unsubscribe, err := status.Current.Subscribe(commandId)
+	if err != nil {
+		api.writeError(w, http.StatusNotFound, "events", err)
+		return
+	}
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	sendEvent := func(eventType string, data interface{}) bool {
+		line, err := json.Marshal(data)
+		if err != nil {
+			api.log.Errorf("can't marshal %s event: %v", eventType, err)
+			return true
+		}
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, line); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	sendStatus := func() bool {
+		row, err := status.Current.GetCommand(commandId)
+		if err != nil {
+			return false
+		}
+		sendEvent("status", row)
+		return row.Status == status.InProgressStatus || row.Status == status.QueuedStatus
+	}
+	if !sendStatus() {
+		return
+	}
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err = fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, isOpen := <-events:
+			if !isOpen {
+				sendStatus()
+				return
+			}
+			if !sendEvent(event.Type, event.Data) {
+				return
+			}
+		}
+	}
+}
+
 func (api *APIServer) UpdateBackupMetrics(ctx context.Context, onlyLocal bool) error {
 	// calc lastXXX metrics, fix https://github.com/Altinity/clickhouse-backup/issues/515
 	var lastBackupCreateLocal *time.Time
@@ -1526,7 +2078,7 @@ func (api *APIServer) CreateIntegrationTables() error {
 	if err != nil {
 		return err
 	}
-	query := fmt.Sprintf("CREATE TABLE system.backup_actions (command String, start DateTime, finish DateTime, status String, error String) ENGINE=URL('%s://%s:%s/backup/actions%s', JSONEachRow) %s", schema, host, port, auth, settings)
+	query := fmt.Sprintf("CREATE TABLE system.backup_actions (command String, start DateTime, finish DateTime, status String, error String, log String) ENGINE=URL('%s://%s:%s/backup/actions%s', JSONEachRow) %s", schema, host, port, auth, settings)
 	if err := ch.CreateTable(clickhouse.Table{Database: "system", Name: "backup_actions"}, query, true, false, "", 0, defaultDataPath); err != nil {
 		return err
 	}
@@ -1548,6 +2100,7 @@ func (api *APIServer) ReloadConfig(w http.ResponseWriter, command string) (*conf
 	}
 	api.config = cfg
 	api.log = apexLog.WithField("logger", "server")
+	status.Current.SetLogMaxLines(cfg.API.ActionsLogMaxLines)
 	api.metrics.NumberBackupsRemoteExpected.Set(float64(cfg.General.BackupsToKeepRemote))
 	api.metrics.NumberBackupsLocalExpected.Set(float64(cfg.General.BackupsToKeepLocal))
 	return cfg, nil
@@ -0,0 +1,120 @@
+package status
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+	apexLog "github.com/apex/log"
+)
+
+const (
+	EventProgress = "progress"
+	EventLog      = "log"
+)
+
+// eventsBufferSize - slow subscriber loses events instead of blocking command
+const eventsBufferSize = 1024
+
+type Event struct {
+	Type string
+	Data interface{}
+}
+
+type LogLine struct {
+	Time    string                 `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Subscribe - progress and log events of command, channel is closed when command finishes, call unsubscribe when events are not required anymore
+func (status *AsyncStatus) Subscribe(commandId int) (<-chan Event, func(), error) {
+	status.RLock()
+	defer status.RUnlock()
+	if commandId < 0 || commandId >= len(status.commands) {
+		return nil, nil, fmt.Errorf("command_id=%d not found", commandId)
+	}
+	events := make(chan Event, eventsBufferSize)
+	if status.commands[commandId].Status != InProgressStatus && status.commands[commandId].Status != QueuedStatus {
+		close(events)
+		return events, func() {}, nil
+	}
+	status.subscribersMutex.Lock()
+	defer status.subscribersMutex.Unlock()
+	if status.subscribers == nil {
+		status.subscribers = map[int]map[chan Event]struct{}{}
+	}
+	if status.subscribers[commandId] == nil {
+		status.subscribers[commandId] = map[chan Event]struct{}{}
+	}
+	status.subscribers[commandId][events] = struct{}{}
+	unsubscribe := func() {
+		status.subscribersMutex.Lock()
+		defer status.subscribersMutex.Unlock()
+		if _, exists := status.subscribers[commandId][events]; exists {
+			delete(status.subscribers[commandId], events)
+			close(events)
+		}
+	}
+	return events, unsubscribe, nil
+}
+
+func (status *AsyncStatus) publish(commandId int, event Event) {
+	status.subscribersMutex.Lock()
+	defer status.subscribersMutex.Unlock()
+	for events := range status.subscribers[commandId] {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// closeSubscribers - command is finished, no more events
+func (status *AsyncStatus) closeSubscribers(commandId int) {
+	status.subscribersMutex.Lock()
+	defer status.subscribersMutex.Unlock()
+	for events := range status.subscribers[commandId] {
+		close(events)
+	}
+	delete(status.subscribers, commandId)
+}
+
+// LogHandler - publish entries with `command_id` field to subscribers of command and keep them in command log, all entries are passed to next handler
+type LogHandler struct {
+	next apexLog.Handler
+}
+
+func NewLogHandler(next apexLog.Handler) *LogHandler {
+	return &LogHandler{next: next}
+}
+
+func (h *LogHandler) HandleLog(e *apexLog.Entry) error {
+	if commandId, ok := e.Fields.Get("command_id").(int); ok {
+		line := newLogLine(e)
+		Current.publish(commandId, Event{Type: EventLog, Data: line})
+		Current.AppendLog(commandId, line)
+	}
+	return h.next.HandleLog(e)
+}
+
+func newLogLine(e *apexLog.Entry) LogLine {
+	line := LogLine{
+		Time:    e.Timestamp.Format(common.TimeFormat),
+		Level:   e.Level.String(),
+		Message: e.Message,
+	}
+	names := e.Fields.Names()
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "command_id" {
+			continue
+		}
+		if line.Fields == nil {
+			line.Fields = make(map[string]interface{}, len(names))
+		}
+		line.Fields[name] = fmt.Sprint(e.Fields.Get(name))
+	}
+	return line
+}
@@ -0,0 +1,269 @@
+package status
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+)
+
+const journalMaxLineSize = 16 * 1024 * 1024
+
+// journal - each line is full ActionRowStatus after change, the last line for command_id wins
+type journal struct {
+	path    string
+	file    *os.File
+	size    int64
+	created time.Time
+	maxSize int64
+	maxAge  time.Duration
+}
+
+// OpenJournal - load commands history from journal and append each status change of command to it,
+// journal rotates to `<path>.1` when it grows more than maxSize bytes or becomes older than maxAge, zero disables each limit
+func (status *AsyncStatus) OpenJournal(path string, maxSize int64, maxAge time.Duration) error {
+	status.Lock()
+	defer status.Unlock()
+	if status.journal != nil {
+		return nil
+	}
+	if len(status.commands) > 0 {
+		return fmt.Errorf("journal %s shall be opened before the first command", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("can't create directory for journal %s: %v", path, err)
+	}
+	rotatedRows, err := readJournal(path + ".1")
+	if err != nil {
+		return err
+	}
+	currentRows, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+	rotated, current := mergeJournalRows(rotatedRows, currentRows)
+	// command ids are positions in status.commands, ids from lost rotated journal shall be reused
+	for i := range rotated {
+		rotated[i].CommandId = i
+	}
+	for i := range current {
+		current[i].CommandId = len(rotated) + i
+	}
+	if err = rewriteJournal(path+".1", rotated); err != nil {
+		return err
+	}
+	if err = rewriteJournal(path, current); err != nil {
+		return err
+	}
+	j := &journal{path: path, created: time.Now(), maxSize: maxSize, maxAge: maxAge}
+	if len(current) > 0 {
+		if created, parseErr := time.ParseInLocation(common.TimeFormat, current[0].Start, time.Local); parseErr == nil {
+			j.created = created
+		}
+	}
+	if err = j.open(); err != nil {
+		return err
+	}
+	status.journal = j
+	for _, row := range append(rotated, current...) {
+		status.commands = append(status.commands, ActionRow{ActionRowStatus: row})
+	}
+	status.log.Infof("load %d commands from journal %s", len(status.commands), path)
+	return nil
+}
+
+func (status *AsyncStatus) CloseJournal() {
+	status.Lock()
+	defer status.Unlock()
+	if status.journal == nil {
+		return
+	}
+	if err := status.journal.file.Close(); err != nil {
+		status.log.Warnf("can't close journal %s: %v", status.journal.path, err)
+	}
+	status.journal = nil
+}
+
+// writeJournal - status shall be locked, journal errors don't break commands
+func (status *AsyncStatus) writeJournal(commandId int) {
+	if status.journal == nil {
+		return
+	}
+	line, err := json.Marshal(status.commands[commandId].ActionRowStatus)
+	if err != nil {
+		status.log.Warnf("can't marshal command_id=%d for journal: %v", commandId, err)
+		return
+	}
+	if len(line) > journalMaxLineSize {
+		status.log.Warnf("command_id=%d status is %d bytes, more than %d, it will not be written to journal", commandId, len(line), journalMaxLineSize)
+		return
+	}
+	if err = status.journal.write(append(line, '\n')); err != nil {
+		status.log.Warnf("can't write command_id=%d to journal %s: %v", commandId, status.journal.path, err)
+	}
+}
+
+func (j *journal) open() error {
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("can't open journal %s: %v", j.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("can't stat journal %s: %v", j.path, err)
+	}
+	j.file = file
+	j.size = info.Size()
+	return nil
+}
+
+func (j *journal) write(line []byte) error {
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if (j.maxSize > 0 && j.size >= j.maxSize) || (j.maxAge > 0 && time.Since(j.created) >= j.maxAge) {
+		return j.rotate()
+	}
+	return nil
+}
+
+func (j *journal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(j.path, j.path+".1"); err != nil {
+		return err
+	}
+	j.created = time.Now()
+	return j.open()
+}
+
+// readJournal - the last state of each command in order of first appearance, broken lines and lines longer than journalMaxLineSize are skipped,
+// the last line could be incomplete after crash
+func readJournal(path string) ([]ActionRowStatus, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't open journal %s: %v", path, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	rows := make([]ActionRowStatus, 0)
+	positions := map[int]int{}
+	reader := bufio.NewReader(file)
+	for {
+		line, tooLong, readErr := readJournalLine(reader)
+		if readErr != nil && readErr != io.EOF {
+			return nil, fmt.Errorf("can't read journal %s: %v", path, readErr)
+		}
+		row := ActionRowStatus{}
+		if !tooLong && len(line) > 0 && json.Unmarshal(line, &row) == nil {
+			if i, exists := positions[row.CommandId]; exists {
+				rows[i] = row
+			} else {
+				positions[row.CommandId] = len(rows)
+				rows = append(rows, row)
+			}
+		}
+		if readErr == io.EOF {
+			return rows, nil
+		}
+	}
+}
+
+// readJournalLine - line without `\n`, the rest of line longer than journalMaxLineSize is read and dropped
+func readJournalLine(reader *bufio.Reader) ([]byte, bool, error) {
+	line := make([]byte, 0)
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			if len(line) > journalMaxLineSize {
+				line, tooLong = nil, true
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return bytes.TrimSuffix(line, []byte("\n")), tooLong, err
+	}
+}
+
+// mergeJournalRows - command could start before rotation and finish after it,
+// commands which were in progress during restart will never finish
+func mergeJournalRows(rotatedRows, currentRows []ActionRowStatus) ([]ActionRowStatus, []ActionRowStatus) {
+	rotatedPositions := make(map[int]int, len(rotatedRows))
+	for i, row := range rotatedRows {
+		rotatedPositions[row.CommandId] = i
+	}
+	current := make([]ActionRowStatus, 0, len(currentRows))
+	for _, row := range currentRows {
+		if i, exists := rotatedPositions[row.CommandId]; exists {
+			rotatedRows[i] = row
+			continue
+		}
+		current = append(current, row)
+	}
+	rotated := rotatedRows
+	for _, rows := range [][]ActionRowStatus{rotated, current} {
+		sort.SliceStable(rows, func(i, j int) bool {
+			return rows[i].CommandId < rows[j].CommandId
+		})
+		for i := range rows {
+			if rows[i].Status == InProgressStatus || rows[i].Status == QueuedStatus {
+				rows[i].Status = ErrorStatus
+				rows[i].Error = "interrupted by clickhouse-backup server restart"
+			}
+		}
+	}
+	return rotated, current
+}
+
+// rewriteJournal - replace journal file atomically, empty journal is removed
+func rewriteJournal(path string, rows []ActionRowStatus) error {
+	if len(rows) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("can't remove journal %s: %v", path, err)
+		}
+		return nil
+	}
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("can't create journal %s: %v", tmpPath, err)
+	}
+	w := bufio.NewWriter(file)
+	for _, row := range rows {
+		line, err := json.Marshal(row)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		if _, err = w.Write(append(line, '\n')); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("can't write journal %s: %v", tmpPath, err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("can't write journal %s: %v", tmpPath, err)
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,115 @@
+package status
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalReload(t *testing.T) {
+	journalPath := path.Join(t.TempDir(), "actions.jsonl")
+	s := newTestStatus()
+	require.NoError(t, s.OpenJournal(journalPath, 0, 0))
+	createId, _ := s.Start("create test_backup")
+	s.SetBackupName(createId, "test_backup")
+	s.Stop(createId, nil)
+	uploadId, _ := s.Start("upload test_backup")
+	s.SetBackupName(uploadId, "test_backup")
+	s.AddBytesTransferred(uploadId, 1024)
+	s.Stop(uploadId, fmt.Errorf("upload failed"))
+	s.Start("restore test_backup")
+	s.CloseJournal()
+
+	reloaded := newTestStatus()
+	require.NoError(t, reloaded.OpenJournal(journalPath, 0, 0))
+	rows := reloaded.GetStatus(false, "", 0)
+	require.Len(t, rows, 3)
+	assert.Equal(t, ActionRowStatus{CommandId: 0, Command: "create test_backup", Status: SuccessStatus, Start: rows[0].Start, Finish: rows[0].Finish, BackupName: "test_backup"}, rows[0])
+	assert.Equal(t, ErrorStatus, rows[1].Status)
+	assert.Equal(t, "upload failed", rows[1].Error)
+	assert.Equal(t, uint64(1024), rows[1].BytesTransferred)
+	assert.Equal(t, ErrorStatus, rows[2].Status)
+	assert.Contains(t, rows[2].Error, "restart")
+	assert.Equal(t, []ActionRowStatus{rows[2]}, reloaded.GetStatus(false, "", 1))
+	assert.False(t, reloaded.InProgress())
+
+	// command id continues after loaded history
+	nextId, _ := reloaded.Start("download test_backup")
+	assert.Equal(t, 3, nextId)
+	reloaded.Stop(nextId, nil)
+	reloaded.CancelAll("canceled during server stop")
+	assert.Equal(t, SuccessStatus, reloaded.GetStatus(false, "download", 0)[0].Status)
+	reloaded.CloseJournal()
+	s.CancelAll("canceled during server stop")
+}
+
+func TestReadJournalSkipTooLongLine(t *testing.T) {
+	journalPath := path.Join(t.TempDir(), "actions.jsonl")
+	tooLong := `{"command_id":1,"command":"` + strings.Repeat("x", journalMaxLineSize) + `"}`
+	body := `{"command_id":0,"command":"create test_backup","status":"success"}` + "\n" + tooLong + "\n" + `{"command_id":2,"command":"upload test_backup","status":"success"}`
+	require.NoError(t, os.WriteFile(journalPath, []byte(body), 0640))
+	rows, err := readJournal(journalPath)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "create test_backup", rows[0].Command)
+	assert.Equal(t, "upload test_backup", rows[1].Command)
+}
+
+func TestJournalRotation(t *testing.T) {
+	journalPath := path.Join(t.TempDir(), "actions.jsonl")
+	s := newTestStatus()
+	require.NoError(t, s.OpenJournal(journalPath, 512, 0))
+	for i := 0; i < 20; i++ {
+		commandId, _ := s.Start(fmt.Sprintf("create backup_%d", i))
+		s.Stop(commandId, nil)
+	}
+	s.CloseJournal()
+	info, err := os.Stat(journalPath + ".1")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, info.Size(), int64(512))
+
+	reloaded := newTestStatus()
+	require.NoError(t, reloaded.OpenJournal(journalPath, 512, 0))
+	rows := reloaded.GetStatus(false, "", 0)
+	require.NotEmpty(t, rows)
+	require.Less(t, len(rows), 20)
+	assert.Equal(t, "create backup_19", rows[len(rows)-1].Command)
+	for i, row := range rows {
+		assert.Equal(t, i, row.CommandId)
+		assert.Equal(t, SuccessStatus, row.Status)
+	}
+	reloaded.CloseJournal()
+}
+
+func TestMergeJournalRows(t *testing.T) {
+	rotated, current := mergeJournalRows(
+		[]ActionRowStatus{{CommandId: 5, Command: "create a", Status: SuccessStatus}, {CommandId: 6, Command: "upload a", Status: InProgressStatus}},
+		[]ActionRowStatus{{CommandId: 6, Command: "upload a", Status: SuccessStatus}, {CommandId: 7, Command: "create b", Status: QueuedStatus}},
+	)
+	assert.Equal(t, []ActionRowStatus{{CommandId: 5, Command: "create a", Status: SuccessStatus}, {CommandId: 6, Command: "upload a", Status: SuccessStatus}}, rotated)
+	require.Len(t, current, 1)
+	assert.Equal(t, ErrorStatus, current[0].Status)
+}
+
+func TestJournalAgeRotation(t *testing.T) {
+	journalPath := path.Join(t.TempDir(), "actions.jsonl")
+	s := newTestStatus()
+	require.NoError(t, s.OpenJournal(journalPath, 0, time.Hour))
+	s.journal.created = time.Now().Add(-2 * time.Hour)
+	commandId, _ := s.Start("create old")
+	s.Stop(commandId, nil)
+	s.CloseJournal()
+	rotatedRows, err := readJournal(journalPath + ".1")
+	require.NoError(t, err)
+	require.Len(t, rotatedRows, 1)
+	currentRows, err := readJournal(journalPath)
+	require.NoError(t, err)
+	require.Len(t, currentRows, 1)
+	assert.Equal(t, SuccessStatus, currentRows[0].Status)
+}
@@ -0,0 +1,57 @@
+package status
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SetLogMaxLines - how many last log lines are kept for each command started from API, 0 disables command log
+func (status *AsyncStatus) SetLogMaxLines(maxLines int) {
+	status.Lock()
+	defer status.Unlock()
+	status.logMaxLines = maxLines
+}
+
+// AppendLog - lines which are logged after command finish are dropped
+func (status *AsyncStatus) AppendLog(commandId int, line LogLine) {
+	status.Lock()
+	defer status.Unlock()
+	status.appendLog(commandId, line)
+}
+
+// appendLog - status shall be locked
+func (status *AsyncStatus) appendLog(commandId int, line LogLine) {
+	if status.logMaxLines <= 0 || commandId < 0 || commandId >= len(status.commands) || status.commands[commandId].Status != InProgressStatus {
+		return
+	}
+	row := &status.commands[commandId]
+	row.logLines = append(row.logLines, line.String())
+	if extra := len(row.logLines) - status.logMaxLines; extra > 0 {
+		row.logLines = append(row.logLines[:0], row.logLines[extra:]...)
+	}
+}
+
+// GetCommandLog - log lines of running or finished command, log is kept only in memory
+func (status *AsyncStatus) GetCommandLog(commandId int) (string, error) {
+	status.RLock()
+	defer status.RUnlock()
+	if commandId < 0 || commandId >= len(status.commands) {
+		return "", fmt.Errorf("command_id=%d not found", commandId)
+	}
+	return strings.Join(status.commands[commandId].logLines, "\n"), nil
+}
+
+// String - the same format as logcli, without message padding
+func (line LogLine) String() string {
+	s := fmt.Sprintf("%s %5s %s", line.Time, line.Level, line.Message)
+	names := make([]string, 0, len(line.Fields))
+	for name := range line.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s += fmt.Sprintf(" %s=%v", name, line.Fields[name])
+	}
+	return s
+}
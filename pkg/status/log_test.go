@@ -0,0 +1,83 @@
+package status
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	apexLog "github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandLog(t *testing.T) {
+	s := newTestStatus()
+	s.SetLogMaxLines(2)
+	commandId, _ := s.Start("upload test_backup")
+	s.AppendLog(commandId, LogLine{Time: "2024-01-01 00:00:00", Level: "info", Message: "first"})
+	s.AppendLog(commandId, LogLine{Time: "2024-01-01 00:00:01", Level: "info", Message: "second", Fields: map[string]interface{}{"table": "default.test", "backup": "test_backup"}})
+	commandLog, err := s.GetCommandLog(commandId)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-01 00:00:00  info first\n2024-01-01 00:00:01  info second backup=test_backup table=default.test", commandLog)
+
+	s.Stop(commandId, fmt.Errorf("can't upload"))
+	commandLog, err = s.GetCommandLog(commandId)
+	require.NoError(t, err)
+	lines := strings.Split(commandLog, "\n")
+	require.Len(t, lines, 2, "only the last lines are kept")
+	assert.Contains(t, lines[0], "second")
+	assert.Contains(t, lines[1], "error can't upload")
+
+	// finished command log is not changed
+	s.AppendLog(commandId, LogLine{Message: "late"})
+	lateLog, err := s.GetCommandLog(commandId)
+	require.NoError(t, err)
+	assert.Equal(t, commandLog, lateLog)
+	_, err = s.GetCommandLog(commandId + 1)
+	assert.Error(t, err)
+
+	disabled := newTestStatus()
+	commandId, _ = disabled.Start("create test_backup")
+	disabled.AppendLog(commandId, LogLine{Message: "skipped"})
+	disabled.Stop(commandId, nil)
+	commandLog, err = disabled.GetCommandLog(commandId)
+	require.NoError(t, err)
+	assert.Empty(t, commandLog)
+}
+
+func TestCommandLogJournal(t *testing.T) {
+	journalPath := path.Join(t.TempDir(), "actions.jsonl")
+	s := newTestStatus()
+	s.SetLogMaxLines(10)
+	require.NoError(t, s.OpenJournal(journalPath, 0, 0))
+	commandId, _ := s.Start("download test_backup")
+	s.AppendLog(commandId, LogLine{Level: "info", Message: "download started"})
+	s.Stop(commandId, fmt.Errorf("download failed"))
+	s.CloseJournal()
+
+	// log is not a part of status, so journal and /backup/actions don't grow with it
+	body, err := os.ReadFile(journalPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "download started")
+	commandLog, err := s.GetCommandLog(commandId)
+	require.NoError(t, err)
+	assert.Contains(t, commandLog, "download started")
+	assert.Contains(t, commandLog, "download failed")
+}
+
+func TestLogHandlerAppendLog(t *testing.T) {
+	Current.SetLogMaxLines(10)
+	defer Current.SetLogMaxLines(0)
+	commandId, _ := Current.Start("restore log_handler")
+	logger := &apexLog.Logger{Handler: NewLogHandler(discard.New()), Level: apexLog.InfoLevel}
+	logger.WithField("command_id", commandId).Info("restored")
+	logger.Info("other command")
+	Current.Stop(commandId, nil)
+	commandLog, err := Current.GetCommandLog(commandId)
+	require.NoError(t, err)
+	assert.Contains(t, commandLog, "restored")
+	assert.NotContains(t, commandLog, "other command")
+}
@@ -0,0 +1,138 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+)
+
+const (
+	ProgressFormatBar  = "bar"
+	ProgressFormatJSON = "json"
+)
+
+// progressInterval - progress is published and printed not often than once per interval, except when table is done
+const progressInterval = time.Second
+
+// Progress - current operation of command, create_remote and restore_remote report each operation separately
+type Progress struct {
+	Operation   string `json:"operation"`
+	BytesDone   uint64 `json:"bytes_done"`
+	BytesTotal  uint64 `json:"bytes_total"`
+	TablesDone  uint64 `json:"tables_done"`
+	TablesTotal uint64 `json:"tables_total"`
+}
+
+type commandIdKey struct{}
+
+type bytesProgressKey struct{}
+
+func withCommandId(ctx context.Context, commandId int) context.Context {
+	return context.WithValue(ctx, commandIdKey{}, commandId)
+}
+
+// CommandIdFromContext - NotFromAPI when context is not created by Start or Enqueue
+func CommandIdFromContext(ctx context.Context) int {
+	if commandId, ok := ctx.Value(commandIdKey{}).(int); ok {
+		return commandId
+	}
+	return NotFromAPI
+}
+
+// WithBytesProgress - bytes counted by progress bars of storage package are added to progress of command from ctx,
+// only data transfers are marked, so metadata, RBAC and config files are not counted
+func WithBytesProgress(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bytesProgressKey{}, true)
+}
+
+// IsBytesProgress - ctx is marked by WithBytesProgress
+func IsBytesProgress(ctx context.Context) bool {
+	isBytesProgress, _ := ctx.Value(bytesProgressKey{}).(bool)
+	return isBytesProgress
+}
+
+// SetProgressFormat - `json` prints progress of commands which are not from API to stdout as JSON lines
+func (status *AsyncStatus) SetProgressFormat(format string) error {
+	if format != ProgressFormatBar && format != ProgressFormatJSON {
+		return fmt.Errorf("unknown progress format `%s`, expected %s or %s", format, ProgressFormatBar, ProgressFormatJSON)
+	}
+	status.Lock()
+	defer status.Unlock()
+	status.progressFormat = format
+	return nil
+}
+
+// ProgressFormat - progress bars shall not be shown for `json`, they would break JSON lines in stdout
+func (status *AsyncStatus) ProgressFormat() string {
+	status.RLock()
+	defer status.RUnlock()
+	return status.progressFormat
+}
+
+// StartProgress - reset progress of command from ctx, zero total means unknown
+func (status *AsyncStatus) StartProgress(ctx context.Context, operation string, tablesTotal, bytesTotal uint64) {
+	status.updateProgress(ctx, func(p *Progress) bool {
+		*p = Progress{Operation: operation, TablesTotal: tablesTotal, BytesTotal: bytesTotal}
+		return true
+	})
+}
+
+// AddProgress - safe for concurrent go-routines, total grows when done exceeds it
+func (status *AsyncStatus) AddProgress(ctx context.Context, tables, bytes uint64) {
+	status.updateProgress(ctx, func(p *Progress) bool {
+		p.TablesDone += tables
+		p.BytesDone += bytes
+		if p.TablesDone > p.TablesTotal {
+			p.TablesTotal = p.TablesDone
+		}
+		if p.BytesDone > p.BytesTotal {
+			p.BytesTotal = p.BytesDone
+		}
+		return tables > 0
+	})
+}
+
+// updateProgress - update returns true when progress shall be published and printed immediately
+func (status *AsyncStatus) updateProgress(ctx context.Context, update func(p *Progress) bool) {
+	commandId := CommandIdFromContext(ctx)
+	status.Lock()
+	defer status.Unlock()
+	if commandId == NotFromAPI {
+		isForced := update(&status.cliProgress)
+		if status.progressFormat == ProgressFormatJSON && (isForced || time.Since(status.cliProgressPrinted) >= progressInterval) {
+			status.cliProgressPrinted = time.Now()
+			printProgress(status.cliProgress)
+		}
+		return
+	}
+	if commandId >= len(status.commands) {
+		return
+	}
+	row := &status.commands[commandId]
+	if row.Progress == nil {
+		row.Progress = &Progress{}
+	}
+	isForced := update(row.Progress)
+	if isForced || time.Since(row.progressPublished) >= progressInterval {
+		row.progressPublished = time.Now()
+		status.publish(commandId, Event{Type: EventProgress, Data: *row.Progress})
+	}
+}
+
+func printProgress(p Progress) {
+	line, err := json.Marshal(struct {
+		Time string `json:"time"`
+		Progress
+	}{
+		Time:     time.Now().Format(common.TimeFormat),
+		Progress: p,
+	})
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintln(os.Stdout, string(line))
+}
@@ -0,0 +1,99 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandIdFromContext(t *testing.T) {
+	s := newTestStatus()
+	commandId, ctx := s.Start("create test_backup")
+	assert.Equal(t, commandId, CommandIdFromContext(ctx))
+	assert.Equal(t, NotFromAPI, CommandIdFromContext(context.Background()))
+	assert.False(t, IsBytesProgress(ctx))
+	assert.True(t, IsBytesProgress(WithBytesProgress(ctx)))
+	assert.Equal(t, commandId, CommandIdFromContext(WithBytesProgress(ctx)))
+	s.Stop(commandId, nil)
+}
+
+func TestAddProgress(t *testing.T) {
+	s := newTestStatus()
+	commandId, ctx := s.Start("upload test_backup")
+	s.StartProgress(ctx, "upload", 2, 100)
+	s.AddProgress(ctx, 0, 60)
+	s.AddProgress(ctx, 1, 0)
+	assert.Equal(t, &Progress{Operation: "upload", BytesDone: 60, BytesTotal: 100, TablesDone: 1, TablesTotal: 2}, s.GetStatus(true, "", 0)[0].Progress)
+	// totals could be estimated too low
+	s.AddProgress(ctx, 2, 60)
+	assert.Equal(t, &Progress{Operation: "upload", BytesDone: 120, BytesTotal: 120, TablesDone: 3, TablesTotal: 3}, s.GetStatus(true, "", 0)[0].Progress)
+
+	s.StartProgress(ctx, "restore", 1, 0)
+	assert.Equal(t, &Progress{Operation: "restore", TablesTotal: 1}, s.GetStatus(true, "", 0)[0].Progress)
+	s.Stop(commandId, nil)
+
+	// command which is not from API doesn't change commands
+	s.StartProgress(context.Background(), "create", 1, 1)
+	s.AddProgress(context.Background(), 1, 1)
+	assert.Equal(t, Progress{Operation: "create", BytesDone: 1, BytesTotal: 1, TablesDone: 1, TablesTotal: 1}, s.cliProgress)
+	assert.Len(t, s.GetStatus(false, "", 0), 1)
+	assert.Error(t, s.SetProgressFormat("xml"))
+}
+
+func TestSubscribe(t *testing.T) {
+	s := newTestStatus()
+	commandId, ctx := s.Start("download test_backup")
+	events, unsubscribe, err := s.Subscribe(commandId)
+	require.NoError(t, err)
+	defer unsubscribe()
+	s.StartProgress(ctx, "download", 1, 10)
+	event := <-events
+	assert.Equal(t, Event{Type: EventProgress, Data: Progress{Operation: "download", BytesTotal: 10, TablesTotal: 1}}, event)
+
+	s.Stop(commandId, fmt.Errorf("download failed"))
+	_, isOpen := <-events
+	assert.False(t, isOpen, "events shall be closed after command finish")
+
+	finished, _, err := s.Subscribe(commandId)
+	require.NoError(t, err)
+	_, isOpen = <-finished
+	assert.False(t, isOpen)
+	_, _, err = s.Subscribe(commandId + 1)
+	assert.Error(t, err)
+}
+
+func TestLogHandler(t *testing.T) {
+	commandId, _ := Current.Start("restore test_backup")
+	defer Current.Stop(commandId, nil)
+	events, unsubscribe, err := Current.Subscribe(commandId)
+	require.NoError(t, err)
+	defer unsubscribe()
+
+	next := memory.New()
+	logger := &apexLog.Logger{Handler: NewLogHandler(next), Level: apexLog.InfoLevel}
+	logger.WithField("command_id", commandId).WithField("table", "default.test").Info("done")
+	logger.WithField("table", "default.other").Info("not published")
+	require.Len(t, next.Entries, 2)
+
+	select {
+	case event := <-events:
+		require.Equal(t, EventLog, event.Type)
+		line := event.Data.(LogLine)
+		assert.Equal(t, "done", line.Message)
+		assert.Equal(t, "info", line.Level)
+		assert.Equal(t, map[string]interface{}{"table": "default.test"}, line.Fields)
+	case <-time.After(time.Second):
+		t.Fatal("log event was not published")
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %v", event)
+	default:
+	}
+}
@@ -0,0 +1,163 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/common"
+)
+
+// QueuedCommand - command which waits in queue, Position 0 will start next
+type QueuedCommand struct {
+	CommandId int    `json:"command_id"`
+	Command   string `json:"command"`
+	Priority  int    `json:"priority"`
+	Position  int    `json:"position"`
+	Queued    string `json:"queued"`
+}
+
+// Enqueue - command starts immediately when queue is empty and no other command in progress, otherwise waits in queue,
+// commands with higher priority start first, commands with the same priority start in FIFO order
+func (status *AsyncStatus) Enqueue(command string, priority int, run func(commandId int, ctx context.Context)) (int, bool) {
+	status.Lock()
+	defer status.Unlock()
+	ctx, cancel := context.WithCancel(withCommandId(context.Background(), len(status.commands)))
+	status.commands = append(status.commands, ActionRow{
+		ActionRowStatus: ActionRowStatus{
+			CommandId: len(status.commands),
+			Command:   command,
+			Status:    QueuedStatus,
+		},
+		Ctx:      ctx,
+		Cancel:   cancel,
+		queued:   time.Now(),
+		priority: priority,
+		run:      run,
+	})
+	commandId := len(status.commands) - 1
+	status.writeJournal(commandId)
+	status.queue = append(status.queue, commandId)
+	status.sortQueue()
+	status.startQueued()
+	isQueued := status.commands[commandId].Status == QueuedStatus
+	if isQueued {
+		status.log.Debugf("api.status.Enqueue -> status.commands[%d] == %+v, queue length %d", commandId, status.commands[commandId].ActionRowStatus, len(status.queue))
+	}
+	return commandId, isQueued
+}
+
+// GetQueue - queued commands in start order
+func (status *AsyncStatus) GetQueue() []QueuedCommand {
+	status.RLock()
+	defer status.RUnlock()
+	queue := make([]QueuedCommand, 0, len(status.queue))
+	for _, commandId := range status.queue {
+		row := status.commands[commandId]
+		if row.Status != QueuedStatus {
+			continue
+		}
+		queue = append(queue, QueuedCommand{
+			CommandId: commandId,
+			Command:   row.Command,
+			Priority:  row.priority,
+			Position:  len(queue),
+			Queued:    row.queued.Format(common.TimeFormat),
+		})
+	}
+	return queue
+}
+
+// SetQueuedPriority - reorder queue, command moves before all commands with lower priority
+func (status *AsyncStatus) SetQueuedPriority(commandId int, priority int) error {
+	status.Lock()
+	defer status.Unlock()
+	if err := status.checkQueued(commandId); err != nil {
+		return err
+	}
+	status.commands[commandId].priority = priority
+	status.sortQueue()
+	return nil
+}
+
+// CancelQueued - remove command from queue, command will not start
+func (status *AsyncStatus) CancelQueued(commandId int, err error) error {
+	status.Lock()
+	defer status.Unlock()
+	if checkErr := status.checkQueued(commandId); checkErr != nil {
+		return checkErr
+	}
+	status.commands[commandId].Cancel()
+	status.commands[commandId].Ctx = nil
+	status.commands[commandId].Cancel = nil
+	status.commands[commandId].run = nil
+	status.commands[commandId].Status = CancelStatus
+	status.commands[commandId].Error = err.Error()
+	status.commands[commandId].Finish = time.Now().Format(common.TimeFormat)
+	status.writeJournal(commandId)
+	status.closeSubscribers(commandId)
+	status.removeFromQueue(commandId)
+	return nil
+}
+
+func (status *AsyncStatus) checkQueued(commandId int) error {
+	if commandId < 0 || commandId >= len(status.commands) {
+		return fmt.Errorf("command_id=%d not found", commandId)
+	}
+	if status.commands[commandId].Status != QueuedStatus {
+		return fmt.Errorf("command_id=%d `%s` is not queued, status=%s", commandId, status.commands[commandId].Command, status.commands[commandId].Status)
+	}
+	return nil
+}
+
+// startQueued - start first queued command when no other command in progress, status shall be locked
+func (status *AsyncStatus) startQueued() {
+	// canceled via Cancel or CancelAll commands still could be in queue
+	for len(status.queue) > 0 && status.commands[status.queue[0]].Status != QueuedStatus {
+		status.queue = status.queue[1:]
+	}
+	if len(status.queue) == 0 || status.isQueueBlocked() {
+		return
+	}
+	commandId := status.queue[0]
+	status.queue = status.queue[1:]
+	row := &status.commands[commandId]
+	row.Status = InProgressStatus
+	row.Start = time.Now().Format(common.TimeFormat)
+	row.running = true
+	run, ctx := row.run, row.Ctx
+	row.run = nil
+	status.log.Debugf("api.status.startQueued -> status.commands[%d] == %+v", commandId, row.ActionRowStatus)
+	status.writeJournal(commandId)
+	go run(commandId, ctx)
+}
+
+// isQueueBlocked - command blocks queue until it calls Stop, watch blocks queue only while its create_remote and delete are running
+func (status *AsyncStatus) isQueueBlocked() bool {
+	for _, row := range status.commands {
+		if row.running && !row.idle {
+			return true
+		}
+	}
+	return false
+}
+
+func (status *AsyncStatus) sortQueue() {
+	sort.SliceStable(status.queue, func(i, j int) bool {
+		a, b := status.commands[status.queue[i]], status.commands[status.queue[j]]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return status.queue[i] < status.queue[j]
+	})
+}
+
+func (status *AsyncStatus) removeFromQueue(commandId int) {
+	for i, queuedId := range status.queue {
+		if queuedId == commandId {
+			status.queue = append(status.queue[:i], status.queue[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStatus() *AsyncStatus {
+	return &AsyncStatus{log: apexLog.WithField("logger", "status")}
+}
+
+func TestEnqueueOrder(t *testing.T) {
+	s := newTestStatus()
+	started := make(chan int, 10)
+	run := func(commandId int, ctx context.Context) {
+		started <- commandId
+	}
+	first, isQueued := s.Enqueue("create first", 0, run)
+	require.False(t, isQueued)
+	require.Equal(t, first, <-started)
+
+	second, isQueued := s.Enqueue("upload second", 0, run)
+	require.True(t, isQueued)
+	third, _ := s.Enqueue("download third", 5, run)
+	fourth, _ := s.Enqueue("restore fourth", 0, run)
+	assert.True(t, s.InProgress(), "first command shall be in progress")
+
+	queue := s.GetQueue()
+	require.Len(t, queue, 3)
+	assert.Equal(t, []int{third, second, fourth}, []int{queue[0].CommandId, queue[1].CommandId, queue[2].CommandId})
+	assert.Equal(t, 2, queue[2].Position)
+
+	require.NoError(t, s.SetQueuedPriority(fourth, 10))
+	require.NoError(t, s.CancelQueued(second, fmt.Errorf("canceled")))
+	assert.Equal(t, CancelStatus, s.GetStatus(false, "upload second", 0)[0].Status)
+	assert.Error(t, s.CancelQueued(second, fmt.Errorf("canceled")))
+	assert.Error(t, s.SetQueuedPriority(first, 1))
+
+	s.Stop(first, nil)
+	require.Equal(t, fourth, <-started)
+	s.Stop(fourth, nil)
+	require.Equal(t, third, <-started)
+	s.Stop(third, nil)
+	assert.Empty(t, s.GetQueue())
+	assert.False(t, s.InProgress())
+}
+
+func TestEnqueueIdleWatch(t *testing.T) {
+	s := newTestStatus()
+	watchId, _ := s.Start("watch --watch-interval=1h")
+	started := make(chan int, 1)
+	commandId, isQueued := s.Enqueue("create_remote", 0, func(commandId int, ctx context.Context) {
+		require.NoError(t, ctx.Err())
+		started <- commandId
+	})
+	assert.True(t, isQueued, "watch blocks queue while its sub-operation is running")
+	s.SetIdle(watchId, true)
+	assert.Equal(t, commandId, <-started)
+	assert.NotEqual(t, watchId, commandId)
+}
+
+func TestCancelStartsQueuedAfterStop(t *testing.T) {
+	s := newTestStatus()
+	started := make(chan int, 1)
+	first, _ := s.Start("restore_remote first")
+	second, isQueued := s.Enqueue("create second", 0, func(commandId int, ctx context.Context) {
+		started <- commandId
+	})
+	require.True(t, isQueued)
+	require.NoError(t, s.Cancel("restore_remote first", fmt.Errorf("canceled")))
+	assert.Len(t, s.GetQueue(), 1, "canceled command is still unwinding")
+	s.Stop(first, context.Canceled)
+	assert.Equal(t, second, <-started)
+	assert.Equal(t, CancelStatus, s.GetStatus(false, "restore_remote first", 0)[0].Status)
+}
@@ -2,6 +2,7 @@ package status
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/common"
 	apexLog "github.com/apex/log"
@@ -12,6 +13,7 @@ import (
 
 const (
 	InProgressStatus = "in progress"
+	QueuedStatus     = "queued"
 	SuccessStatus    = "success"
 	CancelStatus     = "cancel"
 	ErrorStatus      = "error"
@@ -26,38 +28,80 @@ const NotFromAPI = int(-1)
 type AsyncStatus struct {
 	commands []ActionRow
 	log      *apexLog.Entry
+	// ids of queued commands in start order
+	queue   []int
+	journal *journal
+	// progress of command which is not from API
+	cliProgress        Progress
+	cliProgressPrinted time.Time
+	progressFormat     string
+	subscribers        map[int]map[chan Event]struct{}
+	subscribersMutex   sync.Mutex
+	logMaxLines        int
 	sync.RWMutex
 }
 
 type ActionRowStatus struct {
-	Command string `json:"command"`
-	Status  string `json:"status"`
-	Start   string `json:"start,omitempty"`
-	Finish  string `json:"finish,omitempty"`
-	Error   string `json:"error,omitempty"`
+	CommandId        int                 `json:"command_id"`
+	Command          string              `json:"command"`
+	Status           string              `json:"status"`
+	Start            string              `json:"start,omitempty"`
+	Finish           string              `json:"finish,omitempty"`
+	Error            string              `json:"error,omitempty"`
+	BackupName       string              `json:"backup_name,omitempty"`
+	BytesTransferred uint64              `json:"bytes_transferred,omitempty"`
+	Progress         *Progress           `json:"progress,omitempty"`
+	Validation       []RestoreValidation `json:"validation,omitempty"`
+	Plan             json.RawMessage     `json:"plan,omitempty"`
+}
+
+// RestoreValidation - rows count of restored table compared with rows count from backup metadata
+type RestoreValidation struct {
+	Database                  string `json:"database"`
+	Table                     string `json:"table"`
+	Status                    string `json:"status"`
+	ExpectedRows              uint64 `json:"expected_rows"`
+	RestoredRows              uint64 `json:"restored_rows"`
+	ExpectedUncompressedBytes uint64 `json:"expected_uncompressed_bytes"`
+	RestoredUncompressedBytes uint64 `json:"restored_uncompressed_bytes"`
+	Error                     string `json:"error,omitempty"`
 }
 
 type ActionRow struct {
 	ActionRowStatus
-	Ctx    context.Context
-	Cancel context.CancelFunc
+	Ctx      context.Context
+	Cancel   context.CancelFunc
+	queued   time.Time
+	priority int
+	run      func(commandId int, ctx context.Context)
+	// running - go-routine of command is not finished yet, canceled command keeps running until it calls Stop
+	running bool
+	// idle - long-running command, like watch, waits for its next sub-operation and doesn't block queue
+	idle bool
+
+	progressPublished time.Time
+	// logLines - served only by GetCommandLog, they are not a part of ActionRowStatus, so status responses and journal don't grow with log
+	logLines []string
 }
 
 func (status *AsyncStatus) Start(command string) (int, context.Context) {
 	status.Lock()
 	defer status.Unlock()
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(withCommandId(context.Background(), len(status.commands)))
 	status.commands = append(status.commands, ActionRow{
 		ActionRowStatus: ActionRowStatus{
-			Command: command,
-			Start:   time.Now().Format(common.TimeFormat),
-			Status:  InProgressStatus,
+			CommandId: len(status.commands),
+			Command:   command,
+			Start:     time.Now().Format(common.TimeFormat),
+			Status:    InProgressStatus,
 		},
-		Ctx:    ctx,
-		Cancel: cancel,
+		Ctx:     ctx,
+		Cancel:  cancel,
+		running: true,
 	})
 	lastCommandId := len(status.commands) - 1
 	status.log.Debugf("api.status.Start -> status.commands[%d] == %+v", lastCommandId, status.commands[lastCommandId])
+	status.writeJournal(lastCommandId)
 	return lastCommandId, ctx
 }
 
@@ -76,6 +120,10 @@ func (status *AsyncStatus) InProgress() bool {
 	status.RLock()
 	defer status.RUnlock()
 	n := len(status.commands) - 1
+	// queued commands are not started yet
+	for n >= 0 && status.commands[n].Status == QueuedStatus {
+		n--
+	}
 	if n < 0 {
 		status.log.Debugf("api.status.inProgress -> len(status.commands)=%d, inProgress=false", len(status.commands))
 		return false
@@ -104,6 +152,11 @@ func (status *AsyncStatus) Stop(commandId int, err error) {
 	status.Lock()
 	defer status.Unlock()
 	if status.commands[commandId].Status != InProgressStatus {
+		// canceled command is stopped only now, next queued command could start
+		if status.commands[commandId].running {
+			status.commands[commandId].running = false
+			status.startQueued()
+		}
 		return
 	}
 	status.commands[commandId].Cancel()
@@ -111,12 +164,85 @@ func (status *AsyncStatus) Stop(commandId int, err error) {
 	if err != nil {
 		s = ErrorStatus
 		status.commands[commandId].Error = err.Error()
+		// error is returned to caller and often is not logged with command_id
+		status.appendLog(commandId, LogLine{Time: time.Now().Format(common.TimeFormat), Level: "error", Message: err.Error()})
 	}
 	status.commands[commandId].Status = s
 	status.commands[commandId].Finish = time.Now().Format(common.TimeFormat)
 	status.commands[commandId].Ctx = nil
 	status.commands[commandId].Cancel = nil
+	status.commands[commandId].running = false
 	status.log.Debugf("api.status.stop -> status.commands[%d] == %+v", commandId, status.commands[commandId])
+	status.writeJournal(commandId)
+	status.closeSubscribers(commandId)
+	status.startQueued()
+}
+
+func (status *AsyncStatus) AddRestoreValidation(commandId int, validation []RestoreValidation) {
+	if commandId == NotFromAPI || len(validation) == 0 {
+		return
+	}
+	status.Lock()
+	defer status.Unlock()
+	if commandId >= len(status.commands) {
+		return
+	}
+	status.commands[commandId].Validation = append(status.commands[commandId].Validation, validation...)
+}
+
+// SetRestorePlan - result of restore --dry-run, API callers get it from /backup/actions
+func (status *AsyncStatus) SetRestorePlan(commandId int, plan json.RawMessage) {
+	if commandId == NotFromAPI {
+		return
+	}
+	status.Lock()
+	defer status.Unlock()
+	if commandId >= len(status.commands) {
+		return
+	}
+	status.commands[commandId].Plan = plan
+}
+
+// SetIdle - long-running command marks itself idle while it waits for the next sub-operation, queued commands could start meanwhile
+func (status *AsyncStatus) SetIdle(commandId int, idle bool) {
+	if commandId == NotFromAPI {
+		return
+	}
+	status.Lock()
+	defer status.Unlock()
+	if commandId >= len(status.commands) {
+		return
+	}
+	status.commands[commandId].idle = idle
+	if idle {
+		status.startQueued()
+	}
+}
+
+// SetBackupName - backup name could be generated during command execution, when it is not passed from API
+func (status *AsyncStatus) SetBackupName(commandId int, backupName string) {
+	if commandId == NotFromAPI || backupName == "" {
+		return
+	}
+	status.Lock()
+	defer status.Unlock()
+	if commandId >= len(status.commands) {
+		return
+	}
+	status.commands[commandId].BackupName = backupName
+}
+
+// AddBytesTransferred - create_remote and restore_remote sum bytes of upload and download
+func (status *AsyncStatus) AddBytesTransferred(commandId int, bytes uint64) {
+	if commandId == NotFromAPI {
+		return
+	}
+	status.Lock()
+	defer status.Unlock()
+	if commandId >= len(status.commands) {
+		return
+	}
+	status.commands[commandId].BytesTransferred += bytes
 }
 
 func (status *AsyncStatus) Cancel(command string, err error) error {
@@ -160,6 +286,9 @@ func (status *AsyncStatus) Cancel(command string, err error) error {
 	status.commands[commandId].Status = CancelStatus
 	status.commands[commandId].Finish = time.Now().Format(common.TimeFormat)
 	status.log.Debugf("api.status.cancel -> status.commands[%d] == %+v", commandId, status.commands[commandId])
+	status.writeJournal(commandId)
+	status.closeSubscribers(commandId)
+	// next queued command starts when canceled command calls Stop after unwinding
 	return nil
 }
 
@@ -167,16 +296,24 @@ func (status *AsyncStatus) CancelAll(cancelMsg string) {
 	status.Lock()
 	defer status.Unlock()
 	for commandId := range status.commands {
+		// finished commands could be loaded from journal, keep their status
+		if status.commands[commandId].Status != InProgressStatus && status.commands[commandId].Status != QueuedStatus {
+			continue
+		}
 		if status.commands[commandId].Ctx != nil {
 			status.commands[commandId].Cancel()
 			status.commands[commandId].Ctx = nil
 			status.commands[commandId].Cancel = nil
 		}
+		status.commands[commandId].run = nil
 		status.commands[commandId].Status = CancelStatus
 		status.commands[commandId].Error = cancelMsg
 		status.commands[commandId].Finish = time.Now().Format(common.TimeFormat)
 		status.log.Debugf("api.status.cancel -> status.commands[%d] == %+v", commandId, status.commands[commandId])
+		status.writeJournal(commandId)
+		status.closeSubscribers(commandId)
 	}
+	status.queue = nil
 }
 
 func (status *AsyncStatus) GetStatus(current bool, filter string, last int) []ActionRowStatus {
@@ -193,14 +330,7 @@ func (status *AsyncStatus) GetStatus(current bool, filter string, last int) []Ac
 	filteredCommands := make([]ActionRowStatus, 0)
 	for _, command := range status.commands {
 		if filter == "" || (strings.Contains(command.Command, filter) || strings.Contains(command.Status, filter) || strings.Contains(command.Error, filter)) {
-			// copy without context and cancel
-			filteredCommands = append(filteredCommands, ActionRowStatus{
-				Command: command.Command,
-				Status:  command.Status,
-				Start:   command.Start,
-				Finish:  command.Finish,
-				Error:   command.Error,
-			})
+			filteredCommands = append(filteredCommands, command.copyStatus())
 		}
 	}
 	if len(filteredCommands) == 0 {
@@ -218,3 +348,23 @@ func (status *AsyncStatus) GetStatus(current bool, filter string, last int) []Ac
 	}
 	return filteredCommands[begin:end]
 }
+
+// GetCommand - status of one command, for /backup/actions/{command_id}/events
+func (status *AsyncStatus) GetCommand(commandId int) (ActionRowStatus, error) {
+	status.RLock()
+	defer status.RUnlock()
+	if commandId < 0 || commandId >= len(status.commands) {
+		return ActionRowStatus{}, fmt.Errorf("command_id=%d not found", commandId)
+	}
+	return status.commands[commandId].copyStatus(), nil
+}
+
+// copyStatus - copy without context and cancel, progress is changed by running command
+func (row *ActionRow) copyStatus() ActionRowStatus {
+	rowStatus := row.ActionRowStatus
+	if rowStatus.Progress != nil {
+		progress := *rowStatus.Progress
+		rowStatus.Progress = &progress
+	}
+	return rowStatus
+}
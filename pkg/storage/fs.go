@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/apex/log"
+)
+
+// FS Implement RemoteStorage on top of local filesystem, usually mounted NFS / CIFS share or separate disk
+type FS struct {
+	Config *config.FSConfig
+}
+
+func (f *FS) Debug(msg string, v ...interface{}) {
+	if f.Config.Debug {
+		log.Infof(msg, v...)
+	}
+}
+
+func (f *FS) Kind() string {
+	return "FS"
+}
+
+func (f *FS) Connect(ctx context.Context) error {
+	f.Debug("[FS_DEBUG] Connect %s", f.Config.Path)
+	if err := os.MkdirAll(f.Config.Path, 0750); err != nil {
+		return fmt.Errorf("can't create fs->path %s: %v", f.Config.Path, err)
+	}
+	stat, err := os.Stat(f.Config.Path)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("fs->path %s is not a directory", f.Config.Path)
+	}
+	return nil
+}
+
+func (f *FS) Close(ctx context.Context) error {
+	return nil
+}
+
+func (f *FS) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	filePath := path.Join(f.Config.Path, key)
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		f.Debug("[FS_DEBUG] StatFile::STAT %s return error %v", filePath, err)
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &fsFile{
+		size:         stat.Size(),
+		lastModified: stat.ModTime(),
+		name:         stat.Name(),
+	}, nil
+}
+
+// DeleteFile remove file or whole directory with nested content, and cleanup empty parent directories
+func (f *FS) DeleteFile(ctx context.Context, key string) error {
+	f.Debug("[FS_DEBUG] Delete %s", key)
+	filePath := path.Join(f.Config.Path, key)
+	if err := os.RemoveAll(filePath); err != nil {
+		return err
+	}
+	f.removeEmptyParents(path.Dir(filePath))
+	return nil
+}
+
+func (f *FS) removeEmptyParents(dir string) {
+	root := path.Clean(f.Config.Path)
+	for dir != root && dir != "." && dir != "/" && len(dir) > len(root) {
+		// os.Remove fails on non-empty directory, it's expected
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+func (f *FS) Walk(ctx context.Context, remotePath string, recursive bool, process func(context.Context, RemoteFile) error) error {
+	dir := path.Join(f.Config.Path, remotePath)
+	f.Debug("[FS_DEBUG] Walk %s, recursive=%v", dir, recursive)
+
+	if recursive {
+		return filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				// the same behavior as object storage, when prefix not exists
+				if os.IsNotExist(err) && filePath == dir {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			relName, err := filepath.Rel(dir, filePath)
+			if err != nil {
+				return err
+			}
+			return process(ctx, &fsFile{
+				size:         info.Size(),
+				lastModified: info.ModTime(),
+				name:         filepath.ToSlash(relName),
+			})
+		})
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		f.Debug("[FS_DEBUG] Walk::NonRecursive::ReadDir %s return error %v", dir, err)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err = process(ctx, &fsFile{
+			size:         info.Size(),
+			lastModified: info.ModTime(),
+			name:         entry.Name(),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FS) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(path.Join(f.Config.Path, key))
+	if err != nil {
+		return nil, err
+	}
+	// don't return *os.File directly, DownloadCompressedStream remove *os.File after read, cause assume it's temporary file
+	return &fsFileReader{file}, nil
+}
+
+func (f *FS) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return f.GetFileReader(ctx, key)
+}
+
+// PutFile write to temporary file and rename it after success, to avoid partially written files on the share after crash
+func (f *FS) PutFile(ctx context.Context, key string, localFile io.ReadCloser) error {
+	filePath := path.Join(f.Config.Path, key)
+	if err := os.MkdirAll(path.Dir(filePath), 0750); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(path.Dir(filePath), "."+path.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	if _, err = io.Copy(tmpFile, localFile); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Chmod(tmpPath, 0640); err != nil {
+		log.Warnf("can't chmod %s err=%v", tmpPath, err)
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+func (f *FS) CopyObject(ctx context.Context, srcBucket, srcKey, dstKey string) (int64, error) {
+	return 0, fmt.Errorf("CopyObject not imlemented for %s", f.Kind())
+}
+
+func (f *FS) DeleteFileFromObjectDiskBackup(ctx context.Context, key string) error {
+	return fmt.Errorf("DeleteFileFromObjectDiskBackup not imlemented for %s", f.Kind())
+}
+
+type fsFileReader struct {
+	*os.File
+}
+
+// Implement RemoteFile
+type fsFile struct {
+	size         int64
+	lastModified time.Time
+	name         string
+}
+
+func (file *fsFile) Size() int64 {
+	return file.size
+}
+
+func (file *fsFile) LastModified() time.Time {
+	return file.lastModified
+}
+
+func (file *fsFile) Name() string {
+	return file.name
+}
@@ -7,13 +7,17 @@ import (
 	"fmt"
 	"github.com/Altinity/clickhouse-backup/pkg/clickhouse"
 	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/encryption"
+	"github.com/Altinity/clickhouse-backup/pkg/metrics"
 	"github.com/Altinity/clickhouse-backup/pkg/progressbar"
+	"github.com/Altinity/clickhouse-backup/pkg/status"
 	"github.com/Altinity/clickhouse-backup/pkg/utils"
 	"github.com/eapache/go-resiliency/retrier"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -53,20 +57,56 @@ type BackupDestination struct {
 	compressionFormat  string
 	compressionLevel   int
 	disableProgressBar bool
+	keyring            *encryption.Keyring
+}
+
+// EncryptionAlgorithm - algorithm which will apply to uploaded data, empty when client-side encryption disabled
+func (bd *BackupDestination) EncryptionAlgorithm() string {
+	return bd.keyring.Algorithm()
+}
+
+// EncryptionKeyID - identifier of key which will apply to uploaded data
+func (bd *BackupDestination) EncryptionKeyID() string {
+	return bd.keyring.KeyID()
+}
+
+// HasEncryptionKey - check that backup encrypted with keyID could be downloaded with current config
+func (bd *BackupDestination) HasEncryptionKey(keyID string) bool {
+	return bd.keyring.HasKey(keyID)
 }
 
 var metadataCacheLock sync.RWMutex
 
-func (bd *BackupDestination) RemoveOldBackups(ctx context.Context, keep int) error {
-	if keep < 1 {
-		return nil
+// GetOldBackups return remote backups which shall be deleted according to retention policy, or keep latest backups when policy is not enabled, pinned backups are never returned
+// when backupNameRE is not nil, only matched backups are counted and deleted, watch jobs share remote storage this way
+func (bd *BackupDestination) GetOldBackups(ctx context.Context, keep int, policy config.RetentionPolicy, backupNameRE *regexp.Regexp) ([]Backup, error) {
+	if keep < 1 && !policy.Enabled() {
+		return []Backup{}, nil
 	}
-	start := time.Now()
 	backupList, err := bd.BackupList(ctx, true, "")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var backupsToDelete []Backup
+	if policy.Enabled() {
+		backupsToDelete = GetBackupsToDeleteByRetention(FilterBackupsByName(backupList, backupNameRE), policy)
+	} else {
+		backupsToDelete = GetBackupsToDelete(FilterBackupsByName(backupList, backupNameRE), keep)
 	}
-	backupsToDelete := GetBackupsToDelete(backupList, keep)
+	return bd.ExcludeProtectedBackups(ctx, backupList, backupsToDelete)
+}
+
+// RemoveOldBackups - return backups which were deleted, backup which can't be deleted is skipped
+func (bd *BackupDestination) RemoveOldBackups(ctx context.Context, keep int, policy config.RetentionPolicy, backupNameRE *regexp.Regexp) ([]Backup, error) {
+	if keep < 1 && !policy.Enabled() {
+		return nil, nil
+	}
+	start := time.Now()
+	backupsToDelete, err := bd.GetOldBackups(ctx, keep, policy, backupNameRE)
+	if err != nil {
+		return nil, err
+	}
+	deletedBackups := make([]Backup, 0, len(backupsToDelete))
 	bd.Log.WithFields(apexLog.Fields{
 		"operation": "RemoveOldBackups",
 		"duration":  utils.HumanizeDuration(time.Since(start)),
@@ -75,7 +115,9 @@ func (bd *BackupDestination) RemoveOldBackups(ctx context.Context, keep int) err
 		startDelete := time.Now()
 		if err := bd.RemoveBackup(ctx, backupToDelete); err != nil {
 			bd.Log.Warnf("can't deleteKey %s return error : %v", backupToDelete.BackupName, err)
+			continue
 		}
+		deletedBackups = append(deletedBackups, backupToDelete)
 		bd.Log.WithFields(apexLog.Fields{
 			"operation": "RemoveOldBackups",
 			"location":  "remote",
@@ -84,11 +126,11 @@ func (bd *BackupDestination) RemoveOldBackups(ctx context.Context, keep int) err
 		}).Info("done")
 	}
 	bd.Log.WithFields(apexLog.Fields{"operation": "RemoveOldBackups", "duration": utils.HumanizeDuration(time.Since(start))}).Info("done")
-	return nil
+	return deletedBackups, nil
 }
 
 func (bd *BackupDestination) RemoveBackup(ctx context.Context, backup Backup) error {
-	if bd.Kind() == "SFTP" || bd.Kind() == "FTP" {
+	if bd.Kind() == "SFTP" || bd.Kind() == "FTP" || bd.Kind() == "FS" {
 		return bd.DeleteFile(ctx, backup.BackupName)
 	}
 	if backup.Legacy {
@@ -323,49 +365,35 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 	return result, nil
 }
 
-func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remotePath string, localPath string) error {
-	if err := os.MkdirAll(localPath, 0750); err != nil {
-		return err
-	}
-	// get this first as GetFileReader blocks the ftp control channel
-	file, err := bd.StatFile(ctx, remotePath)
-	if err != nil {
-		return err
-	}
-	filesize := file.Size()
+type encryptionRequiredKey struct{}
 
-	reader, err := bd.GetFileReaderWithLocalPath(ctx, remotePath, localPath)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			bd.Log.Warnf("can't close GetFileReader descriptor %v", reader)
-		}
-		switch reader.(type) {
-		case *os.File:
-			fileName := reader.(*os.File).Name()
-			if err := os.Remove(fileName); err != nil {
-				bd.Log.Warnf("can't remove %s", fileName)
-			}
-		}
-	}()
+// WithEncryptionRequired - data of backup with encryption_key_id in metadata.json shall be encrypted, plain data is rejected by decryption
+func WithEncryptionRequired(ctx context.Context, isRequired bool) context.Context {
+	return context.WithValue(ctx, encryptionRequiredKey{}, isRequired)
+}
 
-	bar := progressbar.StartNewByteBar(!bd.disableProgressBar, filesize)
-	buf := buffer.New(BufferSize)
-	defer bar.Finish()
-	bufReader := nio.NewReader(reader, buf)
-	proxyReader := bar.NewProxyReader(bufReader)
-	compressionFormat := bd.compressionFormat
-	if !checkArchiveExtension(path.Ext(remotePath), compressionFormat) {
-		bd.Log.Warnf("remote file backup extension %s not equal with %s", remotePath, compressionFormat)
-		compressionFormat = strings.Replace(path.Ext(remotePath), ".", "", -1)
+// IsEncryptionRequired - ctx is marked by WithEncryptionRequired
+func IsEncryptionRequired(ctx context.Context) bool {
+	isRequired, _ := ctx.Value(encryptionRequiredKey{}).(bool)
+	return isRequired
+}
+
+// newByteBar - bar is not shown for json progress format, bytes are added to progress of command when ctx is marked by status.WithBytesProgress
+func (bd *BackupDestination) newByteBar(ctx context.Context, total int64) *progressbar.Bar {
+	bar := progressbar.StartNewByteBar(!bd.disableProgressBar && status.Current.ProgressFormat() != status.ProgressFormatJSON, total)
+	if status.IsBytesProgress(ctx) {
+		bar.OnAdd(func(n int64) {
+			status.Current.AddProgress(ctx, 0, uint64(n))
+		})
 	}
-	z, err := getArchiveReader(compressionFormat)
-	if err != nil {
+	return bar
+}
+
+func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remotePath string, localPath string) error {
+	if err := os.MkdirAll(localPath, 0750); err != nil {
 		return err
 	}
-	if err := z.Extract(ctx, proxyReader, nil, func(ctx context.Context, file archiver.File) error {
+	return bd.processCompressedStream(ctx, remotePath, localPath, func(ctx context.Context, file archiver.File) error {
 		f, err := file.Open()
 		if err != nil {
 			return fmt.Errorf("can't open %s", file.NameInArchive)
@@ -401,7 +429,58 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 		}
 		//bd.Log.Debugf("extract %s", extractFile)
 		return nil
-	}); err != nil {
+	})
+}
+
+// ReadCompressedStream pass each file from remote archive to handler without extract it to local disk
+func (bd *BackupDestination) ReadCompressedStream(ctx context.Context, remotePath string, handler archiver.FileHandler) error {
+	return bd.processCompressedStream(ctx, remotePath, os.TempDir(), handler)
+}
+
+func (bd *BackupDestination) processCompressedStream(ctx context.Context, remotePath string, localPath string, handler archiver.FileHandler) error {
+	// get this first as GetFileReader blocks the ftp control channel
+	file, err := bd.StatFile(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	filesize := file.Size()
+
+	reader, err := bd.GetFileReaderWithLocalPath(ctx, remotePath, localPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			bd.Log.Warnf("can't close GetFileReader descriptor %v", reader)
+		}
+		switch reader.(type) {
+		case *os.File:
+			fileName := reader.(*os.File).Name()
+			if err := os.Remove(fileName); err != nil {
+				bd.Log.Warnf("can't remove %s", fileName)
+			}
+		}
+	}()
+
+	bar := bd.newByteBar(ctx, filesize)
+	buf := buffer.New(BufferSize)
+	defer bar.Finish()
+	bufReader := nio.NewReader(reader, buf)
+	proxyReader := newProgressReader(ctx, bar, bufReader)
+	decryptReader, err := bd.keyring.NewDecryptReader(io.NopCloser(proxyReader), IsEncryptionRequired(ctx))
+	if err != nil {
+		return fmt.Errorf("%s: %w", remotePath, err)
+	}
+	compressionFormat := bd.compressionFormat
+	if !checkArchiveExtension(path.Ext(remotePath), compressionFormat) {
+		bd.Log.Warnf("remote file backup extension %s not equal with %s", remotePath, compressionFormat)
+		compressionFormat = strings.Replace(path.Ext(remotePath), ".", "", -1)
+	}
+	z, err := getArchiveReader(compressionFormat)
+	if err != nil {
+		return err
+	}
+	if err := z.Extract(ctx, decryptReader, nil, handler); err != nil {
 		return err
 	}
 	return nil
@@ -423,7 +502,7 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 			totalBytes += fInfo.Size()
 		}
 	}
-	bar := progressbar.StartNewByteBar(!bd.disableProgressBar, totalBytes)
+	bar := bd.newByteBar(ctx, totalBytes)
 	defer bar.Finish()
 	pipeBuffer := buffer.New(BufferSize)
 	body, w := nio.Pipe(pipeBuffer)
@@ -484,27 +563,44 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 				}
 			}
 		}()
-		readerErr = bd.PutFile(ctx, remotePath, body)
+		var encryptedBody io.ReadCloser
+		if encryptedBody, readerErr = bd.keyring.NewEncryptReader(body); readerErr != nil {
+			return readerErr
+		}
+		readerErr = bd.PutFile(ctx, remotePath, encryptedBody)
 		return readerErr
 	})
 	return g.Wait()
 }
 
+// GetDataFileReader - the same as GetFileReader but decrypt data which uploaded with UploadPath
+func (bd *BackupDestination) GetDataFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := bd.GetFileReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	decryptReader, err := bd.keyring.NewDecryptReader(r, IsEncryptionRequired(ctx))
+	if err != nil {
+		if closeErr := r.Close(); closeErr != nil {
+			bd.Log.Warnf("can't close GetFileReader descriptor %s: %v", key, closeErr)
+		}
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return decryptReader, nil
+}
+
 func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remotePath string, localPath string, RetriesOnFailure int, RetriesDuration time.Duration) error {
-	var bar *progressbar.Bar
-	if !bd.disableProgressBar {
-		totalBytes := size
-		if size == 0 {
-			if err := bd.Walk(ctx, remotePath, true, func(ctx context.Context, f RemoteFile) error {
-				totalBytes += f.Size()
-				return nil
-			}); err != nil {
-				return err
-			}
+	totalBytes := size
+	if size == 0 && !bd.disableProgressBar {
+		if err := bd.Walk(ctx, remotePath, true, func(ctx context.Context, f RemoteFile) error {
+			totalBytes += f.Size()
+			return nil
+		}); err != nil {
+			return err
 		}
-		bar = progressbar.StartNewByteBar(!bd.disableProgressBar, totalBytes)
-		defer bar.Finish()
 	}
+	bar := bd.newByteBar(ctx, totalBytes)
+	defer bar.Finish()
 	log := bd.Log.WithFields(apexLog.Fields{
 		"path":      remotePath,
 		"operation": "download",
@@ -513,9 +609,9 @@ func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remot
 		if bd.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
 			return nil
 		}
-		retry := retrier.New(retrier.ConstantBackoff(RetriesOnFailure, RetriesDuration), nil)
-		err := retry.RunCtx(ctx, func(ctx context.Context) error {
-			r, err := bd.GetFileReader(ctx, path.Join(remotePath, f.Name()))
+		retry := metrics.NewRetrier("download", retrier.ConstantBackoff(RetriesOnFailure, RetriesDuration))
+		err := retry.RunCtx(WithRetriedReadProgress(ctx), func(ctx context.Context) error {
+			r, err := bd.GetDataFileReader(ctx, path.Join(remotePath, f.Name()))
 			if err != nil {
 				log.Error(err.Error())
 				return err
@@ -531,7 +627,7 @@ func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remot
 				log.Error(err.Error())
 				return err
 			}
-			if _, err := io.CopyBuffer(dst, r, nil); err != nil {
+			if _, err := io.CopyBuffer(dst, newProgressReader(ctx, bar, r), nil); err != nil {
 				log.Error(err.Error())
 				return err
 			}
@@ -545,13 +641,7 @@ func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remot
 			}
 			return nil
 		})
-		if err != nil {
-			return err
-		}
-		if !bd.disableProgressBar {
-			bar.Add64(f.Size())
-		}
-		return nil
+		return err
 	})
 }
 
@@ -570,7 +660,7 @@ func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLoc
 		}
 	}
 	if !bd.disableProgressBar {
-		bar = progressbar.StartNewByteBar(!bd.disableProgressBar, totalBytes)
+		bar = bd.newByteBar(ctx, totalBytes)
 		defer bar.Finish()
 	}
 
@@ -584,9 +674,16 @@ func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLoc
 				bd.Log.Warnf("can't close UploadPath file descriptor %v: %v", f, err)
 			}
 		}
-		retry := retrier.New(retrier.ConstantBackoff(RetriesOnFailure, RetriesDuration), nil)
+		retry := metrics.NewRetrier("upload", retrier.ConstantBackoff(RetriesOnFailure, RetriesDuration))
 		err = retry.RunCtx(ctx, func(ctx context.Context) error {
-			return bd.PutFile(ctx, path.Join(remotePath, filename), f)
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			r, err := bd.keyring.NewEncryptReader(f)
+			if err != nil {
+				return err
+			}
+			return bd.PutFile(ctx, path.Join(remotePath, filename), r)
 		})
 		if err != nil {
 			closeFile()
@@ -605,7 +702,58 @@ func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLoc
 	return totalBytes, nil
 }
 
+// CopyFile - copy remote file inside the same remote storage without decryption, use server-side copy when backend support it, otherwise stream data through the host
+func (bd *BackupDestination) CopyFile(ctx context.Context, srcKey, dstKey string) (int64, error) {
+	var srcBucket, rootPath, objectDiskPath string
+	switch s := unwrapRemoteStorage(bd.RemoteStorage).(type) {
+	case *S3:
+		srcBucket, rootPath, objectDiskPath = s.Config.Bucket, s.Config.Path, s.Config.ObjectDiskPath
+	case *GCS:
+		srcBucket, rootPath, objectDiskPath = s.Config.Bucket, s.Config.Path, s.Config.ObjectDiskPath
+	case *AzureBlob:
+		srcBucket, rootPath, objectDiskPath = s.Config.Container, s.Config.Path, s.Config.ObjectDiskPath
+	default:
+		return bd.copyFileStream(ctx, srcKey, dstKey)
+	}
+	// CopyObject designed for object disks and put dstKey inside object_disk_path, so need relative path from object_disk_path to path
+	relativeDstKey, err := filepath.Rel(path.Join("/", objectDiskPath), path.Join("/", rootPath, dstKey))
+	if err != nil {
+		return 0, err
+	}
+	return bd.CopyObject(ctx, srcBucket, path.Join(rootPath, srcKey), relativeDstKey)
+}
+
+func (bd *BackupDestination) copyFileStream(ctx context.Context, srcKey, dstKey string) (int64, error) {
+	stat, err := bd.StatFile(ctx, srcKey)
+	if err != nil {
+		return 0, err
+	}
+	r, err := bd.GetFileReader(ctx, srcKey)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			bd.Log.Warnf("can't close GetFileReader descriptor %s: %v", srcKey, err)
+		}
+	}()
+	if err = bd.PutFile(ctx, dstKey, r); err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// NewBackupDestination - each RemoteStorage is wrapped by measuredStorage
 func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse, calcMaxSize bool, backupName string) (*BackupDestination, error) {
+	bd, err := newBackupDestination(ctx, cfg, ch, calcMaxSize, backupName)
+	if err != nil {
+		return nil, err
+	}
+	bd.RemoteStorage = newMeasuredStorage(bd.RemoteStorage)
+	return bd, nil
+}
+
+func newBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse, calcMaxSize bool, backupName string) (*BackupDestination, error) {
 	log := apexLog.WithField("logger", "NewBackupDestination")
 	var err error
 	// https://github.com/Altinity/clickhouse-backup/issues/404
@@ -621,6 +769,10 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			cfg.General.MaxFileSize = maxFileSize
 		}
 	}
+	keyring, err := encryption.NewKeyring(&cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
 	switch cfg.General.RemoteStorage {
 	case "azblob":
 		azblobStorage := &AzureBlob{Config: &cfg.AzureBlob}
@@ -650,6 +802,7 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			cfg.AzureBlob.CompressionFormat,
 			cfg.AzureBlob.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			keyring,
 		}, nil
 	case "s3":
 		partSize := cfg.S3.PartSize
@@ -691,6 +844,7 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			cfg.S3.CompressionFormat,
 			cfg.S3.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			keyring,
 		}, nil
 	case "gcs":
 		googleCloudStorage := &GCS{Config: &cfg.GCS}
@@ -713,6 +867,7 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			cfg.GCS.CompressionFormat,
 			cfg.GCS.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			keyring,
 		}, nil
 	case "cos":
 		tencentStorage := &COS{Config: &cfg.COS}
@@ -726,6 +881,7 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			cfg.COS.CompressionFormat,
 			cfg.COS.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			keyring,
 		}, nil
 	case "ftp":
 		ftpStorage := &FTP{
@@ -742,6 +898,7 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			cfg.FTP.CompressionFormat,
 			cfg.FTP.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			keyring,
 		}, nil
 	case "sftp":
 		sftpStorage := &SFTP{
@@ -757,6 +914,23 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			cfg.SFTP.CompressionFormat,
 			cfg.SFTP.CompressionLevel,
 			cfg.General.DisableProgressBar,
+			keyring,
+		}, nil
+	case "fs":
+		fsStorage := &FS{
+			Config: &cfg.FS,
+		}
+		fsStorage.Config.Path, err = ch.ApplyMacros(ctx, fsStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &BackupDestination{
+			fsStorage,
+			log.WithField("logger", "FS"),
+			cfg.FS.CompressionFormat,
+			cfg.FS.CompressionLevel,
+			cfg.General.DisableProgressBar,
+			keyring,
 		}, nil
 	default:
 		return nil, fmt.Errorf("storage type '%s' is not supported", cfg.General.RemoteStorage)
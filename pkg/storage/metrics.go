@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// measuredStorage - NewBackupDestination wraps each RemoteStorage, so requests are measured in clickhouse_backup_remote_storage_request_duration_seconds
+// even when they are called via bd.RemoteStorage directly, bytes are counted in clickhouse_backup_uploaded_bytes and clickhouse_backup_downloaded_bytes while they are transferred
+type measuredStorage struct {
+	RemoteStorage
+}
+
+func newMeasuredStorage(s RemoteStorage) RemoteStorage {
+	if _, isMeasured := s.(*measuredStorage); isMeasured {
+		return s
+	}
+	return &measuredStorage{RemoteStorage: s}
+}
+
+// unwrapRemoteStorage - concrete storage, for type switches
+func unwrapRemoteStorage(s RemoteStorage) RemoteStorage {
+	if measured, isMeasured := s.(*measuredStorage); isMeasured {
+		return measured.RemoteStorage
+	}
+	return s
+}
+
+func (s *measuredStorage) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	defer metrics.ObserveRemoteStorageRequest(s.Kind(), "StatFile", time.Now())
+	return s.RemoteStorage.StatFile(ctx, key)
+}
+
+func (s *measuredStorage) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	defer metrics.ObserveRemoteStorageRequest(s.Kind(), "PutFile", time.Now())
+	return s.RemoteStorage.PutFile(ctx, key, &countingReader{ReadCloser: r, counter: metrics.UploadedBytes})
+}
+
+// GetFileReader - time to open reader, reading is counted by clickhouse_backup_downloaded_bytes
+func (s *measuredStorage) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	defer metrics.ObserveRemoteStorageRequest(s.Kind(), "GetFileReader", time.Now())
+	r, err := s.RemoteStorage.GetFileReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReader{ReadCloser: r, counter: metrics.DownloadedBytes}, nil
+}
+
+// GetFileReaderWithLocalPath - some storages download whole file to localPath and return *os.File, which is removed by caller after read
+func (s *measuredStorage) GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error) {
+	defer metrics.ObserveRemoteStorageRequest(s.Kind(), "GetFileReaderWithLocalPath", time.Now())
+	r, err := s.RemoteStorage.GetFileReaderWithLocalPath(ctx, key, localPath)
+	if err != nil {
+		return nil, err
+	}
+	if f, isFile := r.(*os.File); isFile {
+		if info, statErr := f.Stat(); statErr == nil {
+			metrics.DownloadedBytes.Add(float64(info.Size()))
+		}
+		return r, nil
+	}
+	return &countingReader{ReadCloser: r, counter: metrics.DownloadedBytes}, nil
+}
+
+// Walk - time spent in process callback is not a part of request latency
+func (s *measuredStorage) Walk(ctx context.Context, prefix string, recursive bool, process func(context.Context, RemoteFile) error) error {
+	startTime := time.Now()
+	var processDuration int64
+	err := s.RemoteStorage.Walk(ctx, prefix, recursive, func(ctx context.Context, f RemoteFile) error {
+		processStart := time.Now()
+		defer func() {
+			atomic.AddInt64(&processDuration, int64(time.Since(processStart)))
+		}()
+		return process(ctx, f)
+	})
+	metrics.ObserveRemoteStorageRequest(s.Kind(), "Walk", startTime.Add(time.Duration(atomic.LoadInt64(&processDuration))))
+	return err
+}
+
+func (s *measuredStorage) DeleteFile(ctx context.Context, key string) error {
+	defer metrics.ObserveRemoteStorageRequest(s.Kind(), "DeleteFile", time.Now())
+	return s.RemoteStorage.DeleteFile(ctx, key)
+}
+
+// countingReader - counter grows with each Read, so long transfers are visible before they finish
+type countingReader struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.counter.Add(float64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasuredStorage(t *testing.T) {
+	ctx := context.Background()
+	bd := &BackupDestination{RemoteStorage: newMeasuredStorage(&FS{Config: &config.FSConfig{Path: t.TempDir()}})}
+	assert.Same(t, bd.RemoteStorage, newMeasuredStorage(bd.RemoteStorage))
+	assert.IsType(t, &FS{}, unwrapRemoteStorage(bd.RemoteStorage))
+	require.NoError(t, bd.Connect(ctx))
+
+	uploadedBytes := testutil.ToFloat64(metrics.UploadedBytes)
+	downloadedBytes := testutil.ToFloat64(metrics.DownloadedBytes)
+	require.NoError(t, bd.PutFile(ctx, "backup/metadata.json", io.NopCloser(strings.NewReader("{}"))))
+	assert.Equal(t, uploadedBytes+2, testutil.ToFloat64(metrics.UploadedBytes))
+	// direct calls of embedded storage are measured too
+	r, err := bd.RemoteStorage.GetFileReader(ctx, "backup/metadata.json")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, downloadedBytes+2, testutil.ToFloat64(metrics.DownloadedBytes))
+	_, err = bd.StatFile(ctx, "backup/metadata.json")
+	require.NoError(t, err)
+	walked := 0
+	require.NoError(t, bd.Walk(ctx, "backup/", true, func(ctx context.Context, f RemoteFile) error {
+		walked++
+		return nil
+	}))
+	assert.Equal(t, 1, walked)
+	require.NoError(t, bd.DeleteFile(ctx, "backup/metadata.json"))
+	// FS StatFile, PutFile, GetFileReader, Walk, DeleteFile
+	assert.Equal(t, 5, testutil.CollectAndCount(metrics.RemoteStorageRequestDuration))
+}
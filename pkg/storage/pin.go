@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"time"
+)
+
+// PinFileName - marker stored next to `metadata.json`, pinned backup is protected from retention and delete commands
+// use `.json` extension cause embedded backups cleanup process all other files as object disk metadata
+const PinFileName = "pinned.json"
+
+// RequiredByPinnedFileName - stored in each RequiredBackup of pinned incremental backup, allow to check protection during delete without list all backups
+const RequiredByPinnedFileName = "required_by_pinned.json"
+
+// PinMarker - content of PinFileName
+type PinMarker struct {
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// RequiredByPinnedMarker - content of RequiredByPinnedFileName
+type RequiredByPinnedMarker struct {
+	Backups []string `json:"backups"`
+}
+
+func NewPinMarker() ([]byte, error) {
+	return json.MarshalIndent(PinMarker{PinnedAt: time.Now().UTC()}, "", "\t")
+}
+
+// PinBackup put PinFileName marker into remote backup folder and RequiredByPinnedFileName into each of requiredBackups
+func (bd *BackupDestination) PinBackup(ctx context.Context, backupName string, requiredBackups []string) error {
+	for _, requiredBackup := range requiredBackups {
+		requiredBy, err := bd.GetRequiredByPinned(ctx, requiredBackup)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(requiredBy, backupName) {
+			if err = bd.putRequiredByPinned(ctx, requiredBackup, append(requiredBy, backupName)); err != nil {
+				return err
+			}
+		}
+	}
+	body, err := NewPinMarker()
+	if err != nil {
+		return err
+	}
+	return bd.PutFile(ctx, path.Join(backupName, PinFileName), io.NopCloser(bytes.NewReader(body)))
+}
+
+// UnpinBackup remove PinFileName marker from remote backup folder and backupName from RequiredByPinnedFileName of requiredBackups
+func (bd *BackupDestination) UnpinBackup(ctx context.Context, backupName string, requiredBackups []string) error {
+	for _, requiredBackup := range requiredBackups {
+		requiredBy, err := bd.GetRequiredByPinned(ctx, requiredBackup)
+		if err != nil {
+			return err
+		}
+		if i := slices.Index(requiredBy, backupName); i >= 0 {
+			if err = bd.putRequiredByPinned(ctx, requiredBackup, slices.Delete(requiredBy, i, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return bd.DeleteFile(ctx, path.Join(backupName, PinFileName))
+}
+
+// GetRequiredByPinned return names of pinned incremental backups which require backupName, names could be stale when pinned backup was removed without unpin
+func (bd *BackupDestination) GetRequiredByPinned(ctx context.Context, backupName string) ([]string, error) {
+	if _, err := bd.StatFile(ctx, path.Join(backupName, RequiredByPinnedFileName)); err != nil {
+		if err == ErrNotFound || os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r, err := bd.GetFileReader(ctx, path.Join(backupName, RequiredByPinnedFileName))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(r)
+	if closeErr := r.Close(); closeErr != nil {
+		bd.Log.Warnf("can't close %s: %v", path.Join(backupName, RequiredByPinnedFileName), closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var marker RequiredByPinnedMarker
+	if err = json.Unmarshal(body, &marker); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", path.Join(backupName, RequiredByPinnedFileName), err)
+	}
+	return marker.Backups, nil
+}
+
+func (bd *BackupDestination) putRequiredByPinned(ctx context.Context, backupName string, requiredBy []string) error {
+	if len(requiredBy) == 0 {
+		return bd.DeleteFile(ctx, path.Join(backupName, RequiredByPinnedFileName))
+	}
+	body, err := json.MarshalIndent(RequiredByPinnedMarker{Backups: requiredBy}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return bd.PutFile(ctx, path.Join(backupName, RequiredByPinnedFileName), io.NopCloser(bytes.NewReader(body)))
+}
+
+func (bd *BackupDestination) IsPinned(ctx context.Context, backupName string) (bool, error) {
+	if _, err := bd.StatFile(ctx, path.Join(backupName, PinFileName)); err != nil {
+		if err == ErrNotFound || os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetPinnedBackups return names of pinned backups from backups list, legacy backups can't be pinned
+func (bd *BackupDestination) GetPinnedBackups(ctx context.Context, backups []Backup) (map[string]struct{}, error) {
+	pinned := make(map[string]struct{})
+	for _, backup := range backups {
+		if backup.Legacy {
+			continue
+		}
+		isPinned, err := bd.IsPinned(ctx, backup.BackupName)
+		if err != nil {
+			return nil, err
+		}
+		if isPinned {
+			pinned[backup.BackupName] = struct{}{}
+		}
+	}
+	return pinned, nil
+}
+
+// ExcludeProtectedBackups remove pinned backups and all RequiredBackup for pinned incremental backups from backupsToDelete
+func (bd *BackupDestination) ExcludeProtectedBackups(ctx context.Context, backupList []Backup, backupsToDelete []Backup) ([]Backup, error) {
+	if len(backupsToDelete) == 0 {
+		return backupsToDelete, nil
+	}
+	pinned, err := bd.GetPinnedBackups(ctx, backupList)
+	if err != nil {
+		return nil, err
+	}
+	protected := GetProtectedBackups(GetRetentionItems(backupList), pinned)
+	result := make([]Backup, 0, len(backupsToDelete))
+	for _, backup := range backupsToDelete {
+		if _, isProtected := protected[backup.BackupName]; isProtected {
+			bd.Log.WithField("backup", backup.BackupName).Info("skip delete, backup is pinned or required for pinned backup")
+			continue
+		}
+		result = append(result, backup)
+	}
+	return result, nil
+}
+
+// GetProtectedBackups return names of pinned backups and all backups which required for pinned incremental backups
+func GetProtectedBackups(items []RetentionItem, pinned map[string]struct{}) map[string]struct{} {
+	protected := make(map[string]struct{}, len(pinned))
+	for name := range pinned {
+		protected[name] = struct{}{}
+	}
+	addRequiredBackups(protected, items)
+	return protected
+}
+
+// GetRequiredBackups return names of all backups in RequiredBackup chain of backupName
+func GetRequiredBackups(items []RetentionItem, backupName string) []string {
+	requiredByName := make(map[string]string, len(items))
+	for _, item := range items {
+		requiredByName[item.Name] = item.RequiredBackup
+	}
+	required := make([]string, 0)
+	// len(items) limit protects from cyclic RequiredBackup chain
+	for name, depth := requiredByName[backupName], 0; name != "" && depth < len(items); name, depth = requiredByName[name], depth+1 {
+		required = append(required, name)
+	}
+	return required
+}
+
+// GetDependentBackups return names of incremental backups which require backupName directly or through other incremental backups
+func GetDependentBackups(items []RetentionItem, backupName string) []string {
+	requiredByName := make(map[string]string, len(items))
+	for _, item := range items {
+		requiredByName[item.Name] = item.RequiredBackup
+	}
+	dependent := make([]string, 0)
+	for _, item := range items {
+		// len(items) limit protects from cyclic RequiredBackup chain
+		for required, depth := item.RequiredBackup, 0; required != "" && depth < len(items); required, depth = requiredByName[required], depth+1 {
+			if required == backupName {
+				dependent = append(dependent, item.Name)
+				break
+			}
+		}
+	}
+	return dependent
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetProtectedBackups(t *testing.T) {
+	items := []RetentionItem{
+		{Name: "full", Date: timeParse("2023-01-01T00-00-00")},
+		{Name: "other", Date: timeParse("2023-01-02T00-00-00")},
+		{Name: "increment1", Date: timeParse("2023-01-03T00-00-00"), RequiredBackup: "full"},
+		{Name: "increment2", Date: timeParse("2023-01-04T00-00-00"), RequiredBackup: "increment1"},
+		{Name: "increment3", Date: timeParse("2023-01-05T00-00-00"), RequiredBackup: "increment2"},
+	}
+	protected := GetProtectedBackups(items, map[string]struct{}{"increment2": {}})
+	assert.Equal(t, map[string]struct{}{"full": {}, "increment1": {}, "increment2": {}}, protected)
+	assert.Empty(t, GetProtectedBackups(items, map[string]struct{}{}))
+
+	assert.Equal(t, []string{"increment1", "increment2", "increment3"}, GetDependentBackups(items, "full"))
+	assert.Equal(t, []string{"increment3"}, GetDependentBackups(items, "increment2"))
+	assert.Empty(t, GetDependentBackups(items, "other"))
+
+	// cyclic chain shall not hang
+	cyclic := []RetentionItem{
+		{Name: "a", RequiredBackup: "b"},
+		{Name: "b", RequiredBackup: "a"},
+	}
+	assert.Empty(t, GetDependentBackups(cyclic, "c"))
+}
+
+func TestPinBackupRequiredByPinned(t *testing.T) {
+	ctx := context.Background()
+	bd := &BackupDestination{RemoteStorage: &FS{Config: &config.FSConfig{Path: t.TempDir()}}}
+	items := []RetentionItem{
+		{Name: "full"},
+		{Name: "increment1", RequiredBackup: "full"},
+		{Name: "increment2", RequiredBackup: "increment1"},
+	}
+	assert.Equal(t, []string{"increment1", "full"}, GetRequiredBackups(items, "increment2"))
+	assert.Empty(t, GetRequiredBackups(items, "full"))
+
+	require.NoError(t, bd.PinBackup(ctx, "increment2", GetRequiredBackups(items, "increment2")))
+	require.NoError(t, bd.PinBackup(ctx, "increment1", GetRequiredBackups(items, "increment1")))
+	requiredBy, err := bd.GetRequiredByPinned(ctx, "full")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"increment2", "increment1"}, requiredBy)
+
+	require.NoError(t, bd.UnpinBackup(ctx, "increment2", GetRequiredBackups(items, "increment2")))
+	isPinned, err := bd.IsPinned(ctx, "increment2")
+	require.NoError(t, err)
+	assert.False(t, isPinned)
+	requiredBy, err = bd.GetRequiredByPinned(ctx, "full")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"increment1"}, requiredBy)
+
+	require.NoError(t, bd.UnpinBackup(ctx, "increment1", GetRequiredBackups(items, "increment1")))
+	requiredBy, err = bd.GetRequiredByPinned(ctx, "full")
+	require.NoError(t, err)
+	assert.Empty(t, requiredBy)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/Altinity/clickhouse-backup/pkg/progressbar"
+)
+
+// retriedReadProgress - retried attempt reads remote file from the beginning again,
+// only bytes beyond the longest previous attempt are added to progress, so retries don't inflate it
+type retriedReadProgress struct {
+	sync.Mutex
+	attemptBytes  int64
+	reportedBytes int64
+}
+
+type retriedReadProgressKey struct{}
+
+// WithRetriedReadProgress - use for ctx of retry.RunCtx around one remote file download, each attempt shall use ctx passed to work
+func WithRetriedReadProgress(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retriedReadProgressKey{}, &retriedReadProgress{})
+}
+
+func (p *retriedReadProgress) startAttempt() {
+	p.Lock()
+	defer p.Unlock()
+	p.attemptBytes = 0
+}
+
+// add - returns bytes which were not reported by previous attempts
+func (p *retriedReadProgress) add(n int64) int64 {
+	p.Lock()
+	defer p.Unlock()
+	p.attemptBytes += n
+	if p.attemptBytes <= p.reportedBytes {
+		return 0
+	}
+	notReported := p.attemptBytes - p.reportedBytes
+	p.reportedBytes = p.attemptBytes
+	return notReported
+}
+
+// newProgressReader - the same as bar.NewProxyReader, when ctx is marked by WithRetriedReadProgress, bytes read by previous attempts are not added to bar again
+func newProgressReader(ctx context.Context, bar *progressbar.Bar, r io.Reader) io.Reader {
+	progress, isRetried := ctx.Value(retriedReadProgressKey{}).(*retriedReadProgress)
+	if !isRetried {
+		return bar.NewProxyReader(r)
+	}
+	progress.startAttempt()
+	return &retriedProgressReader{Reader: r, progress: progress, bar: bar}
+}
+
+type retriedProgressReader struct {
+	io.Reader
+	progress *retriedReadProgress
+	bar      *progressbar.Bar
+}
+
+func (r *retriedProgressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		if notReported := r.progress.add(int64(n)); notReported > 0 {
+			r.bar.Add64(notReported)
+		}
+	}
+	return n, err
+}
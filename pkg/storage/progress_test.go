@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Altinity/clickhouse-backup/pkg/progressbar"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingReader struct {
+	io.Reader
+	failAfter int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.failAfter <= 0 {
+		return 0, errors.New("connection reset")
+	}
+	if len(p) > r.failAfter {
+		p = p[:r.failAfter]
+	}
+	n, err := r.Reader.Read(p)
+	r.failAfter -= n
+	return n, err
+}
+
+func TestRetriedReadProgress(t *testing.T) {
+	var reported int64
+	bar := progressbar.StartNewByteBar(false, 10).OnAdd(func(n int64) { reported += n })
+	ctx := WithRetriedReadProgress(context.Background())
+	data := "0123456789"
+
+	_, err := io.Copy(io.Discard, newProgressReader(ctx, bar, &failingReader{Reader: strings.NewReader(data), failAfter: 6}))
+	assert.Error(t, err)
+	assert.Equal(t, int64(6), reported)
+
+	_, err = io.Copy(io.Discard, newProgressReader(ctx, bar, &failingReader{Reader: strings.NewReader(data), failAfter: 3}))
+	assert.Error(t, err)
+	assert.Equal(t, int64(6), reported)
+
+	_, err = io.Copy(io.Discard, newProgressReader(ctx, bar, strings.NewReader(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), reported)
+
+	reported = 0
+	_, err = io.Copy(io.Discard, newProgressReader(context.Background(), bar, strings.NewReader(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), reported)
+}
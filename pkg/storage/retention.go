@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+)
+
+// RetentionItem - common fields of local and remote backups, which required for retention policy
+type RetentionItem struct {
+	Name           string
+	Date           time.Time
+	RequiredBackup string
+}
+
+type retentionPeriod struct {
+	keep int
+	key  func(t time.Time) string
+}
+
+// GetBackupsToKeepByRetention return names of backups which shall be kept according to grandfather-father-son policy
+// for each period the newest backup is kept, periods without backups are not counted, calendar boundaries calculated in UTC
+// all RequiredBackup for kept incremental backups are kept too
+func GetBackupsToKeepByRetention(items []RetentionItem, policy config.RetentionPolicy) map[string]struct{} {
+	sorted := make([]RetentionItem, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.After(sorted[j].Date)
+	})
+	keep := make(map[string]struct{})
+	for i := 0; i < policy.KeepLast && i < len(sorted); i++ {
+		keep[sorted[i].Name] = struct{}{}
+	}
+	periods := []retentionPeriod{
+		{policy.KeepHourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{policy.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{policy.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{policy.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, period := range periods {
+		lastKey := ""
+		kept := 0
+		for _, item := range sorted {
+			if kept >= period.keep {
+				break
+			}
+			if key := period.key(item.Date.UTC()); key != lastKey {
+				keep[item.Name] = struct{}{}
+				lastKey = key
+				kept++
+			}
+		}
+	}
+	// fix https://github.com/Altinity/clickhouse-backup/issues/111, incremental chain shall be kept whole
+	addRequiredBackups(keep, sorted)
+	return keep
+}
+
+// addRequiredBackups add to keep whole RequiredBackup chain for each kept backup
+func addRequiredBackups(keep map[string]struct{}, items []RetentionItem) {
+	requiredByName := make(map[string]string, len(items))
+	for _, item := range items {
+		requiredByName[item.Name] = item.RequiredBackup
+	}
+	for name := range keep {
+		for required := requiredByName[name]; required != ""; required = requiredByName[required] {
+			if _, exists := keep[required]; exists {
+				break
+			}
+			keep[required] = struct{}{}
+		}
+	}
+}
+
+func GetRetentionItems(backups []Backup) []RetentionItem {
+	items := make([]RetentionItem, len(backups))
+	for i, b := range backups {
+		items[i] = RetentionItem{Name: b.BackupName, Date: b.UploadDate, RequiredBackup: b.RequiredBackup}
+	}
+	return items
+}
+
+// GetRetentionItemsToDelete return items which are not kept by retention policy, newest first, used for local and remote backups
+func GetRetentionItemsToDelete(items []RetentionItem, policy config.RetentionPolicy) []RetentionItem {
+	keep := GetBackupsToKeepByRetention(items, policy)
+	sorted := make([]RetentionItem, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.After(sorted[j].Date)
+	})
+	deletedItems := make([]RetentionItem, 0)
+	for _, item := range sorted {
+		if _, exists := keep[item.Name]; exists {
+			continue
+		}
+		// fix https://github.com/Altinity/clickhouse-backup/issues/409
+		if item.Date == time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC) {
+			continue
+		}
+		deletedItems = append(deletedItems, item)
+	}
+	return deletedItems
+}
+
+// GetBackupsToDeleteByRetention the same as GetBackupsToDelete, but use retention policy instead of backups count
+func GetBackupsToDeleteByRetention(backups []Backup, policy config.RetentionPolicy) []Backup {
+	backupsByName := make(map[string]Backup, len(backups))
+	for _, b := range backups {
+		backupsByName[b.BackupName] = b
+	}
+	deletedBackups := make([]Backup, 0)
+	for _, item := range GetRetentionItemsToDelete(GetRetentionItems(backups), policy) {
+		deletedBackups = append(deletedBackups, backupsByName[item.Name])
+	}
+	return deletedBackups
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Altinity/clickhouse-backup/pkg/config"
+	"github.com/Altinity/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func backupNames(backups []Backup) []string {
+	names := make([]string, len(backups))
+	for i := range backups {
+		names[i] = backups[i].BackupName
+	}
+	return names
+}
+
+func TestGetBackupsToDeleteByRetention(t *testing.T) {
+	// daily backups during 2 months, the newest backup is 2023-03-31
+	testData := make([]Backup, 0)
+	for d := timeParse("2023-02-01T01-00-00"); !d.After(timeParse("2023-03-31T01-00-00")); d = d.Add(24 * time.Hour) {
+		testData = append(testData, Backup{BackupMetadata: metadata.BackupMetadata{BackupName: d.Format("2006-01-02")}, UploadDate: d})
+	}
+	// 3 daily, 2 weekly (2023-03-26 is Sunday, last day of ISO week), 2 monthly
+	policy := config.RetentionPolicy{KeepDaily: 3, KeepWeekly: 2, KeepMonthly: 2}
+	deleted := GetBackupsToDeleteByRetention(testData, policy)
+	assert.Equal(t, len(testData)-5, len(deleted))
+	assert.NotContains(t, backupNames(deleted), "2023-03-31")
+	assert.NotContains(t, backupNames(deleted), "2023-03-30")
+	assert.NotContains(t, backupNames(deleted), "2023-03-29")
+	assert.NotContains(t, backupNames(deleted), "2023-03-26")
+	assert.NotContains(t, backupNames(deleted), "2023-02-28")
+	assert.Contains(t, backupNames(deleted), "2023-03-28")
+	assert.Equal(t, "2023-03-28", deleted[0].BackupName)
+}
+
+func TestGetBackupsToDeleteByRetentionWithRequiredBackup(t *testing.T) {
+	testData := []Backup{
+		{metadata.BackupMetadata{BackupName: "full"}, false, "", "", timeParse("2023-01-01T00-00-00")},
+		{metadata.BackupMetadata{BackupName: "other"}, false, "", "", timeParse("2023-01-02T00-00-00")},
+		{metadata.BackupMetadata{BackupName: "increment1", RequiredBackup: "full"}, false, "", "", timeParse("2023-01-03T00-00-00")},
+		{metadata.BackupMetadata{BackupName: "increment2", RequiredBackup: "increment1"}, false, "", "", timeParse("2023-01-04T00-00-00")},
+		{metadata.BackupMetadata{BackupName: "in_progress"}, false, "", "", time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	deleted := GetBackupsToDeleteByRetention(testData, config.RetentionPolicy{KeepLast: 1})
+	assert.Equal(t, []string{"other"}, backupNames(deleted))
+	assert.Empty(t, GetBackupsToDeleteByRetention(testData, config.RetentionPolicy{KeepYearly: 1, KeepDaily: 4}))
+}
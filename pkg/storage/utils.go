@@ -5,11 +5,26 @@ import (
 	"github.com/apex/log"
 	"github.com/klauspost/compress/zstd"
 	"github.com/mholt/archiver/v4"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
 )
 
+// FilterBackupsByName - all backups are returned when backupNameRE is nil
+func FilterBackupsByName(backups []Backup, backupNameRE *regexp.Regexp) []Backup {
+	if backupNameRE == nil {
+		return backups
+	}
+	filtered := make([]Backup, 0, len(backups))
+	for _, b := range backups {
+		if backupNameRE.MatchString(b.BackupName) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
+
 func GetBackupsToDelete(backups []Backup, keep int) []Backup {
 	if len(backups) > keep {
 		// sort backup ascending
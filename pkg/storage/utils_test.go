@@ -2,6 +2,7 @@ package storage
 
 import (
 	"log"
+	"regexp"
 	"testing"
 	"time"
 
@@ -125,3 +126,14 @@ func TestGetBackupsToDeleteWithRecursiveRequiredBackups(t *testing.T) {
 	}
 	assert.Equal(t, expectedData, GetBackupsToDelete(testData, 6))
 }
+
+func TestFilterBackupsByName(t *testing.T) {
+	testData := []Backup{
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "events-full-20240105020000"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "dim-full-20240105020000"}},
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "events-increment-20240105060000"}},
+	}
+	assert.Equal(t, testData, FilterBackupsByName(testData, nil))
+	filtered := FilterBackupsByName(testData, regexp.MustCompile(`^events-`))
+	assert.Equal(t, []Backup{testData[0], testData[2]}, filtered)
+}
@@ -691,7 +691,7 @@ func testAPIRestart(r *require.Assertions, ch *TestClickHouse) {
 	time.Sleep(6 * time.Second)
 
 	var inProgressActions uint64
-	r.NoError(ch.chbackend.SelectSingleRowNoCtx(&inProgressActions, "SELECT count() FROM system.backup_actions WHERE status!=?", status.CancelStatus))
+	r.NoError(ch.chbackend.SelectSingleRowNoCtx(&inProgressActions, "SELECT count() FROM system.backup_actions WHERE status IN (?,?)", status.InProgressStatus, status.QueuedStatus))
 	r.Equal(uint64(0), inProgressActions)
 }
 
@@ -732,11 +732,20 @@ func testAPIBackupActions(r *require.Assertions, ch *TestClickHouse) {
 	r.NoError(ch.chbackend.StructSelect(&inProgressActions, "SELECT command, status FROM system.backup_actions WHERE command LIKE '%actions%' AND status IN (?,?)", status.InProgressStatus, status.ErrorStatus))
 	r.Equal(0, len(inProgressActions), "inProgressActions=%+v", inProgressActions)
 
+	out, err := dockerExecOut("clickhouse-backup", "bash", "-ce", "curl -sfL 'http://localhost:7171/backup/actions?filter=create+actions_backup2' | grep -o '\"command_id\":[0-9]*' | tail -n 1 | cut -d: -f2")
+	r.NoError(err, "%s", out)
+	createLog, err := dockerExecOut("clickhouse-backup", "bash", "-ce", fmt.Sprintf("curl -sfL 'http://localhost:7171/backup/actions/%s/log'", strings.TrimSpace(out)))
+	r.NoError(err, "%s", createLog)
+	r.Contains(createLog, "done")
+	r.Contains(createLog, "backup=actions_backup2")
+	r.NoError(ch.chbackend.SelectSingleRowNoCtx(&createLog, "SELECT log FROM system.backup_actions WHERE command='create actions_backup2'"))
+	r.Contains(createLog, "backup=actions_backup2")
+
 	var actionsBackups uint64
 	r.NoError(ch.chbackend.SelectSingleRowNoCtx(&actionsBackups, "SELECT count() FROM system.backup_list WHERE name LIKE 'backup_action%'"))
 	r.Equal(uint64(0), actionsBackups)
 
-	out, err := dockerExecOut("clickhouse-backup", "curl", "http://localhost:7171/metrics")
+	out, err = dockerExecOut("clickhouse-backup", "curl", "http://localhost:7171/metrics")
 	r.NoError(err)
 	r.Contains(out, "clickhouse_backup_last_create_remote_status 1")
 	r.Contains(out, "clickhouse_backup_last_create_status 1")
@@ -1777,6 +1786,60 @@ func TestRestoreDatabaseMapping(t *testing.T) {
 	fullCleanup(t, r, ch, []string{testBackupName}, []string{"local"}, databaseList, true, true, "config-database-mapping.yml")
 }
 
+func TestRestoreAtomicReplicated(t *testing.T) {
+	if compareVersion(os.Getenv("CLICKHOUSE_VERSION"), "21.8") < 0 {
+		t.Skipf("Test skipped, EXCHANGE TABLES and Atomic database are not stable for %s version", os.Getenv("CLICKHOUSE_VERSION"))
+	}
+	//t.Parallel()
+	r := require.New(t)
+	ch := &TestClickHouse{}
+	ch.connectWithWait(r, 500*time.Millisecond, 1*time.Second)
+	defer ch.chbackend.Close()
+	checkCount := func(expectedCount uint64, query string) {
+		var count uint64
+		r.NoError(ch.chbackend.SelectSingleRowNoCtx(&count, query))
+		r.Equal(expectedCount, count, query)
+	}
+
+	testBackupName := "test_restore_atomic_replicated"
+	databaseList := []string{"test_atomic"}
+	fullCleanup(t, r, ch, []string{testBackupName}, []string{"local"}, databaseList, false, false, "config-s3.yml")
+
+	ch.queryWithNoError(r, "CREATE DATABASE test_atomic ENGINE=Atomic")
+	// ZooKeeper path contains neither table name nor {uuid}, staging table with the same path would fail with REPLICA_ALREADY_EXISTS
+	ch.queryWithNoError(r, "CREATE TABLE test_atomic.replicated (dt Date, v UInt64) ENGINE=ReplicatedMergeTree('/clickhouse/tables/test_atomic/fixed_path','{replica}') PARTITION BY toYYYYMM(dt) ORDER BY v")
+	ch.queryWithNoError(r, "CREATE TABLE test_atomic.plain (v UInt64) ENGINE=MergeTree() ORDER BY v")
+	ch.queryWithNoError(r, "CREATE TABLE test_atomic.distributed AS test_atomic.replicated ENGINE=Distributed('{cluster}', test_atomic, replicated)")
+	ch.queryWithNoError(r, "CREATE VIEW test_atomic.view AS SELECT * FROM test_atomic.replicated")
+	ch.queryWithNoError(r, "CREATE MATERIALIZED VIEW test_atomic.mv TO test_atomic.plain AS SELECT v FROM test_atomic.replicated")
+	ch.queryWithNoError(r, "INSERT INTO test_atomic.replicated SELECT '2022-01-01', number FROM numbers(10)")
+
+	log.Info("Create backup")
+	r.NoError(dockerExec("clickhouse-backup", "clickhouse-backup", "-c", "/etc/clickhouse-backup/config-s3.yml", "create", testBackupName))
+	ch.queryWithNoError(r, "INSERT INTO test_atomic.replicated SELECT '2023-01-01', number FROM numbers(5)")
+	checkCount(15, "SELECT count() FROM test_atomic.replicated")
+	checkCount(15, "SELECT count() FROM test_atomic.plain")
+
+	// second restore shall not fail on existing views, distributed and staging tables of first restore
+	for i := 0; i < 2; i++ {
+		log.Infof("Restore --atomic #%d", i+1)
+		r.NoError(dockerExec("clickhouse-backup", "clickhouse-backup", "-c", "/etc/clickhouse-backup/config-s3.yml", "restore", "--atomic", "--tables", "test_atomic.*", testBackupName))
+		checkCount(10, "SELECT count() FROM test_atomic.replicated")
+		checkCount(0, "SELECT count() FROM test_atomic.replicated WHERE dt='2023-01-01'")
+		checkCount(10, "SELECT count() FROM test_atomic.plain")
+		checkCount(10, "SELECT count() FROM test_atomic.distributed")
+		checkCount(10, "SELECT count() FROM test_atomic.view")
+		checkCount(1, "SELECT count() FROM system.replicas WHERE database='test_atomic' AND table='replicated' AND zookeeper_path='/clickhouse/tables/test_atomic/fixed_path'")
+		checkCount(0, "SELECT count() FROM system.tables WHERE database='test_atomic' AND name LIKE '%__restore_%'")
+	}
+
+	log.Info("Materialized view writes into exchanged table")
+	ch.queryWithNoError(r, "INSERT INTO test_atomic.replicated SELECT '2024-01-01', number FROM numbers(3)")
+	checkCount(13, "SELECT count() FROM test_atomic.plain")
+
+	fullCleanup(t, r, ch, []string{testBackupName}, []string{"local"}, databaseList, true, true, "config-s3.yml")
+}
+
 func TestMySQLMaterialized(t *testing.T) {
 	t.Skipf("Wait when fix DROP TABLE not supported by MaterializedMySQL, just attach will not help")
 	if compareVersion(os.Getenv("CLICKHOUSE_VERSION"), "22.12") == -1 {